@@ -0,0 +1,231 @@
+// client.go - embedding API
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package spray is the API for embedding the spray load generator in
+// other programs.  Its types are meant to remain stable, unlike those of
+// the packages implementing it.
+package spray
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/internal/spray"
+	"github.com/katzenpost/spray/report"
+)
+
+// Scenario is a measurement run executed by Client.Run.
+type Scenario struct {
+	// Rate is the send rate in packets per second, by default the
+	// Debug SendRate of the configuration.
+	Rate float64
+
+	// Burst is the burst size of the send rate limiter, by default the
+	// Debug SendBurst of the configuration.
+	Burst int
+
+	// Duration is how long the run lasts.  Without it the run lasts as
+	// long as the configured Schedule, Ramp, Staircase, RunDuration or
+	// MaxPackets, or until the context of Run is done.
+	Duration time.Duration
+}
+
+// Stats is a snapshot of the progress of the current run.
+type Stats struct {
+	// Running is true while a run is in progress.
+	Running bool
+
+	// Duration is the time elapsed since the run started.
+	Duration time.Duration
+
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// Outcomes is the number of probes per outcome.
+	Outcomes map[string]uint64
+
+	// Outstanding is the number of SURBs awaiting a reply.
+	Outstanding int
+
+	// P50, P90, P99 and P999 are latency percentiles of the successful
+	// probes.
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+
+	// PKIFetchP50 and PKIFetchP99 are latency percentiles of the PKI
+	// document fetches.
+	PKIFetchP50 time.Duration
+	PKIFetchP99 time.Duration
+
+	// Complete is the fraction of the run completed and ETA the
+	// estimated time remaining, if the run is limited by a RunDuration
+	// or MaxPackets.  ETA is zero if it can not be estimated yet.
+	Complete float64
+	ETA      time.Duration
+}
+
+// Result is the outcome of a run executed by Client.Run.
+type Result struct {
+	// ID identifies the run.
+	ID string
+
+	// Duration is how long the run lasted.
+	Duration time.Duration
+
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// Outcomes is the number of probes per outcome.
+	Outcomes map[string]uint64
+
+	// P50, P90, P99 and P999 are latency percentiles of the successful
+	// probes.
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+}
+
+func newResult(r *report.Run) *Result {
+	res := &Result{
+		ID:       r.ID,
+		Duration: r.Duration,
+		Sent:     r.Sent,
+		Outcomes: make(map[string]uint64, len(r.Outcomes)),
+		P50:      r.Latency.Percentile(50),
+		P90:      r.Latency.Percentile(90),
+		P99:      r.Latency.Percentile(99),
+		P999:     r.Latency.Percentile(99.9),
+	}
+	for k, v := range r.Outcomes {
+		res.Outcomes[k] = v
+	}
+	return res
+}
+
+// Client is the API for embedding spray in other programs.  It executes
+// runs one after the other, and unlike the implementation it wraps only
+// exposes types that are meant to remain stable.
+type Client struct {
+	s *spray.Spray
+}
+
+// Connect returns a new Client for the configuration cfg.  The
+// connections to the Providers are established by every Run.
+func Connect(cfg *config.Config) (*Client, error) {
+	s, err := spray.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, errors.New("spray: configuration only generates keys")
+	}
+	s.Embed()
+	return &Client{s: s}, nil
+}
+
+// Run executes the scenario sc, which may be nil for the configured
+// one, and returns the result of the run once it ends, after writing the
+// configured report files.  The run is ended early, and the result still
+// returned, if ctx is done.
+func (c *Client) Run(ctx context.Context, sc *Scenario) (*Result, error) {
+	if sc == nil {
+		sc = new(Scenario)
+	}
+	sess, err := c.s.Start()
+	if err != nil {
+		return nil, err
+	}
+	if sc.Rate > 0 || sc.Burst > 0 {
+		rate := sc.Rate
+		if rate == 0 {
+			rate, _ = sess.Rate()
+		}
+		for _, sess := range c.s.Sessions() {
+			sess.SetRate(rate, sc.Burst)
+		}
+	}
+	var expired <-chan time.Time
+	if sc.Duration > 0 {
+		t := time.NewTimer(sc.Duration)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-expired:
+	case <-sess.Done():
+	case <-ctx.Done():
+	case <-c.s.HaltCh():
+		return nil, errors.New("spray: halted during the run")
+	}
+	c.s.Stop()
+	return newResult(c.s.RunReport()), nil
+}
+
+// Stats returns a snapshot of the progress of the current run, or of
+// the last one if none is in progress.
+func (c *Client) Stats() *Stats {
+	status := c.s.Status()
+	st := &Stats{
+		Running:     status.Running,
+		Outstanding: status.Outstanding,
+	}
+	r := status.Report
+	if r == nil {
+		return st
+	}
+	st.Duration = r.Duration
+	st.Sent = r.Sent
+	st.Outcomes = r.Outcomes
+	st.P50 = r.Latency.Percentile(50)
+	st.P90 = r.Latency.Percentile(90)
+	st.P99 = r.Latency.Percentile(99)
+	st.P999 = r.Latency.Percentile(99.9)
+	if f := r.PKIFetch; f != nil {
+		st.PKIFetchP50 = f.Percentile(50)
+		st.PKIFetchP99 = f.Percentile(99)
+	}
+	if p := status.Progress; p != nil {
+		st.Complete = p.Complete
+		st.ETA = p.ETA
+	}
+	return st
+}
+
+// OnFatalError registers fn to be called with the fatal errors of the
+// runs, instead of the Client closing itself on the first one.  fn is
+// called on a goroutine of its own, and a Run in progress is not ended
+// by the error.
+func (c *Client) OnFatalError(fn func(error)) {
+	c.s.OnFatalError(fn)
+}
+
+// Verdict returns the SLO verdict of the last run, or nil if no SLO is
+// configured.
+func (c *Client) Verdict() *report.Verdict {
+	return c.s.SLOVerdict()
+}
+
+// Close ends the current run, if any, and releases the resources of the
+// Client.
+func (c *Client) Close() {
+	c.s.Shutdown()
+	c.s.Wait()
+}
@@ -0,0 +1,175 @@
+// main.go - spray load testing client
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/internal/spray"
+)
+
+// targetsFlag is a repeatable flag of "recipient@provider[:weight]"
+// load targets.
+type targetsFlag []*config.Target
+
+func (f *targetsFlag) String() string {
+	var s []string
+	for _, t := range *f {
+		s = append(s, fmt.Sprintf("%v@%v:%v", t.Recipient, t.Provider, t.Weight))
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *targetsFlag) Set(v string) error {
+	t := new(config.Target)
+	if i := strings.LastIndexByte(v, ':'); i >= 0 {
+		w, err := strconv.Atoi(v[i+1:])
+		if err != nil {
+			return fmt.Errorf("invalid weight '%v'", v[i+1:])
+		}
+		t.Weight, v = w, v[:i]
+	}
+	i := strings.LastIndexByte(v, '@')
+	if i <= 0 || i == len(v)-1 {
+		return fmt.Errorf("target '%v' is not of the form recipient@provider", v)
+	}
+	t.Recipient, t.Provider = v[:i], v[i+1:]
+	*f = append(*f, t)
+	return nil
+}
+
+func main() {
+	cfgFile := flag.String("f", "spray.toml", "Path to the client config file.")
+	genOnly := flag.Bool("g", false, "Generate the keys and exit immediately.")
+	rate := flag.Float64("rate", -1, "Override the send rate in packets per second.")
+	duration := flag.Duration("duration", 0, "Stop sending after the given duration, rounded up to whole seconds, and end the run once the outstanding replies are in, by default run until interrupted.")
+	slo := flag.String("slo", "", "Override the service level objectives, e.g. \"p99<30s,loss<1%\".")
+	tui := flag.Bool("tui", false, "Render a live dashboard in the terminal, best used with logging to a file.")
+	var targets targetsFlag
+	flag.Var(&targets, "target", "Override the load targets with recipient@provider[:weight], may be repeated.")
+	flag.Parse()
+
+	overrides := func(cfg *config.Config) {
+		if *rate >= 0 && cfg.Debug != nil {
+			cfg.Debug.SendRate = *rate
+		}
+		if len(targets) > 0 {
+			cfg.Target = targets
+			if cfg.Debug != nil {
+				cfg.Debug.TargetsFile = ""
+				cfg.Debug.TargetProvider, cfg.Debug.TargetRecipient = "", ""
+			}
+		}
+		if *duration > 0 {
+			if cfg.Traffic == nil {
+				cfg.Traffic = new(config.Traffic)
+			}
+			cfg.Traffic.RunDuration = int((*duration + time.Second - 1) / time.Second)
+		}
+		if *slo != "" {
+			if cfg.Report == nil {
+				cfg.Report = new(config.Report)
+			}
+			cfg.Report.SLO = *slo
+		}
+	}
+	cfg, err := config.LoadFileWithOverrides(*cfgFile, *genOnly, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config file '%v': %v\n", *cfgFile, err)
+		os.Exit(-1)
+	}
+
+	// Setup the signal handling.
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	// Start up the client.
+	c, err := spray.New(cfg)
+	if cfg.Debug.GenerateOnly {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate keys: %v\n", err)
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to spawn client instance: %v\n", err)
+		os.Exit(-1)
+	}
+	if cfg.Sweep != nil {
+		go func() {
+			sw, err := c.Sweep()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Sweep failed: %v\n", err)
+			} else {
+				fmt.Println(sw)
+			}
+			c.Shutdown()
+		}()
+	} else if _, err = c.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start the run: %v\n", err)
+		c.Shutdown()
+		os.Exit(-1)
+	}
+
+	if *tui {
+		go newDashboard(c, os.Stdout).run()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+			case <-hupCh:
+				// Reload the traffic parameters, keeping the flag overrides.
+				newCfg, err := config.LoadFileWithOverrides(*cfgFile, false, overrides)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reload config file '%v': %v\n", *cfgFile, err)
+				} else if err = c.Reload(newCfg); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reload the configuration: %v\n", err)
+				}
+				continue
+			}
+			c.Shutdown()
+			return
+		}
+	}()
+
+	// Wait for the client to explode or be terminated.
+	c.Wait()
+
+	if err := c.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Run aborted: %v\n", err)
+		os.Exit(-1)
+	}
+	if v := c.SLOVerdict(); v != nil {
+		fmt.Println(v)
+		if !v.Pass {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// tui.go - live terminal dashboard
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/katzenpost/spray/event"
+	"github.com/katzenpost/spray/internal/session"
+	"github.com/katzenpost/spray/internal/spray"
+	"github.com/katzenpost/spray/report"
+)
+
+const (
+	// dashboardRefresh is the refresh interval of the dashboard, and
+	// the width of the buckets of the latency sparkline.
+	dashboardRefresh = time.Second
+
+	// sparklineWidth is the number of buckets of the latency sparkline.
+	sparklineWidth = 60
+
+	// dashboardBufSize is the number of events buffered for the
+	// dashboard.
+	dashboardBufSize = 4096
+)
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders the values as a line of block characters scaled to
+// their maximum, with blanks for the zero values.
+func sparkline(values []time.Duration) string {
+	var max time.Duration
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var b bytes.Buffer
+	for _, v := range values {
+		if v == 0 || max == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		i := int(int64(len(sparkTicks)-1) * int64(v) / int64(max))
+		b.WriteRune(sparkTicks[i])
+	}
+	return b.String()
+}
+
+// dashboard is the live terminal dashboard enabled with -tui, rendering
+// the send rates, loss, latency, and the connection state and epoch of
+// every account of the run in progress every second.  It reads the events of the Spray, and
+// should be used with logging to a file rather than to the terminal.
+type dashboard struct {
+	c   *spray.Spray
+	out io.Writer
+
+	lastSent uint64
+	lastAt   time.Time
+
+	// latencies is the mean latency of the successful probes of every
+	// refresh interval, oldest first, and sum and count accumulate
+	// those of the current interval.
+	latencies []time.Duration
+	sum       time.Duration
+	count     int
+}
+
+func newDashboard(c *spray.Spray, out io.Writer) *dashboard {
+	return &dashboard{
+		c:         c,
+		out:       out,
+		lastAt:    time.Now(),
+		latencies: make([]time.Duration, sparklineWidth),
+	}
+}
+
+// run renders the dashboard until the Spray is shut down.
+func (d *dashboard) run() {
+	sub := d.c.Events().Subscribe(dashboardBufSize)
+	defer sub.Close()
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			d.onEvent(e)
+		case <-ticker.C:
+			d.render()
+		}
+	}
+}
+
+func (d *dashboard) onEvent(e event.Event) {
+	if e, ok := e.(*event.ProbeEvent); ok && e.Probe.Outcome == report.OutcomeOK {
+		d.sum += e.Probe.Latency
+		d.count++
+	}
+}
+
+// connectionState returns the connection state of a session.
+func connectionState(sess *session.Session) string {
+	switch {
+	case sess.IsDisconnected():
+		return "offline"
+	case !sess.IsConnected():
+		return "disconnected"
+	case sess.IsPaused():
+		return "connected, paused"
+	}
+	return "connected"
+}
+
+func (d *dashboard) render() {
+	var mean time.Duration
+	if d.count > 0 {
+		mean = d.sum / time.Duration(d.count)
+	}
+	d.latencies = append(d.latencies[1:], mean)
+	d.sum, d.count = 0, 0
+
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "spray  %s\n\n", time.Now().Format("15:04:05"))
+
+	sessions := d.c.Sessions()
+	r := d.c.RunReport()
+	if len(sessions) == 0 || r == nil {
+		b.WriteString("Waiting for the run to start...\n")
+		d.out.Write(b.Bytes())
+		return
+	}
+
+	var sent uint64
+	var qps float64
+	for _, s := range sessions {
+		sent += s.Counters().Sent
+		rate, _ := s.Rate()
+		qps += rate
+	}
+	now := time.Now()
+	achieved := 0.0
+	if sent >= d.lastSent {
+		achieved = float64(sent-d.lastSent) / now.Sub(d.lastAt).Seconds()
+	}
+	d.lastSent, d.lastAt = sent, now
+
+	var probes uint64
+	for _, v := range r.Outcomes {
+		probes += v
+	}
+	loss := 0.0
+	if probes > 0 {
+		loss = 100 * float64(r.Outcomes[report.OutcomeLost]) / float64(probes)
+	}
+	h := r.Latency
+
+	for _, sess := range sessions {
+		account := ""
+		if len(sessions) > 1 {
+			account = sess.Config().Account.Identifier() + ": "
+		}
+		epoch, till := sess.Epoch()
+		fmt.Fprintf(&b, "Connection   %s%s, epoch %d (next in %v)\n", account, connectionState(sess), epoch, till.Round(time.Second))
+	}
+	fmt.Fprintf(&b, "Send rate    %.2f/s requested, %.2f/s achieved\n", qps, achieved)
+	fmt.Fprintf(&b, "Packets      %d sent, %d probes, %d ok, %d lost (%.2f%%)\n",
+		r.Sent, probes, r.Outcomes[report.OutcomeOK], r.Outcomes[report.OutcomeLost], loss)
+	fmt.Fprintf(&b, "Latency      p50 %v  p99 %v  max %v\n",
+		h.Percentile(50).Round(time.Millisecond), h.Percentile(99).Round(time.Millisecond), h.Max().Round(time.Millisecond))
+	fmt.Fprintf(&b, "Last %ds     |%s|\n", sparklineWidth, sparkline(d.latencies))
+	if p := sessions[0].Progress(); p != nil {
+		fmt.Fprintf(&b, "Progress     %v\n", p)
+	}
+	d.out.Write(b.Bytes())
+}
@@ -0,0 +1,65 @@
+// authorityload.go - authority load test configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+)
+
+// OfflineLinkProtocol is the name of the link protocol that never
+// connects to the Provider, and only follows the PKI documents.
+const OfflineLinkProtocol = "offline"
+
+const (
+	defaultAuthorityLoadConcurrency = 1
+	defaultAuthorityLoadTimeout     = 30
+)
+
+// AuthorityLoad is the authority load test mode configuration.  The
+// fetches are paced by the Debug SendRate and SendBurst.
+type AuthorityLoad struct {
+	// Concurrency is the number of fetches in flight at once, by
+	// default 1.
+	Concurrency int
+
+	// Timeout is the number of seconds after which a fetch is
+	// considered lost.
+	Timeout int
+
+	// NextEpoch fetches the document of the next epoch instead of the
+	// current one, exercising the authority while it is being published.
+	NextEpoch bool
+}
+
+func (aCfg *AuthorityLoad) fixup() {
+	if aCfg.Concurrency == 0 {
+		aCfg.Concurrency = defaultAuthorityLoadConcurrency
+	}
+	if aCfg.Timeout == 0 {
+		aCfg.Timeout = defaultAuthorityLoadTimeout
+	}
+}
+
+func (aCfg *AuthorityLoad) validate() error {
+	if aCfg.Concurrency < 0 {
+		return fmt.Errorf("config: AuthorityLoad: Concurrency '%v' is invalid", aCfg.Concurrency)
+	}
+	if aCfg.Timeout < 0 {
+		return fmt.Errorf("config: AuthorityLoad: Timeout '%v' is invalid", aCfg.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// bundle.go - encrypted key bundles
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	keyBundleVersion = 1
+
+	bundleSaltLength = 16
+	bundleKDFTime    = 3
+	bundleKDFMemory  = 64 * 1024
+	bundleKDFThreads = 4
+)
+
+var errBundlePassphrase = errors.New("config: key bundle passphrase is wrong or the bundle is corrupt")
+
+// keyBundle is an exported, passphrase encrypted account key directory.
+// The key is derived from the passphrase with Argon2id, and the JSON
+// encoded keyBundleContent is sealed with AES-256-GCM, authenticating
+// the account identifier as additional data.
+type keyBundle struct {
+	Version    int
+	Identifier string
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+type keyBundleContent struct {
+	// Files maps the names of the PEM files of the key directory to
+	// their contents.
+	Files map[string][]byte
+}
+
+func bundleAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, salt, bundleKDFTime, bundleKDFMemory, bundleKDFThreads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ExportKeys returns the key material of the account, every PEM file of
+// its key directory, as a bundle encrypted with passphrase, for
+// ImportKeys to install on another host.
+func ExportKeys(cfg *Config, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("config: key bundle passphrase is empty")
+	}
+	id := cfg.Account.Identifier()
+	basePath := filepath.Join(cfg.Proxy.DataDir, id)
+	fis, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return nil, err
+	}
+	content := &keyBundleContent{Files: make(map[string][]byte)}
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".pem") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(basePath, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		content.Files[fi.Name()] = b
+	}
+	if len(content.Files) == 0 {
+		return nil, fmt.Errorf("config: no keys found in '%v'", basePath)
+	}
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &keyBundle{
+		Version:    keyBundleVersion,
+		Identifier: id,
+		Salt:       make([]byte, bundleSaltLength),
+	}
+	if _, err = io.ReadFull(rand.Reader, bundle.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := bundleAEAD(passphrase, bundle.Salt)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Nonce = make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, bundle.Nonce); err != nil {
+		return nil, err
+	}
+	bundle.Ciphertext = aead.Seal(nil, bundle.Nonce, plaintext, []byte(id))
+	return json.MarshalIndent(bundle, "", "  ")
+}
+
+// ImportKeys decrypts a bundle made by ExportKeys with passphrase and
+// installs the keys in the key directory of the account, which must be
+// the account the bundle was exported from.  Existing keys are never
+// overwritten.
+func ImportKeys(cfg *Config, b, passphrase []byte) error {
+	bundle := new(keyBundle)
+	if err := json.Unmarshal(b, bundle); err != nil {
+		return fmt.Errorf("config: invalid key bundle: %v", err)
+	}
+	if bundle.Version != keyBundleVersion {
+		return fmt.Errorf("config: unsupported key bundle version: %v", bundle.Version)
+	}
+	id := cfg.Account.Identifier()
+	if bundle.Identifier != id {
+		return fmt.Errorf("config: key bundle is for account '%v', not '%v'", bundle.Identifier, id)
+	}
+	aead, err := bundleAEAD(passphrase, bundle.Salt)
+	if err != nil {
+		return err
+	}
+	if len(bundle.Nonce) != aead.NonceSize() {
+		return errBundlePassphrase
+	}
+	plaintext, err := aead.Open(nil, bundle.Nonce, bundle.Ciphertext, []byte(id))
+	if err != nil {
+		return errBundlePassphrase
+	}
+	content := new(keyBundleContent)
+	if err = json.Unmarshal(plaintext, content); err != nil {
+		return fmt.Errorf("config: invalid key bundle: %v", err)
+	}
+
+	basePath := filepath.Join(cfg.Proxy.DataDir, id)
+	for name := range content.Files {
+		if name != filepath.Base(name) || !strings.HasSuffix(name, ".pem") {
+			return fmt.Errorf("config: invalid key bundle file name: '%v'", name)
+		}
+		if fileExists(filepath.Join(basePath, name)) {
+			return fmt.Errorf("config: '%v' already exists", filepath.Join(basePath, name))
+		}
+	}
+	if err = MkDataDir(basePath); err != nil {
+		return err
+	}
+	for name, data := range content.Files {
+		f := filepath.Join(basePath, name)
+		if err = ioutil.WriteFile(f, data, os.FileMode(0600)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
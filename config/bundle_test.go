@@ -0,0 +1,210 @@
+// bundle_test.go - key bundle tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bundleConfig returns a configuration for the account user with the
+// given DataDir.
+func bundleConfig(t *testing.T, dataDir, user string) *Config {
+	cfg := &Config{
+		Proxy:   &Proxy{DataDir: dataDir},
+		Logging: &Logging{Disable: true},
+		Debug:   &Debug{Mode: ModeKaetzchen},
+		Account: &Account{User: user, Provider: "provider-0"},
+		Mock:    &Mock{Providers: 2, MeanDelay: 10},
+	}
+	if err := cfg.FixupAndValidate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// bundleDirs returns two temporary DataDirs, the first one holding the
+// keys of the account user.
+func bundleDirs(t *testing.T, user string) (string, string, map[string][]byte) {
+	var dirs [2]string
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "spray-bundle-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs[i] = dir
+	}
+	cfg := bundleConfig(t, dirs[0], user)
+	keyDir := filepath.Join(dirs[0], cfg.Account.Identifier())
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string][]byte{
+		"link.private.pem":     []byte("-----BEGIN X25519 PRIVATE KEY-----\nbGluaw==\n-----END X25519 PRIVATE KEY-----\n"),
+		"identity.private.pem": []byte("-----BEGIN ED25519 PRIVATE KEY-----\naWRlbnRpdHk=\n-----END ED25519 PRIVATE KEY-----\n"),
+	}
+	for name, data := range keys {
+		if err := ioutil.WriteFile(filepath.Join(keyDir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Other files of the key directory are not part of the bundle.
+	if err := ioutil.WriteFile(filepath.Join(keyDir, "notes.txt"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dirs[0], dirs[1], keys
+}
+
+func TestKeyBundleRoundTrip(t *testing.T) {
+	src, dst, keys := bundleDirs(t, "alice")
+	defer os.RemoveAll(src)
+	defer os.RemoveAll(dst)
+	passphrase := []byte("correct horse battery staple")
+
+	b, err := ExportKeys(bundleConfig(t, src, "alice"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := bundleConfig(t, dst, "alice")
+	if err = ImportKeys(cfg, b, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	keyDir := filepath.Join(dst, cfg.Account.Identifier())
+	fis, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != len(keys) {
+		t.Fatalf("%d files imported, want %d", len(fis), len(keys))
+	}
+	for name, want := range keys {
+		got, err := ioutil.ReadFile(filepath.Join(keyDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v imported as %q, want %q", name, got, want)
+		}
+	}
+
+	// Existing keys are never overwritten.
+	if err = ImportKeys(cfg, b, passphrase); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("importing over existing keys returned %v", err)
+	}
+}
+
+func TestKeyBundleExportErrors(t *testing.T) {
+	src, dst, _ := bundleDirs(t, "alice")
+	defer os.RemoveAll(src)
+	defer os.RemoveAll(dst)
+
+	if _, err := ExportKeys(bundleConfig(t, src, "alice"), nil); err == nil {
+		t.Error("a bundle was exported with an empty passphrase")
+	}
+	if _, err := ExportKeys(bundleConfig(t, dst, "alice"), []byte("passphrase")); err == nil {
+		t.Error("a bundle was exported without a key directory")
+	}
+	if err := os.MkdirAll(filepath.Join(dst, bundleConfig(t, dst, "alice").Account.Identifier()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExportKeys(bundleConfig(t, dst, "alice"), []byte("passphrase")); err == nil {
+		t.Error("a bundle was exported without any keys")
+	}
+}
+
+// sealBundle returns a bundle for the account of cfg holding content,
+// which ExportKeys would never produce.
+func sealBundle(t *testing.T, cfg *Config, content *keyBundleContent, passphrase []byte) []byte {
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &keyBundle{
+		Version:    keyBundleVersion,
+		Identifier: cfg.Account.Identifier(),
+		Salt:       make([]byte, bundleSaltLength),
+	}
+	aead, err := bundleAEAD(passphrase, bundle.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle.Nonce = make([]byte, aead.NonceSize())
+	bundle.Ciphertext = aead.Seal(nil, bundle.Nonce, plaintext, []byte(bundle.Identifier))
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestKeyBundleMalformed(t *testing.T) {
+	src, dst, _ := bundleDirs(t, "alice")
+	defer os.RemoveAll(src)
+	defer os.RemoveAll(dst)
+	passphrase := []byte("passphrase")
+	b, err := ExportKeys(bundleConfig(t, src, "alice"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := bundleConfig(t, dst, "alice")
+
+	// modify returns the bundle with fn applied.
+	modify := func(fn func(*keyBundle)) []byte {
+		bundle := new(keyBundle)
+		if err := json.Unmarshal(b, bundle); err != nil {
+			t.Fatal(err)
+		}
+		fn(bundle)
+		m, err := json.Marshal(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	tests := []struct {
+		name       string
+		cfg        *Config
+		bundle     []byte
+		passphrase []byte
+		err        string
+	}{
+		{"not JSON", cfg, []byte("not a bundle"), passphrase, "invalid key bundle"},
+		{"truncated", cfg, b[:len(b)/2], passphrase, "invalid key bundle"},
+		{"version", cfg, modify(func(k *keyBundle) { k.Version++ }), passphrase, "unsupported key bundle version"},
+		{"other account", bundleConfig(t, dst, "bob"), b, passphrase, "is for account"},
+		{"wrong passphrase", cfg, b, []byte("wrong"), errBundlePassphrase.Error()},
+		{"nonce", cfg, modify(func(k *keyBundle) { k.Nonce = k.Nonce[1:] }), passphrase, errBundlePassphrase.Error()},
+		{"ciphertext", cfg, modify(func(k *keyBundle) { k.Ciphertext[0] ^= 1 }), passphrase, errBundlePassphrase.Error()},
+		{"identifier", cfg, modify(func(k *keyBundle) { k.Identifier = "bob@provider-0" }), passphrase, "is for account"},
+		{"path", cfg, sealBundle(t, cfg, &keyBundleContent{Files: map[string][]byte{"../link.private.pem": nil}}, passphrase), passphrase, "invalid key bundle file name"},
+		{"not a key", cfg, sealBundle(t, cfg, &keyBundleContent{Files: map[string][]byte{"notes.txt": nil}}, passphrase), passphrase, "invalid key bundle file name"},
+	}
+	for _, tt := range tests {
+		err := ImportKeys(tt.cfg, tt.bundle, tt.passphrase)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: ImportKeys returned %v, want %q", tt.name, err, tt.err)
+		}
+	}
+	if fis, _ := ioutil.ReadDir(dst); len(fis) != 0 {
+		t.Errorf("a malformed bundle left %v behind", fis[0].Name())
+	}
+}
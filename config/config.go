@@ -18,11 +18,14 @@
 package config
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/mail"
+	"math"
+	"net"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -34,15 +37,110 @@ import (
 	"github.com/katzenpost/core/crypto/rand"
 	"github.com/katzenpost/core/log"
 	"github.com/katzenpost/core/pki"
-	"github.com/katzenpost/core/utils"
-	"golang.org/x/net/idna"
-	"golang.org/x/text/secure/precis"
+	"github.com/katzenpost/spray/report"
 )
 
 const (
 	defaultLogLevel                    = "NOTICE"
 	defaultPollingInterval             = 10
 	defaultInitialMaxPKIRetrievalDelay = 10
+	defaultSelfTestProbes              = 3
+	defaultSelfTestTimeout             = 120
+	defaultMemspoolMessageSize         = 1000
+	defaultMemspoolTimeout             = 60
+	defaultPacketPoolSize              = 1000
+	defaultClosedLoopConcurrency       = 1
+	defaultClosedLoopTimeout           = 60
+	defaultKaetzchenProbe              = "echo"
+	defaultLinkProtocol                = "default"
+	defaultTrafficGenerator            = "default"
+	defaultLinkKeyType                 = LinkKeyX25519
+	defaultKaetzchenTimeout            = 60
+	defaultLoopTimeout                 = 60
+	defaultCaptureDir                  = "capture"
+	defaultDocumentPolicy              = DocumentPolicyWarn
+	defaultClockSkewPolicy             = ClockSkewPolicyAnnotate
+	defaultReconnectBackoff            = 1000
+	defaultMaxReconnectBackoff         = 60000
+
+	// ModeFlood floods the target recipient with forward packets.
+	ModeFlood = "flood"
+
+	// ModeMemspool exercises the memspool Kaetzchen service.
+	ModeMemspool = "memspool"
+
+	// ModeKaetzchen exercises a Kaetzchen service with a registered
+	// request/response probe.
+	ModeKaetzchen = "kaetzchen"
+
+	// ModeMailboxSender floods the target recipient's spool with
+	// stamped messages, for consumption by a mailbox receiver.
+	ModeMailboxSender = "mailbox-sender"
+
+	// ModeMailboxReceiver sends nothing and measures the retrieval
+	// latency and spool backlog of stamped messages sent to us.
+	ModeMailboxReceiver = "mailbox-receiver"
+
+	// ModeComposeOnly composes packets at the configured rate without
+	// ever sending them, measuring compose throughput and route delays.
+	ModeComposeOnly = "compose-only"
+
+	// ModeBurst precomposes a pool of packets to the targets before
+	// sending them at the configured rate, recomposing it when it runs
+	// out or the epoch changes, so that packet composition does not
+	// limit the send rate.
+	ModeBurst = "burst"
+
+	// ModeMailproxy sends end-to-end encrypted messages to mailproxy
+	// recipients.
+	ModeMailproxy = "mailproxy"
+
+	// ModeDrain sends nothing, and retrieves and discards the messages
+	// in our own spool until it is empty, ending the run.
+	ModeDrain = "drain"
+
+	// ModeCrossCheck sends nothing, and fetches the document of every
+	// epoch from every configured authority, flagging divergence.
+	ModeCrossCheck = "crosscheck"
+
+	// ModeAuthority never connects to the Provider, and load tests the
+	// authority with document fetches instead.
+	ModeAuthority = "authority"
+)
+
+// Document policies, applied when a PKI document received after the
+// first fails validation.
+const (
+	// DocumentPolicyWarn logs a warning and keeps generating load.
+	DocumentPolicyWarn = "warn"
+
+	// DocumentPolicyPause pauses load generation until a valid document
+	// is received.
+	DocumentPolicyPause = "pause"
+
+	// DocumentPolicyAbort aborts the session.
+	DocumentPolicyAbort = "abort"
+)
+
+// Clock skew policies, applied when the clock skew versus the Provider
+// exceeds the Debug MaxClockSkew.
+const (
+	// ClockSkewPolicyAnnotate marks the results of the run as measured
+	// with an excessive clock skew.
+	ClockSkewPolicyAnnotate = "annotate"
+
+	// ClockSkewPolicyAbort aborts the session.
+	ClockSkewPolicyAbort = "abort"
+)
+
+// Link key types.
+const (
+	// LinkKeyX25519 is a classical X25519 link key.
+	LinkKeyX25519 = "x25519"
+
+	// LinkKeyX25519Kyber768 is a hybrid X25519 and Kyber768 link key,
+	// for Providers with post-quantum link handshakes enabled.
+	LinkKeyX25519Kyber768 = "x25519-kyber768"
 )
 
 var defaultLogging = Logging{
@@ -53,12 +151,32 @@ var defaultLogging = Logging{
 
 // Proxy is the proxy configuration.
 type Proxy struct {
-	// DataDir is the absolute path to the data directory.
+	// DataDir is the absolute path to the data directory.  It may use
+	// forward slashes on every system, and must include the drive
+	// letter on Windows.  If it is ":memory:", or is not writable, the
+	// keys and any other state are kept in memory only, and the output
+	// files must be given absolute paths.  With ":memory:" so must the
+	// TargetsFile, the Script, the log File and the control Socket.
 	DataDir string
+
+	// ephemeral is set by InitDataDir.
+	ephemeral bool
+}
+
+func (pCfg *Proxy) fixup() {
+	if pCfg.DataDir != MemoryDataDir {
+		pCfg.DataDir = cleanPath(pCfg.DataDir)
+	}
 }
 
 func (pCfg *Proxy) validate() error {
+	if pCfg.DataDir == MemoryDataDir {
+		return nil
+	}
 	if !filepath.IsAbs(pCfg.DataDir) {
+		if IsWindows && pCfg.DataDir != "" && filepath.VolumeName(pCfg.DataDir) == "" {
+			return fmt.Errorf("config: Proxy: DataDir '%v' is not an absolute path, it lacks a drive letter", pCfg.DataDir)
+		}
 		return fmt.Errorf("config: Proxy: DataDir '%v' is not an absolute path", pCfg.DataDir)
 	}
 	return nil
@@ -74,6 +192,10 @@ type Logging struct {
 
 	// Level specifies the log level.
 	Level string
+
+	// PerSession additionally writes each session's log to its own
+	// "session.log" file in the account's directory under the DataDir.
+	PerSession bool
 }
 
 func (lCfg *Logging) validate() error {
@@ -91,18 +213,47 @@ func (lCfg *Logging) validate() error {
 
 // Debug is the debug configuration.
 type Debug struct {
-	// TargetProvider is the target service provider for our probes.
-	TargetProvider string
+	// Mode selects the kind of load to generate, one of "flood" (the
+	// default), "memspool", "kaetzchen", "mailbox-sender",
+	// "mailbox-receiver", "compose-only", "burst", "mailproxy", "drain",
+	// "crosscheck" or "authority".
+	Mode string
 
-	// TargetRecipient is the target recipient for our probes.
+	// TargetProvider and TargetRecipient are the single target of
+	// earlier versions, which becomes a Target block.
+	//
+	// Deprecated: Use a Target block instead.
+	TargetProvider  string
 	TargetRecipient string
 
+	// TargetsFile is the path of a CSV or JSON file listing weighted
+	// (provider, recipient) targets, see LoadTargets.  If set, it takes
+	// precedence over the Target blocks.  Relative paths are relative to
+	// the DataDir.
+	TargetsFile string
+
 	// SendBurst controls the burst rate of the egress rate limiter.
 	SendBurst int
 
+	// ReplyHops is the number of hops of the SURB reply paths, including
+	// both Providers, zero meaning the Geometry NrHops.  Fewer hops than
+	// that require a link protocol able to skip mix layers on the reply
+	// paths independently of the forward paths.
+	ReplyHops int
+
+	// CryptoWorkers is the number of goroutines composing Sphinx
+	// packets in parallel, by default GOMAXPROCS.
+	CryptoWorkers int
+
 	// SendRate controls the egress rate limiter and is packets per second.
 	SendRate float64
 
+	// LambdaP, if set, replaces the egress rate limiter with a Poisson
+	// process, as the Loopix decoy traffic model.  It is the inverse of
+	// the mean interval between sends in milliseconds, in the units of
+	// the LambdaP parameter of PKI documents.
+	LambdaP float64
+
 	// SessionDialTimeout is the number of seconds that a session dial
 	// is allowed to take until it is cancelled.
 	SessionDialTimeout int
@@ -119,21 +270,863 @@ type Debug struct {
 	// key generation.
 	GenerateOnly bool
 
+	// LinkProtocol is the name of the link protocol implementation used
+	// to talk to the Provider, as registered with the session package.
+	LinkProtocol string
+
+	// ProbeTagKey is the hex encoded secret, of at least 16 bytes, used
+	// to mask and authenticate the headers of the probes sent in the
+	// mailbox sender mode, instead of sending plaintext sequence
+	// numbers.  The mailbox receiver must be configured with the same
+	// key.
+	ProbeTagKey string
+
+	// TrafficGenerator is the name of the traffic generator deciding
+	// what the flood and mailbox sender modes send and when, as
+	// registered with the session package.
+	TrafficGenerator string
+
+	// LinkKeyType is the type of the link key, either "x25519" (the
+	// default) or "x25519-kyber768" for a hybrid post-quantum link key.
+	// Hybrid link keys require a link protocol that supports them.
+	LinkKeyType string
+
 	// PollingInterval is the interval in seconds that will be used to
 	// poll the receive queue.  By default this is 30 seconds.  Reducing
 	// the value too far WILL result in uneccesary Provider load, and
 	// increasing the value too far WILL adversely affect large message
 	// transmit performance.
 	PollingInterval int
+
+	// ActivePollingInterval, if set, is the interval in milliseconds
+	// the receive queue is polled at while SURB replies are outstanding,
+	// so that the reply latency is not inflated by the PollingInterval,
+	// which applies again once no replies are outstanding.
+	ActivePollingInterval int
+
+	// EpochPeriod is the epoch period in seconds of time-compressed lab
+	// networks whose authorities use shorter epochs, by default the
+	// compiled in period.  It applies to every session of the process.
+	EpochPeriod int
+
+	// Calibrate measures the send cadence the host can deliver before
+	// connecting, and warns if SendRate exceeds it.
+	Calibrate bool
+
+	// Seed, if nonzero, seeds the random choices of spray itself, such
+	// as the target selection, the Poisson schedule, churn, fault
+	// injection and decoy loops, for repeatable runs.  The path
+	// selection and the cryptography remain random.
+	Seed int64
+
+	// DocumentPolicy is what to do when a PKI document received after
+	// the first fails validation, one of "warn" (the default), "pause"
+	// or "abort".  An invalid first document always aborts.
+	DocumentPolicy string
+
+	// EnableTimeSync makes minclient correct its notion of the time with
+	// the clock skew versus the Provider.
+	EnableTimeSync bool
+
+	// MaxClockSkew is the number of seconds the clock skew versus the
+	// Provider, as measured on connecting, may amount to before the
+	// ClockSkewPolicy is applied, zero meaning no bound.
+	MaxClockSkew int
+
+	// ClockSkewPolicy is what to do when the clock skew exceeds the
+	// MaxClockSkew, one of "annotate" (the default) or "abort".
+	ClockSkewPolicy string
+
+	// ReconnectBackoff is the number of milliseconds waited for the
+	// connection to the Provider to come back on its own after it is
+	// lost, before it is replaced, by default 1000.  The wait doubles
+	// with every failed attempt, up to MaxReconnectBackoff, by default
+	// 60000.  Load generation is paused meanwhile.
+	ReconnectBackoff    int
+	MaxReconnectBackoff int
 }
 
 func (d *Debug) fixup() {
+	if d.Mode == "" {
+		d.Mode = ModeFlood
+	}
+	if d.LinkProtocol == "" {
+		d.LinkProtocol = defaultLinkProtocol
+	}
+	if d.TrafficGenerator == "" {
+		d.TrafficGenerator = defaultTrafficGenerator
+	}
+	if d.LinkKeyType == "" {
+		d.LinkKeyType = defaultLinkKeyType
+	}
 	if d.PollingInterval == 0 {
 		d.PollingInterval = defaultPollingInterval
 	}
 	if d.InitialMaxPKIRetrievalDelay == 0 {
 		d.InitialMaxPKIRetrievalDelay = defaultInitialMaxPKIRetrievalDelay
 	}
+	if d.DocumentPolicy == "" {
+		d.DocumentPolicy = defaultDocumentPolicy
+	}
+	if d.ClockSkewPolicy == "" {
+		d.ClockSkewPolicy = defaultClockSkewPolicy
+	}
+	if d.CryptoWorkers == 0 {
+		d.CryptoWorkers = runtime.GOMAXPROCS(0)
+	}
+	if d.ReconnectBackoff == 0 {
+		d.ReconnectBackoff = defaultReconnectBackoff
+	}
+	if d.MaxReconnectBackoff == 0 {
+		d.MaxReconnectBackoff = defaultMaxReconnectBackoff
+	}
+}
+
+func (d *Debug) validate() error {
+	switch d.Mode {
+	case ModeFlood, ModeMemspool, ModeKaetzchen, ModeMailboxSender, ModeMailboxReceiver, ModeComposeOnly, ModeBurst, ModeMailproxy, ModeDrain, ModeCrossCheck, ModeAuthority:
+	default:
+		return fmt.Errorf("config: Debug: Mode '%v' is invalid", d.Mode)
+	}
+	if d.ProbeTagKey != "" {
+		if k, err := hex.DecodeString(d.ProbeTagKey); err != nil || len(k) < 16 {
+			return errors.New("config: Debug: ProbeTagKey is invalid")
+		}
+	}
+	switch d.LinkKeyType {
+	case LinkKeyX25519, LinkKeyX25519Kyber768:
+	default:
+		return fmt.Errorf("config: Debug: LinkKeyType '%v' is invalid", d.LinkKeyType)
+	}
+	if d.ActivePollingInterval < 0 {
+		return fmt.Errorf("config: Debug: ActivePollingInterval '%v' is invalid", d.ActivePollingInterval)
+	}
+	if d.EpochPeriod < 0 {
+		return fmt.Errorf("config: Debug: EpochPeriod '%v' is invalid", d.EpochPeriod)
+	}
+	if d.LambdaP < 0 || math.IsInf(d.LambdaP, 0) || math.IsNaN(d.LambdaP) {
+		return fmt.Errorf("config: Debug: LambdaP '%v' is invalid", d.LambdaP)
+	}
+	switch d.DocumentPolicy {
+	case DocumentPolicyWarn, DocumentPolicyPause, DocumentPolicyAbort:
+	default:
+		return fmt.Errorf("config: Debug: DocumentPolicy '%v' is invalid", d.DocumentPolicy)
+	}
+	if d.MaxClockSkew < 0 {
+		return fmt.Errorf("config: Debug: MaxClockSkew '%v' is invalid", d.MaxClockSkew)
+	}
+	switch d.ClockSkewPolicy {
+	case ClockSkewPolicyAnnotate, ClockSkewPolicyAbort:
+	default:
+		return fmt.Errorf("config: Debug: ClockSkewPolicy '%v' is invalid", d.ClockSkewPolicy)
+	}
+	if d.CryptoWorkers < 0 {
+		return fmt.Errorf("config: Debug: CryptoWorkers '%v' is invalid", d.CryptoWorkers)
+	}
+	if d.ReconnectBackoff < 0 {
+		return fmt.Errorf("config: Debug: ReconnectBackoff '%v' is invalid", d.ReconnectBackoff)
+	}
+	if d.MaxReconnectBackoff < d.ReconnectBackoff {
+		return fmt.Errorf("config: Debug: MaxReconnectBackoff '%v' is invalid", d.MaxReconnectBackoff)
+	}
+	return nil
+}
+
+// SelfTest is the pre-run end-to-end self test configuration.  When
+// present, a small number of SURB probes are sent through the loop
+// service before any load is generated, and the session is aborted
+// if too few of them come back intact.
+type SelfTest struct {
+	// Probes is the number of SURB probes to send.
+	Probes int
+
+	// MinReplies is the minimum number of intact replies required for
+	// the self test to pass.  By default a single reply is sufficient.
+	MinReplies int
+
+	// Timeout is the number of seconds to wait for probe replies.
+	Timeout int
+}
+
+func (sCfg *SelfTest) fixup() {
+	if sCfg.Probes == 0 {
+		sCfg.Probes = defaultSelfTestProbes
+	}
+	if sCfg.MinReplies == 0 {
+		sCfg.MinReplies = 1
+	}
+	if sCfg.Timeout == 0 {
+		sCfg.Timeout = defaultSelfTestTimeout
+	}
+}
+
+func (sCfg *SelfTest) validate() error {
+	if sCfg.Probes < 0 {
+		return fmt.Errorf("config: SelfTest: Probes '%v' is invalid", sCfg.Probes)
+	}
+	if sCfg.MinReplies < 0 || sCfg.MinReplies > sCfg.Probes {
+		return fmt.Errorf("config: SelfTest: MinReplies '%v' is invalid", sCfg.MinReplies)
+	}
+	if sCfg.Timeout < 0 {
+		return fmt.Errorf("config: SelfTest: Timeout '%v' is invalid", sCfg.Timeout)
+	}
+	return nil
+}
+
+// Memspool is the memspool probe mode configuration.
+type Memspool struct {
+	// Operations is the total number of spool operations to perform,
+	// zero means to keep going until halted.
+	Operations int
+
+	// CreateWeight, AppendWeight and ReadWeight are the relative weights
+	// of each operation in the operation mix.  A spool is always created
+	// before any other operation is attempted.
+	CreateWeight int
+	AppendWeight int
+	ReadWeight   int
+
+	// MessageSize is the size in bytes of appended messages.
+	MessageSize int
+
+	// Timeout is the number of seconds to wait for each reply.
+	Timeout int
+}
+
+func (mCfg *Memspool) fixup() {
+	if mCfg.CreateWeight == 0 && mCfg.AppendWeight == 0 && mCfg.ReadWeight == 0 {
+		mCfg.AppendWeight = 1
+		mCfg.ReadWeight = 1
+	}
+	if mCfg.MessageSize == 0 {
+		mCfg.MessageSize = defaultMemspoolMessageSize
+	}
+	if mCfg.Timeout == 0 {
+		mCfg.Timeout = defaultMemspoolTimeout
+	}
+}
+
+func (mCfg *Memspool) validate() error {
+	if mCfg.Operations < 0 {
+		return fmt.Errorf("config: Memspool: Operations '%v' is invalid", mCfg.Operations)
+	}
+	if mCfg.CreateWeight < 0 || mCfg.AppendWeight < 0 || mCfg.ReadWeight < 0 {
+		return errors.New("config: Memspool: operation weights must not be negative")
+	}
+	if mCfg.MessageSize < 0 {
+		return fmt.Errorf("config: Memspool: MessageSize '%v' is invalid", mCfg.MessageSize)
+	}
+	if mCfg.Timeout < 0 {
+		return fmt.Errorf("config: Memspool: Timeout '%v' is invalid", mCfg.Timeout)
+	}
+	return nil
+}
+
+// PacketPool is the burst mode configuration.
+type PacketPool struct {
+	// Size is the number of packets precomposed at a time, by default
+	// 1000.
+	Size int
+}
+
+func (pCfg *PacketPool) fixup() {
+	if pCfg.Size == 0 {
+		pCfg.Size = defaultPacketPoolSize
+	}
+}
+
+func (pCfg *PacketPool) validate() error {
+	if pCfg.Size < 0 {
+		return fmt.Errorf("config: PacketPool: Size '%v' is invalid", pCfg.Size)
+	}
+	return nil
+}
+
+// ClosedLoop is the closed-loop configuration of the flood mode.  When
+// present, every target is sent SURB probes by Concurrency workers, each
+// of which only sends its next probe once the reply to the previous one
+// arrived or timed out, so that the latency is measured under a
+// controlled concurrency rather than an open-loop send rate, which still
+// applies as a cap.  The targets must reply to SURBs, as Kaetzchen
+// services such as the loop service do.
+type ClosedLoop struct {
+	// Concurrency is the number of probes outstanding per target, by
+	// default 1.
+	Concurrency int
+
+	// Timeout is the number of seconds to wait for each reply.
+	Timeout int
+}
+
+func (cCfg *ClosedLoop) fixup() {
+	if cCfg.Concurrency == 0 {
+		cCfg.Concurrency = defaultClosedLoopConcurrency
+	}
+	if cCfg.Timeout == 0 {
+		cCfg.Timeout = defaultClosedLoopTimeout
+	}
+}
+
+func (cCfg *ClosedLoop) validate(mode string) error {
+	if mode != ModeFlood {
+		return fmt.Errorf("config: ClosedLoop: Mode '%v' is not supported", mode)
+	}
+	if cCfg.Concurrency < 0 {
+		return fmt.Errorf("config: ClosedLoop: Concurrency '%v' is invalid", cCfg.Concurrency)
+	}
+	if cCfg.Timeout < 0 {
+		return fmt.Errorf("config: ClosedLoop: Timeout '%v' is invalid", cCfg.Timeout)
+	}
+	return nil
+}
+
+// Kaetzchen is the generic Kaetzchen probe mode configuration.
+type Kaetzchen struct {
+	// Probe is the name of the registered probe to use, by default the
+	// "echo" probe of the loop service.
+	Probe string
+
+	// Requests is the total number of requests to send, zero means to
+	// keep going until halted.
+	Requests int
+
+	// Timeout is the number of seconds to wait for each reply.
+	Timeout int
+
+	// EchoHMAC makes the echo probe authenticate replies with an HMAC
+	// under a per-run secret instead of comparing them byte for byte.
+	EchoHMAC bool
+}
+
+func (kCfg *Kaetzchen) fixup() {
+	if kCfg.Probe == "" {
+		kCfg.Probe = defaultKaetzchenProbe
+	}
+	if kCfg.Timeout == 0 {
+		kCfg.Timeout = defaultKaetzchenTimeout
+	}
+}
+
+func (kCfg *Kaetzchen) validate() error {
+	if kCfg.Requests < 0 {
+		return fmt.Errorf("config: Kaetzchen: Requests '%v' is invalid", kCfg.Requests)
+	}
+	if kCfg.Timeout < 0 {
+		return fmt.Errorf("config: Kaetzchen: Timeout '%v' is invalid", kCfg.Timeout)
+	}
+	return nil
+}
+
+// Report is the run report configuration.  Unless noted otherwise report
+// files are written when the Spray instance is shut down, and relative
+// paths are relative to the DataDir.
+type Report struct {
+	// Labels is a free form description of the run included in reports.
+	Labels string
+
+	// FortioFile is the path of the Fortio compatible JSON results file.
+	FortioFile string
+
+	// HdrHistogramFile is the path of the HdrHistogram (wrk2 style)
+	// latency percentile distribution file.
+	HdrHistogramFile string
+
+	// VegetaFile is the path of the file per-probe results are streamed
+	// to in vegeta's result encoding, as the run progresses.
+	VegetaFile string
+
+	// VegetaFormat is the vegeta encoding to use, "json" (the default)
+	// or "csv".
+	VegetaFormat string
+
+	// TimeSeriesFile is the path of the file a record per probe, with
+	// its send time, SURB ID, target, ack time, latency and outcome, is
+	// streamed to as the run progresses.
+	TimeSeriesFile string
+
+	// TimeSeriesFormat is the encoding of the TimeSeriesFile, "csv" (the
+	// default) or "ndjson".
+	TimeSeriesFormat string
+
+	// TopologyFile is the path of the file the PKI topology is written
+	// to when the run starts, in Graphviz DOT format if it has a ".dot"
+	// extension and JSON otherwise.
+	TopologyFile string
+
+	// SLO is a comma separated list of service level objectives such as
+	// "p99<30s,loss<1%", evaluated at the end of the run.  The verdict
+	// is printed to stdout, and a failed verdict is reported by
+	// Spray.SLOVerdict so that callers can exit nonzero.
+	SLO string
+
+	// EmissionsFile is the path of the CSV file the emission time of
+	// every packet sent is streamed to, as "unix_nanoseconds,class,length"
+	// records where the class is either "real" or "decoy".
+	EmissionsFile string
+
+	// CoverTrafficFile is the path of the JSON cover traffic analysis,
+	// with the inter-departure distributions of real and decoy packets
+	// and KS tests against the configured Poisson parameters.
+	CoverTrafficFile string
+
+	// WireFile is the path of the wire traffic capture, recording the
+	// time, direction and size of every read and write on the
+	// connections to the Provider, in the pcapng format if it has a
+	// ".pcapng" extension and CSV otherwise.
+	WireFile string
+
+	// HeatmapInterval is the duration in seconds of the time buckets of
+	// the latency heatmap included in the report, zero disabling it.
+	HeatmapInterval int
+
+	// HeatmapFile is the path of the CSV file the latency heatmap is
+	// written to, if HeatmapInterval is set.
+	HeatmapFile string
+
+	// RunsDir is the directory, relative to the DataDir, holding a
+	// directory per run named after its start time and run ID, with a
+	// "manifest.json" describing the run written when it starts, the
+	// events of the run streamed to "events.ndjson", and the full run
+	// report written to "report.json" when it ends.  If set, the
+	// relative paths of the report and session output files are
+	// relative to the run's directory.
+	RunsDir string
+
+	// RunsKeep is the number of the most recent run directories kept
+	// in the RunsDir, the older ones being removed when a run starts,
+	// zero keeping them all.
+	RunsKeep int
+
+	// RunsMaxAge is the number of days after which run directories are
+	// removed from the RunsDir when a run starts, zero keeping them
+	// regardless of their age.
+	RunsMaxAge int
+
+	// SweepFile is the path of the JSON aggregate of the runs of a
+	// Sweep.
+	SweepFile string
+
+	// SummaryFile is the path of the JSON summary written when the run
+	// ends, with the packet counts, loss, latency percentiles, error
+	// counts, SLO verdict and a snapshot of the configuration.
+	SummaryFile string
+
+	// HTMLFile is the path of the standalone HTML report written when
+	// the run ends, for sharing the results.
+	HTMLFile string
+}
+
+func (rCfg *Report) validate() error {
+	switch rCfg.VegetaFormat {
+	case "", "json", "csv":
+	default:
+		return fmt.Errorf("config: Report: VegetaFormat '%v' is invalid", rCfg.VegetaFormat)
+	}
+	switch rCfg.TimeSeriesFormat {
+	case "", report.TimeSeriesCSV, report.TimeSeriesNDJSON:
+	default:
+		return fmt.Errorf("config: Report: TimeSeriesFormat '%v' is invalid", rCfg.TimeSeriesFormat)
+	}
+	if rCfg.SLO != "" {
+		if _, err := report.ParseSLO(rCfg.SLO); err != nil {
+			return fmt.Errorf("config: Report: SLO is invalid: %v", err)
+		}
+	}
+	if rCfg.HeatmapInterval < 0 {
+		return fmt.Errorf("config: Report: HeatmapInterval '%v' is invalid", rCfg.HeatmapInterval)
+	}
+	if rCfg.HeatmapFile != "" && rCfg.HeatmapInterval == 0 {
+		return errors.New("config: Report: HeatmapFile requires a HeatmapInterval")
+	}
+	if rCfg.RunsKeep < 0 {
+		return fmt.Errorf("config: Report: RunsKeep '%v' is invalid", rCfg.RunsKeep)
+	}
+	if rCfg.RunsMaxAge < 0 {
+		return fmt.Errorf("config: Report: RunsMaxAge '%v' is invalid", rCfg.RunsMaxAge)
+	}
+	if (rCfg.RunsKeep > 0 || rCfg.RunsMaxAge > 0) && rCfg.RunsDir == "" {
+		return errors.New("config: Report: RunsKeep and RunsMaxAge require a RunsDir")
+	}
+	return nil
+}
+
+// Sink is the configuration of an output sink, a result backend
+// registered by name.
+type Sink struct {
+	// Name is the name the output sink was registered with.
+	Name string
+
+	// Options are the options of the output sink, interpreted by the
+	// sink.
+	Options map[string]string
+}
+
+func (s *Sink) validate() error {
+	if s.Name == "" {
+		return errors.New("Name is missing")
+	}
+	return nil
+}
+
+// Shaper is the link shaping configuration, emulating a client on a slow
+// or high latency link.
+type Shaper struct {
+	// Rate is the bandwidth limit in bytes per second in each direction,
+	// zero means unlimited.
+	Rate int
+
+	// Burst is the number of bytes that may be sent or received at once
+	// in excess of Rate, by default Rate.
+	Burst int
+
+	// Latency is the number of milliseconds added to every write.
+	Latency int
+
+	// Jitter is the maximum random deviation from Latency in
+	// milliseconds.
+	Jitter int
+}
+
+func (sCfg *Shaper) fixup() {
+	if sCfg.Burst == 0 {
+		sCfg.Burst = sCfg.Rate
+	}
+}
+
+func (sCfg *Shaper) validate() error {
+	if sCfg.Rate < 0 {
+		return fmt.Errorf("config: Shaper: Rate '%v' is invalid", sCfg.Rate)
+	}
+	if sCfg.Burst < 0 {
+		return fmt.Errorf("config: Shaper: Burst '%v' is invalid", sCfg.Burst)
+	}
+	if sCfg.Latency < 0 {
+		return fmt.Errorf("config: Shaper: Latency '%v' is invalid", sCfg.Latency)
+	}
+	if sCfg.Jitter < 0 || sCfg.Jitter > sCfg.Latency {
+		return fmt.Errorf("config: Shaper: Jitter '%v' is invalid", sCfg.Jitter)
+	}
+	return nil
+}
+
+// Churn model duration distributions.
+const (
+	// ChurnExponential draws exponentially distributed durations.
+	ChurnExponential = "exponential"
+
+	// ChurnUniform draws durations uniformly distributed between zero
+	// and twice the mean.
+	ChurnUniform = "uniform"
+
+	// ChurnConstant always uses the mean.
+	ChurnConstant = "constant"
+)
+
+// Churn is the intermittent connectivity configuration.  When present
+// the session is alternately connected and cleanly disconnected from
+// the Provider, emulating mobile or flaky clients.  Load generation is
+// paused while disconnected.
+type Churn struct {
+	// OnDistribution is the distribution of the connected durations,
+	// "exponential" (the default), "uniform" or "constant".
+	OnDistribution string
+
+	// OnMean is the mean connected duration in seconds.
+	OnMean float64
+
+	// OffDistribution is the distribution of the disconnected
+	// durations.
+	OffDistribution string
+
+	// OffMean is the mean disconnected duration in seconds.
+	OffMean float64
+}
+
+func (cCfg *Churn) fixup() {
+	if cCfg.OnDistribution == "" {
+		cCfg.OnDistribution = ChurnExponential
+	}
+	if cCfg.OffDistribution == "" {
+		cCfg.OffDistribution = ChurnExponential
+	}
+}
+
+func (cCfg *Churn) validate() error {
+	for _, d := range []string{cCfg.OnDistribution, cCfg.OffDistribution} {
+		switch d {
+		case ChurnExponential, ChurnUniform, ChurnConstant:
+		default:
+			return fmt.Errorf("config: Churn: distribution '%v' is invalid", d)
+		}
+	}
+	if cCfg.OnMean <= 0 {
+		return fmt.Errorf("config: Churn: OnMean '%v' is invalid", cCfg.OnMean)
+	}
+	if cCfg.OffMean <= 0 {
+		return fmt.Errorf("config: Churn: OffMean '%v' is invalid", cCfg.OffMean)
+	}
+	return nil
+}
+
+// Phase is a phase of a scheduled run.
+type Phase struct {
+	// Name is the name of the phase, used in logs and events.
+	Name string
+
+	// SendRate is the send rate limit of the phase in packets per
+	// second, zero means the Debug SendRate.
+	SendRate float64
+
+	// Duration is the number of seconds the phase lasts, if the
+	// schedule is not aligned to epochs.
+	Duration int
+
+	// Epochs is the number of epochs the phase lasts, if the schedule
+	// is aligned to epochs.
+	Epochs int
+
+	// Hops is the number of hops of the paths of the phase, including
+	// both Providers, zero meaning the Geometry NrHops.  Fewer hops
+	// than that require a link protocol able to skip mix layers.
+	Hops int
+}
+
+// Sweep is the seeded sweep configuration.  The same scenario is run
+// Runs times, each run with the next seed, and the runs are aggregated.
+type Sweep struct {
+	// Runs is the number of runs.
+	Runs int
+
+	// Seed is the Debug Seed of the first run, by default 1.
+	Seed int64
+
+	// Duration is the number of seconds every run lasts.  Without it
+	// the runs last as long as the Schedule, the Ramp, the Staircase,
+	// the Traffic RunDuration or MaxPackets, or in the drain mode until
+	// the spool is empty.
+	Duration int
+}
+
+func (sCfg *Sweep) fixup() {
+	if sCfg.Seed == 0 {
+		sCfg.Seed = 1
+	}
+}
+
+func (sCfg *Sweep) validate(cfg *Config) error {
+	if sCfg.Runs <= 0 {
+		return fmt.Errorf("config: Sweep: Runs '%v' is invalid", sCfg.Runs)
+	}
+	if sCfg.Duration < 0 {
+		return fmt.Errorf("config: Sweep: Duration '%v' is invalid", sCfg.Duration)
+	}
+	if sCfg.Duration == 0 && cfg.Schedule == nil && cfg.Ramp == nil && cfg.Staircase == nil && !cfg.Traffic.Limited() && cfg.Debug.Mode != ModeDrain {
+		return errors.New("config: Sweep: Duration is required without a Schedule, Ramp, Staircase, RunDuration or MaxPackets")
+	}
+	return nil
+}
+
+// Schedule is the run schedule configuration.  The phases are run in
+// order, and the run ends after the last one.
+type Schedule struct {
+	// AlignToEpochs delays the start of the run to the next epoch
+	// boundary, and makes phases last whole epochs instead of a
+	// wall clock Duration.
+	AlignToEpochs bool
+
+	// GateTargets checks before every phase that the Provider of every
+	// target is still in the PKI document, and with Discovery that it
+	// still offers the directory service, and skips the other targets
+	// for the phase instead of counting their probes as lost.  If no
+	// target is left the phase is run paused.
+	GateTargets bool
+
+	// SlowStart is the number of seconds over the start of every phase
+	// during which the send rate is raised linearly from the rate of the
+	// previous phase, or zero for the first one, to the rate of the
+	// phase, to avoid instantaneous rate steps.
+	SlowStart int
+
+	// Phases are the phases of the run.
+	Phases []*Phase
+}
+
+func (sCfg *Schedule) validate(nrHops int) error {
+	if len(sCfg.Phases) == 0 {
+		return errors.New("config: Schedule: no Phases")
+	}
+	if sCfg.SlowStart < 0 {
+		return fmt.Errorf("config: Schedule: SlowStart '%v' is invalid", sCfg.SlowStart)
+	}
+	for i, p := range sCfg.Phases {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("phase %d", i)
+		}
+		if p.SendRate < 0 {
+			return fmt.Errorf("config: Schedule: Phase '%v': SendRate '%v' is invalid", p.Name, p.SendRate)
+		}
+		if sCfg.AlignToEpochs && p.Epochs <= 0 {
+			return fmt.Errorf("config: Schedule: Phase '%v': Epochs '%v' is invalid", p.Name, p.Epochs)
+		}
+		if !sCfg.AlignToEpochs && (p.Duration <= 0 || p.Duration <= sCfg.SlowStart) {
+			return fmt.Errorf("config: Schedule: Phase '%v': Duration '%v' is invalid", p.Name, p.Duration)
+		}
+		if p.Hops != 0 && (p.Hops < 2 || p.Hops > nrHops) {
+			return fmt.Errorf("config: Schedule: Phase '%v': Hops '%v' is invalid", p.Name, p.Hops)
+		}
+	}
+	return nil
+}
+
+// Loop is the decoy loop configuration.  When present, loop messages
+// are sent to the loop service of our own Provider alongside the load
+// of any mode, and their round trip times and drop rates are accounted
+// for separately from the other probes.
+type Loop struct {
+	// Rate is the mean number of loop messages sent per second, with
+	// exponentially distributed intervals.  By default the LambdaL
+	// parameter of the current PKI document is used.
+	Rate float64
+
+	// Timeout is the number of seconds after which a loop message is
+	// considered dropped.
+	Timeout int
+}
+
+func (lCfg *Loop) fixup() {
+	if lCfg.Timeout == 0 {
+		lCfg.Timeout = defaultLoopTimeout
+	}
+}
+
+func (lCfg *Loop) validate() error {
+	if lCfg.Rate < 0 {
+		return fmt.Errorf("config: Loop: Rate '%v' is invalid", lCfg.Rate)
+	}
+	if lCfg.Timeout < 0 {
+		return fmt.Errorf("config: Loop: Timeout '%v' is invalid", lCfg.Timeout)
+	}
+	return nil
+}
+
+// Script is the scenario script configuration.  The script may drive
+// the rate, targets and payloads of the run through the "script" traffic
+// generator and react to probe results and epoch changes.
+type Script struct {
+	// File is the Starlark scenario script, relative to the DataDir.
+	File string
+}
+
+func (sCfg *Script) validate() error {
+	if sCfg.File == "" {
+		return errors.New("config: Script: File is missing")
+	}
+	return nil
+}
+
+// Vantage is the vantage point label of the agent, included in the
+// probe metadata and the reports, so that results from geographically
+// distributed agents can be analyzed by vantage point.
+type Vantage struct {
+	// Region is the region of the agent, such as "eu-west".
+	Region string
+
+	// ASN is the autonomous system number of the agent's network.
+	ASN int
+
+	// Note is a free form note.
+	Note string
+}
+
+func (vCfg *Vantage) validate() error {
+	if vCfg.ASN < 0 || int64(vCfg.ASN) > math.MaxUint32 {
+		return fmt.Errorf("config: Vantage: ASN '%v' is invalid", vCfg.ASN)
+	}
+	return nil
+}
+
+// Control is the local control socket configuration.
+type Control struct {
+	// Socket is the path of the UNIX domain control socket, relative
+	// to the DataDir.
+	Socket string
+
+	// HTTPAddress is the TCP address of the HTTP control interface,
+	// such as "127.0.0.1:9231", which additionally allows runs to be
+	// started and stopped.  As the interface is unauthenticated, the
+	// address must be a loopback address.
+	HTTPAddress string
+}
+
+func (cCfg *Control) validate() error {
+	if cCfg.Socket == "" && cCfg.HTTPAddress == "" {
+		return errors.New("config: Control: Socket or HTTPAddress is required")
+	}
+	if cCfg.HTTPAddress != "" {
+		host, _, err := net.SplitHostPort(cCfg.HTTPAddress)
+		if err != nil {
+			return fmt.Errorf("config: Control: HTTPAddress '%v' is invalid: %v", cCfg.HTTPAddress, err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("config: Control: HTTPAddress '%v' is not a loopback address", cCfg.HTTPAddress)
+		}
+	}
+	return nil
+}
+
+// Capture is the received message capture configuration, used to debug
+// Provider spool behavior and reply corruption.  Every received message
+// and SURB reply is written to its own file.
+type Capture struct {
+	// Dir is the directory the captured messages are written to,
+	// relative to the DataDir, by default "capture".
+	Dir string
+
+	// Ciphertext additionally captures the raw ciphertext of SURB
+	// replies, including the replies that fail to decrypt.
+	Ciphertext bool
+}
+
+func (cCfg *Capture) fixup() {
+	if cCfg.Dir == "" {
+		cCfg.Dir = defaultCaptureDir
+	}
+}
+
+// FaultInjection is the fault injection configuration, used to exercise
+// spray's own retry, drain and accounting logic.  It MUST NOT be enabled
+// for real measurements.
+type FaultInjection struct {
+	// ComposeFailureProbability is the probability that composing a
+	// Sphinx packet fails.
+	ComposeFailureProbability float64
+
+	// ACKDelayProbability is the probability that a received SURB ACK
+	// is held back for ACKDelay milliseconds before being processed.
+	ACKDelayProbability float64
+
+	// ACKDelay is the number of milliseconds delayed ACKs are held back.
+	ACKDelay int
+
+	// DocumentDropProbability is the probability that a newly received
+	// PKI document is silently dropped.
+	DocumentDropProbability float64
+}
+
+func (fCfg *FaultInjection) validate() error {
+	for name, p := range map[string]float64{
+		"ComposeFailureProbability": fCfg.ComposeFailureProbability,
+		"ACKDelayProbability":       fCfg.ACKDelayProbability,
+		"DocumentDropProbability":   fCfg.DocumentDropProbability,
+	} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("config: FaultInjection: %v '%v' is not a probability", name, p)
+		}
+	}
+	if fCfg.ACKDelay < 0 {
+		return fmt.Errorf("config: FaultInjection: ACKDelay '%v' is invalid", fCfg.ACKDelay)
+	}
+	return nil
 }
 
 // NonvotingAuthority is a non-voting authority configuration.
@@ -194,6 +1187,8 @@ func (vACfg *VotingAuthority) validate() error {
 // NewPKIClient returns a voting or nonvoting implementation of pki.Client or error
 func (c *Config) NewPKIClient(l *log.Backend) (pki.Client, error) {
 	switch {
+	case c.Mock != nil:
+		return c.Mock.newPKIClient(c)
 	case c.NonvotingAuthority != nil:
 		return c.NonvotingAuthority.New(l)
 	case c.VotingAuthority != nil:
@@ -202,6 +1197,44 @@ func (c *Config) NewPKIClient(l *log.Backend) (pki.Client, error) {
 	return nil, fmt.Errorf("No Authority found")
 }
 
+// PKISource is a named source of PKI documents.
+type PKISource struct {
+	// Name identifies the authority.
+	Name string
+
+	// Client fetches the documents of the authority.
+	Client pki.Client
+}
+
+// NewPKISources returns a PKI client for the nonvoting authority, if
+// any, and one for every voting authority peer on its own, so that the
+// documents they serve can be compared.
+func (c *Config) NewPKISources(l *log.Backend) ([]*PKISource, error) {
+	var sources []*PKISource
+	if nvACfg := c.NonvotingAuthority; nvACfg != nil {
+		client, err := nvACfg.New(l)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, &PKISource{Name: "nonvoting " + nvACfg.Address, Client: client})
+	}
+	if vACfg := c.VotingAuthority; vACfg != nil {
+		for i, peer := range vACfg.Peers {
+			peerCfg := &VotingAuthority{Peers: []*vServerConfig.AuthorityPeer{peer}}
+			client, err := peerCfg.New(l)
+			if err != nil {
+				return nil, err
+			}
+			name := fmt.Sprintf("voting peer %d", i)
+			if len(peer.Addresses) > 0 {
+				name = "voting " + peer.Addresses[0]
+			}
+			sources = append(sources, &PKISource{Name: name, Client: client})
+		}
+	}
+	return sources, nil
+}
+
 // Account is a provider account configuration.
 type Account struct {
 	// User is the account user name.
@@ -212,29 +1245,33 @@ type Account struct {
 
 	// ProviderKeyPin is the optional pinned provider signing key.
 	ProviderKeyPin *eddsa.PublicKey
+
+	// IdentifierFormat is the name of the format of the account
+	// identifier, "email" (the default) or "raw", or any format
+	// registered with RegisterIdentifierFormat.
+	IdentifierFormat string
+
+	identifier string
+}
+
+// Identifier returns the account identifier, which names its key
+// directory and its logs.
+func (accCfg *Account) Identifier() string {
+	return accCfg.identifier
 }
 
 func (accCfg *Account) fixup(cfg *Config) error {
-	var err error
-	if !cfg.Debug.CaseSensitiveUserIdentifiers {
-		accCfg.User, err = precis.UsernameCaseMapped.String(accCfg.User)
-	} else {
-		accCfg.User, err = precis.UsernameCasePreserved.String(accCfg.User)
+	if accCfg.IdentifierFormat == "" {
+		accCfg.IdentifierFormat = IdentifierEmail
 	}
+	f, err := getIdentifierFormat(accCfg.IdentifierFormat)
 	if err != nil {
 		return err
 	}
-
-	accCfg.Provider, err = idna.Lookup.ToASCII(accCfg.Provider)
-	return err
-}
-
-func (accCfg *Account) toEmailAddr() (string, error) {
-	addr := fmt.Sprintf("%s@%s", accCfg.User, accCfg.Provider)
-	if _, err := mail.ParseAddress(addr); err != nil {
-		return "", fmt.Errorf("User/Provider does not form a valid e-mail address: %v", err)
+	if accCfg.identifier, err = f(accCfg, cfg.Debug.CaseSensitiveUserIdentifiers); err != nil {
+		return err
 	}
-	return addr, nil
+	return validateDirName(accCfg.identifier)
 }
 
 func (accCfg *Account) validate(cfg *Config) error {
@@ -254,7 +1291,123 @@ type Config struct {
 	Debug              *Debug
 	NonvotingAuthority *NonvotingAuthority
 	VotingAuthority    *VotingAuthority
-	Account            *Account
+	SelfTest           *SelfTest
+	FaultInjection     *FaultInjection
+	Memspool           *Memspool
+	PacketPool         *PacketPool
+	ClosedLoop         *ClosedLoop
+	Kaetzchen          *Kaetzchen
+	Report             *Report
+	Geometry           *Geometry
+	Script             *Script
+	Control            *Control
+	Loop               *Loop
+	Schedule           *Schedule
+	Churn              *Churn
+	Shaper             *Shaper
+	Mock               *Mock
+	Capture            *Capture
+	Vantage            *Vantage
+	Mailproxy          *Mailproxy
+	Discovery          *Discovery
+	Metrics            *Metrics
+	Nodes              *Nodes
+	Traffic            *Traffic
+	AuthorityLoad      *AuthorityLoad
+	Sweep              *Sweep
+	Ramp               *Ramp
+	Staircase          *Staircase
+
+	// Target is the list of weighted load targets, from [[Target]]
+	// tables.
+	Target []*Target
+
+	// Sink is the list of output sinks the results are written to, from
+	// [[Sink]] tables.
+	Sink []*Sink
+
+	// Accounts are the accounts of the configuration, from either a
+	// single [Account] table or several [[Account]] tables, each of
+	// which gets its own session.  Account is the first of them, or
+	// the account of the session a configuration returned by
+	// ForAccount is for.
+	Accounts []*Account `toml:"-"`
+	Account  *Account   `toml:"-"`
+
+	// RunDir is the directory of the current run, if the Report RunsDir
+	// is set, which the relative paths of the session output files are
+	// relative to.
+	RunDir string `toml:"-" json:"-"`
+
+	targets []*Target
+}
+
+// ForAccount returns a copy of the configuration for the session of the
+// account a, one of the Accounts, with a Debug block of its own.
+func (c *Config) ForAccount(a *Account) *Config {
+	cp := *c
+	cp.Account = a
+	debug := *c.Debug
+	cp.Debug = &debug
+	return &cp
+}
+
+// WithSeed returns a copy of the configuration with the Debug Seed set
+// to seed, for a run of a sweep.
+func (c *Config) WithSeed(seed int64) *Config {
+	cp := *c
+	debug := *c.Debug
+	debug.Seed = seed
+	cp.Debug = &debug
+	return &cp
+}
+
+// WithTraffic returns a copy of the configuration with the traffic
+// parameters a running session may reload taken from r: the send rate
+// and burst, the payload size and the targets.
+func (c *Config) WithTraffic(r *Config) *Config {
+	cp := *c
+	debug := *c.Debug
+	debug.SendRate, debug.SendBurst = r.Debug.SendRate, r.Debug.SendBurst
+	debug.TargetsFile = r.Debug.TargetsFile
+	cp.Debug = &debug
+	traffic := *c.Traffic
+	traffic.PayloadSize = r.Traffic.PayloadSize
+	cp.Traffic = &traffic
+	cp.Target, cp.targets = r.Target, r.targets
+	return &cp
+}
+
+// Targets returns the weighted list of load targets, from either the
+// TargetsFile or the Target blocks.
+func (c *Config) Targets() []*Target {
+	return c.targets
+}
+
+// DataPath returns f made absolute, relative to the DataDir.
+func (c *Config) DataPath(f string) string {
+	f = cleanPath(f)
+	if filepath.IsAbs(f) {
+		return f
+	}
+	return filepath.Join(c.Proxy.DataDir, f)
+}
+
+// OutputPath returns the path of the session output file f, which is
+// DataPath(f), or relative to the RunDir if set, with the account
+// identifier appended if there are several accounts, so that their
+// sessions do not overwrite each other's files.
+func (c *Config) OutputPath(f string) string {
+	if f = cleanPath(f); c.RunDir != "" && !filepath.IsAbs(f) {
+		f = filepath.Join(c.RunDir, f)
+	} else {
+		f = c.DataPath(f)
+	}
+	if len(c.Accounts) <= 1 {
+		return f
+	}
+	ext := filepath.Ext(f)
+	return strings.TrimSuffix(f, ext) + "-" + c.Account.Identifier() + ext
 }
 
 // FixupAndValidate applies defaults to config entries and validates the
@@ -275,13 +1428,227 @@ func (c *Config) FixupAndValidate() error {
 	}
 
 	// Validate/fixup the various sections.
+	c.Proxy.fixup()
 	if err := c.Proxy.validate(); err != nil {
 		return err
 	}
 	if err := c.Logging.validate(); err != nil {
 		return err
 	}
+	if err := c.Debug.validate(); err != nil {
+		return err
+	}
+	if c.Proxy.DataDir == MemoryDataDir {
+		if err := c.validateMemoryPaths(); err != nil {
+			return err
+		}
+	}
+	if c.Debug.TargetProvider != "" || c.Debug.TargetRecipient != "" {
+		if len(c.Target) > 0 || c.Debug.TargetsFile != "" {
+			return errors.New("config: Debug: TargetProvider and TargetRecipient are deprecated and can not be combined with Target blocks or a TargetsFile, use a Target block instead")
+		}
+		c.Target = []*Target{{Provider: c.Debug.TargetProvider, Recipient: c.Debug.TargetRecipient}}
+		c.Debug.TargetProvider, c.Debug.TargetRecipient = "", ""
+	}
+	if c.Debug.TargetsFile != "" {
+		targets, err := LoadTargets(c.DataPath(c.Debug.TargetsFile))
+		if err != nil {
+			return err
+		}
+		c.targets = targets
+	} else {
+		for i, t := range c.Target {
+			if err := t.fixupAndValidate(); err != nil {
+				return fmt.Errorf("config: Target %d is invalid: %v", i, err)
+			}
+		}
+		c.targets = c.Target
+	}
+	for i, s := range c.Sink {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("config: Sink %d is invalid: %v", i, err)
+		}
+	}
+	switch c.Debug.Mode {
+	case ModeFlood, ModeMailboxSender, ModeComposeOnly, ModeBurst:
+		if len(c.targets) == 0 && c.Discovery == nil {
+			return errors.New("config: No Target block was present")
+		}
+	}
+	if c.Debug.Mode == ModeMemspool && c.Memspool == nil {
+		c.Memspool = new(Memspool)
+	}
+	if c.Memspool != nil {
+		c.Memspool.fixup()
+		if err := c.Memspool.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Debug.Mode == ModeBurst && c.PacketPool == nil {
+		c.PacketPool = new(PacketPool)
+	}
+	if c.PacketPool != nil {
+		c.PacketPool.fixup()
+		if err := c.PacketPool.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Debug.Mode == ModeKaetzchen && c.Kaetzchen == nil {
+		c.Kaetzchen = new(Kaetzchen)
+	}
+	if c.Kaetzchen != nil {
+		c.Kaetzchen.fixup()
+		if err := c.Kaetzchen.validate(); err != nil {
+			return err
+		}
+	}
+	if c.SelfTest != nil {
+		c.SelfTest.fixup()
+		if err := c.SelfTest.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Report != nil {
+		if err := c.Report.validate(); err != nil {
+			return err
+		}
+	}
+	if c.FaultInjection != nil {
+		if err := c.FaultInjection.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Script != nil {
+		if err := c.Script.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Shaper != nil {
+		c.Shaper.fixup()
+		if err := c.Shaper.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Churn != nil {
+		c.Churn.fixup()
+		if err := c.Churn.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ClosedLoop != nil {
+		c.ClosedLoop.fixup()
+		if err := c.ClosedLoop.validate(c.Debug.Mode); err != nil {
+			return err
+		}
+	}
+	if c.Loop != nil {
+		c.Loop.fixup()
+		if err := c.Loop.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Control != nil {
+		if err := c.Control.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Capture != nil {
+		c.Capture.fixup()
+	}
+	if c.Metrics != nil {
+		c.Metrics.fixup()
+		if err := c.Metrics.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Discovery != nil {
+		c.Discovery.fixup()
+		if err := c.Discovery.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Vantage != nil {
+		if err := c.Vantage.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Debug.Mode == ModeComposeOnly && (c.SelfTest != nil || c.Loop != nil) {
+		return errors.New("config: Debug: Mode 'compose-only' never sends, SelfTest and Loop must not be set")
+	}
+	if c.Debug.Mode == ModeAuthority {
+		if c.SelfTest != nil || c.Loop != nil || c.Churn != nil || c.Mock != nil {
+			return errors.New("config: Debug: Mode 'authority' never connects, SelfTest, Loop, Churn and Mock must not be set")
+		}
+		switch c.Debug.LinkProtocol {
+		case defaultLinkProtocol, OfflineLinkProtocol:
+			c.Debug.LinkProtocol = OfflineLinkProtocol
+		default:
+			return fmt.Errorf("config: Debug: LinkProtocol '%v' is invalid with Mode 'authority'", c.Debug.LinkProtocol)
+		}
+		if c.AuthorityLoad == nil {
+			c.AuthorityLoad = new(AuthorityLoad)
+		}
+	}
+	if c.AuthorityLoad != nil {
+		c.AuthorityLoad.fixup()
+		if err := c.AuthorityLoad.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Geometry == nil {
+		c.Geometry = new(Geometry)
+	}
+	c.Geometry.fixup()
+	if err := c.Geometry.validate(); err != nil {
+		return err
+	}
+	if c.Traffic == nil {
+		c.Traffic = new(Traffic)
+	}
+	c.Traffic.fixup(c.Geometry)
+	if err := c.Traffic.validate(c.Geometry); err != nil {
+		return err
+	}
+	if h := c.Debug.ReplyHops; h != 0 && (h < 2 || h > c.Geometry.NrHops) {
+		return fmt.Errorf("config: Debug: ReplyHops '%v' is invalid", h)
+	}
+	if c.Schedule != nil {
+		if err := c.Schedule.validate(c.Geometry.NrHops); err != nil {
+			return err
+		}
+	}
+	if c.Ramp != nil {
+		c.Ramp.fixup()
+		if err := c.Ramp.validate(c); err != nil {
+			return err
+		}
+	}
+	if c.Staircase != nil {
+		if err := c.Staircase.validate(c); err != nil {
+			return err
+		}
+	}
+	if c.Sweep != nil {
+		c.Sweep.fixup()
+		if err := c.Sweep.validate(c); err != nil {
+			return err
+		}
+	}
+	if c.Mock != nil {
+		c.Mock.fixup()
+		if err := c.Mock.validate(); err != nil {
+			return err
+		}
+		switch c.Debug.LinkProtocol {
+		case defaultLinkProtocol, MockLinkProtocol:
+			c.Debug.LinkProtocol = MockLinkProtocol
+		default:
+			return fmt.Errorf("config: Debug: LinkProtocol '%v' is invalid with a Mock block", c.Debug.LinkProtocol)
+		}
+	}
 	switch {
+	case c.Mock != nil:
+		// The simulated network has no authority.
 	case c.NonvotingAuthority == nil && c.VotingAuthority != nil:
 		if err := c.VotingAuthority.validate(); err != nil {
 			return fmt.Errorf("config: NonvotingAuthority is invalid: %s", err)
@@ -290,35 +1657,110 @@ func (c *Config) FixupAndValidate() error {
 		if err := c.NonvotingAuthority.validate(); err != nil {
 			return fmt.Errorf("config: NonvotingAuthority is invalid: %s", err)
 		}
+	case c.NonvotingAuthority != nil && c.Debug.Mode == ModeCrossCheck:
+		// Both authorities are cross-checked, the nonvoting authority
+		// serves the session.
+		if err := c.NonvotingAuthority.validate(); err != nil {
+			return fmt.Errorf("config: NonvotingAuthority is invalid: %s", err)
+		}
+		if err := c.VotingAuthority.validate(); err != nil {
+			return fmt.Errorf("config: VotingAuthority is invalid: %s", err)
+		}
 	default:
 		return fmt.Errorf("config: Authority configuration is invalid")
 	}
+	if c.Debug.Mode == ModeCrossCheck {
+		n := 0
+		if c.NonvotingAuthority != nil {
+			n++
+		}
+		if c.VotingAuthority != nil {
+			n += len(c.VotingAuthority.Peers)
+		}
+		if n < 2 {
+			return errors.New("config: Debug: Mode 'crosscheck' requires at least two authorities")
+		}
+	}
 
-	// account
-	if err := c.Account.fixup(c); err != nil {
-		return fmt.Errorf("config: Account is invalid (User): %v", err)
+	// accounts
+	if len(c.Accounts) == 0 && c.Account != nil {
+		c.Accounts = []*Account{c.Account}
 	}
-	addr, err := c.Account.toEmailAddr()
-	if err != nil {
-		return fmt.Errorf("config: Account is invalid (Identifier): %v", err)
+	if len(c.Accounts) == 0 {
+		return errors.New("config: No Account block was present")
 	}
-	if err := c.Account.validate(c); err != nil {
-		return fmt.Errorf("config: Account '%v' is invalid: %v", addr, err)
+	c.Account = c.Accounts[0]
+	ids := make(map[string]bool)
+	for _, a := range c.Accounts {
+		if err := a.fixup(c); err != nil {
+			return fmt.Errorf("config: Account is invalid (Identifier): %v", err)
+		}
+		if err := a.validate(c); err != nil {
+			return fmt.Errorf("config: Account '%v' is invalid: %v", a.Identifier(), err)
+		}
+		if ids[a.Identifier()] {
+			return fmt.Errorf("config: Account '%v' is configured more than once", a.Identifier())
+		}
+		ids[a.Identifier()] = true
+		if c.Nodes != nil {
+			if err := c.Nodes.validate(a); err != nil {
+				return err
+			}
+		}
 	}
 
+	if c.Debug.Mode == ModeMailproxy {
+		if c.Mailproxy == nil {
+			return errors.New("config: Debug: Mode 'mailproxy' requires a Mailproxy block")
+		}
+		if c.Debug.TrafficGenerator == defaultTrafficGenerator {
+			c.Debug.TrafficGenerator = MailproxyTrafficGenerator
+		}
+	}
+	if c.Mailproxy != nil {
+		c.Mailproxy.fixup(c)
+		if err := c.Mailproxy.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Load parses and validates the provided buffer b as a config file body and
 // returns the Config.
 func Load(b []byte, forceGenOnly bool) (*Config, error) {
+	return LoadWithOverrides(b, forceGenOnly, nil)
+}
+
+// LoadWithOverrides is Load, calling overrides, if not nil, on the parsed
+// Config before it is validated, such as to apply command line flags.
+func LoadWithOverrides(b []byte, forceGenOnly bool, overrides func(*Config)) (*Config, error) {
 	cfg := new(Config)
 	md, err := toml.Decode(string(b), cfg)
 	if err != nil {
 		return nil, err
 	}
-	if undecoded := md.Undecoded(); len(undecoded) != 0 {
-		return nil, fmt.Errorf("config: Undecoded keys in config file: %v", undecoded)
+	accounts, accountsMD, err := decodeAccounts(string(b))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Accounts = accounts
+	var undecoded []toml.Key
+	for _, k := range md.Undecoded() {
+		if k[0] != accountTable {
+			undecoded = append(undecoded, k)
+		}
+	}
+	for _, k := range accountsMD.Undecoded() {
+		if k[0] == accountTable {
+			undecoded = append(undecoded, k)
+		}
+	}
+	if len(undecoded) != 0 {
+		return nil, undecodedError(undecoded)
+	}
+	if overrides != nil {
+		overrides(cfg)
 	}
 	if err := cfg.FixupAndValidate(); err != nil {
 		return nil, err
@@ -329,16 +1771,66 @@ func Load(b []byte, forceGenOnly bool) (*Config, error) {
 	return cfg, nil
 }
 
-// GenerateKeys makes the key dir and then
+// accountTable is the name of the account table(s) of config files.
+const accountTable = "Account"
+
+// decodeAccounts decodes either a single [Account] table or an array of
+// [[Account]] tables from the config file body s.
+func decodeAccounts(s string) ([]*Account, toml.MetaData, error) {
+	var multi struct {
+		Account []*Account
+	}
+	md, err := toml.Decode(s, &multi)
+	if err == nil {
+		return multi.Account, md, nil
+	}
+	var single struct {
+		Account *Account
+	}
+	md, sErr := toml.Decode(s, &single)
+	if sErr != nil {
+		return nil, md, err
+	}
+	if single.Account == nil {
+		return nil, md, nil
+	}
+	return []*Account{single.Account}, md, nil
+}
+
+// GenerateKeys makes the key dir of every account and then
 // generates the keys and saves them into pem files
 func GenerateKeys(cfg *Config) error {
-	id := cfg.Account.User + "@" + cfg.Account.Provider
+	for _, a := range cfg.Accounts {
+		if err := generateKeys(cfg.ForAccount(a)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func generateKeys(cfg *Config) error {
+	if cfg.Proxy.Ephemeral() {
+		return errors.New("config: keys can not be generated without a writable DataDir")
+	}
+	id := cfg.Account.Identifier()
 	basePath := filepath.Join(cfg.Proxy.DataDir, id)
-	if err := utils.MkDataDir(basePath); err != nil {
+	if err := MkDataDir(basePath); err != nil {
 		return err
 	}
-	_, err := LoadLinkKey(basePath)
-	return err
+	if _, err := LoadLinkKey(basePath); err != nil {
+		return err
+	}
+	if cfg.Debug.LinkKeyType == LinkKeyX25519Kyber768 {
+		if _, err := LoadLinkKEMKey(basePath); err != nil {
+			return err
+		}
+	}
+	if cfg.Mailproxy != nil {
+		if _, err := LoadIdentityKey(basePath); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // LoadLinkKey can load or generate the keys
@@ -362,3 +1854,13 @@ func LoadFile(f string, forceGenOnly bool) (*Config, error) {
 	}
 	return Load(b, forceGenOnly)
 }
+
+// LoadFileWithOverrides is LoadFile with the overrides of
+// LoadWithOverrides.
+func LoadFileWithOverrides(f string, forceGenOnly bool, overrides func(*Config)) (*Config, error) {
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return LoadWithOverrides(b, forceGenOnly, overrides)
+}
@@ -0,0 +1,39 @@
+// config_test.go - configuration tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import "testing"
+
+func TestConfigCopies(t *testing.T) {
+	cfg := &Config{Debug: &Debug{Seed: 1, SendRate: 10}}
+	alice, bob := &Account{User: "alice"}, &Account{User: "bob"}
+
+	a := cfg.ForAccount(alice)
+	b := cfg.ForAccount(bob)
+	a.Debug.Seed = 2
+	if cfg.Debug.Seed != 1 || b.Debug.Seed != 1 {
+		t.Errorf("the Debug block of an account is shared: %d, %d", cfg.Debug.Seed, b.Debug.Seed)
+	}
+	if a.Account != alice || b.Account != bob || a.Debug.SendRate != 10 {
+		t.Errorf("ForAccount did not copy the configuration")
+	}
+
+	s := cfg.WithSeed(3)
+	if s.Debug.Seed != 3 || cfg.Debug.Seed != 1 || s.Debug.SendRate != 10 {
+		t.Errorf("WithSeed set seed %d, left %d", s.Debug.Seed, cfg.Debug.Seed)
+	}
+}
@@ -0,0 +1,71 @@
+// discovery.go - recipient discovery configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+)
+
+const (
+	defaultDiscoveryCapability = "userdir"
+	defaultDiscoveryTimeout    = 60
+)
+
+// Discovery is the recipient discovery configuration.  When present, the
+// load targets are the recipients listed by the user directory Kaetzchen
+// service of every Provider that advertises one, instead of the
+// configured targets.
+type Discovery struct {
+	// Capability is the Kaetzchen capability of the user directory
+	// service, "userdir" by default.
+	Capability string
+
+	// Providers restricts discovery to the named Providers.  By default
+	// every Provider advertising the capability is queried.
+	Providers []string
+
+	// MaxRecipients is the maximum number of recipients to target per
+	// Provider, zero meaning no limit.
+	MaxRecipients int
+
+	// Timeout is the number of seconds to wait for each directory reply.
+	Timeout int
+}
+
+func (dCfg *Discovery) fixup() {
+	if dCfg.Capability == "" {
+		dCfg.Capability = defaultDiscoveryCapability
+	}
+	if dCfg.Timeout == 0 {
+		dCfg.Timeout = defaultDiscoveryTimeout
+	}
+}
+
+func (dCfg *Discovery) validate() error {
+	for _, p := range dCfg.Providers {
+		if p == "" {
+			return fmt.Errorf("config: Discovery: Providers contains an empty name")
+		}
+	}
+	if dCfg.MaxRecipients < 0 {
+		return fmt.Errorf("config: Discovery: MaxRecipients '%v' is invalid", dCfg.MaxRecipients)
+	}
+	if dCfg.Timeout < 0 {
+		return fmt.Errorf("config: Discovery: Timeout '%v' is invalid", dCfg.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// ephemeral.go - in-memory state for read-only data directories
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"git.schwanenlied.me/yawning/kyber.git"
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+)
+
+// MemoryDataDir is the DataDir of the ephemeral mode, keeping the keys
+// and any other state in memory only, for hosts without writable
+// storage.
+const MemoryDataDir = ":memory:"
+
+// Ephemeral returns true if the keys and any other state are kept in
+// memory only, as the DataDir is MemoryDataDir or is not writable.
+func (pCfg *Proxy) Ephemeral() bool {
+	return pCfg.ephemeral
+}
+
+// InitDataDir creates the DataDir.  If the DataDir is MemoryDataDir or
+// can not be written to, the configuration switches to the ephemeral
+// mode instead, and the reason is returned as notice.
+func (c *Config) InitDataDir() (notice string, err error) {
+	pCfg := c.Proxy
+	if pCfg.DataDir == MemoryDataDir {
+		pCfg.ephemeral = true
+		return "DataDir is " + MemoryDataDir + ", keeping all state in memory.", nil
+	}
+	if err = MkDataDir(pCfg.DataDir); err != nil {
+		if !os.IsPermission(err) && !isReadOnly(err) {
+			return "", err
+		}
+	} else if err = probeWritable(pCfg.DataDir); err == nil {
+		return "", nil
+	}
+	pCfg.ephemeral = true
+	return fmt.Sprintf("DataDir '%v' is not writable (%v), keeping all state in memory.", pCfg.DataDir, err), nil
+}
+
+// validateMemoryPaths checks that the files read, or used by the process
+// as a whole, are given absolute paths with the MemoryDataDir, which
+// there are no paths relative to.
+func (c *Config) validateMemoryPaths() error {
+	paths := []struct{ field, path string }{
+		{"Debug: TargetsFile", c.Debug.TargetsFile},
+		{"Logging: File", c.Logging.File},
+	}
+	if c.Script != nil {
+		paths = append(paths, struct{ field, path string }{"Script: File", c.Script.File})
+	}
+	if c.Control != nil {
+		paths = append(paths, struct{ field, path string }{"Control: Socket", c.Control.Socket})
+	}
+	for _, p := range paths {
+		if p.path != "" && !filepath.IsAbs(cleanPath(p.path)) {
+			return fmt.Errorf("config: %v '%v' is not an absolute path, which the %v DataDir requires", p.field, p.path, MemoryDataDir)
+		}
+	}
+	return nil
+}
+
+// isReadOnly returns true if err is caused by a read-only file system.
+func isReadOnly(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.EROFS
+}
+
+// probeWritable checks that a file can be created in dir.
+func probeWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".spray-probe")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// keyPath returns the path of the key file f of the account key
+// directory basePath in the ephemeral mode, or "" if there is no such
+// file to load.
+func keyPath(basePath, f string) string {
+	if f = filepath.Join(basePath, f); fileExists(f) {
+		return f
+	}
+	return ""
+}
+
+// EphemeralLinkKey loads the link key from basePath if it exists, and
+// otherwise generates one which is kept in memory only.
+func EphemeralLinkKey(basePath string) (*ecdh.PrivateKey, error) {
+	priv, pub := keyPath(basePath, "link.private.pem"), keyPath(basePath, "link.public.pem")
+	if priv != "" && pub != "" {
+		return ecdh.Load(priv, pub, rand.Reader)
+	}
+	return ecdh.NewKeypair(rand.Reader)
+}
+
+// EphemeralLinkKEMKey loads the Kyber768 half of a hybrid link key from
+// basePath if it exists, and otherwise generates one which is kept in
+// memory only.
+func EphemeralLinkKEMKey(basePath string) (*kyber.PrivateKey, error) {
+	priv, pub := keyPath(basePath, "link.kyber768.private.pem"), keyPath(basePath, "link.kyber768.public.pem")
+	if priv != "" && pub != "" {
+		return loadKEMKey(priv, pub)
+	}
+	_, key, err := kyber.Kyber768.GenerateKeyPair(rand.Reader)
+	return key, err
+}
+
+// EphemeralIdentityKey loads the mailproxy identity key from basePath if
+// it exists, and otherwise generates one which is kept in memory only.
+func EphemeralIdentityKey(basePath string) (*ecdh.PrivateKey, error) {
+	priv, pub := keyPath(basePath, "identity.private.pem"), keyPath(basePath, "identity.public.pem")
+	if priv != "" && pub != "" {
+		return ecdh.Load(priv, pub, rand.Reader)
+	}
+	return ecdh.NewKeypair(rand.Reader)
+}
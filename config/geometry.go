@@ -0,0 +1,80 @@
+// geometry.go - Sphinx geometry configuration.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+
+	coreconstants "github.com/katzenpost/core/constants"
+	"github.com/katzenpost/core/sphinx/constants"
+)
+
+// Geometry is the Sphinx packet geometry of the target network.  Any
+// field left unset defaults to the geometry spray was compiled with.
+type Geometry struct {
+	// PacketLength is the length of a Sphinx packet in bytes.
+	PacketLength int
+
+	// UserForwardPayloadLength is the length of the user payload of a
+	// forward packet in bytes.
+	UserForwardPayloadLength int
+
+	// NrHops is the number of hops of a path, including both
+	// Providers.
+	NrHops int
+}
+
+// DefaultGeometry returns the geometry spray was compiled with.
+func DefaultGeometry() *Geometry {
+	return &Geometry{
+		PacketLength:             constants.PacketLength,
+		UserForwardPayloadLength: coreconstants.UserForwardPayloadLength,
+		NrHops:                   constants.NrHops,
+	}
+}
+
+// IsDefault returns true if the geometry is the one spray was compiled
+// with.
+func (g *Geometry) IsDefault() bool {
+	return *g == *DefaultGeometry()
+}
+
+func (g *Geometry) fixup() {
+	def := DefaultGeometry()
+	if g.PacketLength == 0 {
+		g.PacketLength = def.PacketLength
+	}
+	if g.UserForwardPayloadLength == 0 {
+		g.UserForwardPayloadLength = def.UserForwardPayloadLength
+	}
+	if g.NrHops == 0 {
+		g.NrHops = def.NrHops
+	}
+}
+
+func (g *Geometry) validate() error {
+	if g.PacketLength < 0 {
+		return fmt.Errorf("config: Geometry: PacketLength '%v' is invalid", g.PacketLength)
+	}
+	if g.UserForwardPayloadLength <= 0 || g.UserForwardPayloadLength >= g.PacketLength {
+		return fmt.Errorf("config: Geometry: UserForwardPayloadLength '%v' is invalid", g.UserForwardPayloadLength)
+	}
+	if g.NrHops < 2 {
+		return fmt.Errorf("config: Geometry: NrHops '%v' is invalid", g.NrHops)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// identifier.go - account identifier formats
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/secure/precis"
+)
+
+// Account identifier formats.
+const (
+	// IdentifierEmail normalizes the User and Provider and requires
+	// them to form a valid e-mail address.
+	IdentifierEmail = "email"
+
+	// IdentifierRaw uses the User and Provider as is, for lab setups
+	// with names that are not valid e-mail addresses.
+	IdentifierRaw = "raw"
+)
+
+// IdentifierFormat normalizes the User and Provider of an account and
+// returns its identifier, which names its key directory and its logs.
+// caseSensitive is the Debug CaseSensitiveUserIdentifiers setting.
+type IdentifierFormat func(accCfg *Account, caseSensitive bool) (string, error)
+
+var (
+	identifierFormatsLock sync.Mutex
+	identifierFormats     = map[string]IdentifierFormat{
+		IdentifierEmail: emailIdentifier,
+		IdentifierRaw:   rawIdentifier,
+	}
+)
+
+// RegisterIdentifierFormat registers an account identifier format by
+// name, for use with the Account IdentifierFormat setting.
+func RegisterIdentifierFormat(name string, f IdentifierFormat) {
+	identifierFormatsLock.Lock()
+	defer identifierFormatsLock.Unlock()
+	identifierFormats[name] = f
+}
+
+func getIdentifierFormat(name string) (IdentifierFormat, error) {
+	identifierFormatsLock.Lock()
+	defer identifierFormatsLock.Unlock()
+	f, ok := identifierFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown identifier format: %v", name)
+	}
+	return f, nil
+}
+
+func emailIdentifier(accCfg *Account, caseSensitive bool) (string, error) {
+	var err error
+	if !caseSensitive {
+		accCfg.User, err = precis.UsernameCaseMapped.String(accCfg.User)
+	} else {
+		accCfg.User, err = precis.UsernameCasePreserved.String(accCfg.User)
+	}
+	if err != nil {
+		return "", err
+	}
+	if accCfg.Provider, err = idna.Lookup.ToASCII(accCfg.Provider); err != nil {
+		return "", err
+	}
+
+	addr := fmt.Sprintf("%s@%s", accCfg.User, accCfg.Provider)
+	if _, err := mail.ParseAddress(addr); err != nil {
+		return "", fmt.Errorf("User/Provider does not form a valid e-mail address: %v", err)
+	}
+	return addr, nil
+}
+
+func rawIdentifier(accCfg *Account, caseSensitive bool) (string, error) {
+	if !caseSensitive {
+		accCfg.User = strings.ToLower(accCfg.User)
+	}
+	// The identifier names a directory.
+	for _, v := range []string{accCfg.User, accCfg.Provider} {
+		if v == "." || v == ".." || strings.ContainsAny(v, "/\\\x00") {
+			return "", errors.New("User/Provider can not be used as a directory name")
+		}
+	}
+	return accCfg.User + "@" + accCfg.Provider, nil
+}
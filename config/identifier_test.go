@@ -0,0 +1,113 @@
+// identifier_test.go - account identifier tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIdentifierFormats(t *testing.T) {
+	tests := []struct {
+		format        string
+		user          string
+		provider      string
+		caseSensitive bool
+		want          string
+	}{
+		{IdentifierEmail, "alice", "provider-0", false, "alice@provider-0"},
+		{IdentifierEmail, "Alice", "Provider-0", false, "alice@provider-0"},
+		{IdentifierEmail, "Alice", "Provider-0", true, "Alice@provider-0"},
+		{IdentifierEmail, "al ice", "provider-0", false, ""},
+		{IdentifierEmail, "alice", "provider 0", false, ""},
+		{IdentifierEmail, "alice@example", "provider-0", false, ""},
+		{IdentifierEmail, "alice", "provider-0@example", false, ""},
+		{IdentifierRaw, "alice", "provider-0", false, "alice@provider-0"},
+		{IdentifierRaw, "Alice", "Provider_0", false, "alice@Provider_0"},
+		{IdentifierRaw, "Alice", "Provider_0", true, "Alice@Provider_0"},
+		{IdentifierRaw, "al ice", "provider 0", false, "al ice@provider 0"},
+		{IdentifierRaw, "..", "provider-0", false, ""},
+		{IdentifierRaw, "alice", ".", false, ""},
+		{IdentifierRaw, "../alice", "provider-0", false, ""},
+		{IdentifierRaw, "alice", "provider\\0", false, ""},
+		{IdentifierRaw, "alice\x00", "provider-0", false, ""},
+	}
+	for _, tt := range tests {
+		f, err := getIdentifierFormat(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accCfg := &Account{User: tt.user, Provider: tt.provider}
+		id, err := f(accCfg, tt.caseSensitive)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%v %q@%q: invalid input accepted as %q", tt.format, tt.user, tt.provider, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %q@%q: %v", tt.format, tt.user, tt.provider, err)
+			continue
+		}
+		if id != tt.want {
+			t.Errorf("%v %q@%q: identifier %q, want %q", tt.format, tt.user, tt.provider, id, tt.want)
+		}
+
+		// The normalized User and Provider round trip to the same
+		// identifier, unchanged.
+		again := &Account{User: accCfg.User, Provider: accCfg.Provider}
+		if id2, err := f(again, tt.caseSensitive); err != nil || id2 != id {
+			t.Errorf("%v %q@%q: normalized identifier %q became %q (%v)", tt.format, tt.user, tt.provider, id, id2, err)
+		}
+		if again.User != accCfg.User || again.Provider != accCfg.Provider {
+			t.Errorf("%v %q@%q: normalizing %q@%q again changed it to %q@%q", tt.format, tt.user, tt.provider,
+				accCfg.User, accCfg.Provider, again.User, again.Provider)
+		}
+	}
+}
+
+func TestRegisterIdentifierFormat(t *testing.T) {
+	cfg := &Config{Debug: &Debug{}}
+	accCfg := &Account{User: "alice", Provider: "provider-0", IdentifierFormat: "test-unknown"}
+	if err := accCfg.fixup(cfg); err == nil {
+		t.Fatal("an unknown identifier format was accepted")
+	}
+
+	errFormat := errors.New("rejected")
+	RegisterIdentifierFormat("test-reverse", func(accCfg *Account, caseSensitive bool) (string, error) {
+		if accCfg.User == "mallory" {
+			return "", errFormat
+		}
+		return accCfg.Provider + "." + accCfg.User, nil
+	})
+	accCfg = &Account{User: "alice", Provider: "provider-0", IdentifierFormat: "test-reverse"}
+	if err := accCfg.fixup(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if id := accCfg.Identifier(); id != "provider-0.alice" {
+		t.Errorf("registered format returned %q", id)
+	}
+	accCfg = &Account{User: "mallory", Provider: "provider-0", IdentifierFormat: "test-reverse"}
+	if err := accCfg.fixup(cfg); err != errFormat {
+		t.Errorf("registered format error became %v", err)
+	}
+
+	accCfg = &Account{User: "alice", Provider: "provider-0"}
+	if err := accCfg.fixup(cfg); err != nil || accCfg.IdentifierFormat != IdentifierEmail || accCfg.Identifier() != "alice@provider-0" {
+		t.Errorf("default format: %q, %q, %v", accCfg.IdentifierFormat, accCfg.Identifier(), err)
+	}
+}
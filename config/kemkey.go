@@ -0,0 +1,107 @@
+// kemkey.go - post-quantum link key handling.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"git.schwanenlied.me/yawning/kyber.git"
+	"github.com/katzenpost/core/crypto/rand"
+)
+
+const (
+	kemPrivatePEMType = "KYBER768 PRIVATE KEY"
+	kemPublicPEMType  = "KYBER768 PUBLIC KEY"
+)
+
+// LoadLinkKEMKey can load or generate the Kyber768 half of a hybrid
+// link key.
+func LoadLinkKEMKey(basePath string) (*kyber.PrivateKey, error) {
+	linkPriv := filepath.Join(basePath, "link.kyber768.private.pem")
+	linkPub := filepath.Join(basePath, "link.kyber768.public.pem")
+
+	privExists, pubExists := fileExists(linkPriv), fileExists(linkPub)
+	switch {
+	case privExists && pubExists:
+		return loadKEMKey(linkPriv, linkPub)
+	case privExists || pubExists:
+		return nil, fmt.Errorf("config: Kyber768 link key is incomplete, '%v' or '%v' is missing", linkPriv, linkPub)
+	}
+
+	pub, priv, err := kyber.Kyber768.GenerateKeyPair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = writePEM(linkPriv, kemPrivatePEMType, priv.Bytes()); err != nil {
+		return nil, err
+	}
+	if err = writePEM(linkPub, kemPublicPEMType, pub.Bytes()); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func loadKEMKey(privFile, pubFile string) (*kyber.PrivateKey, error) {
+	b, err := readPEM(privFile, kemPrivatePEMType)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := kyber.Kyber768.PrivateKeyFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to parse '%v': %v", privFile, err)
+	}
+	if b, err = readPEM(pubFile, kemPublicPEMType); err != nil {
+		return nil, err
+	}
+	pub, err := kyber.Kyber768.PublicKeyFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to parse '%v': %v", pubFile, err)
+	}
+	if string(pub.Bytes()) != string(priv.PublicKey.Bytes()) {
+		return nil, fmt.Errorf("config: Kyber768 public key '%v' does not match the private key", pubFile)
+	}
+	return priv, nil
+}
+
+func fileExists(f string) bool {
+	_, err := os.Stat(f)
+	return err == nil
+}
+
+func readPEM(f, pemType string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(buf)
+	if blk == nil || blk.Type != pemType {
+		return nil, fmt.Errorf("config: '%v' is not a %v PEM file", f, pemType)
+	}
+	return blk.Bytes, nil
+}
+
+func writePEM(f, pemType string, b []byte) error {
+	blk := &pem.Block{
+		Type:  pemType,
+		Bytes: b,
+	}
+	return ioutil.WriteFile(f, pem.EncodeToMemory(blk), os.FileMode(0600))
+}
@@ -0,0 +1,96 @@
+// mailproxy.go - mailproxy interop configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+)
+
+// MailproxyTrafficGenerator is the name of the traffic generator sending
+// mailproxy messages, used by the "mailproxy" mode.
+const MailproxyTrafficGenerator = "mailproxy"
+
+const defaultMailproxyMessageSize = 1000
+
+// Mailproxy is the mailproxy interop configuration.  In the "mailproxy"
+// mode spray sends end-to-end encrypted email messages in the block
+// format of the Katzenpost client stack, which real mailproxy recipients
+// decrypt, reassemble and deliver to their mailboxes.
+type Mailproxy struct {
+	// From is the From header of the messages, by default the account.
+	From string
+
+	// MessageSize is the size of the message bodies in bytes, messages
+	// larger than a block are sent as multiple blocks.
+	MessageSize int
+
+	// Recipients are the mailproxy recipients the messages are sent
+	// to, at random.
+	Recipients []*MailproxyRecipient
+}
+
+// MailproxyRecipient is a mailproxy recipient.
+type MailproxyRecipient struct {
+	// User is the recipient's account user name.
+	User string
+
+	// Provider is the recipient's Provider.
+	Provider string
+
+	// PublicKey is the recipient's identity public key.
+	PublicKey *ecdh.PublicKey
+}
+
+func (mCfg *Mailproxy) fixup(cfg *Config) {
+	if mCfg.From == "" {
+		mCfg.From = cfg.Account.User + "@" + cfg.Account.Provider
+	}
+	if mCfg.MessageSize == 0 {
+		mCfg.MessageSize = defaultMailproxyMessageSize
+	}
+}
+
+func (mCfg *Mailproxy) validate() error {
+	if mCfg.MessageSize < 0 {
+		return fmt.Errorf("config: Mailproxy: MessageSize '%v' is invalid", mCfg.MessageSize)
+	}
+	if len(mCfg.Recipients) == 0 {
+		return errors.New("config: Mailproxy: No Recipients were present")
+	}
+	for _, r := range mCfg.Recipients {
+		if r.User == "" || r.Provider == "" {
+			return errors.New("config: Mailproxy: Recipient is missing the User or Provider")
+		}
+		if r.PublicKey == nil {
+			return fmt.Errorf("config: Mailproxy: Recipient '%v@%v' is missing the PublicKey", r.User, r.Provider)
+		}
+	}
+	return nil
+}
+
+// LoadIdentityKey can load or generate the identity keys mailproxy
+// recipients see messages as coming from.
+func LoadIdentityKey(basePath string) (*ecdh.PrivateKey, error) {
+	idPriv := filepath.Join(basePath, "identity.private.pem")
+	idPub := filepath.Join(basePath, "identity.public.pem")
+	return ecdh.Load(idPriv, idPub, rand.Reader)
+}
@@ -0,0 +1,89 @@
+// metrics.go - metrics exporter configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	defaultMetricsPath    = "/metrics"
+	defaultStatsdPrefix   = "spray"
+	defaultStatsdInterval = 10
+)
+
+// Metrics is the metrics exporter configuration, of the Prometheus
+// exporter, the statsd sink, or both.
+type Metrics struct {
+	// Address is the TCP address the Prometheus exporter listens on,
+	// such as "127.0.0.1:9230".
+	Address string
+
+	// Path is the HTTP path the metrics are served on, by default
+	// "/metrics".
+	Path string
+
+	// StatsdAddress is the UDP address of the statsd server the metrics
+	// are pushed to, such as "127.0.0.1:8125".
+	StatsdAddress string
+
+	// StatsdPrefix is the prefix of the names of the statsd metrics, by
+	// default "spray".
+	StatsdPrefix string
+
+	// StatsdInterval is the number of seconds between the pushes to the
+	// statsd server, by default 10.
+	StatsdInterval int
+}
+
+func (mCfg *Metrics) fixup() {
+	if mCfg.Path == "" {
+		mCfg.Path = defaultMetricsPath
+	}
+	if mCfg.StatsdPrefix == "" {
+		mCfg.StatsdPrefix = defaultStatsdPrefix
+	}
+	if mCfg.StatsdInterval == 0 {
+		mCfg.StatsdInterval = defaultStatsdInterval
+	}
+}
+
+func (mCfg *Metrics) validate() error {
+	if mCfg.Address == "" && mCfg.StatsdAddress == "" {
+		return errors.New("config: Metrics: Address or StatsdAddress is missing")
+	}
+	if mCfg.Address != "" {
+		if _, _, err := net.SplitHostPort(mCfg.Address); err != nil {
+			return fmt.Errorf("config: Metrics: Address '%v' is invalid: %v", mCfg.Address, err)
+		}
+	}
+	if !strings.HasPrefix(mCfg.Path, "/") {
+		return fmt.Errorf("config: Metrics: Path '%v' is invalid", mCfg.Path)
+	}
+	if mCfg.StatsdAddress != "" {
+		if _, _, err := net.SplitHostPort(mCfg.StatsdAddress); err != nil {
+			return fmt.Errorf("config: Metrics: StatsdAddress '%v' is invalid: %v", mCfg.StatsdAddress, err)
+		}
+	}
+	if mCfg.StatsdInterval < 0 {
+		return fmt.Errorf("config: Metrics: StatsdInterval '%v' is invalid", mCfg.StatsdInterval)
+	}
+	return nil
+}
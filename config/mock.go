@@ -0,0 +1,110 @@
+// mock.go - mock mixnet configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/katzenpost/spray/internal/mockpki"
+)
+
+// MockLinkProtocol is the name of the link protocol of the in-process
+// mock mixnet.
+const MockLinkProtocol = "mock"
+
+const (
+	defaultMockProviders     = 2
+	defaultMockMixesPerLayer = 2
+)
+
+// Mock is the in-process mock mixnet configuration.  When present, the
+// session runs against a simulated network instead of an authority and
+// a Provider, so that the whole pipeline can run in CI and demos.
+// Every simulated Provider runs the loop service, and messages sent to
+// the account are delivered to its own mailbox.
+type Mock struct {
+	// Providers is the number of simulated Providers, including the
+	// account's Provider.
+	Providers int
+
+	// MixesPerLayer is the number of mixes in every layer.
+	MixesPerLayer int
+
+	// MeanDelay is the mean per hop mixing delay in milliseconds, as
+	// published in the simulated PKI documents.
+	MeanDelay int
+
+	// MaxDelay is the maximum per hop mixing delay in milliseconds,
+	// zero meaning unbounded.
+	MaxDelay int
+
+	// Latency is the one way link latency in milliseconds, added to
+	// every path.
+	Latency int
+
+	// Loss is the probability of a packet being lost on a path, between
+	// 0 and 1.
+	Loss float64
+}
+
+func (mCfg *Mock) fixup() {
+	if mCfg.Providers == 0 {
+		mCfg.Providers = defaultMockProviders
+	}
+	if mCfg.MixesPerLayer == 0 {
+		mCfg.MixesPerLayer = defaultMockMixesPerLayer
+	}
+}
+
+func (mCfg *Mock) validate() error {
+	if mCfg.Providers < 1 {
+		return fmt.Errorf("config: Mock: Providers '%v' is invalid", mCfg.Providers)
+	}
+	if mCfg.MixesPerLayer < 1 {
+		return fmt.Errorf("config: Mock: MixesPerLayer '%v' is invalid", mCfg.MixesPerLayer)
+	}
+	if mCfg.MeanDelay < 0 {
+		return fmt.Errorf("config: Mock: MeanDelay '%v' is invalid", mCfg.MeanDelay)
+	}
+	if mCfg.MaxDelay < 0 {
+		return fmt.Errorf("config: Mock: MaxDelay '%v' is invalid", mCfg.MaxDelay)
+	}
+	if mCfg.Latency < 0 {
+		return fmt.Errorf("config: Mock: Latency '%v' is invalid", mCfg.Latency)
+	}
+	if mCfg.Loss < 0 || mCfg.Loss > 1 {
+		return fmt.Errorf("config: Mock: Loss '%v' is invalid", mCfg.Loss)
+	}
+	return nil
+}
+
+// newPKIClient returns the PKI client of the simulated network, whose
+// first Providers are the Providers of the accounts.
+func (mCfg *Mock) newPKIClient(c *Config) (*mockpki.Client, error) {
+	var providers []string
+	seen := make(map[string]bool)
+	for _, a := range c.Accounts {
+		if !seen[a.Provider] {
+			seen[a.Provider] = true
+			providers = append(providers, a.Provider)
+		}
+	}
+	for i := len(providers); i < mCfg.Providers; i++ {
+		providers = append(providers, fmt.Sprintf("provider-%d", i))
+	}
+	return mockpki.New(c.Geometry.NrHops-2, mCfg.MixesPerLayer, providers, uint64(mCfg.MeanDelay), uint64(mCfg.MaxDelay))
+}
@@ -0,0 +1,52 @@
+// nodes.go - node selection configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Nodes restricts the mixes and Providers used by the routes and the
+// targets of the load, for example to avoid nodes under maintenance
+// without editing the authority.  Nodes are named by their name or by
+// their identity key, in base64 or hex.
+type Nodes struct {
+	// Deny lists the mixes and Providers excluded from the routes and
+	// the targets.  The account's own Provider can not be excluded.
+	Deny []string
+
+	// AllowProviders, if set, lists the only Providers that may be
+	// targeted, besides the account's own Provider.
+	AllowProviders []string
+}
+
+func (nCfg *Nodes) validate(accCfg *Account) error {
+	for _, list := range [][]string{nCfg.Deny, nCfg.AllowProviders} {
+		for _, n := range list {
+			if n == "" {
+				return errors.New("config: Nodes: empty node name")
+			}
+		}
+	}
+	for _, n := range nCfg.Deny {
+		if n == accCfg.Provider {
+			return fmt.Errorf("config: Nodes: Deny '%v' is the account's Provider", n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// paths.go - portable path and permission handling.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/katzenpost/core/utils"
+)
+
+// IsWindows is true when running on Windows, where file modes do not
+// reflect the access control lists, and the POSIX permission checks
+// can not be applied.
+var IsWindows = runtime.GOOS == "windows"
+
+// windowsReservedNames are the device names Windows reserves in every
+// directory, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// MkDataDir creates the directory dir if it does not exist.  On POSIX
+// systems it must be accessible by its owner only, and on Windows,
+// where the mode bits are meaningless, it is left to inherit the access
+// control list of its parent and only has to be a directory.
+func MkDataDir(dir string) error {
+	if dir == MemoryDataDir || strings.HasPrefix(dir, MemoryDataDir+string(filepath.Separator)) {
+		return fmt.Errorf("config: '%v' can not be created with the %v DataDir", dir, MemoryDataDir)
+	}
+	if !IsWindows {
+		return utils.MkDataDir(dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("config: Data directory '%v' is not a directory", dir)
+	}
+	return nil
+}
+
+// validateDirName checks that name, an account identifier, can name a
+// directory on this system.
+func validateDirName(name string) error {
+	if !IsWindows {
+		return nil
+	}
+	if strings.ContainsAny(name, "<>:\"|?*") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return fmt.Errorf("'%v' can not be used as a directory name on Windows", name)
+	}
+	base := strings.ToUpper(name)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReservedNames[base] {
+		return fmt.Errorf("'%v' is a reserved name on Windows", name)
+	}
+	return nil
+}
+
+// cleanPath converts the slashes of a configured path to the separator
+// of this system, so that configurations can be shared between hosts.
+func cleanPath(f string) string {
+	if f == "" {
+		return f
+	}
+	return filepath.Clean(filepath.FromSlash(f))
+}
@@ -0,0 +1,82 @@
+// ramp.go - ramp load profile configuration.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+const defaultRampStep = 1000
+
+// Ramp is the ramp load profile configuration.  The send rate increases
+// linearly from the StartRate to the PeakRate over RampUp seconds, holds
+// the PeakRate for Hold seconds, and decreases linearly back to the
+// StartRate over RampDown seconds, after which the run ends.
+type Ramp struct {
+	// StartRate is the send rate in packets per second the ramp starts
+	// and ends at.
+	StartRate float64
+
+	// PeakRate is the send rate in packets per second at the top of
+	// the ramp.
+	PeakRate float64
+
+	// RampUp, Hold and RampDown are the durations in seconds of the
+	// stages of the ramp.
+	RampUp   int
+	Hold     int
+	RampDown int
+
+	// Step is the interval in milliseconds the send rate is updated
+	// at, by default 1000.
+	Step int
+}
+
+func (rCfg *Ramp) fixup() {
+	if rCfg.Step == 0 {
+		rCfg.Step = defaultRampStep
+	}
+}
+
+func (rCfg *Ramp) validate(cfg *Config) error {
+	if rCfg.StartRate < 0 {
+		return fmt.Errorf("config: Ramp: StartRate '%v' is invalid", rCfg.StartRate)
+	}
+	if rCfg.PeakRate <= 0 || rCfg.PeakRate < rCfg.StartRate {
+		return fmt.Errorf("config: Ramp: PeakRate '%v' is invalid", rCfg.PeakRate)
+	}
+	if rCfg.RampUp <= 0 {
+		return fmt.Errorf("config: Ramp: RampUp '%v' is invalid", rCfg.RampUp)
+	}
+	if rCfg.Hold < 0 {
+		return fmt.Errorf("config: Ramp: Hold '%v' is invalid", rCfg.Hold)
+	}
+	if rCfg.RampDown < 0 {
+		return fmt.Errorf("config: Ramp: RampDown '%v' is invalid", rCfg.RampDown)
+	}
+	if rCfg.Step <= 0 {
+		return fmt.Errorf("config: Ramp: Step '%v' is invalid", rCfg.Step)
+	}
+	if cfg.Schedule != nil {
+		return errors.New("config: Ramp: Ramp and Schedule are mutually exclusive")
+	}
+	if cfg.Debug.LambdaP != 0 {
+		return errors.New("config: Ramp: Ramp requires the rate limiter, not LambdaP")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// staircase.go - staircase load profile configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Step is a step of the staircase load profile.
+type Step struct {
+	// SendRate is the send rate in packets per second of the step.
+	SendRate float64
+
+	// Duration is the duration of the step in seconds.
+	Duration int
+}
+
+// Staircase is the staircase load profile configuration.  The send rate
+// is switched to the rate of every step in order, from [[Staircase.Step]]
+// tables, and the run ends after the last one.
+type Staircase struct {
+	// SlowStart is the number of seconds over the start of every step
+	// during which the send rate is raised linearly from the rate of the
+	// previous step, or zero for the first one, to the rate of the step,
+	// to avoid instantaneous rate steps.
+	SlowStart int
+
+	Step []*Step
+}
+
+func (sCfg *Staircase) validate(cfg *Config) error {
+	if len(sCfg.Step) == 0 {
+		return errors.New("config: Staircase: No Step block was present")
+	}
+	if sCfg.SlowStart < 0 {
+		return fmt.Errorf("config: Staircase: SlowStart '%v' is invalid", sCfg.SlowStart)
+	}
+	for i, s := range sCfg.Step {
+		if s.SendRate <= 0 {
+			return fmt.Errorf("config: Staircase: Step %d: SendRate '%v' is invalid", i, s.SendRate)
+		}
+		if s.Duration <= 0 || s.Duration <= sCfg.SlowStart {
+			return fmt.Errorf("config: Staircase: Step %d: Duration '%v' is invalid", i, s.Duration)
+		}
+	}
+	if cfg.Schedule != nil || cfg.Ramp != nil {
+		return errors.New("config: Staircase: Staircase, Ramp and Schedule are mutually exclusive")
+	}
+	if cfg.Debug.LambdaP != 0 {
+		return errors.New("config: Staircase: Staircase requires the rate limiter, not LambdaP")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// suggest.go - undecoded key suggestions
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// undecodedError returns the error reporting undecoded keys, suggesting
+// the closest known key for each of them where there is one.
+func undecodedError(undecoded []toml.Key) error {
+	keys := make([]string, 0, len(undecoded))
+	for _, k := range undecoded {
+		s := strings.Join(k, ".")
+		if hint := suggestKey(k); hint != "" {
+			s = fmt.Sprintf("%s (did you mean %s?)", s, hint)
+		}
+		keys = append(keys, s)
+	}
+	return fmt.Errorf("config: Undecoded keys in config file: %v", strings.Join(keys, ", "))
+}
+
+// suggestKey returns the known key closest to key, or the empty string if
+// there is none.
+func suggestKey(key toml.Key) string {
+	t := reflect.TypeOf(Config{})
+	path := make([]string, 0, len(key))
+	for _, k := range key {
+		fields := tableFields(t)
+		if fields == nil {
+			return ""
+		}
+		name := matchField(fields, k)
+		if name == "" {
+			return ""
+		}
+		path = append(path, name)
+		t = fields[name]
+	}
+	if s := strings.Join(path, "."); s != strings.Join(key, ".") {
+		return s
+	}
+	return ""
+}
+
+// tableFields returns the types of the fields of the TOML table decoded
+// into t, or nil if t is not decoded from a table.
+func tableFields(t reflect.Type) map[string]reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			fields[f.Name] = f.Type
+		}
+	}
+	return fields
+}
+
+// matchField returns the name of the field closest to k, ignoring case,
+// underscores and dashes, or the empty string if none is close enough.
+func matchField(fields map[string]reflect.Type, k string) string {
+	if _, ok := fields[k]; ok {
+		return k
+	}
+	norm := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(s))
+	}
+	nk := norm(k)
+	best, bestDist := "", -1
+	for name := range fields {
+		d := editDistance(nk, norm(name))
+		if d == 0 {
+			return name
+		}
+		if bestDist < 0 || d < bestDist || (d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	if bestDist > 2 || bestDist*2 >= len(nk) {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
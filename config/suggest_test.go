@@ -0,0 +1,78 @@
+// suggest_test.go - unknown key suggestion tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestSuggestKey(t *testing.T) {
+	tests := []struct {
+		key  toml.Key
+		want string
+	}{
+		{toml.Key{"Debug", "SendRat"}, "Debug.SendRate"},
+		{toml.Key{"Debug", "send_rate"}, "Debug.SendRate"},
+		{toml.Key{"debug", "send-rate"}, "Debug.SendRate"},
+		{toml.Key{"Debgu", "SendRate"}, "Debug.SendRate"},
+		{toml.Key{"Traffic", "PaylodSize"}, "Traffic.PayloadSize"},
+		{toml.Key{"Account", "Usr"}, "Account.User"},
+		{toml.Key{"Target", "Provder"}, "Target.Provider"},
+		{toml.Key{"Debug", "SendRate"}, ""},
+		{toml.Key{"Debug", "Frobnicate"}, ""},
+		{toml.Key{"Nonsense"}, ""},
+		{toml.Key{"Debug", "SendRate", "Burst"}, ""},
+		{toml.Key{"Debug", "X"}, ""},
+	}
+	for _, tt := range tests {
+		if got := suggestKey(tt.key); got != tt.want {
+			t.Errorf("suggestKey(%v) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestUndecodedKeys(t *testing.T) {
+	tests := []struct {
+		config string
+		want   []string
+	}{
+		{"[Debug]\nSendRat = 1.0\n", []string{"Debug.SendRat (did you mean Debug.SendRate?)"}},
+		{"[Traffic]\npayload_size = 100\n", []string{"Traffic.payload_size (did you mean Traffic.PayloadSize?)"}},
+		{"[Debgu]\nSendRate = 1.0\n", []string{"Debgu", "Debgu.SendRate (did you mean Debug.SendRate?)"}},
+		{"[Debug]\nFrobnicate = true\n", []string{"Debug.Frobnicate"}},
+		{"[[Target]]\nProvider = \"provider-0\"\nRecipent = \"spray\"\n", []string{"Target.Recipent (did you mean Target.Recipient?)"}},
+	}
+	for _, tt := range tests {
+		_, err := LoadWithOverrides([]byte(tt.config), false, nil)
+		if err == nil {
+			t.Errorf("%q: undecoded keys were accepted", tt.config)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "config: Undecoded keys in config file: ") {
+			t.Errorf("%q: unexpected error: %v", tt.config, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%q: %q is missing from %v", tt.config, want, err)
+			}
+		}
+	}
+}
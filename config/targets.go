@@ -0,0 +1,120 @@
+// targets.go - load target lists.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target is a destination of the generated load.
+type Target struct {
+	// Provider is the Provider of the target recipient.
+	Provider string
+
+	// Recipient is the target recipient.
+	Recipient string
+
+	// Weight is the relative share of the load sent to this target.
+	// It defaults to 1.
+	Weight int
+}
+
+func (t *Target) fixupAndValidate() error {
+	if t.Provider == "" {
+		return fmt.Errorf("Provider is missing")
+	}
+	if t.Recipient == "" {
+		return fmt.Errorf("Recipient is missing")
+	}
+	if t.Weight == 0 {
+		t.Weight = 1
+	}
+	if t.Weight < 0 {
+		return fmt.Errorf("Weight '%v' is invalid", t.Weight)
+	}
+	return nil
+}
+
+// LoadTargets loads a target list from the named file.  Files with a
+// ".json" extension must contain a JSON array of objects with Provider,
+// Recipient and optional Weight fields.  Any other file is treated as
+// CSV with "provider,recipient[,weight]" records, where empty lines and
+// lines starting with '#' are ignored.
+func LoadTargets(f string) ([]*Target, error) {
+	fd, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var targets []*Target
+	if strings.ToLower(filepath.Ext(f)) == ".json" {
+		err = json.NewDecoder(fd).Decode(&targets)
+	} else {
+		targets, err = readCSVTargets(fd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to parse targets file '%v': %v", f, err)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("config: targets file '%v' contains no targets", f)
+	}
+	for i, t := range targets {
+		if err := t.fixupAndValidate(); err != nil {
+			return nil, fmt.Errorf("config: targets file '%v': target %d is invalid: %v", f, i, err)
+		}
+	}
+	return targets, nil
+}
+
+func readCSVTargets(r io.Reader) ([]*Target, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	targets := []*Target{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return targets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 || len(rec) > 3 {
+			return nil, fmt.Errorf("record %v has %d fields", rec, len(rec))
+		}
+		t := &Target{
+			Provider:  rec[0],
+			Recipient: rec[1],
+		}
+		if len(rec) == 3 {
+			if t.Weight, err = strconv.Atoi(rec[2]); err != nil {
+				return nil, fmt.Errorf("record %v has an invalid weight: %v", rec, err)
+			}
+		}
+		targets = append(targets, t)
+	}
+}
@@ -0,0 +1,73 @@
+// targets_test.go - load target tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeprecatedTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    string
+		recipient   string
+		target      []*Target
+		targetsFile string
+		err         string
+	}{
+		{name: "alias", provider: "provider-1", recipient: "echo"},
+		{name: "no recipient", provider: "provider-1", err: "Recipient is missing"},
+		{name: "with Target", provider: "provider-1", recipient: "echo", target: []*Target{{Provider: "provider-0", Recipient: "echo"}}, err: "use a Target block instead"},
+		{name: "with TargetsFile", provider: "provider-1", recipient: "echo", targetsFile: "targets.csv", err: "use a Target block instead"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			Proxy:   &Proxy{DataDir: "/tmp/spray-targets-test"},
+			Logging: &Logging{Disable: true},
+			Debug: &Debug{
+				Mode:            ModeFlood,
+				TargetProvider:  tt.provider,
+				TargetRecipient: tt.recipient,
+				TargetsFile:     tt.targetsFile,
+			},
+			Account: &Account{User: "alice", Provider: "provider-0"},
+			Mock:    &Mock{Providers: 2, MeanDelay: 10},
+			Target:  tt.target,
+		}
+		err := cfg.FixupAndValidate()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: FixupAndValidate returned %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		targets := cfg.Targets()
+		if len(targets) != 1 || targets[0].Provider != tt.provider || targets[0].Recipient != tt.recipient || targets[0].Weight != 1 {
+			t.Errorf("%s: deprecated target became %+v", tt.name, targets)
+		}
+		// The alias is consumed, so that the configuration may be
+		// validated again.
+		if err = cfg.FixupAndValidate(); err != nil {
+			t.Errorf("%s: revalidating failed: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// traffic.go - traffic payload configuration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Payload fills.
+const (
+	// PayloadFillZero fills payloads with zero bytes.
+	PayloadFillZero = "zero"
+
+	// PayloadFillRandom fills every payload with fresh random bytes.
+	PayloadFillRandom = "random"
+
+	// PayloadFillPattern fills payloads with PayloadPattern repeated.
+	PayloadFillPattern = "pattern"
+
+	defaultPayloadFill      = PayloadFillZero
+	defaultPayloadPattern   = "5350524159"
+	defaultDrainGrace       = 30
+	defaultProgressInterval = 10
+)
+
+// Traffic is the configuration of the payloads of the packets sent by
+// the default traffic generator.
+type Traffic struct {
+	// PayloadSize is the size of the payloads in bytes, by default and
+	// at most the UserForwardPayloadLength of the Sphinx geometry.
+	// Shorter payloads are padded by the Sphinx packet format.
+	PayloadSize int
+
+	// PayloadFill is the content of the payloads, one of "zero" (the
+	// default), "random" or "pattern".
+	PayloadFill string
+
+	// PayloadPattern is the hex encoded byte pattern repeated by the
+	// "pattern" fill, by default "SPRAY" in ASCII.
+	PayloadPattern string
+
+	// RunDuration is the number of seconds after which sending stops
+	// and the run ends, by default unlimited.
+	RunDuration int
+
+	// MaxPackets is the number of packets after which sending stops
+	// and the run ends, by default unlimited.
+	MaxPackets uint64
+
+	// DrainGrace is the number of seconds the replies still outstanding
+	// once the RunDuration has elapsed or MaxPackets were sent are
+	// waited for, by default 30.
+	DrainGrace int
+
+	// ProgressInterval is the number of seconds between the progress
+	// reports logged when a RunDuration or MaxPackets is set, by
+	// default 10.
+	ProgressInterval int
+}
+
+func (tCfg *Traffic) fixup(g *Geometry) {
+	if tCfg.PayloadSize == 0 {
+		tCfg.PayloadSize = g.UserForwardPayloadLength
+	}
+	if tCfg.PayloadFill == "" {
+		tCfg.PayloadFill = defaultPayloadFill
+	}
+	if tCfg.DrainGrace == 0 {
+		tCfg.DrainGrace = defaultDrainGrace
+	}
+	if tCfg.ProgressInterval == 0 {
+		tCfg.ProgressInterval = defaultProgressInterval
+	}
+	if tCfg.PayloadFill == PayloadFillPattern && tCfg.PayloadPattern == "" {
+		tCfg.PayloadPattern = defaultPayloadPattern
+	}
+}
+
+func (tCfg *Traffic) validate(g *Geometry) error {
+	if tCfg.PayloadSize < 0 || tCfg.PayloadSize > g.UserForwardPayloadLength {
+		return fmt.Errorf("config: Traffic: PayloadSize '%v' is invalid", tCfg.PayloadSize)
+	}
+	if tCfg.RunDuration < 0 {
+		return fmt.Errorf("config: Traffic: RunDuration '%v' is invalid", tCfg.RunDuration)
+	}
+	if tCfg.DrainGrace < 0 {
+		return fmt.Errorf("config: Traffic: DrainGrace '%v' is invalid", tCfg.DrainGrace)
+	}
+	if tCfg.ProgressInterval < 0 {
+		return fmt.Errorf("config: Traffic: ProgressInterval '%v' is invalid", tCfg.ProgressInterval)
+	}
+	switch tCfg.PayloadFill {
+	case PayloadFillZero, PayloadFillRandom, PayloadFillPattern:
+	default:
+		return fmt.Errorf("config: Traffic: PayloadFill '%v' is invalid", tCfg.PayloadFill)
+	}
+	if tCfg.PayloadPattern != "" {
+		if p, err := hex.DecodeString(tCfg.PayloadPattern); err != nil || len(p) == 0 {
+			return fmt.Errorf("config: Traffic: PayloadPattern '%v' is invalid", tCfg.PayloadPattern)
+		}
+	}
+	return nil
+}
+
+// Limited returns true if the run ends after a RunDuration or after
+// MaxPackets were sent.
+func (tCfg *Traffic) Limited() bool {
+	return tCfg.RunDuration > 0 || tCfg.MaxPackets > 0
+}
+
+// Pattern returns the decoded PayloadPattern.
+func (tCfg *Traffic) Pattern() []byte {
+	p, _ := hex.DecodeString(tCfg.PayloadPattern)
+	return p
+}
@@ -0,0 +1,229 @@
+// event.go - in-process event bus
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package event implements the publish/subscribe event bus used to
+// observe a running spray session.
+package event
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/topology"
+)
+
+// Event is an event published on a Bus.  It is one of the *Event types
+// of this package.
+type Event interface {
+	// Time returns the time the event happened.
+	Time() time.Time
+}
+
+// ConnectionEvent is published when the connection to the Provider is
+// established or lost.
+type ConnectionEvent struct {
+	// At is the time of the status change.
+	At time.Time
+
+	// IsConnected is true if the connection was established.
+	IsConnected bool
+}
+
+// Time implements Event.
+func (e *ConnectionEvent) Time() time.Time { return e.At }
+
+// DocumentEvent is published for every new PKI document.
+type DocumentEvent struct {
+	// At is the time the document was received.
+	At time.Time
+
+	// Document is the new document.
+	Document *pki.Document
+
+	// Diff is the difference to the previous document, or nil for the
+	// first document.
+	Diff *topology.Diff
+}
+
+// Time implements Event.
+func (e *DocumentEvent) Time() time.Time { return e.At }
+
+// SentEvent is published for every packet handed to the Provider.
+type SentEvent struct {
+	// At is the time the packet was sent.
+	At time.Time
+
+	// Length is the length of the packet in bytes.
+	Length int
+}
+
+// Time implements Event.
+func (e *SentEvent) Time() time.Time { return e.At }
+
+// PhaseEvent is published when a phase of the run schedule starts.
+type PhaseEvent struct {
+	// At is the time the phase started.
+	At time.Time
+
+	// Index is the index of the phase in the schedule.
+	Index int
+
+	// Name is the name of the phase.
+	Name string
+}
+
+// Time implements Event.
+func (e *PhaseEvent) Time() time.Time { return e.At }
+
+// StepEvent is published when the send rate changes at a step boundary
+// of the staircase load profile.
+type StepEvent struct {
+	// At is the time the step started.
+	At time.Time
+
+	// Index is the index of the step in the staircase.
+	Index int
+
+	// SendRate is the send rate in packets per second of the step.
+	SendRate float64
+}
+
+// Time implements Event.
+func (e *StepEvent) Time() time.Time { return e.At }
+
+// ProbeEvent is published for every completed probe.
+type ProbeEvent struct {
+	// Probe is the probe result.
+	Probe *report.Probe
+}
+
+// Time implements Event.
+func (e *ProbeEvent) Time() time.Time { return e.Probe.Timestamp }
+
+// Subscription is a subscriber's view of a Bus.
+type Subscription struct {
+	bus     *Bus
+	ch      chan Event
+	dropped uint64
+	spill   *spillQueue
+}
+
+// C returns the channel events are delivered on.  It is closed when the
+// subscription or the Bus is closed.
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns the number of events dropped because the subscriber
+// did not keep up.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes from the Bus.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus is a publish/subscribe event bus.  Publishing never blocks, events
+// are dropped for subscribers whose buffer is full, so that a slow
+// consumer can not distort the measurements, or spilled to disk for
+// subscribers created with SubscribeSpill.
+type Bus struct {
+	sync.RWMutex
+
+	subs   map[*Subscription]bool
+	closed bool
+}
+
+// NewBus returns a new Bus.
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]bool),
+	}
+}
+
+// Subscribe returns a new Subscription buffering up to bufSize events.
+func (b *Bus) Subscribe(bufSize int) *Subscription {
+	s := &Subscription{
+		bus: b,
+		ch:  make(chan Event, bufSize),
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		close(s.ch)
+	} else {
+		b.subs[s] = true
+	}
+	return s
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subs[s] {
+		delete(b.subs, s)
+		if s.spill == nil {
+			close(s.ch)
+		}
+	}
+	if s.spill != nil {
+		s.spill.abort()
+	}
+}
+
+// Publish delivers e to every subscriber.  It is safe to call on a nil
+// Bus.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.RLock()
+	defer b.RUnlock()
+	for s := range b.subs {
+		if s.spill != nil {
+			s.spill.publish(e)
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Close closes the Bus and all of its subscriptions.
+func (b *Bus) Close() {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subs {
+		if s.spill != nil {
+			s.spill.finish()
+		} else {
+			close(s.ch)
+		}
+	}
+	b.subs = nil
+}
@@ -0,0 +1,360 @@
+// spill.go - disk backed spill queue
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package event
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Spilled event record types.  Events that do not serialize well, such
+// as DocumentEvents, are rare and are kept in memory, with only a
+// reference to them spilled, so that the delivery order is preserved.
+const (
+	spillConnection = "connection"
+	spillSent       = "sent"
+	spillPhase      = "phase"
+	spillStep       = "step"
+	spillProbe      = "probe"
+	spillRef        = "ref"
+)
+
+type spillRecord struct {
+	Type  string
+	Ref   uint64          `json:",omitempty"`
+	Event json.RawMessage `json:",omitempty"`
+}
+
+func encodeSpillRecord(e Event, ref uint64) ([]byte, bool, error) {
+	var typ string
+	switch e.(type) {
+	case *ConnectionEvent:
+		typ = spillConnection
+	case *SentEvent:
+		typ = spillSent
+	case *PhaseEvent:
+		typ = spillPhase
+	case *StepEvent:
+		typ = spillStep
+	case *ProbeEvent:
+		typ = spillProbe
+	default:
+		b, err := json.Marshal(&spillRecord{Type: spillRef, Ref: ref})
+		return b, true, err
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := json.Marshal(&spillRecord{Type: typ, Event: raw})
+	return b, false, err
+}
+
+func decodeSpillRecord(b []byte, refs map[uint64]Event) (Event, error) {
+	r := new(spillRecord)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	var e Event
+	switch r.Type {
+	case spillConnection:
+		e = new(ConnectionEvent)
+	case spillSent:
+		e = new(SentEvent)
+	case spillPhase:
+		e = new(PhaseEvent)
+	case spillStep:
+		e = new(StepEvent)
+	case spillProbe:
+		e = new(ProbeEvent)
+	case spillRef:
+		e, ok := refs[r.Ref]
+		if !ok {
+			return nil, fmt.Errorf("event: missing spilled event reference %d", r.Ref)
+		}
+		delete(refs, r.Ref)
+		return e, nil
+	default:
+		return nil, fmt.Errorf("event: invalid spilled event type '%v'", r.Type)
+	}
+	return e, json.Unmarshal(r.Event, e)
+}
+
+// spillBacklog is the number of events that may wait in memory for the
+// spill worker to write them out, beyond which events are dropped.
+const spillBacklog = 1024
+
+// spillQueue is a bounded on-disk FIFO of the events that did not fit
+// in a subscription's buffer, drained into the buffer in order as the
+// subscriber catches up.  Publishers only ever hand events to the worker
+// through a bounded channel, the worker alone touches the queue file.
+type spillQueue struct {
+	sync.Mutex
+
+	sub *Subscription
+
+	// pending is the number of events handed to the worker that are
+	// yet to be delivered, and spilled the number written to the queue
+	// file.  They are protected by the lock.
+	pending uint64
+	spilled uint64
+
+	// The queue file and its accounting are owned by the worker.
+	f        *os.File
+	maxBytes int64
+	readOff  int64
+	writeOff int64
+	queued   uint64
+	refs     map[uint64]Event
+	nextRef  uint64
+
+	inCh     chan Event
+	finishCh chan struct{}
+	abortCh  chan struct{}
+	doneCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newSpillQueue(sub *Subscription, dir string, maxBytes int64) (*spillQueue, error) {
+	f, err := ioutil.TempFile(dir, "spray-events-")
+	if err != nil {
+		return nil, err
+	}
+	q := &spillQueue{
+		sub:      sub,
+		f:        f,
+		maxBytes: maxBytes,
+		refs:     make(map[uint64]Event),
+		inCh:     make(chan Event, spillBacklog),
+		finishCh: make(chan struct{}),
+		abortCh:  make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	go q.worker()
+	return q, nil
+}
+
+// publish delivers e straight to the subscription's buffer if nothing
+// is pending and it has room, and hands it to the worker to spill
+// otherwise.  It never blocks on the worker or on disk I/O.
+func (q *spillQueue) publish(e Event) {
+	q.Lock()
+	defer q.Unlock()
+	if q.pending == 0 {
+		select {
+		case q.sub.ch <- e:
+			return
+		default:
+		}
+	}
+	select {
+	case q.inCh <- e:
+		q.pending++
+	default:
+		atomic.AddUint64(&q.sub.dropped, 1)
+	}
+}
+
+// done accounts for n pending events that were delivered or dropped.
+func (q *spillQueue) done(n uint64) {
+	q.Lock()
+	defer q.Unlock()
+	q.pending -= n
+}
+
+// spill appends e to the queue file, dropping it if the file is full.
+func (q *spillQueue) spill(e Event) {
+	if err := q.push(e); err != nil {
+		atomic.AddUint64(&q.sub.dropped, 1)
+		q.done(1)
+		return
+	}
+	q.queued++
+	q.Lock()
+	q.spilled++
+	q.Unlock()
+}
+
+func (q *spillQueue) push(e Event) error {
+	b, isRef, err := encodeSpillRecord(e, q.nextRef)
+	if err != nil {
+		return err
+	}
+	n := int64(4 + len(b))
+	if q.writeOff+n > q.maxBytes {
+		return fmt.Errorf("event: spill queue is full")
+	}
+	rec := make([]byte, n)
+	binary.BigEndian.PutUint32(rec, uint32(len(b)))
+	copy(rec[4:], b)
+	if _, err := q.f.WriteAt(rec, q.writeOff); err != nil {
+		return err
+	}
+	q.writeOff += n
+	if isRef {
+		q.refs[q.nextRef] = e
+		q.nextRef++
+	}
+	return nil
+}
+
+// peek decodes the oldest queued event without removing it, returning
+// the offset of the next one.  Decoding consumes the in-memory reference
+// of events that are not spilled themselves, so every queued event is
+// only peeked once.
+func (q *spillQueue) peek() (Event, int64, error) {
+	if q.readOff == q.writeOff {
+		return nil, 0, nil
+	}
+	var l [4]byte
+	if _, err := q.f.ReadAt(l[:], q.readOff); err != nil {
+		return nil, 0, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(l[:]))
+	if _, err := q.f.ReadAt(b, q.readOff+4); err != nil {
+		return nil, 0, err
+	}
+	e, err := decodeSpillRecord(b, q.refs)
+	return e, q.readOff + 4 + int64(len(b)), err
+}
+
+// commit removes the oldest queued event once delivered, reclaiming the
+// disk space once the queue is empty.
+func (q *spillQueue) commit(next int64) {
+	q.readOff = next
+	q.queued--
+	if q.readOff == q.writeOff {
+		q.reset()
+	}
+	q.done(1)
+}
+
+// reset empties the queue file.
+func (q *spillQueue) reset() {
+	q.readOff, q.writeOff = 0, 0
+	q.refs = make(map[uint64]Event)
+	q.f.Truncate(0)
+}
+
+// worker spills the events handed to it and moves queued events into
+// the subscription's buffer as it drains.  Once finishing it delivers
+// the remaining events before closing the subscription's channel,
+// unless aborted.
+func (q *spillQueue) worker() {
+	defer func() {
+		close(q.sub.ch)
+		q.f.Close()
+		os.Remove(q.f.Name())
+		close(q.doneCh)
+	}()
+	finishCh := q.finishCh
+	var head Event
+	var next int64
+	for {
+		if head == nil {
+			var err error
+			if head, next, err = q.peek(); err != nil {
+				// The queue is corrupt, give up on its content.
+				atomic.AddUint64(&q.sub.dropped, q.queued)
+				q.done(q.queued)
+				q.queued = 0
+				q.reset()
+				head = nil
+				continue
+			}
+		}
+		if head == nil {
+			if finishCh == nil && len(q.inCh) == 0 {
+				return
+			}
+			select {
+			case e := <-q.inCh:
+				q.spill(e)
+			case <-finishCh:
+				finishCh = nil
+			case <-q.abortCh:
+				return
+			}
+			continue
+		}
+		select {
+		case q.sub.ch <- head:
+			q.commit(next)
+			head = nil
+		case e := <-q.inCh:
+			q.spill(e)
+		case <-finishCh:
+			finishCh = nil
+		case <-q.abortCh:
+			return
+		}
+	}
+}
+
+// finish delivers the remaining events, then closes the subscription's
+// channel.
+func (q *spillQueue) finish() {
+	q.stopOnce.Do(func() { close(q.finishCh) })
+}
+
+// abort discards the remaining events and closes the subscription's
+// channel.
+func (q *spillQueue) abort() {
+	q.stopOnce.Do(func() { close(q.abortCh) })
+	<-q.doneCh
+}
+
+// SubscribeSpill returns a new Subscription buffering up to bufSize
+// events in memory like Subscribe, and spilling the events that do not
+// fit to a temporary queue file of at most maxBytes in dir, instead of
+// dropping them.  Events are delivered in order as the subscriber
+// catches up, and only dropped once the queue file is full.  The
+// remaining spilled events are still delivered after the Bus is closed.
+func (b *Bus) SubscribeSpill(bufSize int, dir string, maxBytes int64) (*Subscription, error) {
+	s := &Subscription{
+		bus: b,
+		ch:  make(chan Event, bufSize),
+	}
+	q, err := newSpillQueue(s, dir, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.spill = q
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		q.finish()
+	} else {
+		b.subs[s] = true
+	}
+	return s, nil
+}
+
+// Spilled returns the number of events that were spilled to disk.
+func (s *Subscription) Spilled() uint64 {
+	if s.spill == nil {
+		return 0
+	}
+	s.spill.Lock()
+	defer s.spill.Unlock()
+	return s.spill.spilled
+}
@@ -0,0 +1,156 @@
+// spill_test.go - spill queue tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package event
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// spillDir returns a temporary directory for the queue files.
+func spillDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spray-spill-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// drain returns the events delivered on s until its channel is closed.
+func drain(t *testing.T, s *Subscription) []Event {
+	var events []Event
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e, ok := <-s.C():
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		case <-timeout:
+			t.Fatalf("subscription not closed after %d events", len(events))
+		}
+	}
+}
+
+// assertRemoved fails unless the queue file in dir was removed.
+func assertRemoved(t *testing.T, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("queue file %v was not removed", files[0].Name())
+	}
+}
+
+func TestSpillOrder(t *testing.T) {
+	dir := spillDir(t)
+	defer os.RemoveAll(dir)
+	b := NewBus()
+	s, err := b.SubscribeSpill(1, dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 100
+	for i := 0; i < n; i++ {
+		if i == n/2 {
+			// Documents are not spilled themselves, only a reference.
+			b.Publish(&DocumentEvent{At: time.Unix(int64(i), 0)})
+			continue
+		}
+		b.Publish(&StepEvent{At: time.Unix(int64(i), 0), Index: i})
+	}
+	b.Close()
+
+	events := drain(t, s)
+	if len(events) != n {
+		t.Fatalf("%d events delivered, want %d (%d dropped)", len(events), n, s.Dropped())
+	}
+	for i, e := range events {
+		if e.Time().Unix() != int64(i) {
+			t.Fatalf("event %d delivered at position %d", e.Time().Unix(), i)
+		}
+		if i == n/2 {
+			if _, ok := e.(*DocumentEvent); !ok {
+				t.Fatalf("referenced event delivered as %T", e)
+			}
+		} else if e.(*StepEvent).Index != i {
+			t.Fatalf("step %d decoded as %d", i, e.(*StepEvent).Index)
+		}
+	}
+	if s.Spilled() == 0 {
+		t.Error("nothing was spilled past the buffer")
+	}
+	if s.Dropped() != 0 {
+		t.Errorf("%d events dropped", s.Dropped())
+	}
+	assertRemoved(t, dir)
+}
+
+func TestSpillFull(t *testing.T) {
+	dir := spillDir(t)
+	defer os.RemoveAll(dir)
+	b := NewBus()
+	s, err := b.SubscribeSpill(1, dir, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	for i := 0; i < n; i++ {
+		b.Publish(&StepEvent{At: time.Unix(int64(i), 0), Index: i})
+	}
+	b.Close()
+
+	events := drain(t, s)
+	if s.Dropped() == 0 {
+		t.Error("nothing was dropped by a full queue")
+	}
+	if uint64(len(events))+s.Dropped() != n {
+		t.Errorf("%d events delivered and %d dropped, want %d in total", len(events), s.Dropped(), n)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Time().Before(events[i-1].Time()) {
+			t.Fatalf("event %d delivered after event %d", events[i].Time().Unix(), events[i-1].Time().Unix())
+		}
+	}
+	assertRemoved(t, dir)
+}
+
+func TestSpillAbort(t *testing.T) {
+	dir := spillDir(t)
+	defer os.RemoveAll(dir)
+	b := NewBus()
+	defer b.Close()
+	s, err := b.SubscribeSpill(1, dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		b.Publish(&StepEvent{At: time.Unix(int64(i), 0), Index: i})
+	}
+	s.Close()
+
+	// The buffered event may still be delivered, the spilled ones not.
+	if events := drain(t, s); len(events) > 1 {
+		t.Errorf("%d events delivered after the subscription was closed", len(events))
+	}
+	b.Publish(&StepEvent{At: time.Unix(10, 0), Index: 10})
+	assertRemoved(t, dir)
+}
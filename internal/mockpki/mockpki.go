@@ -0,0 +1,122 @@
+// mockpki.go - PKI client serving synthetic documents.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mockpki implements a pki.Client serving synthetic documents,
+// for running without an authority.
+package mockpki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/pki"
+)
+
+// LoopEndpoint is the endpoint of the loop service of every Provider.
+const LoopEndpoint = "+loop"
+
+var errNotSupported = errors.New("mockpki: operation not supported")
+
+// Client is a pki.Client serving the same synthetic topology for every
+// epoch.
+type Client struct {
+	layers    [][]*pki.MixDescriptor
+	providers []*pki.MixDescriptor
+
+	mu         float64
+	muMaxDelay uint64
+}
+
+// New returns a Client serving documents with the given number of mix
+// layers and mixes per layer, and the named Providers.  Every Provider
+// runs the loop service.  meanDelay and maxDelay are the mean and
+// maximum per hop mixing delays in milliseconds, zero meaning that the
+// documents do not specify mixing delays.
+func New(layers, mixesPerLayer int, providers []string, meanDelay, maxDelay uint64) (*Client, error) {
+	c := &Client{muMaxDelay: maxDelay}
+	if meanDelay > 0 {
+		c.mu = 1 / float64(meanDelay)
+	}
+	for l := 0; l < layers; l++ {
+		var layer []*pki.MixDescriptor
+		for i := 0; i < mixesPerLayer; i++ {
+			desc, err := newDescriptor(fmt.Sprintf("mix-%d-%d", l, i), uint8(l))
+			if err != nil {
+				return nil, err
+			}
+			layer = append(layer, desc)
+		}
+		c.layers = append(c.layers, layer)
+	}
+	for _, name := range providers {
+		desc, err := newDescriptor(name, pki.LayerProvider)
+		if err != nil {
+			return nil, err
+		}
+		desc.Kaetzchen = map[string]map[string]interface{}{
+			"loop": {"endpoint": LoopEndpoint},
+		}
+		c.providers = append(c.providers, desc)
+	}
+	return c, nil
+}
+
+func newDescriptor(name string, layer uint8) (*pki.MixDescriptor, error) {
+	identityKey, err := eddsa.NewKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	linkKey, err := ecdh.NewKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &pki.MixDescriptor{
+		Name:        name,
+		IdentityKey: identityKey.PublicKey(),
+		LinkKey:     linkKey.PublicKey(),
+		Layer:       layer,
+	}, nil
+}
+
+// Document returns the document of the given epoch.
+func (c *Client) Document(epoch uint64) *pki.Document {
+	return &pki.Document{
+		Epoch:      epoch,
+		Mu:         c.mu,
+		MuMaxDelay: c.muMaxDelay,
+		Topology:   c.layers,
+		Providers:  c.providers,
+	}
+}
+
+// Get returns the document of the given epoch.
+func (c *Client) Get(ctx context.Context, epoch uint64) (*pki.Document, []byte, error) {
+	return c.Document(epoch), nil, nil
+}
+
+// Post is not supported.
+func (c *Client) Post(ctx context.Context, epoch uint64, signingKey *eddsa.PrivateKey, d *pki.MixDescriptor) error {
+	return errNotSupported
+}
+
+// Deserialize is not supported.
+func (c *Client) Deserialize(raw []byte) (*pki.Document, error) {
+	return nil, errNotSupported
+}
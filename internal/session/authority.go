@@ -0,0 +1,104 @@
+// authority.go - authority load test mode
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/report"
+)
+
+// fetch fetches the document of epoch with client, returning the probe
+// result.
+func (s *Session) fetch(client pki.Client, epoch uint64, timeout time.Duration) (*report.Probe, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.HaltCh():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	p := &report.Probe{Timestamp: time.Now()}
+	s.results.onSent(p.Timestamp)
+	_, raw, err := client.Get(ctx, epoch)
+	p.Latency = time.Since(p.Timestamp)
+	p.BytesIn = uint64(len(raw))
+	switch {
+	case err == nil:
+	case s.isHalted():
+		return p, errHalted
+	case ctx.Err() == context.DeadlineExceeded:
+		err = errReplyTimeout
+	}
+	return p, err
+}
+
+func (s *Session) isHalted() bool {
+	select {
+	case <-s.HaltCh():
+		return true
+	default:
+		return false
+	}
+}
+
+// authorityWorker issues document fetches with its own PKI client, paced
+// by the rate limiter, recording every fetch as a probe.
+func (s *Session) authorityWorker(client pki.Client) {
+	cfg := s.config().AuthorityLoad
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	for {
+		if !s.waitUnpaused() {
+			return
+		}
+		if err := s.limiter.Wait(context.Background()); err != nil {
+			s.log.Errorf("authority: %v", err)
+			return
+		}
+		epoch, _, _ := s.clock.now()
+		if cfg.NextEpoch {
+			epoch++
+		}
+		res, err := s.fetch(client, epoch, timeout)
+		if err == errHalted {
+			return
+		}
+		s.results.record(res, err)
+		if err != nil {
+			s.log.Debugf("authority: fetch of epoch %d failed: %v", epoch, err)
+		}
+	}
+}
+
+// startAuthorityWorkers starts the configured number of concurrent
+// authority workers.
+func (s *Session) startAuthorityWorkers() {
+	s.log.Noticef("Load testing the authority with %d concurrent fetches.", len(s.authorityClients))
+	for _, client := range s.authorityClients {
+		client := client
+		s.Go(func() { s.authorityWorker(client) })
+	}
+	s.Go(func() {
+		<-s.HaltCh()
+		s.logResults("authority")
+	})
+}
@@ -0,0 +1,87 @@
+// bottleneck.go - send pipeline bottleneck attribution
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+)
+
+const bottleneckLogInterval = 30 * time.Second
+
+// pipelineStats measures the stages of the send pipeline: composing
+// packets in the cryptoWorkers, and handing them to the Provider in the
+// sendWorker.  Durations are in nanoseconds.
+type pipelineStats struct {
+	composed    uint64
+	composeTime int64
+	sent        uint64
+	sendTime    int64
+}
+
+func (p *pipelineStats) onCompose(d time.Duration) {
+	atomic.AddUint64(&p.composed, 1)
+	atomic.AddInt64(&p.composeTime, int64(d))
+}
+
+func (p *pipelineStats) onSend(d time.Duration) {
+	atomic.AddUint64(&p.sent, 1)
+	atomic.AddInt64(&p.sendTime, int64(d))
+}
+
+func capacity(n uint64, t int64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	return float64(n) / time.Duration(t).Seconds()
+}
+
+// bottleneck attributes the achieved send rate to the slowest stage of
+// the pipeline, or returns nil if nothing was sent yet.
+func (s *Session) bottleneck(elapsed time.Duration) *report.Bottleneck {
+	p := &s.pipeline
+	sent := atomic.LoadUint64(&p.sent)
+	if sent == 0 || elapsed <= 0 {
+		return nil
+	}
+	limit, _ := s.Rate()
+	b := &report.Bottleneck{
+		AchievedRate: float64(sent) / elapsed.Seconds(),
+		CryptoRate:   capacity(atomic.LoadUint64(&p.composed), atomic.LoadInt64(&p.composeTime)) * float64(s.config().Debug.CryptoWorkers),
+		LimiterRate:  limit,
+		WireRate:     capacity(sent, atomic.LoadInt64(&p.sendTime)),
+	}
+	b.Attribute()
+	return b
+}
+
+// bottleneckWorker periodically logs the bottleneck attribution.
+func (s *Session) bottleneckWorker() {
+	start := time.Now()
+	for {
+		select {
+		case <-s.HaltCh():
+			return
+		case <-time.After(bottleneckLogInterval):
+		}
+		if b := s.bottleneck(time.Since(start)); b != nil {
+			s.log.Infof("Send pipeline: %v", b)
+		}
+	}
+}
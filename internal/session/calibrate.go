@@ -0,0 +1,134 @@
+// calibrate.go - send cadence calibration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+	"golang.org/x/time/rate"
+)
+
+const (
+	calibrationSleeps   = 200
+	calibrationWrites   = 1000
+	calibrationPackets  = 1000
+	calibrationDuration = 2 * time.Second
+)
+
+// Calibrate measures the send cadence the host can actually deliver,
+// timer resolution and system call overhead included, and how
+// accurately the egress rate limiter paces sendRate packets per second.
+// It takes a few seconds.
+func Calibrate(sendRate float64, sendBurst int) (*report.Calibration, error) {
+	c := &report.Calibration{
+		TimerResolution: measureTimerResolution(),
+		RequestedRate:   sendRate,
+	}
+	var err error
+	if c.SyscallOverhead, err = measureSyscallOverhead(); err != nil {
+		return nil, err
+	}
+	gap := c.TimerResolution
+	if c.SyscallOverhead > gap {
+		gap = c.SyscallOverhead
+	}
+	if gap > 0 {
+		c.MaxAccurateRate = float64(time.Second) / float64(gap)
+	}
+	if sendRate > 0 {
+		c.AchievedRate, c.PacingJitter = measurePacing(sendRate, sendBurst)
+	}
+	return c, nil
+}
+
+func measureTimerResolution() time.Duration {
+	d := make([]time.Duration, calibrationSleeps)
+	for i := range d {
+		start := time.Now()
+		time.Sleep(time.Nanosecond)
+		d[i] = time.Since(start)
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	return d[len(d)/2]
+}
+
+func measureSyscallOverhead() (time.Duration, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	go io.Copy(ioutil.Discard, r)
+
+	b := []byte{0}
+	start := time.Now()
+	for i := 0; i < calibrationWrites; i++ {
+		if _, err := w.Write(b); err != nil {
+			w.Close()
+			return 0, err
+		}
+	}
+	d := time.Since(start)
+	return d / calibrationWrites, w.Close()
+}
+
+// measurePacing returns the rate achieved by a rate limiter set to
+// sendRate, and the 99th percentile of the deviation of the gaps from
+// the ideal one.
+func measurePacing(sendRate float64, sendBurst int) (float64, time.Duration) {
+	if sendBurst < 1 {
+		sendBurst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(sendRate), sendBurst)
+	ideal := time.Duration(float64(time.Second) / sendRate)
+	ctx, cancel := context.WithTimeout(context.Background(), calibrationDuration)
+	defer cancel()
+
+	// Drain the initial burst so that only the paced sends count.
+	for i := 0; i < sendBurst; i++ {
+		if !limiter.Allow() {
+			break
+		}
+	}
+	var jitter []time.Duration
+	start := time.Now()
+	last := start
+	for i := 0; i < calibrationPackets; i++ {
+		if limiter.Wait(ctx) != nil {
+			break
+		}
+		now := time.Now()
+		dev := now.Sub(last) - ideal
+		if dev < 0 {
+			dev = -dev
+		}
+		jitter = append(jitter, dev)
+		last = now
+	}
+	if len(jitter) == 0 {
+		return 0, 0
+	}
+	sort.Slice(jitter, func(i, j int) bool { return jitter[i] < jitter[j] })
+	achieved := float64(len(jitter)) / last.Sub(start).Seconds()
+	return achieved, jitter[len(jitter)*99/100]
+}
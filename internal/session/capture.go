@@ -0,0 +1,82 @@
+// capture.go - received message capture
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const (
+	captureMessage = "message"
+	captureReply   = "reply"
+)
+
+// capture writes received payloads to per-message files, named after
+// the order they were received in, their kind and an optional id.
+// Payloads are written to ".payload" files and raw ciphertexts, if
+// enabled, to ".ciphertext" files.
+type capture struct {
+	dir        string
+	ciphertext bool
+	seq        uint64
+}
+
+func newCapture(dir string, ciphertext bool) (*capture, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &capture{
+		dir:        dir,
+		ciphertext: ciphertext,
+	}, nil
+}
+
+// write captures a received message.  Either payload or ciphertext may
+// be nil, the latter being ignored unless ciphertexts are captured.
+func (c *capture) write(kind, id string, payload, ciphertext []byte) error {
+	seq := atomic.AddUint64(&c.seq, 1)
+	name := fmt.Sprintf("%08d-%s", seq, kind)
+	if id != "" {
+		name += "-" + id
+	}
+	name = filepath.Join(c.dir, name)
+	if payload != nil {
+		if err := ioutil.WriteFile(name+".payload", payload, 0600); err != nil {
+			return err
+		}
+	}
+	if ciphertext != nil && c.ciphertext {
+		if err := ioutil.WriteFile(name+".ciphertext", ciphertext, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureReceived captures a received message if capture is enabled.
+func (s *Session) captureReceived(kind, id string, payload, ciphertext []byte) {
+	if s.capture == nil {
+		return
+	}
+	if err := s.capture.write(kind, id, payload, ciphertext); err != nil {
+		s.log.Warningf("Failed to capture received %s: %v", kind, err)
+	}
+}
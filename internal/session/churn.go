@@ -0,0 +1,241 @@
+// churn.go - intermittent connectivity emulation
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/spray/config"
+)
+
+var errDisconnected = errors.New("disconnected from the Provider")
+
+// switchableClient is a MixClient whose underlying client can be torn
+// down and replaced, so that the session survives disconnects.
+type switchableClient struct {
+	sync.RWMutex
+
+	c         MixClient
+	hops      int
+	replyHops int
+	poll      time.Duration
+}
+
+func (sc *switchableClient) client() MixClient {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.c
+}
+
+func (sc *switchableClient) set(c MixClient) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.c = c
+	if hs, ok := c.(HopSetter); ok && sc.hops != 0 {
+		hs.SetHops(sc.hops)
+	}
+	if rs, ok := c.(ReplyHopSetter); ok && sc.replyHops != 0 {
+		rs.SetReplyHops(sc.replyHops)
+	}
+	if ps, ok := c.(PollIntervalSetter); ok && sc.poll != 0 {
+		ps.SetPollInterval(sc.poll)
+	}
+}
+
+// setPollInterval sets the poll interval of the underlying client, if
+// it polls, and of any client replacing it.
+func (sc *switchableClient) setPollInterval(interval time.Duration) {
+	sc.Lock()
+	defer sc.Unlock()
+	if ps, ok := sc.c.(PollIntervalSetter); ok {
+		ps.SetPollInterval(interval)
+	}
+	sc.poll = interval
+}
+
+// setHops sets the number of hops of the underlying client, and of any
+// client replacing it.
+func (sc *switchableClient) setHops(hops int) error {
+	sc.Lock()
+	defer sc.Unlock()
+	if hs, ok := sc.c.(HopSetter); ok {
+		if err := hs.SetHops(hops); err != nil {
+			return err
+		}
+	} else if hops != 0 {
+		return errors.New("the link protocol can not change the number of hops")
+	}
+	sc.hops = hops
+	return nil
+}
+
+// setReplyHops sets the number of hops of the SURB reply paths of the
+// underlying client, and of any client replacing it.
+func (sc *switchableClient) setReplyHops(hops int) error {
+	sc.Lock()
+	defer sc.Unlock()
+	if rs, ok := sc.c.(ReplyHopSetter); ok {
+		if err := rs.SetReplyHops(hops); err != nil {
+			return err
+		}
+	} else if hops != 0 {
+		return errors.New("the link protocol can not change the number of reply hops")
+	}
+	sc.replyHops = hops
+	return nil
+}
+
+// disconnect shuts down the underlying client.
+func (sc *switchableClient) disconnect() {
+	sc.Lock()
+	c := sc.c
+	sc.c = nil
+	sc.Unlock()
+	if c != nil {
+		c.Shutdown()
+	}
+}
+
+func (sc *switchableClient) ComposeSphinxPacket(recipient, provider string, surbID *[constants.SURBIDLength]byte, b []byte) ([]byte, []byte, time.Duration, error) {
+	c := sc.client()
+	if c == nil {
+		return nil, nil, 0, errDisconnected
+	}
+	return c.ComposeSphinxPacket(recipient, provider, surbID, b)
+}
+
+func (sc *switchableClient) SendSphinxPacket(pkt []byte) error {
+	c := sc.client()
+	if c == nil {
+		return errDisconnected
+	}
+	return c.SendSphinxPacket(pkt)
+}
+
+func (sc *switchableClient) CurrentDocument() *pki.Document {
+	c := sc.client()
+	if c == nil {
+		return nil
+	}
+	return c.CurrentDocument()
+}
+
+func (sc *switchableClient) ClockSkew() time.Duration {
+	c := sc.client()
+	if c == nil {
+		return 0
+	}
+	return c.ClockSkew()
+}
+
+// decryptSURBPayload decrypts a SURB reply payload, with the underlying
+// client if it is a SURBDecrypter.
+func (sc *switchableClient) decryptSURBPayload(payload, key []byte) ([]byte, error) {
+	if d, ok := sc.client().(SURBDecrypter); ok {
+		return d.DecryptSURBPayload(payload, key)
+	}
+	return sphinx.DecryptSURBPayload(payload, key)
+}
+
+func (sc *switchableClient) Shutdown() {
+	sc.disconnect()
+}
+
+// churnDuration draws a duration from the named distribution with the
+// given mean in seconds.
+func churnDuration(rng *mrand.Rand, distribution string, mean float64) time.Duration {
+	var secs float64
+	switch distribution {
+	case config.ChurnConstant:
+		secs = mean
+	case config.ChurnUniform:
+		secs = 2 * mean * rng.Float64()
+	default:
+		secs = rand.Exp(rng, 1/mean)
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// reconnect brings up a new client and waits for it to connect.
+func (s *Session) reconnect() error {
+	// Discard a stale connection notification.
+	select {
+	case <-s.onlineCh:
+	default:
+	}
+	c, err := newMixClient(s.config().Debug.LinkProtocol, s.linkCfg)
+	if err != nil {
+		return err
+	}
+	s.link.set(c)
+
+	timeout := time.Duration(s.config().Debug.SessionDialTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	select {
+	case <-s.onlineCh:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout reconnecting to the Provider")
+	case <-s.HaltCh():
+		return errHalted
+	}
+}
+
+// churnWorker alternately keeps the session connected and disconnected
+// for random durations, pausing load generation while disconnected.
+// The offline time is accounted for in the run results.
+func (s *Session) churnWorker() {
+	cfg := s.config().Churn
+	rng := newRand(s.config(), "churn")
+	for {
+		if !s.sleep(churnDuration(rng, cfg.OnDistribution, cfg.OnMean)) {
+			return
+		}
+
+		off := churnDuration(rng, cfg.OffDistribution, cfg.OffMean)
+		s.log.Noticef("Churn: disconnecting for %v.", off)
+		wentOffline := time.Now()
+		s.churnGate.pause()
+		s.link.disconnect()
+		if !s.sleep(off) {
+			return
+		}
+		err := s.reconnect()
+		s.results.onOffline(time.Since(wentOffline))
+		if err == errHalted {
+			return
+		}
+		if err != nil {
+			select {
+			case s.fatalErrCh <- err:
+			case <-s.HaltCh():
+			}
+			return
+		}
+		s.log.Noticef("Churn: reconnected after %v.", time.Since(wentOffline))
+		s.churnGate.resume()
+	}
+}
@@ -0,0 +1,79 @@
+// closedloop.go - closed-loop load generation
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+)
+
+// startClosedLoop starts the closed-loop workers of every target.
+func (s *Session) startClosedLoop() {
+	cfg := s.config().ClosedLoop
+	_, targets, _ := s.traffic.get()
+	s.log.Noticef("Closed loop: %d probes outstanding to each of %d targets.", cfg.Concurrency, len(targets))
+	for _, t := range targets {
+		for i := 0; i < cfg.Concurrency; i++ {
+			t := t
+			s.Go(func() { s.closedLoopWorker(t) })
+		}
+	}
+}
+
+// closedLoopPayload returns a payload of size bytes with the configured
+// fill.
+func (s *Session) closedLoopPayload(size int) []byte {
+	b := make([]byte, size)
+	switch s.config().Traffic.PayloadFill {
+	case config.PayloadFillRandom:
+		newRand(s.config(), "payload").Read(b)
+	case config.PayloadFillPattern:
+		pattern := s.config().Traffic.Pattern()
+		for i := range b {
+			b[i] = pattern[i%len(pattern)]
+		}
+	}
+	return b
+}
+
+// closedLoopWorker sends SURB probes to target one at a time, sending
+// the next one once the reply to the previous one arrived or timed out.
+func (s *Session) closedLoopWorker(target *config.Target) {
+	timeout := time.Duration(s.config().ClosedLoop.Timeout) * time.Second
+	_, _, payloadSize := s.traffic.get()
+	payload := s.closedLoopPayload(payloadSize)
+	for {
+		if !s.waitUnpaused() {
+			return
+		}
+		if err := s.limiter.Wait(context.Background()); err != nil {
+			s.log.Errorf("closed loop: %v", err)
+			return
+		}
+		_, res, err := s.roundTrip(target.Recipient, target.Provider, payload, report.PacketReal, timeout)
+		if err == errHalted {
+			return
+		}
+		s.results.record(res, err)
+		if err != nil {
+			s.log.Debugf("closed loop %s@%s failure: %v", target.Recipient, target.Provider, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// compose.go - compose-only dry run mode
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+// composeStats accumulates the compose-only mode measurements.
+type composeStats struct {
+	sync.Mutex
+
+	composed    uint64
+	errors      uint64
+	composeTime *stats.Histogram
+	routeDelay  *stats.Histogram
+}
+
+func newComposeStats() *composeStats {
+	return &composeStats{
+		composeTime: stats.NewHistogram(),
+		routeDelay:  stats.NewHistogram(),
+	}
+}
+
+func (c *composeStats) report(elapsed time.Duration) *report.Compose {
+	c.Lock()
+	defer c.Unlock()
+	r := &report.Compose{
+		Composed:    c.composed,
+		Errors:      c.errors,
+		ComposeTime: copyHistogram(c.composeTime),
+		RouteDelay:  copyHistogram(c.routeDelay),
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(c.composed) / elapsed.Seconds()
+	}
+	return r
+}
+
+// composeOnlyWorker composes packets carrying SURBs at the configured
+// rate and discards them, so that nothing but the PKI fetch and the
+// Provider connection ever touch the wire.  The SURBs make minclient
+// report the total mixing delay of each selected route.
+func (s *Session) composeOnlyWorker(gen TrafficGenerator) {
+	start := time.Now()
+	defer func() {
+		s.log.Noticef("compose-only: %v", s.compose.report(time.Since(start)))
+	}()
+
+	ctx, cancel := s.haltContext()
+	defer cancel()
+
+	var surbID [constants.SURBIDLength]byte
+	for {
+		if !s.waitUnpaused() {
+			return
+		}
+		if err := s.limiter.Wait(ctx); err != nil {
+			if ctx.Err() == nil {
+				s.log.Errorf("compose-only: %v", err)
+			}
+			return
+		}
+		target, payload, delay := gen.NextSend()
+		if target == nil {
+			s.log.Notice("Traffic generator is exhausted.")
+			return
+		}
+		if delay > 0 && !s.sleep(delay) {
+			return
+		}
+		if _, err := io.ReadFull(rand.Reader, surbID[:]); err != nil {
+			select {
+			case s.fatalErrCh <- err:
+			case <-s.HaltCh():
+			}
+			return
+		}
+
+		composeStart := time.Now()
+		_, _, eta, err := s.composeSphinxPacket(target.Recipient, target.Provider, &surbID, payload)
+		composeTime := time.Since(composeStart)
+
+		s.compose.Lock()
+		if err != nil {
+			s.compose.errors++
+		} else {
+			s.compose.composed++
+			s.compose.composeTime.Record(composeTime)
+			s.compose.routeDelay.Record(eta)
+		}
+		s.compose.Unlock()
+		if err != nil {
+			s.log.Warningf("compose-only: compose failure: %v", err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// control.go - run time control of a session
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// pauseGate holds back load generation while the session is paused.
+type pauseGate struct {
+	sync.Mutex
+
+	// resumeCh is closed on resume, and nil while not paused.
+	resumeCh chan interface{}
+}
+
+// pause closes the gate, and returns false if it already was.
+func (g *pauseGate) pause() bool {
+	g.Lock()
+	defer g.Unlock()
+	if g.resumeCh != nil {
+		return false
+	}
+	g.resumeCh = make(chan interface{})
+	return true
+}
+
+// resume opens the gate, and returns false if it already was.
+func (g *pauseGate) resume() bool {
+	g.Lock()
+	defer g.Unlock()
+	if g.resumeCh == nil {
+		return false
+	}
+	close(g.resumeCh)
+	g.resumeCh = nil
+	return true
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.Lock()
+	defer g.Unlock()
+	return g.resumeCh != nil
+}
+
+// wait blocks while the gate is closed, and returns false if haltCh is
+// closed in the meantime.
+func (g *pauseGate) wait(haltCh <-chan interface{}) bool {
+	g.Lock()
+	resumeCh := g.resumeCh
+	g.Unlock()
+	if resumeCh == nil {
+		select {
+		case <-haltCh:
+			return false
+		default:
+			return true
+		}
+	}
+	select {
+	case <-resumeCh:
+		return true
+	case <-haltCh:
+		return false
+	}
+}
+
+// Pause stops the generation of new load until Resume is called.
+// Packets and probes already in flight are unaffected.
+func (s *Session) Pause() {
+	if s.pause.pause() {
+		s.log.Notice("Load generation paused.")
+	}
+}
+
+// Resume resumes load generation after a Pause.
+func (s *Session) Resume() {
+	if s.pause.resume() {
+		s.log.Notice("Load generation resumed.")
+	}
+}
+
+// IsPaused returns true if load generation is paused.
+func (s *Session) IsPaused() bool {
+	return s.pause.isPaused()
+}
+
+// waitUnpaused blocks while the session is paused, waiting for the
+// connection to the Provider to be recovered, paused by the document
+// policy or kept offline by Churn, and returns false if the session was
+// halted in the meantime.
+func (s *Session) waitUnpaused() bool {
+	for _, g := range []*pauseGate{&s.pause, &s.recovery.gate, &s.docGate, &s.churnGate} {
+		if !g.wait(s.HaltCh()) {
+			return false
+		}
+	}
+	return true
+}
+
+// offlineGate tracks a deliberate disconnection by Disconnect.
+type offlineGate struct {
+	sync.Mutex
+
+	// since is the time of the disconnection, and zero while connected.
+	since time.Time
+}
+
+// Disconnect tears down the connection to the Provider until Reconnect
+// is called.  Load generation is not paused, and packets sent in the
+// meantime fail, unless the caller pauses it as well.  It fails if the
+// session is already disconnected, or if the link is churned by the
+// Churn configuration.
+func (s *Session) Disconnect() error {
+	if s.config().Churn != nil {
+		return errors.New("the connection is managed by the Churn configuration")
+	}
+	s.offline.Lock()
+	defer s.offline.Unlock()
+	if !s.offline.since.IsZero() {
+		return errors.New("already disconnected")
+	}
+	s.offline.since = time.Now()
+	s.link.disconnect()
+	s.log.Notice("Disconnected from the Provider.")
+	return nil
+}
+
+// Reconnect reconnects to the Provider after a Disconnect, and waits for
+// the connection to be established.  The time spent disconnected is
+// accounted for in the run results.
+func (s *Session) Reconnect() error {
+	s.offline.Lock()
+	defer s.offline.Unlock()
+	if s.offline.since.IsZero() {
+		return errors.New("not disconnected")
+	}
+	if err := s.reconnect(); err != nil {
+		// Tear the new client down, so that Reconnect may be retried.
+		s.link.disconnect()
+		return err
+	}
+	offline := time.Since(s.offline.since)
+	s.results.onOffline(offline)
+	s.offline.since = time.Time{}
+	s.log.Noticef("Reconnected to the Provider after %v.", offline)
+	return nil
+}
+
+// IsDisconnected returns true if the session was disconnected with
+// Disconnect.
+func (s *Session) IsDisconnected() bool {
+	s.offline.Lock()
+	defer s.offline.Unlock()
+	return !s.offline.since.IsZero()
+}
+
+// IsConnected returns true if the session is connected to the Provider.
+func (s *Session) IsConnected() bool {
+	return atomic.LoadUint32(&s.connected) == 1
+}
+
+// Rate returns the current send rate limit in packets per second and
+// the burst size.
+func (s *Session) Rate() (float64, int) {
+	return float64(s.limiter.Limit()), s.limiter.Burst()
+}
+
+// SetRate changes the send rate limit to qps packets per second.  If
+// burst is positive the burst size is changed as well.
+func (s *Session) SetRate(qps float64, burst int) {
+	s.limiter.SetLimit(rate.Limit(qps))
+	if burst > 0 {
+		s.limiter.SetBurst(burst)
+	}
+	s.log.Noticef("Send rate set to %v packets per second.", qps)
+}
+
+// SetReplyHops changes the number of hops of the SURB reply paths of
+// subsequently sent probes, including both Providers, zero meaning all of
+// the mix layers, independently of the forward paths.  It fails if the
+// link protocol can not route replies through fewer mix layers.
+func (s *Session) SetReplyHops(hops int) error {
+	if err := s.link.setReplyHops(hops); err != nil {
+		return err
+	}
+	if hops == 0 {
+		hops = s.config().Geometry.NrHops
+	}
+	s.results.setReplyHops(hops)
+	s.log.Noticef("Number of reply hops set to %d.", hops)
+	return nil
+}
+
+// SetHops changes the number of hops of the paths of subsequently sent
+// packets, including both Providers, zero meaning all of the mix layers.
+// It fails if the link protocol can not skip mix layers.
+func (s *Session) SetHops(hops int) error {
+	if err := s.link.setHops(hops); err != nil {
+		return err
+	}
+	if hops == 0 {
+		hops = s.config().Geometry.NrHops
+	}
+	s.results.setHops(hops)
+	s.log.Noticef("Number of hops set to %d.", hops)
+	return nil
+}
@@ -0,0 +1,72 @@
+// counters.go - session counters
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Counters are the cumulative counters of a session, cheap enough to be
+// read at any time, for example by a metrics exporter.
+type Counters struct {
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// SendFailures is the number of packets that failed to be sent.
+	SendFailures uint64
+
+	// Received is the number of messages received from our spool.
+	Received uint64
+
+	// ACKs is the number of SURB replies received.
+	ACKs uint64
+
+	// LimiterWaits is the number of times a packet waited for its
+	// scheduled send time, by the rate limiter or the Poisson process,
+	// and LimiterWait the total time spent waiting.
+	LimiterWaits uint64
+	LimiterWait  time.Duration
+}
+
+// counters are the live Counters, updated atomically.
+type counters struct {
+	sent         uint64
+	sendFailures uint64
+	received     uint64
+	acks         uint64
+	limiterWaits uint64
+	limiterWait  int64
+}
+
+func (c *counters) onLimiterWait(d time.Duration) {
+	atomic.AddUint64(&c.limiterWaits, 1)
+	atomic.AddInt64(&c.limiterWait, int64(d))
+}
+
+// Counters returns a snapshot of the counters of the session.
+func (s *Session) Counters() *Counters {
+	c := &s.counters
+	return &Counters{
+		Sent:         atomic.LoadUint64(&c.sent),
+		SendFailures: atomic.LoadUint64(&c.sendFailures),
+		Received:     atomic.LoadUint64(&c.received),
+		ACKs:         atomic.LoadUint64(&c.acks),
+		LimiterWaits: atomic.LoadUint64(&c.limiterWaits),
+		LimiterWait:  time.Duration(atomic.LoadInt64(&c.limiterWait)),
+	}
+}
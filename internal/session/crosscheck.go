@@ -0,0 +1,147 @@
+// crosscheck.go - PKI document cross-check mode
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/topology"
+)
+
+const crossCheckTimeout = 60 * time.Second
+
+// crossChecks accumulates the results of the crosscheck mode.
+type crossChecks struct {
+	sync.Mutex
+
+	sources []*config.PKISource
+	checks  []*report.CrossCheck
+}
+
+func (c *crossChecks) report() []*report.CrossCheck {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*report.CrossCheck(nil), c.checks...)
+}
+
+// crossCheck fetches the document for epoch from every source
+// concurrently, and compares them with the first one in the order of
+// the sources that was served.
+func (c *crossChecks) crossCheck(ctx context.Context, epoch uint64) *report.CrossCheck {
+	docs := make([]*pki.Document, len(c.sources))
+	errs := make([]error, len(c.sources))
+	var wg sync.WaitGroup
+	for i, src := range c.sources {
+		wg.Add(1)
+		go func(i int, src *config.PKISource) {
+			defer wg.Done()
+			docs[i], _, errs[i] = src.Client.Get(ctx, epoch)
+		}(i, src)
+	}
+	wg.Wait()
+
+	r := &report.CrossCheck{Epoch: epoch}
+	var ref *pki.Document
+	for i, src := range c.sources {
+		if errs[i] != nil {
+			if r.Failed == nil {
+				r.Failed = make(map[string]string)
+			}
+			r.Failed[src.Name] = errs[i].Error()
+			continue
+		}
+		if ref == nil {
+			ref = docs[i]
+			r.Reference = src.Name
+			r.Agreeing++
+			continue
+		}
+		if diff := documentDivergence(ref, docs[i]); diff != "" {
+			r.Divergent = append(r.Divergent, &report.Divergence{Source: src.Name, Diff: diff})
+		} else {
+			r.Agreeing++
+		}
+	}
+	c.Lock()
+	c.checks = append(c.checks, r)
+	c.Unlock()
+	return r
+}
+
+// documentDivergence describes how doc differs from ref, or returns the
+// empty string if they agree.
+func documentDivergence(ref, doc *pki.Document) string {
+	if doc.Epoch != ref.Epoch {
+		return fmt.Sprintf("epoch %d instead of %d", doc.Epoch, ref.Epoch)
+	}
+	if !bytes.Equal(doc.SharedRandomValue, ref.SharedRandomValue) {
+		return "shared random value differs"
+	}
+	if diff := topology.NewDiff(ref, doc); !diff.IsEmpty() {
+		return diff.String()
+	}
+	return ""
+}
+
+// crossCheckWorker cross-checks the documents of the current epoch and
+// then of every following epoch as it starts.
+func (s *Session) crossCheckWorker() {
+	defer func() {
+		checks := s.crossChecks.report()
+		disagreed := 0
+		for _, c := range checks {
+			if !c.Agrees() {
+				disagreed++
+			}
+		}
+		s.log.Noticef("PKI cross-check: %d epochs checked against %d authorities, %d disagreed", len(checks), len(s.crossChecks.sources), disagreed)
+	}()
+
+	var last uint64
+	for {
+		epoch, _, till := s.clock.now()
+		if epoch != last {
+			last = epoch
+			ctx, cancel := context.WithTimeout(context.Background(), crossCheckTimeout)
+			go func() {
+				select {
+				case <-s.HaltCh():
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			c := s.crossChecks.crossCheck(ctx, epoch)
+			cancel()
+			if c.Agrees() {
+				s.log.Debugf("PKI cross-check: %v", c)
+			} else {
+				s.log.Warningf("PKI cross-check: %v", c)
+			}
+			_, _, till = s.clock.now()
+		}
+		if !s.sleep(till) {
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// discovery.go - recipient discovery
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+)
+
+// userdirRequest is a request for a page of the user list of a user
+// directory service, starting at Offset.
+type userdirRequest struct {
+	Version int
+	Offset  int
+}
+
+// userdirResponse is a page of the user list.  Next is the offset of the
+// next page, zero if this is the last one.
+type userdirResponse struct {
+	Version int
+	Users   []string
+	Next    int
+	Error   string `json:",omitempty"`
+}
+
+// discoverTargets queries the user directory service of the Providers
+// and returns their users as load targets.
+func (s *Session) discoverTargets(ctx context.Context) ([]*config.Target, error) {
+	cfg := s.config().Discovery
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if err := s.waitForConnection(ctx); err != nil {
+		return nil, fmt.Errorf("discovery failure, never connected to Provider: %v", err)
+	}
+
+	var targets []*config.Target
+	for _, sd := range FindServices(cfg.Capability, s.lastDoc) {
+		if !discoveryWanted(cfg.Providers, sd.Provider) {
+			continue
+		}
+		users, err := s.listUsers(&sd, cfg.MaxRecipients, timeout)
+		if err == errHalted {
+			return nil, err
+		}
+		if err != nil {
+			s.log.Warningf("Discovery: listing the users of %v failed: %v", sd.Provider, err)
+			continue
+		}
+		s.log.Noticef("Discovery: %d recipients on %v.", len(users), sd.Provider)
+		for _, u := range users {
+			targets = append(targets, &config.Target{
+				Provider:  sd.Provider,
+				Recipient: u,
+				Weight:    1,
+			})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("discovery failure, no recipients found via the %v service", cfg.Capability)
+	}
+	return targets, nil
+}
+
+func discoveryWanted(providers []string, provider string) bool {
+	if len(providers) == 0 {
+		return true
+	}
+	for _, p := range providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// listUsers pages through the user list of a user directory service,
+// returning at most max users unless max is zero.
+func (s *Session) listUsers(service *ServiceDescriptor, max int, timeout time.Duration) ([]string, error) {
+	var users []string
+	offset := 0
+	for {
+		req, err := json.Marshal(&userdirRequest{Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		b, _, err := s.roundTrip(service.Name, service.Provider, req, report.PacketReal, timeout)
+		if err != nil {
+			return nil, err
+		}
+		// The reply is padded by the Provider.
+		var resp userdirResponse
+		if err = json.Unmarshal(bytes.TrimRight(b, "\x00"), &resp); err != nil {
+			return nil, fmt.Errorf("invalid reply: %v", err)
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("service error: %v", resp.Error)
+		}
+		for _, u := range resp.Users {
+			if u == "" {
+				continue
+			}
+			users = append(users, u)
+			if max > 0 && len(users) == max {
+				return users, nil
+			}
+		}
+		if resp.Next == 0 {
+			return users, nil
+		}
+		if resp.Next <= offset {
+			return nil, fmt.Errorf("invalid next page offset %v", resp.Next)
+		}
+		offset = resp.Next
+	}
+}
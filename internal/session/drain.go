@@ -0,0 +1,101 @@
+// drain.go - spool drain mode
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+)
+
+// drainStats accounts for the messages retrieved from our own spool in
+// the drain mode, which empties the spool of an account filled by a
+// previous test.
+type drainStats struct {
+	sync.Mutex
+
+	start    time.Time
+	end      time.Time
+	messages uint64
+	bytes    uint64
+	emptyCh  chan interface{}
+}
+
+func newDrainStats() *drainStats {
+	return &drainStats{
+		start:   time.Now(),
+		emptyCh: make(chan interface{}, 1),
+	}
+}
+
+func (d *drainStats) onMessage(b []byte) {
+	d.Lock()
+	defer d.Unlock()
+	d.messages++
+	d.bytes += uint64(len(b))
+}
+
+// onEmpty is called every time the Provider reports the spool empty.
+func (d *drainStats) onEmpty() {
+	select {
+	case d.emptyCh <- true:
+	default:
+	}
+}
+
+func (d *drainStats) report() *report.Drain {
+	d.Lock()
+	defer d.Unlock()
+	r := &report.Drain{
+		Messages: d.messages,
+		Bytes:    d.bytes,
+		Complete: !d.end.IsZero(),
+	}
+	if r.Complete {
+		r.Duration = d.end.Sub(d.start)
+	} else {
+		r.Duration = time.Since(d.start)
+	}
+	return r
+}
+
+// drainWorker waits for the Provider to report the spool empty, and then
+// ends the run.
+func (s *Session) drainWorker() {
+	d := s.drain
+	s.log.Noticef("Draining the spool of %v.", s.config().Account.Identifier())
+	select {
+	case <-d.emptyCh:
+	case <-s.HaltCh():
+		return
+	}
+	d.Lock()
+	d.end = time.Now()
+	d.Unlock()
+	s.log.Noticef("drain: %v", d.report())
+	s.finish()
+}
+
+// onEmpty will be called by the minclient api when the spool is empty.
+func (s *Session) onEmpty() error {
+	select {
+	case s.opCh <- opIsEmpty{}:
+	case <-s.HaltCh():
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// echo.go - loop service echo probe
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+
+	"github.com/katzenpost/core/crypto/rand"
+)
+
+const (
+	loopService       = "loop"
+	echoPayloadLength = 256
+	echoHMACKeyLength = 32
+)
+
+func init() {
+	RegisterKaetzchenProbe("echo", func(s *Session) (KaetzchenProbe, error) {
+		p := new(echoProbe)
+		if s.config().Kaetzchen != nil && s.config().Kaetzchen.EchoHMAC {
+			p.hmacKey = make([]byte, echoHMACKeyLength)
+			if _, err := io.ReadFull(rand.Reader, p.hmacKey); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	})
+}
+
+// echoProbe sends random payloads to the loop service and expects them
+// to be echoed back unmodified.  Replies are either compared byte for
+// byte against the request, or if an HMAC key is set, authenticated with
+// a tag computed under a per-run secret that covers the whole payload.
+type echoProbe struct {
+	hmacKey []byte
+}
+
+func (p *echoProbe) tag(body []byte) []byte {
+	m := hmac.New(sha256.New, p.hmacKey)
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+func (p *echoProbe) Capability() string {
+	return loopService
+}
+
+func (p *echoProbe) NewRequest() ([]byte, error) {
+	payload := make([]byte, echoPayloadLength)
+	if _, err := io.ReadFull(rand.Reader, payload); err != nil {
+		return nil, err
+	}
+	if p.hmacKey != nil {
+		body := payload[:echoPayloadLength-sha256.Size]
+		copy(payload[len(body):], p.tag(body))
+	}
+	return payload, nil
+}
+
+func (p *echoProbe) ValidateResponse(request, response []byte) error {
+	// The reply is padded by the Provider, so only the prefix matters.
+	if len(response) < echoPayloadLength {
+		return ErrIntegrity
+	}
+	if p.hmacKey != nil {
+		body := response[:echoPayloadLength-sha256.Size]
+		if !hmac.Equal(response[len(body):echoPayloadLength], p.tag(body)) {
+			return ErrIntegrity
+		}
+		return nil
+	}
+	if !bytes.Equal(response[:len(request)], request) {
+		return ErrIntegrity
+	}
+	return nil
+}
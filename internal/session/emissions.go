@@ -0,0 +1,181 @@
+// emissions.go - packet emission time recording
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/spray/event"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+// maxEmissionSamples bounds the number of intervals retained per packet
+// class for the goodness of fit tests.
+const maxEmissionSamples = 1 << 20
+
+type emissionClass struct {
+	packets   uint64
+	first     time.Time
+	last      time.Time
+	intervals []float64
+	histogram *stats.Histogram
+	truncated bool
+}
+
+// emissions records the emission time of every packet sent, by class.
+type emissions struct {
+	sync.Mutex
+
+	classes map[string]*emissionClass
+	out     *bufio.Writer
+	outFile *os.File
+}
+
+func newEmissions() *emissions {
+	return &emissions{
+		classes: make(map[string]*emissionClass),
+	}
+}
+
+// openLog starts logging every emission to the named file as CSV
+// "unix_nanoseconds,class,length" records.
+func (e *emissions) openLog(f string) error {
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.outFile = out
+	e.out = bufio.NewWriter(out)
+	return nil
+}
+
+func (e *emissions) record(class string, t time.Time, length int) {
+	e.Lock()
+	defer e.Unlock()
+	c, ok := e.classes[class]
+	if !ok {
+		c = &emissionClass{
+			first:     t,
+			histogram: stats.NewHistogram(),
+		}
+		e.classes[class] = c
+	} else {
+		d := t.Sub(c.last)
+		c.histogram.Record(d)
+		if len(c.intervals) < maxEmissionSamples {
+			c.intervals = append(c.intervals, d.Seconds())
+		} else {
+			c.truncated = true
+		}
+	}
+	c.packets++
+	c.last = t
+	if e.out != nil {
+		fmt.Fprintf(e.out, "%d,%s,%d\n", t.UnixNano(), class, length)
+	}
+}
+
+// analyze returns the analysis of the recorded emissions, given the
+// expected Poisson rates by class.
+func (e *emissions) analyze(expected map[string]float64) *report.CoverTraffic {
+	e.Lock()
+	defer e.Unlock()
+	r := new(report.CoverTraffic)
+	for _, class := range []string{report.PacketReal, report.PacketDecoy} {
+		c, ok := e.classes[class]
+		if !ok {
+			continue
+		}
+		a := &report.CoverTrafficClass{
+			Class:          class,
+			Packets:        c.packets,
+			ExpectedRate:   expected[class],
+			InterDeparture: c.histogram.Buckets(),
+			Truncated:      c.truncated,
+		}
+		if span := c.last.Sub(c.first).Seconds(); span > 0 {
+			a.ObservedRate = float64(c.packets-1) / span
+		}
+		samples := make([]float64, len(c.intervals))
+		copy(samples, c.intervals)
+		a.KS = stats.KSExponential(samples, a.ExpectedRate)
+		a.KSFitted = stats.KSExponential(samples, a.ObservedRate)
+		r.Classes = append(r.Classes, a)
+	}
+	return r
+}
+
+func (e *emissions) close() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.out == nil {
+		return nil
+	}
+	err := e.out.Flush()
+	if cErr := e.outFile.Close(); err == nil {
+		err = cErr
+	}
+	e.out = nil
+	e.outFile = nil
+	return err
+}
+
+// sendPacket sends a composed packet to the Provider, accounting for it
+// as a packet of the given class.
+func (s *Session) sendPacket(pkt []byte, class string) error {
+	if err := s.minclient.SendSphinxPacket(pkt); err != nil {
+		atomic.AddUint64(&s.counters.sendFailures, 1)
+		return err
+	}
+	if n := atomic.AddUint64(&s.counters.sent, 1); n == s.config().Traffic.MaxPackets {
+		close(s.limitCh)
+	}
+	now := time.Now()
+	if s.emissions != nil {
+		s.emissions.record(class, now, len(pkt))
+	}
+	s.results.onSent(now)
+	s.events.Publish(&event.SentEvent{At: now, Length: len(pkt)})
+	return nil
+}
+
+// CoverTraffic returns the analysis of the emission times of the real
+// and decoy packets sent so far, or nil if emissions are not recorded.
+func (s *Session) CoverTraffic() *report.CoverTraffic {
+	if s.emissions == nil {
+		return nil
+	}
+	expected := map[string]float64{
+		report.PacketReal: s.config().Debug.SendRate,
+	}
+	if lCfg := s.config().Loop; lCfg != nil {
+		if lCfg.Rate > 0 {
+			expected[report.PacketDecoy] = lCfg.Rate
+		} else if doc := s.minclient.CurrentDocument(); doc != nil {
+			expected[report.PacketDecoy] = doc.LambdaL * 1000
+		}
+	}
+	return s.emissions.analyze(expected)
+}
@@ -0,0 +1,144 @@
+// epoch.go - epoch clock
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/config"
+)
+
+var (
+	epochPeriodLock sync.Mutex
+	epochPeriodSet  time.Duration
+)
+
+// SetEpochPeriod sets the process wide epoch period, which minclient and
+// the PKI clients pick up from epochtime, from the Debug EpochPeriod of
+// cfg.  It must be called before any session is started, as the period
+// is only written the first time, and a later configuration that
+// disagrees with it is rejected.
+func SetEpochPeriod(cfg *config.Config) error {
+	if cfg.Debug.EpochPeriod <= 0 {
+		return nil
+	}
+	period := time.Duration(cfg.Debug.EpochPeriod) * time.Second
+
+	epochPeriodLock.Lock()
+	defer epochPeriodLock.Unlock()
+	switch epochPeriodSet {
+	case 0:
+		epochtime.Period = period
+		epochPeriodSet = period
+	case period:
+	default:
+		return fmt.Errorf("session: Debug EpochPeriod %v disagrees with the process wide epoch period %v", period, epochPeriodSet)
+	}
+	return nil
+}
+
+// epochClock maps times to epochs.  Its period starts out as the
+// configured or compiled in epoch period, and is re-derived from the PKI
+// documents if they disagree with it, so that all of the epoch based
+// timers follow time-compressed lab networks.
+type epochClock struct {
+	sync.RWMutex
+
+	period time.Duration
+}
+
+func newEpochClock(period time.Duration) *epochClock {
+	return &epochClock{period: period}
+}
+
+func (c *epochClock) getPeriod() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.period
+}
+
+// at returns the epoch at t, the time elapsed since its start and the
+// time till the next one.
+func (c *epochClock) at(t time.Time) (current uint64, elapsed, till time.Duration) {
+	period := c.getPeriod()
+	d := t.Sub(epochtime.Epoch)
+	current = uint64(d / period)
+	elapsed = d - time.Duration(current)*period
+	till = period - elapsed
+	return
+}
+
+// now returns the current epoch, the time elapsed since its start and
+// the time till the next one.
+func (c *epochClock) now() (current uint64, elapsed, till time.Duration) {
+	return c.at(time.Now())
+}
+
+// sync checks the epoch of a document received at t against the clock,
+// allowing for the document to be off by one around epoch boundaries.
+// If it is further off, the period is re-derived from the document, and
+// the new period is returned.
+func (c *epochClock) sync(epoch uint64, t time.Time) (time.Duration, bool) {
+	current, _, _ := c.at(t)
+	if epoch+1 >= current && epoch <= current+1 {
+		return 0, false
+	}
+	period, ok := inferEpochPeriod(epoch, t)
+	if !ok {
+		return 0, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.period = period
+	return period, true
+}
+
+// inferEpochPeriod derives the epoch period from the current epoch at t.
+// The period lies between the time elapsed since the start of epoch zero
+// divided by epoch+1 and by epoch, a window narrow enough for anything
+// but the first few epochs to pin down a period of whole seconds.
+func inferEpochPeriod(epoch uint64, t time.Time) (time.Duration, bool) {
+	elapsed := t.Sub(epochtime.Epoch)
+	if epoch == 0 || elapsed <= 0 {
+		return 0, false
+	}
+	hi := elapsed / time.Duration(epoch)
+	lo := elapsed / time.Duration(epoch+1)
+	period := hi - hi%time.Second
+	if period <= lo {
+		return 0, false
+	}
+	return period, true
+}
+
+// syncEpochClock checks the epoch clock against a new document.
+func (s *Session) syncEpochClock(doc *pki.Document) {
+	if period, ok := s.clock.sync(doc.Epoch, time.Now()); ok {
+		s.log.Warningf("PKI document for epoch %d disagrees with the epoch clock, using a derived epoch period of %v.  Set Debug EpochPeriod to match the network.", doc.Epoch, period)
+	}
+}
+
+// Epoch returns the current epoch as per the session's epoch clock, and
+// the time till the next one.
+func (s *Session) Epoch() (uint64, time.Duration) {
+	epoch, _, till := s.clock.now()
+	return epoch, till
+}
@@ -0,0 +1,74 @@
+// faults.go - fault injection hooks
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+)
+
+var errInjectedComposeFailure = errors.New("fault injection: compose failure")
+
+// faultInjector decides when to inject faults.  A nil *faultInjector
+// never injects anything, so the hooks may be called unconditionally.
+type faultInjector struct {
+	sync.Mutex
+
+	cfg *config.FaultInjection
+	rng *mrand.Rand
+}
+
+func newFaultInjector(cfg *config.FaultInjection, rng *mrand.Rand) *faultInjector {
+	if cfg == nil {
+		return nil
+	}
+	return &faultInjector{
+		cfg: cfg,
+		rng: rng,
+	}
+}
+
+func (f *faultInjector) roll(p float64) bool {
+	if p == 0 {
+		return false
+	}
+	f.Lock()
+	defer f.Unlock()
+	return f.rng.Float64() < p
+}
+
+func (f *faultInjector) composeFailure() error {
+	if f == nil || !f.roll(f.cfg.ComposeFailureProbability) {
+		return nil
+	}
+	return errInjectedComposeFailure
+}
+
+func (f *faultInjector) ackDelay() time.Duration {
+	if f == nil || !f.roll(f.cfg.ACKDelayProbability) {
+		return 0
+	}
+	return time.Duration(f.cfg.ACKDelay) * time.Millisecond
+}
+
+func (f *faultInjector) dropDocument() bool {
+	return f != nil && f.roll(f.cfg.DocumentDropProbability)
+}
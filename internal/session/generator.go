@@ -0,0 +1,157 @@
+// generator.go - pluggable traffic generators
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"gopkg.in/op/go-logging.v1"
+)
+
+// DefaultTrafficGenerator is the name of the stock traffic generator,
+// which sends to the configured targets as fast as the rate limiter
+// allows.
+const DefaultTrafficGenerator = "default"
+
+// TrafficGenerator decides what the flood and mailbox sender modes send
+// and when.  NextSend is never called concurrently.
+type TrafficGenerator interface {
+	// NextSend returns the target and payload of the next packet, and
+	// how long to wait before composing it.  The send rate limit is
+	// applied on top of the delay.  A nil target ends the run.  The
+	// payload may be reused once NextSend is called again.
+	NextSend() (*config.Target, []byte, time.Duration)
+}
+
+// TrafficGeneratorFactory constructs a TrafficGenerator for a Session.
+type TrafficGeneratorFactory func(s *Session) (TrafficGenerator, error)
+
+var (
+	trafficGeneratorsLock sync.Mutex
+	trafficGenerators     = map[string]TrafficGeneratorFactory{
+		DefaultTrafficGenerator: newDefaultGenerator,
+	}
+)
+
+// RegisterTrafficGenerator registers a traffic generator by name, making
+// it selectable with the Debug TrafficGenerator configuration option.
+func RegisterTrafficGenerator(name string, factory TrafficGeneratorFactory) {
+	trafficGeneratorsLock.Lock()
+	defer trafficGeneratorsLock.Unlock()
+	trafficGenerators[name] = factory
+}
+
+func (s *Session) newTrafficGenerator(name string) (TrafficGenerator, error) {
+	trafficGeneratorsLock.Lock()
+	factory, ok := trafficGenerators[name]
+	trafficGeneratorsLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown traffic generator: %v", name)
+	}
+	return factory(s)
+}
+
+// defaultGenerator sends to the weighted targets without delay, payloads
+// sized and filled as the Traffic block configures, stamping each payload
+// with a probe header in the mailbox sender mode.  Every
+// target has its own sequence space, so that the receivers see no gaps
+// caused by the probes sent to the other targets.  The targets and the
+// payload size are those of the session's traffic parameters, and follow
+// Reload.
+type defaultGenerator struct {
+	traffic    *trafficParams
+	generation uint64
+	targets    *targetPicker
+	payload    []byte
+	codec      *probeCodec
+	skew       func() time.Duration
+	log        *logging.Logger
+	stamp      bool
+	seqs       map[config.Target]uint64
+
+	// rng refills the payload before every send with the random fill,
+	// and pattern is the pattern fill, if any.  pickRng picks the
+	// targets, across reloads.
+	rng     *mrand.Rand
+	pattern []byte
+	pickRng *mrand.Rand
+}
+
+func newDefaultGenerator(s *Session) (TrafficGenerator, error) {
+	tCfg := s.config().Traffic
+	g := &defaultGenerator{
+		traffic: &s.traffic,
+		codec:   s.probeCodec,
+		skew:    s.minclient.ClockSkew,
+		log:     s.log,
+		stamp:   s.config().Debug.Mode == config.ModeMailboxSender,
+		seqs:    make(map[config.Target]uint64),
+		pickRng: newRand(s.config(), "targets"),
+	}
+	switch tCfg.PayloadFill {
+	case config.PayloadFillRandom:
+		g.rng = newRand(s.config(), "payload")
+	case config.PayloadFillPattern:
+		g.pattern = tCfg.Pattern()
+	}
+	g.reload()
+	if g.stamp && len(g.payload) < taggedProbeLength {
+		return nil, fmt.Errorf("Traffic PayloadSize %v is too small for the probe header", len(g.payload))
+	}
+	return g, nil
+}
+
+// reload picks up the current traffic parameters.
+func (g *defaultGenerator) reload() {
+	generation, targets, payloadSize := g.traffic.get()
+	g.generation = generation
+	g.targets = newTargetPicker(targets, g.pickRng)
+	g.payload = make([]byte, payloadSize)
+	if g.pattern != nil {
+		for i := range g.payload {
+			g.payload[i] = g.pattern[i%len(g.pattern)]
+		}
+	}
+}
+
+func (g *defaultGenerator) NextSend() (*config.Target, []byte, time.Duration) {
+	if g.traffic.changed(g.generation) {
+		g.reload()
+	}
+	target := g.targets.next()
+	if g.rng != nil {
+		g.rng.Read(g.payload)
+	}
+	if g.stamp {
+		k := config.Target{Provider: target.Provider, Recipient: target.Recipient}
+		h := &probeHeader{
+			seq:    g.seqs[k],
+			sentAt: time.Now(),
+			skew:   g.skew(),
+		}
+		if err := g.codec.encode(h, g.payload); err != nil {
+			g.log.Errorf("Failed to stamp probe: %v", err)
+			return nil, nil, 0
+		}
+		g.seqs[k]++
+	}
+	return target, g.payload, 0
+}
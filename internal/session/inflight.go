@@ -0,0 +1,70 @@
+// inflight.go - outstanding probe inspection
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"sort"
+	"time"
+)
+
+// InFlightProbe is a probe still awaiting its SURB reply.
+type InFlightProbe struct {
+	// Seq is the order in which the probe was sent, across all of the
+	// SURB probes of the session.
+	Seq uint64
+
+	// Recipient is the recipient of the probe.
+	Recipient string
+
+	// Provider is the Provider of the recipient.
+	Provider string
+
+	// Class is the packet class of the probe, either "real" or "decoy".
+	Class string
+
+	// SentAt is the time the probe was sent.
+	SentAt time.Time
+
+	// Age is the time elapsed since the probe was sent.
+	Age time.Duration
+
+	// ETA is the expected round trip time of the probe.
+	ETA time.Duration
+}
+
+// InFlight returns a snapshot of the probes still awaiting their replies,
+// oldest first, so that operators can see what the session is waiting on.
+func (s *Session) InFlight() []*InFlightProbe {
+	now := time.Now()
+	t := s.surbs
+	t.Lock()
+	probes := make([]*InFlightProbe, 0, len(t.pending))
+	for _, r := range t.pending {
+		probes = append(probes, &InFlightProbe{
+			Seq:       r.seq,
+			Recipient: r.recipient,
+			Provider:  r.provider,
+			Class:     r.class,
+			SentAt:    r.sentAt,
+			Age:       now.Sub(r.sentAt),
+			ETA:       r.eta,
+		})
+	}
+	t.Unlock()
+	sort.Slice(probes, func(i, j int) bool { return probes[i].Seq < probes[j].Seq })
+	return probes
+}
@@ -0,0 +1,130 @@
+// kaetzchen.go - generic Kaetzchen request/response probes
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+)
+
+// ErrIntegrity is returned by KaetzchenProbe.ValidateResponse when a reply
+// arrived but its content was corrupted along the path.
+var ErrIntegrity = errors.New("reply integrity check failed")
+
+// KaetzchenProbe is a request/response probe against a Provider-side
+// Kaetzchen service.  Load testing a new service only requires
+// implementing this interface and registering it with
+// RegisterKaetzchenProbe.
+type KaetzchenProbe interface {
+	// Capability returns the Kaetzchen capability name of the service.
+	Capability() string
+
+	// NewRequest returns the payload of the next request.
+	NewRequest() ([]byte, error)
+
+	// ValidateResponse returns an error if response is not a valid
+	// reply to request, ErrIntegrity if the reply content is corrupt.
+	ValidateResponse(request, response []byte) error
+}
+
+// KaetzchenProbeFactory constructs a KaetzchenProbe for a Session.
+type KaetzchenProbeFactory func(s *Session) (KaetzchenProbe, error)
+
+var (
+	kaetzchenProbesLock sync.Mutex
+	kaetzchenProbes     = make(map[string]KaetzchenProbeFactory)
+)
+
+// RegisterKaetzchenProbe registers a KaetzchenProbe factory by name, for
+// use with the "kaetzchen" mode.
+func RegisterKaetzchenProbe(name string, factory KaetzchenProbeFactory) {
+	kaetzchenProbesLock.Lock()
+	defer kaetzchenProbesLock.Unlock()
+	kaetzchenProbes[name] = factory
+}
+
+func newKaetzchenProbe(name string, s *Session) (KaetzchenProbe, error) {
+	kaetzchenProbesLock.Lock()
+	factory, ok := kaetzchenProbes[name]
+	kaetzchenProbesLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown Kaetzchen probe: %v", name)
+	}
+	return factory(s)
+}
+
+// Probe performs a single request/response exchange with a randomly
+// selected service matching the probe's capability, returning the round
+// trip time.
+func (s *Session) Probe(p KaetzchenProbe, timeout time.Duration) (time.Duration, error) {
+	res, err := s.probe(p, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return res.Latency, nil
+}
+
+func (s *Session) probe(p KaetzchenProbe, timeout time.Duration) (*report.Probe, error) {
+	service, err := s.GetService(p.Capability())
+	if err != nil {
+		return nil, err
+	}
+	req, err := p.NewRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, res, err := s.roundTrip(service.Name, service.Provider, req, report.PacketReal, timeout)
+	if err != nil {
+		return res, err
+	}
+	return res, p.ValidateResponse(req, resp)
+}
+
+func (s *Session) kaetzchenWorker() {
+	cfg := s.config().Kaetzchen
+	p, err := newKaetzchenProbe(cfg.Probe, s)
+	if err != nil {
+		select {
+		case s.fatalErrCh <- err:
+		case <-s.HaltCh():
+		}
+		return
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	defer s.logResults("kaetzchen " + cfg.Probe)
+
+	s.log.Noticef("Probing Kaetzchen capability '%s' with the %s probe.", p.Capability(), cfg.Probe)
+	for i := 0; cfg.Requests == 0 || i < cfg.Requests; i++ {
+		if !s.waitUnpaused() {
+			return
+		}
+		res, err := s.probe(p, timeout)
+		if err == errHalted {
+			return
+		}
+		s.results.record(res, err)
+		if err != nil {
+			s.log.Warningf("kaetzchen %s failure: %v", cfg.Probe, err)
+			continue
+		}
+		s.log.Debugf("kaetzchen %s took %v", cfg.Probe, res.Latency)
+	}
+}
@@ -0,0 +1,143 @@
+// link.go - pluggable link protocols
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"git.schwanenlied.me/yawning/kyber.git"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/minclient"
+	"github.com/katzenpost/spray/config"
+)
+
+// DefaultLinkProtocol is the name of the stock minclient link protocol.
+const DefaultLinkProtocol = "default"
+
+// MixClient is the mixnet client API used by a Session, as implemented
+// by minclient.
+type MixClient interface {
+	// ComposeSphinxPacket composes a Sphinx packet destined to the
+	// recipient, returning the packet, the SURB decryption key and the
+	// expected round trip time if surbID is not nil.
+	ComposeSphinxPacket(recipient, provider string, surbID *[constants.SURBIDLength]byte, b []byte) ([]byte, []byte, time.Duration, error)
+
+	// SendSphinxPacket sends a composed packet to the Provider.
+	SendSphinxPacket(pkt []byte) error
+
+	// CurrentDocument returns the current PKI document, or nil.
+	CurrentDocument() *pki.Document
+
+	// ClockSkew returns the estimated clock skew versus the Provider.
+	ClockSkew() time.Duration
+
+	// Shutdown tears down the client.
+	Shutdown()
+}
+
+// LinkConfig is the configuration handed to a LinkProtocolFactory.
+type LinkConfig struct {
+	*minclient.ClientConfig
+
+	// KEMKey is the Kyber768 half of a hybrid link key, or nil if the
+	// link key is a classical X25519 key.
+	KEMKey *kyber.PrivateKey
+
+	// Geometry is the Sphinx geometry of the target network.
+	Geometry *config.Geometry
+
+	// Mock is the mock mixnet configuration, or nil.
+	Mock *config.Mock
+}
+
+// PollIntervalSetter is implemented by MixClients polling the receive
+// queue of the Provider, such as minclient's.
+type PollIntervalSetter interface {
+	// SetPollInterval sets the interval the receive queue is polled at.
+	SetPollInterval(interval time.Duration)
+}
+
+// HopSetter is implemented by MixClients able to route through fewer
+// than all of the mix layers.
+type HopSetter interface {
+	// SetHops sets the number of hops of the paths of subsequently
+	// composed packets, including both Providers, zero meaning all of
+	// the layers of the PKI document.
+	SetHops(hops int) error
+}
+
+// ReplyHopSetter is implemented by MixClients able to route SURB replies
+// through fewer than all of the mix layers, independently of the forward
+// paths.
+type ReplyHopSetter interface {
+	// SetReplyHops sets the number of hops of the reply paths of the
+	// SURBs of subsequently composed packets, including both Providers,
+	// zero meaning all of the layers of the PKI document.
+	SetReplyHops(hops int) error
+}
+
+// SURBDecrypter is implemented by MixClients whose SURB replies are not
+// Sphinx encrypted, such as the mock mixnet.
+type SURBDecrypter interface {
+	// DecryptSURBPayload decrypts a SURB reply payload with the key
+	// returned by ComposeSphinxPacket.
+	DecryptSURBPayload(payload, key []byte) ([]byte, error)
+}
+
+// LinkProtocolFactory constructs a MixClient speaking a particular link
+// protocol.
+type LinkProtocolFactory func(cfg *LinkConfig) (MixClient, error)
+
+var (
+	linkProtocolsLock sync.Mutex
+	linkProtocols     = map[string]LinkProtocolFactory{
+		DefaultLinkProtocol: newDefaultMixClient,
+	}
+)
+
+func newDefaultMixClient(cfg *LinkConfig) (MixClient, error) {
+	if cfg.KEMKey != nil {
+		return nil, fmt.Errorf("link protocol '%v' does not support hybrid link keys", DefaultLinkProtocol)
+	}
+	if !cfg.Geometry.IsDefault() {
+		return nil, fmt.Errorf("link protocol '%v' only supports the compiled in Sphinx geometry", DefaultLinkProtocol)
+	}
+	return minclient.New(cfg.ClientConfig)
+}
+
+// RegisterLinkProtocol registers a link protocol by name, making it
+// selectable with the Debug LinkProtocol configuration option.  This
+// allows testing Providers during link protocol migrations without
+// forking the session code.
+func RegisterLinkProtocol(name string, factory LinkProtocolFactory) {
+	linkProtocolsLock.Lock()
+	defer linkProtocolsLock.Unlock()
+	linkProtocols[name] = factory
+}
+
+func newMixClient(name string, cfg *LinkConfig) (MixClient, error) {
+	linkProtocolsLock.Lock()
+	factory, ok := linkProtocols[name]
+	linkProtocolsLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown link protocol: %v", name)
+	}
+	return factory(cfg)
+}
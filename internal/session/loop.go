@@ -0,0 +1,164 @@
+// loop.go - decoy loop round trip statistics
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+// loopStats accumulates the decoy loop measurements.
+type loopStats struct {
+	sync.Mutex
+
+	clock   *epochClock
+	sent    uint64
+	lost    uint64
+	corrupt uint64
+	rtt     *stats.Histogram
+	epochs  map[uint64]*report.LoopEpoch
+}
+
+func newLoopStats(clock *epochClock) *loopStats {
+	return &loopStats{
+		clock:  clock,
+		rtt:    stats.NewHistogram(),
+		epochs: make(map[uint64]*report.LoopEpoch),
+	}
+}
+
+func (l *loopStats) record(p *report.Probe, err error) {
+	epoch, _, _ := l.clock.at(p.Timestamp)
+
+	l.Lock()
+	defer l.Unlock()
+	e, ok := l.epochs[epoch]
+	if !ok {
+		e = &report.LoopEpoch{Epoch: epoch}
+		l.epochs[epoch] = e
+	}
+	l.sent++
+	e.Sent++
+	switch err {
+	case nil:
+		l.rtt.Record(p.Latency)
+	case errReplyTimeout:
+		l.lost++
+		e.Lost++
+	case ErrIntegrity:
+		l.corrupt++
+	}
+}
+
+func (l *loopStats) report() *report.Loops {
+	l.Lock()
+	defer l.Unlock()
+	r := &report.Loops{
+		Sent:    l.sent,
+		Lost:    l.lost,
+		Corrupt: l.corrupt,
+		RTT:     copyHistogram(l.rtt),
+	}
+	for _, e := range l.epochs {
+		c := *e
+		r.Epochs = append(r.Epochs, &c)
+	}
+	sort.Slice(r.Epochs, func(i, j int) bool { return r.Epochs[i].Epoch < r.Epochs[j].Epoch })
+	return r
+}
+
+// loopInterval returns the time to wait before sending the next loop.
+func (s *Session) loopInterval(rng *mrand.Rand) (time.Duration, error) {
+	if rate := s.config().Loop.Rate; rate > 0 {
+		return time.Duration(rand.Exp(rng, rate) * float64(time.Second)), nil
+	}
+	doc := s.minclient.CurrentDocument()
+	if doc == nil || doc.LambdaL <= 0 {
+		return 0, fmt.Errorf("the PKI document does not specify LambdaL")
+	}
+	ms := rand.Exp(rng, doc.LambdaL)
+	if doc.LambdaLMaxDelay > 0 && ms > float64(doc.LambdaLMaxDelay) {
+		ms = float64(doc.LambdaLMaxDelay)
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// loopWorker sends decoy loops to the loop service of our own Provider.
+func (s *Session) loopWorker() {
+	defer s.logLoops()
+
+	var service *ServiceDescriptor
+	for _, sd := range FindServices(loopService, s.lastDoc) {
+		if sd.Provider == s.config().Account.Provider {
+			service = &sd
+			break
+		}
+	}
+	if service == nil {
+		s.log.Errorf("Decoy loops disabled: Provider %v has no %v service.", s.config().Account.Provider, loopService)
+		return
+	}
+
+	rng := newRand(s.config(), "loop")
+	p := new(echoProbe)
+	timeout := time.Duration(s.config().Loop.Timeout) * time.Second
+	s.log.Noticef("Sending decoy loops to %s@%s.", service.Name, service.Provider)
+	for {
+		interval, err := s.loopInterval(rng)
+		if err != nil {
+			s.log.Errorf("Decoy loops disabled: %v", err)
+			return
+		}
+		select {
+		case <-time.After(interval):
+		case <-s.HaltCh():
+			return
+		}
+		s.Go(func() {
+			req, err := p.NewRequest()
+			if err != nil {
+				s.log.Errorf("Failed to make a decoy loop: %v", err)
+				return
+			}
+			resp, res, err := s.roundTrip(service.Name, service.Provider, req, report.PacketDecoy, timeout)
+			if res == nil || err == errHalted {
+				return
+			}
+			if err == nil {
+				err = p.ValidateResponse(req, resp)
+			}
+			s.loops.record(res, err)
+		})
+	}
+}
+
+func (s *Session) logLoops() {
+	l := s.loops.report()
+	s.log.Noticef("decoy loops: %d sent, %d lost (%.2f%%), %d corrupt, RTT min %v mean %v p50 %v p99 %v max %v",
+		l.Sent, l.Lost, 100*l.DropRate(), l.Corrupt,
+		l.RTT.Min(), l.RTT.Mean(), l.RTT.Percentile(50), l.RTT.Percentile(99), l.RTT.Max())
+	for _, e := range l.Epochs {
+		s.log.Noticef("decoy loops: epoch %d: %d sent, %d lost (%.2f%%)", e.Epoch, e.Sent, e.Lost, 100*e.DropRate())
+	}
+}
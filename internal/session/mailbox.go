@@ -0,0 +1,118 @@
+// mailbox.go - mailbox stress mode
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+const mailboxStatusInterval = 30 * time.Second
+
+// mailboxReceiver tracks the stamped probes retrieved from our own
+// Provider's spool in the mailbox receiver mode.  The sender is expected
+// to be another spray instance in the mailbox sender mode targeting
+// this account.  Latency is corrected for the clock skew of both hosts
+// versus their Providers, which assumes that the Providers' clocks agree.
+type mailboxReceiver struct {
+	sync.Mutex
+
+	codec *probeCodec
+
+	latency    *stats.Histogram
+	rawLatency *stats.Histogram
+	received   uint64
+	foreign    uint64
+	seqs       *seqTracker
+}
+
+func newMailboxReceiver(codec *probeCodec) *mailboxReceiver {
+	return &mailboxReceiver{
+		codec:      codec,
+		latency:    stats.NewHistogram(),
+		rawLatency: stats.NewHistogram(),
+		seqs:       newSeqTracker(),
+	}
+}
+
+// onMessage accounts for a received message, returning the raw and the
+// clock skew corrected latency of the probe.
+func (m *mailboxReceiver) onMessage(payload []byte, skew time.Duration) (raw, latency time.Duration, err error) {
+	h, err := m.codec.decode(payload)
+	if err != nil {
+		m.Lock()
+		m.foreign++
+		m.Unlock()
+		return 0, 0, err
+	}
+	raw, latency = h.latency(time.Now(), skew)
+	m.latency.Record(latency)
+	m.rawLatency.Record(raw)
+
+	m.Lock()
+	defer m.Unlock()
+	m.received++
+	m.seqs.record(h.seq)
+	return raw, latency, nil
+}
+
+// backlog estimates the number of messages still sitting in the spool,
+// based on the highest sequence number seen so far.
+func (m *mailboxReceiver) backlog() uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.seqs.report().Missing
+}
+
+// sequence returns the sequence accounting of the received probes.
+func (m *mailboxReceiver) sequence() *report.Sequence {
+	m.Lock()
+	defer m.Unlock()
+	return m.seqs.report()
+}
+
+func (s *Session) mailboxReceiverWorker() {
+	ticker := time.NewTicker(mailboxStatusInterval)
+	defer ticker.Stop()
+
+	var last uint64
+	defer func() { s.logMailboxStatus(last) }()
+	for {
+		select {
+		case <-s.HaltCh():
+			return
+		case <-ticker.C:
+		}
+		last = s.logMailboxStatus(last)
+	}
+}
+
+func (s *Session) logMailboxStatus(last uint64) uint64 {
+	m := s.mailbox
+	m.Lock()
+	received, foreign := m.received, m.foreign
+	m.Unlock()
+	h, raw := m.latency, m.rawLatency
+	rate := float64(received-last) / mailboxStatusInterval.Seconds()
+	s.log.Noticef("mailbox: %d received (%.2f/s), %d foreign, est. spool backlog %d, latency p50 %v p99 %v max %v (raw p50 %v)",
+		received, rate, foreign, m.backlog(), h.Percentile(50), h.Percentile(99), h.Max(), raw.Percentile(50))
+	s.log.Noticef("mailbox: sequence: %v", m.sequence())
+	return received
+}
@@ -0,0 +1,175 @@
+// mailproxy.go - mailproxy interop traffic generator
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"time"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/minclient/block"
+	"github.com/katzenpost/spray/config"
+	"gopkg.in/op/go-logging.v1"
+)
+
+// mailproxyProbeHeader is the message header carrying the probe header,
+// hex encoded, so that deliveries can be correlated with sends.
+const mailproxyProbeHeader = "X-Spray-Probe"
+
+func init() {
+	RegisterTrafficGenerator(config.MailproxyTrafficGenerator, newMailproxyGenerator)
+}
+
+// mailproxyGenerator sends email messages to mailproxy recipients, in
+// the end-to-end encrypted block format of the Katzenpost client stack,
+// one block per packet.
+type mailproxyGenerator struct {
+	cfg         *config.Mailproxy
+	identityKey *ecdh.PrivateKey
+	codec       *probeCodec
+	skew        func() time.Duration
+	log         *logging.Logger
+	rng         *mrand.Rand
+	seqs        map[*config.MailproxyRecipient]uint64
+
+	target *config.Target
+	blocks [][]byte
+}
+
+func newMailproxyGenerator(s *Session) (TrafficGenerator, error) {
+	if s.config().Mailproxy == nil {
+		return nil, errors.New("the mailproxy traffic generator requires a Mailproxy block")
+	}
+	if !s.config().Geometry.IsDefault() {
+		return nil, errors.New("the mailproxy traffic generator only supports the compiled in Sphinx geometry")
+	}
+	return &mailproxyGenerator{
+		cfg:         s.config().Mailproxy,
+		identityKey: s.identityKey,
+		codec:       s.probeCodec,
+		skew:        s.minclient.ClockSkew,
+		log:         s.log,
+		rng:         newRand(s.config(), "mailproxy"),
+		seqs:        make(map[*config.MailproxyRecipient]uint64),
+	}, nil
+}
+
+func (g *mailproxyGenerator) NextSend() (*config.Target, []byte, time.Duration) {
+	if len(g.blocks) == 0 {
+		if err := g.newMessage(); err != nil {
+			g.log.Errorf("Failed to compose mailproxy message: %v", err)
+			return nil, nil, 0
+		}
+	}
+	b := g.blocks[0]
+	g.blocks = g.blocks[1:]
+	return g.target, b, 0
+}
+
+// newMessage composes a message to a random recipient and encrypts it
+// into blocks.
+func (g *mailproxyGenerator) newMessage() error {
+	r := g.cfg.Recipients[g.rng.Intn(len(g.cfg.Recipients))]
+	msg, err := g.message(r, g.seqs[r])
+	if err != nil {
+		return err
+	}
+	g.seqs[r]++
+
+	var id [block.MessageIDLength]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return err
+	}
+	nrBlocks := (len(msg) + block.BlockPayloadLength - 1) / block.BlockPayloadLength
+	if nrBlocks > 0xffff {
+		return fmt.Errorf("message too large: %v bytes", len(msg))
+	}
+	g.blocks = g.blocks[:0]
+	for i := 0; i < nrBlocks; i++ {
+		end := (i + 1) * block.BlockPayloadLength
+		if end > len(msg) {
+			end = len(msg)
+		}
+		blk := &block.Block{
+			MessageID:   id,
+			TotalBlocks: uint16(nrBlocks),
+			BlockID:     uint16(i),
+			Payload:     msg[i*block.BlockPayloadLength : end],
+		}
+		ct, err := block.EncryptBlock(blk, g.identityKey, r.PublicKey)
+		if err != nil {
+			return err
+		}
+		g.blocks = append(g.blocks, ct)
+	}
+	g.target = &config.Target{
+		Provider:  r.Provider,
+		Recipient: r.User,
+		Weight:    1,
+	}
+	return nil
+}
+
+// message returns an RFC 5322 message to r, stamped with a probe header
+// carrying seq, the sequence number of the message among those to r.
+func (g *mailproxyGenerator) message(r *config.MailproxyRecipient, seq uint64) ([]byte, error) {
+	now := time.Now()
+	stamp := make([]byte, taggedProbeLength)
+	if err := g.codec.encode(&probeHeader{seq: seq, sentAt: now, skew: g.skew()}, stamp); err != nil {
+		return nil, err
+	}
+	if g.codec.key == nil {
+		stamp = stamp[:probeHeaderLength]
+	}
+	var msgID [16]byte
+	if _, err := io.ReadFull(rand.Reader, msgID[:]); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", g.cfg.From)
+	fmt.Fprintf(&buf, "To: %s@%s\r\n", r.User, r.Provider)
+	fmt.Fprintf(&buf, "Subject: spray probe %d\r\n", seq)
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@spray>\r\n", hex.EncodeToString(msgID[:]))
+	fmt.Fprintf(&buf, "%s: %s\r\n", mailproxyProbeHeader, hex.EncodeToString(stamp))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=us-ascii\r\n")
+	buf.WriteString("\r\n")
+
+	// The body is MessageSize bytes of text in 76 character lines.
+	const lineLength = 76
+	line := bytes.Repeat([]byte{'x'}, lineLength)
+	for n := g.cfg.MessageSize; n > 0; n -= lineLength + 2 {
+		l := lineLength
+		if n < l+2 {
+			l = n - 2
+			if l < 0 {
+				l = 0
+			}
+		}
+		buf.Write(line[:l])
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
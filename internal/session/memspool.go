@@ -0,0 +1,221 @@
+// memspool.go - memspool service probes
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"time"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/memspool/common"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+const (
+	memspoolService = "spool"
+
+	opCreate = "create"
+	opAppend = "append"
+	opRead   = "read"
+)
+
+type spool struct {
+	id       [common.SpoolIDSize]byte
+	key      *eddsa.PrivateKey
+	appended uint32
+}
+
+type memspoolOpStats struct {
+	latency *stats.Histogram
+	errors  uint64
+}
+
+// memspoolProber performs a configurable mix of memspool operations
+// and measures the latency of each operation type.
+type memspoolProber struct {
+	s       *Session
+	service *ServiceDescriptor
+	rng     *mrand.Rand
+	spools  []*spool
+	stats   map[string]*memspoolOpStats
+}
+
+func (s *Session) memspoolWorker() {
+	cfg := s.config().Memspool
+	service, err := s.GetService(memspoolService)
+	if err != nil {
+		select {
+		case s.fatalErrCh <- fmt.Errorf("memspool: %v", err):
+		case <-s.HaltCh():
+		}
+		return
+	}
+	p := &memspoolProber{
+		s:       s,
+		service: service,
+		rng:     newRand(s.config(), "memspool"),
+		stats:   make(map[string]*memspoolOpStats),
+	}
+	for _, op := range []string{opCreate, opAppend, opRead} {
+		p.stats[op] = &memspoolOpStats{latency: stats.NewHistogram()}
+	}
+	defer p.logSummary()
+
+	s.log.Noticef("Probing memspool service %s@%s.", service.Name, service.Provider)
+	for i := 0; cfg.Operations == 0 || i < cfg.Operations; i++ {
+		if !s.waitUnpaused() {
+			return
+		}
+		op := p.nextOp()
+		res, err := p.do(op)
+		if err == errHalted {
+			return
+		}
+		s.results.record(res, err)
+		st := p.stats[op]
+		if err != nil {
+			s.log.Warningf("memspool %s failure: %v", op, err)
+			st.errors++
+			continue
+		}
+		st.latency.Record(res.Latency)
+		s.log.Debugf("memspool %s took %v", op, res.Latency)
+	}
+}
+
+func (p *memspoolProber) nextOp() string {
+	cfg := p.s.config().Memspool
+	if len(p.spools) == 0 {
+		return opCreate
+	}
+	n := p.rng.Intn(cfg.CreateWeight + cfg.AppendWeight + cfg.ReadWeight)
+	switch {
+	case n < cfg.CreateWeight:
+		return opCreate
+	case n < cfg.CreateWeight+cfg.AppendWeight:
+		return opAppend
+	case len(p.readable()) == 0:
+		return opAppend
+	default:
+		return opRead
+	}
+}
+
+func (p *memspoolProber) readable() []*spool {
+	spools := []*spool{}
+	for _, sp := range p.spools {
+		if sp.appended > 0 {
+			spools = append(spools, sp)
+		}
+	}
+	return spools
+}
+
+func (p *memspoolProber) do(op string) (*report.Probe, error) {
+	switch op {
+	case opCreate:
+		return p.create()
+	case opAppend:
+		return p.append()
+	case opRead:
+		return p.read()
+	}
+	return nil, fmt.Errorf("BUG: invalid memspool operation: %v", op)
+}
+
+func (p *memspoolProber) request(req []byte) (*common.SpoolResponse, *report.Probe, error) {
+	timeout := time.Duration(p.s.config().Memspool.Timeout) * time.Second
+	reply, res, err := p.s.roundTrip(p.service.Name, p.service.Provider, req, report.PacketReal, timeout)
+	if err != nil {
+		return nil, res, err
+	}
+	resp, err := common.SpoolResponseFromBytes(reply)
+	if err != nil {
+		return nil, res, err
+	}
+	if !resp.IsOK() {
+		return nil, res, fmt.Errorf("spool error status: %v", resp.Status)
+	}
+	return &resp, res, nil
+}
+
+func (p *memspoolProber) create() (*report.Probe, error) {
+	key, err := eddsa.NewKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	req, err := common.CreateSpool(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, res, err := p.request(req)
+	if err != nil {
+		return res, err
+	}
+	p.spools = append(p.spools, &spool{id: resp.SpoolID, key: key})
+	return res, nil
+}
+
+func (p *memspoolProber) append() (*report.Probe, error) {
+	sp := p.spools[p.rng.Intn(len(p.spools))]
+	msg := make([]byte, p.s.config().Memspool.MessageSize)
+	if _, err := io.ReadFull(rand.Reader, msg); err != nil {
+		return nil, err
+	}
+	req, err := common.AppendToSpool(sp.id, msg)
+	if err != nil {
+		return nil, err
+	}
+	_, res, err := p.request(req)
+	if err != nil {
+		return res, err
+	}
+	sp.appended++
+	return res, nil
+}
+
+func (p *memspoolProber) read() (*report.Probe, error) {
+	spools := p.readable()
+	sp := spools[p.rng.Intn(len(spools))]
+	messageID := uint32(p.rng.Int63n(int64(sp.appended))) + 1
+	req, err := common.ReadFromSpool(sp.id, messageID, sp.key)
+	if err != nil {
+		return nil, err
+	}
+	resp, res, err := p.request(req)
+	if err != nil {
+		return res, err
+	}
+	if len(resp.Message) == 0 {
+		return res, errors.New("read returned an empty message")
+	}
+	return res, nil
+}
+
+func (p *memspoolProber) logSummary() {
+	for _, op := range []string{opCreate, opAppend, opRead} {
+		st := p.stats[op]
+		h := st.latency
+		p.s.log.Noticef("memspool %s: %d ok, %d failed, latency min %v mean %v p50 %v p99 %v max %v",
+			op, h.Count(), st.errors, h.Min(), h.Mean(), h.Percentile(50), h.Percentile(99), h.Max())
+	}
+}
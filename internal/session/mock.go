@@ -0,0 +1,293 @@
+// mock.go - in-process mock mixnet
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/core/worker"
+	"github.com/katzenpost/spray/config"
+)
+
+const mockHeaderLength = 1 + constants.SURBIDLength + 8 + 8 + 1 + 1 + 4
+
+var errMockPacket = errors.New("mock: malformed packet")
+
+func init() {
+	RegisterLinkProtocol(config.MockLinkProtocol, newMockMixClient)
+}
+
+// mockPacket is a packet of the mock mixnet.  Instead of being Sphinx
+// encrypted, it carries its routing information and the mixing delays
+// drawn for its paths in the clear.
+type mockPacket struct {
+	surbID       *[constants.SURBIDLength]byte
+	forwardDelay time.Duration
+	replyDelay   time.Duration
+	recipient    string
+	provider     string
+	payload      []byte
+}
+
+func (p *mockPacket) marshal(packetLength int) []byte {
+	b := make([]byte, mockHeaderLength)
+	if p.surbID != nil {
+		b[0] = 1
+		copy(b[1:], p.surbID[:])
+	}
+	off := 1 + constants.SURBIDLength
+	binary.BigEndian.PutUint64(b[off:], uint64(p.forwardDelay))
+	binary.BigEndian.PutUint64(b[off+8:], uint64(p.replyDelay))
+	b[off+16] = byte(len(p.recipient))
+	b[off+17] = byte(len(p.provider))
+	binary.BigEndian.PutUint32(b[off+18:], uint32(len(p.payload)))
+	b = append(b, p.recipient...)
+	b = append(b, p.provider...)
+	b = append(b, p.payload...)
+	if len(b) < packetLength {
+		b = append(b, make([]byte, packetLength-len(b))...)
+	}
+	return b
+}
+
+func (p *mockPacket) unmarshal(b []byte) error {
+	if len(b) < mockHeaderLength {
+		return errMockPacket
+	}
+	if b[0] == 1 {
+		p.surbID = new([constants.SURBIDLength]byte)
+		copy(p.surbID[:], b[1:])
+	}
+	off := 1 + constants.SURBIDLength
+	p.forwardDelay = time.Duration(binary.BigEndian.Uint64(b[off:]))
+	p.replyDelay = time.Duration(binary.BigEndian.Uint64(b[off+8:]))
+	rLen, pLen := int(b[off+16]), int(b[off+17])
+	bLen := int(binary.BigEndian.Uint32(b[off+18:]))
+	b = b[mockHeaderLength:]
+	if len(b) < rLen+pLen+bLen {
+		return errMockPacket
+	}
+	p.recipient = string(b[:rLen])
+	p.provider = string(b[rLen : rLen+pLen])
+	p.payload = b[rLen+pLen : rLen+pLen+bLen]
+	return nil
+}
+
+// mockClient is a MixClient simulating a mixnet in-process.  Every hop
+// of a path delays packets by an exponentially distributed delay drawn
+// with the parameters of the PKI document, on top of the link latency,
+// and every path loses packets with the configured probability.  SURB
+// packets sent to a Provider are echoed back as the loop service would,
+// and packets sent to the account are delivered to its mailbox.
+type mockClient struct {
+	worker.Worker
+	sync.Mutex
+
+	cfg  *LinkConfig
+	mock *config.Mock
+	rng  *mrand.Rand
+	doc  *pki.Document
+	hops int
+
+	// replyHops is the number of hops of the SURB reply paths, zero
+	// meaning all of the layers.
+	replyHops int
+}
+
+func newMockMixClient(cfg *LinkConfig) (MixClient, error) {
+	if cfg.Mock == nil {
+		return nil, fmt.Errorf("link protocol '%v' requires a Mock block", config.MockLinkProtocol)
+	}
+	c := &mockClient{
+		cfg:  cfg,
+		mock: cfg.Mock,
+		rng:  rand.NewMath(),
+	}
+	c.Go(c.worker)
+	return c, nil
+}
+
+// worker fetches the document of every epoch as minclient would, and
+// reports the connection once the first document is known.
+func (c *mockClient) worker() {
+	connected := false
+	for {
+		epoch, _, till := epochtime.Now()
+		doc, _, err := c.cfg.PKIClient.Get(context.Background(), epoch)
+		if err == nil {
+			c.Lock()
+			c.doc = doc
+			c.Unlock()
+			c.cfg.OnDocumentFn(doc)
+			if !connected {
+				c.cfg.OnConnFn(nil)
+				connected = true
+			}
+		}
+		select {
+		case <-c.HaltCh():
+			return
+		case <-time.After(till):
+		}
+	}
+}
+
+// SetHops sets the number of hops of the paths, skipping mix layers.
+func (c *mockClient) SetHops(hops int) error {
+	if hops != 0 && (hops < 2 || hops > c.cfg.Geometry.NrHops) {
+		return fmt.Errorf("mock: invalid number of hops: %v", hops)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.hops = hops
+	return nil
+}
+
+// SetReplyHops sets the number of hops of the SURB reply paths, skipping
+// mix layers.
+func (c *mockClient) SetReplyHops(hops int) error {
+	if hops != 0 && (hops < 2 || hops > c.cfg.Geometry.NrHops) {
+		return fmt.Errorf("mock: invalid number of reply hops: %v", hops)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.replyHops = hops
+	return nil
+}
+
+// pathDelay draws the total mixing delay of a path of the given number
+// of hops, zero meaning all of the mix layers, through the mix layers
+// and the destination Provider.
+func (c *mockClient) pathDelay(doc *pki.Document, hops int) time.Duration {
+	d := time.Duration(c.mock.Latency) * time.Millisecond
+	if doc.Mu <= 0 {
+		return d
+	}
+	delays := len(doc.Topology) + 1
+	if hops != 0 && hops-1 < delays {
+		delays = hops - 1
+	}
+	for i := 0; i < delays; i++ {
+		delay := rand.Exp(c.rng, doc.Mu)
+		if doc.MuMaxDelay > 0 && delay > float64(doc.MuMaxDelay) {
+			delay = float64(doc.MuMaxDelay)
+		}
+		d += time.Duration(delay * float64(time.Millisecond))
+	}
+	return d
+}
+
+func (c *mockClient) lost() bool {
+	return c.mock.Loss > 0 && c.rng.Float64() < c.mock.Loss
+}
+
+func (c *mockClient) ComposeSphinxPacket(recipient, provider string, surbID *[constants.SURBIDLength]byte, b []byte) ([]byte, []byte, time.Duration, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.doc == nil {
+		return nil, nil, 0, errors.New("mock: no PKI document")
+	}
+	if len(recipient) > constants.RecipientIDLength {
+		return nil, nil, 0, fmt.Errorf("mock: invalid recipient: '%v'", recipient)
+	}
+	if _, err := c.doc.GetProvider(provider); err != nil {
+		return nil, nil, 0, err
+	}
+	if len(b) > c.cfg.Geometry.UserForwardPayloadLength {
+		return nil, nil, 0, fmt.Errorf("mock: payload too large: %v", len(b))
+	}
+	p := &mockPacket{
+		surbID:       surbID,
+		forwardDelay: c.pathDelay(c.doc, c.hops),
+		recipient:    recipient,
+		provider:     provider,
+		payload:      b,
+	}
+	if surbID == nil {
+		return p.marshal(c.cfg.Geometry.PacketLength), nil, 0, nil
+	}
+	p.replyDelay = c.pathDelay(c.doc, c.replyHops)
+	return p.marshal(c.cfg.Geometry.PacketLength), []byte{}, p.forwardDelay + p.replyDelay, nil
+}
+
+func (c *mockClient) SendSphinxPacket(pkt []byte) error {
+	p := new(mockPacket)
+	if err := p.unmarshal(pkt); err != nil {
+		return err
+	}
+	c.Lock()
+	lost := c.lost()
+	if p.surbID != nil && !lost {
+		lost = c.lost()
+	}
+	c.Unlock()
+	if lost {
+		return nil
+	}
+
+	payload := make([]byte, len(p.payload))
+	copy(payload, p.payload)
+	switch {
+	case p.surbID != nil:
+		reply := make([]byte, surbReplyHeaderLength+len(payload))
+		copy(reply[surbReplyHeaderLength:], payload)
+		c.deliver(p.forwardDelay+p.replyDelay, func() { c.cfg.OnACKFn(p.surbID, reply) })
+	case p.recipient == c.cfg.User && p.provider == c.cfg.Provider:
+		c.deliver(p.forwardDelay, func() { c.cfg.OnMessageFn(payload) })
+	}
+	return nil
+}
+
+func (c *mockClient) deliver(delay time.Duration, fn func()) {
+	time.AfterFunc(delay, func() {
+		select {
+		case <-c.HaltCh():
+		default:
+			fn()
+		}
+	})
+}
+
+// DecryptSURBPayload returns the payload as is, since mock SURB replies
+// are not encrypted.
+func (c *mockClient) DecryptSURBPayload(payload, key []byte) ([]byte, error) {
+	return payload, nil
+}
+
+func (c *mockClient) CurrentDocument() *pki.Document {
+	c.Lock()
+	defer c.Unlock()
+	return c.doc
+}
+
+func (c *mockClient) ClockSkew() time.Duration {
+	return 0
+}
+
+func (c *mockClient) Shutdown() {
+	c.Halt()
+}
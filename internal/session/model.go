@@ -0,0 +1,83 @@
+// model.go - expected latency model
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+// modelSamples is the number of simulated paths per latency model.
+const modelSamples = 10000
+
+// modelDelays returns the number of mixing delays a measured probe is
+// subject to: every hop but the last of the forward path, and of the
+// SURB reply path unless the mode measures one-way latency.
+func (s *Session) modelDelays() int {
+	delays := s.config().Geometry.NrHops - 1
+	if s.config().Debug.Mode != config.ModeMailboxReceiver {
+		replyHops := s.config().Debug.ReplyHops
+		if replyHops == 0 {
+			replyHops = s.config().Geometry.NrHops
+		}
+		delays += replyHops - 1
+	}
+	return delays
+}
+
+// newLatencyModel simulates the distribution of the total mixing delay
+// of a probe, as the sum of delays exponentially distributed delays
+// drawn with the parameters of doc.  It returns nil if the document
+// does not specify mixing delays.
+func newLatencyModel(doc *pki.Document, delays int) *stats.Histogram {
+	if doc.Mu <= 0 {
+		return nil
+	}
+	rng := rand.NewMath()
+	h := stats.NewHistogram()
+	for i := 0; i < modelSamples; i++ {
+		var total float64
+		for j := 0; j < delays; j++ {
+			d := rand.Exp(rng, doc.Mu)
+			if doc.MuMaxDelay > 0 && d > float64(doc.MuMaxDelay) {
+				d = float64(doc.MuMaxDelay)
+			}
+			total += d
+		}
+		h.Record(time.Duration(total * float64(time.Millisecond)))
+	}
+	return h
+}
+
+// updateModel computes the latency model for the epoch of doc.
+func (s *Session) updateModel(doc *pki.Document) {
+	s.results.setParams(doc.Epoch, &report.Params{
+		Mu:      doc.Mu,
+		LambdaP: doc.LambdaP,
+		LambdaL: doc.LambdaL,
+		LambdaD: doc.LambdaD,
+		LambdaM: doc.LambdaM,
+	})
+	if model := newLatencyModel(doc, s.modelDelays()); model != nil {
+		s.results.setModel(doc.Epoch, model)
+	}
+}
@@ -0,0 +1,114 @@
+// nodes.go - node selection
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/config"
+)
+
+// nodeFilter decides which nodes may be used, as configured by a Nodes
+// block.
+type nodeFilter struct {
+	cfg      *config.Nodes
+	provider string
+}
+
+func matchesNode(names []string, d *pki.MixDescriptor) bool {
+	for _, n := range names {
+		if n == d.Name {
+			return true
+		}
+		if d.IdentityKey != nil && (n == d.IdentityKey.String() || n == hex.EncodeToString(d.IdentityKey.Bytes())) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *nodeFilter) mixAllowed(d *pki.MixDescriptor) bool {
+	return !matchesNode(f.cfg.Deny, d)
+}
+
+func (f *nodeFilter) providerAllowed(d *pki.MixDescriptor) bool {
+	if d.Name == f.provider {
+		return true
+	}
+	if matchesNode(f.cfg.Deny, d) {
+		return false
+	}
+	return len(f.cfg.AllowProviders) == 0 || matchesNode(f.cfg.AllowProviders, d)
+}
+
+// filter returns a copy of doc without the excluded nodes.
+func (f *nodeFilter) filter(doc *pki.Document) (*pki.Document, error) {
+	c := *doc
+	c.Topology = make([][]*pki.MixDescriptor, len(doc.Topology))
+	for i, layer := range doc.Topology {
+		for _, d := range layer {
+			if f.mixAllowed(d) {
+				c.Topology[i] = append(c.Topology[i], d)
+			}
+		}
+		if len(c.Topology[i]) == 0 {
+			return nil, fmt.Errorf("every mix of layer %d is excluded", i)
+		}
+	}
+	c.Providers = nil
+	for _, d := range doc.Providers {
+		if f.providerAllowed(d) {
+			c.Providers = append(c.Providers, d)
+		}
+	}
+	return &c, nil
+}
+
+// filterTargets returns the targets on allowed Providers.
+func (f *nodeFilter) filterTargets(doc *pki.Document, targets []*config.Target) []*config.Target {
+	var allowed []*config.Target
+	for _, t := range targets {
+		d, err := doc.GetProvider(t.Provider)
+		if err == nil && !f.providerAllowed(d) {
+			continue
+		}
+		allowed = append(allowed, t)
+	}
+	return allowed
+}
+
+// filteredPKIClient is a pki.Client returning documents without the
+// excluded nodes, so that they are never selected for routes.
+type filteredPKIClient struct {
+	pki.Client
+
+	filter *nodeFilter
+}
+
+func (c *filteredPKIClient) Get(ctx context.Context, epoch uint64) (*pki.Document, []byte, error) {
+	doc, raw, err := c.Client.Get(ctx, epoch)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc, err = c.filter.filter(doc); err != nil {
+		return nil, nil, fmt.Errorf("epoch %v: %v", epoch, err)
+	}
+	return doc, raw, nil
+}
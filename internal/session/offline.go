@@ -0,0 +1,100 @@
+// offline.go - link protocol that never connects
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/core/worker"
+	"github.com/katzenpost/spray/config"
+)
+
+var errOffline = errors.New("offline: not connected to a Provider")
+
+func init() {
+	RegisterLinkProtocol(config.OfflineLinkProtocol, newOfflineMixClient)
+}
+
+// offlineClient is a MixClient that never connects to the Provider, for
+// the modes that only talk to the authority.  It follows the PKI
+// documents as minclient would, and fails to compose or send anything.
+type offlineClient struct {
+	worker.Worker
+	sync.Mutex
+
+	cfg *LinkConfig
+	doc *pki.Document
+}
+
+func newOfflineMixClient(cfg *LinkConfig) (MixClient, error) {
+	c := &offlineClient{cfg: cfg}
+	c.Go(c.worker)
+	return c, nil
+}
+
+// worker fetches the document of every epoch, and reports the
+// connection once the first document is known.
+func (c *offlineClient) worker() {
+	connected := false
+	for {
+		epoch, _, till := epochtime.Now()
+		doc, _, err := c.cfg.PKIClient.Get(context.Background(), epoch)
+		if err == nil {
+			c.Lock()
+			c.doc = doc
+			c.Unlock()
+			c.cfg.OnDocumentFn(doc)
+			if !connected {
+				c.cfg.OnConnFn(nil)
+				connected = true
+			}
+		}
+		select {
+		case <-c.HaltCh():
+			return
+		case <-time.After(till):
+		}
+	}
+}
+
+func (c *offlineClient) ComposeSphinxPacket(recipient, provider string, surbID *[constants.SURBIDLength]byte, b []byte) ([]byte, []byte, time.Duration, error) {
+	return nil, nil, 0, errOffline
+}
+
+func (c *offlineClient) SendSphinxPacket(pkt []byte) error {
+	return errOffline
+}
+
+func (c *offlineClient) CurrentDocument() *pki.Document {
+	c.Lock()
+	defer c.Unlock()
+	return c.doc
+}
+
+func (c *offlineClient) ClockSkew() time.Duration {
+	return 0
+}
+
+func (c *offlineClient) Shutdown() {
+	c.Halt()
+}
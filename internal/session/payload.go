@@ -0,0 +1,148 @@
+// payload.go - probe payload encoding
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+)
+
+const (
+	probeMagic        = "SPRY"
+	probeHeaderLength = len(probeMagic) + 8 + 8 + 8
+
+	probeNonceLength       = 8
+	probeTagLength         = 8
+	taggedProbeFieldLength = 8 + 8 + 8
+	taggedProbeLength      = probeNonceLength + taggedProbeFieldLength + probeTagLength
+)
+
+var errNotAProbe = errors.New("payload is not a spray probe")
+
+// probeHeader is prepended to the payload of stamped probes so that
+// the receiving side can measure latency and detect loss.
+type probeHeader struct {
+	seq    uint64
+	sentAt time.Time
+
+	// skew is the clock skew of the sender versus its Provider when
+	// the probe was sent, so that the receiver can correct for it.
+	skew time.Duration
+}
+
+// latency returns the one way latency of a probe received at now, both
+// raw and corrected for the clock skew of the receiver and the sender
+// versus their Providers.  Skews are the local clock minus the
+// Provider's clock.
+func (h *probeHeader) latency(now time.Time, skew time.Duration) (raw, corrected time.Duration) {
+	raw = now.Sub(h.sentAt)
+	return raw, raw - skew + h.skew
+}
+
+func (h *probeHeader) marshalTo(b []byte) {
+	copy(b, probeMagic)
+	off := len(probeMagic)
+	binary.BigEndian.PutUint64(b[off:], h.seq)
+	binary.BigEndian.PutUint64(b[off+8:], uint64(h.sentAt.UnixNano()))
+	binary.BigEndian.PutUint64(b[off+16:], uint64(h.skew))
+}
+
+func (h *probeHeader) unmarshal(b []byte) error {
+	if len(b) < probeHeaderLength || string(b[:len(probeMagic)]) != probeMagic {
+		return errNotAProbe
+	}
+	off := len(probeMagic)
+	h.seq = binary.BigEndian.Uint64(b[off:])
+	h.sentAt = time.Unix(0, int64(binary.BigEndian.Uint64(b[off+8:])))
+	h.skew = time.Duration(binary.BigEndian.Uint64(b[off+16:]))
+	return nil
+}
+
+// probeCodec encodes and decodes probe headers.  Without a key headers
+// are plaintext.  With a key, the sequence number and timestamp are
+// masked with a keystream derived from a random nonce, and the header is
+// authenticated with an HMAC tag instead of the magic, so that the
+// Providers handling the probes can not link them to each other or to
+// spray, while spray instances sharing the key can still correlate them.
+type probeCodec struct {
+	key []byte
+}
+
+func (c *probeCodec) mac(label string, b ...[]byte) []byte {
+	m := hmac.New(sha256.New, c.key)
+	m.Write([]byte(label))
+	for _, v := range b {
+		m.Write(v)
+	}
+	return m.Sum(nil)
+}
+
+// encode writes the header h to the start of b.
+func (c *probeCodec) encode(h *probeHeader, b []byte) error {
+	if c.key == nil {
+		h.marshalTo(b)
+		return nil
+	}
+	nonce := b[:probeNonceLength]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	var fields [taggedProbeFieldLength]byte
+	binary.BigEndian.PutUint64(fields[0:], h.seq)
+	binary.BigEndian.PutUint64(fields[8:], uint64(h.sentAt.UnixNano()))
+	binary.BigEndian.PutUint64(fields[16:], uint64(h.skew))
+	tag := c.mac("tag", nonce, fields[:])
+
+	masked := b[probeNonceLength : probeNonceLength+taggedProbeFieldLength]
+	mask := c.mac("mask", nonce)
+	for i := range masked {
+		masked[i] = fields[i] ^ mask[i]
+	}
+	copy(b[probeNonceLength+taggedProbeFieldLength:taggedProbeLength], tag)
+	return nil
+}
+
+// decode reads a header from the start of b.
+func (c *probeCodec) decode(b []byte) (*probeHeader, error) {
+	h := new(probeHeader)
+	if c.key == nil {
+		return h, h.unmarshal(b)
+	}
+	if len(b) < taggedProbeLength {
+		return nil, errNotAProbe
+	}
+	nonce := b[:probeNonceLength]
+	var fields [taggedProbeFieldLength]byte
+	mask := c.mac("mask", nonce)
+	for i := range fields {
+		fields[i] = b[probeNonceLength+i] ^ mask[i]
+	}
+	tag := c.mac("tag", nonce, fields[:])
+	if !hmac.Equal(b[probeNonceLength+taggedProbeFieldLength:taggedProbeLength], tag[:probeTagLength]) {
+		return nil, errNotAProbe
+	}
+	h.seq = binary.BigEndian.Uint64(fields[0:])
+	h.sentAt = time.Unix(0, int64(binary.BigEndian.Uint64(fields[8:])))
+	h.skew = time.Duration(binary.BigEndian.Uint64(fields[16:]))
+	return h, nil
+}
@@ -0,0 +1,42 @@
+// pkitiming.go - PKI fetch latency
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/spray/stats"
+)
+
+// timedPKIClient is a pki.Client recording the latency of the successful
+// document fetches.
+type timedPKIClient struct {
+	pki.Client
+
+	latency *stats.Histogram
+}
+
+func (c *timedPKIClient) Get(ctx context.Context, epoch uint64) (*pki.Document, []byte, error) {
+	start := time.Now()
+	doc, raw, err := c.Client.Get(ctx, epoch)
+	if err == nil {
+		c.latency.Record(time.Since(start))
+	}
+	return doc, raw, err
+}
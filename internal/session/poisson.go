@@ -0,0 +1,84 @@
+// poisson.go - Poisson send scheduling
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"math"
+	mrand "math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+)
+
+// poissonPacer schedules sends as a Poisson process, with exponentially
+// distributed intervals, as clients following the Loopix decoy traffic
+// model do.  Only the sendWorker may call schedule.
+type poissonPacer struct {
+	// lambda holds the bits of LambdaP, zero meaning that the rate
+	// limiter paces the sends instead.
+	lambda uint64
+
+	rng  *mrand.Rand
+	next time.Time
+}
+
+func newPoissonPacer(lambdaP float64, rng *mrand.Rand) *poissonPacer {
+	return &poissonPacer{
+		lambda: math.Float64bits(lambdaP),
+		rng:    rng,
+	}
+}
+
+func (p *poissonPacer) lambdaP() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&p.lambda))
+}
+
+func (p *poissonPacer) setLambdaP(lambdaP float64) {
+	atomic.StoreUint64(&p.lambda, math.Float64bits(lambdaP))
+}
+
+// schedule returns the time at which to send the next packet.  The
+// interval is drawn from the last scheduled send, or from now if the
+// sender has fallen behind, so that a stall is not followed by a burst.
+func (p *poissonPacer) schedule(now time.Time, lambdaP float64) time.Time {
+	if p.next.Before(now) {
+		p.next = now
+	}
+	ms := rand.Exp(p.rng, lambdaP)
+	p.next = p.next.Add(time.Duration(ms * float64(time.Millisecond)))
+	return p.next
+}
+
+// LambdaP returns the Poisson send rate parameter, zero if the sends are
+// paced by the rate limiter.
+func (s *Session) LambdaP() float64 {
+	return s.poisson.lambdaP()
+}
+
+// SetLambdaP switches the send scheduling to a Poisson process with the
+// rate parameter lambdaP, the inverse of the mean interval between sends
+// in milliseconds, as the LambdaP of PKI documents.  Zero switches back
+// to the rate limiter.
+func (s *Session) SetLambdaP(lambdaP float64) {
+	s.poisson.setLambdaP(lambdaP)
+	if lambdaP > 0 {
+		s.log.Noticef("Poisson send scheduling with LambdaP %v (mean interval %v).", lambdaP, time.Duration(float64(time.Millisecond)/lambdaP))
+	} else {
+		s.log.Notice("Send scheduling by the rate limiter.")
+	}
+}
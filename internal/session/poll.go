@@ -0,0 +1,44 @@
+// poll.go - adaptive receive queue polling.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import "time"
+
+// pollWorker polls the receive queue at the ActivePollingInterval while
+// SURB replies are outstanding, and at the PollingInterval otherwise.
+func (s *Session) pollWorker() {
+	idle := time.Duration(s.config().Debug.PollingInterval) * time.Second
+	active := time.Duration(s.config().Debug.ActivePollingInterval) * time.Millisecond
+	current := idle
+	for {
+		select {
+		case <-s.HaltCh():
+			return
+		case <-s.surbs.idleCh:
+		}
+		interval := idle
+		if s.surbs.outstanding() > 0 {
+			interval = active
+		}
+		if interval == current {
+			continue
+		}
+		s.log.Debugf("Polling the receive queue every %v.", interval)
+		s.link.setPollInterval(interval)
+		current = interval
+	}
+}
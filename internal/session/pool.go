@@ -0,0 +1,153 @@
+// pool.go - precomposed packet pool
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+)
+
+// packetPool accumulates the burst mode measurements.
+type packetPool struct {
+	sync.Mutex
+
+	fills       uint64
+	composed    uint64
+	discarded   uint64
+	composeTime time.Duration
+}
+
+func (p *packetPool) report() *report.Pool {
+	p.Lock()
+	defer p.Unlock()
+	return &report.Pool{
+		Fills:       p.fills,
+		Composed:    p.composed,
+		Discarded:   p.discarded,
+		ComposeTime: p.composeTime,
+	}
+}
+
+// fillPool composes up to size packets of the traffic generator with
+// the CryptoWorkers, and returns them.  Fewer packets are returned if
+// the generator is exhausted, and false if the session was halted or a
+// packet could not be composed.
+func (s *Session) fillPool(src *sendSource, size int) ([][]byte, bool) {
+	start := time.Now()
+	tokens := make(chan struct{}, size)
+	for i := 0; i < size; i++ {
+		tokens <- struct{}{}
+	}
+	close(tokens)
+
+	var mu sync.Mutex
+	pkts := make([][]byte, 0, size)
+	var composeErr error
+	var wg sync.WaitGroup
+	for i := 0; i < s.config().Debug.CryptoWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range tokens {
+				target, payload := src.next(s)
+				if target == nil {
+					return
+				}
+				composeStart := time.Now()
+				pkt, _, _, err := s.composeSphinxPacket(target.Recipient, target.Provider, nil, payload)
+				s.pipeline.onCompose(time.Since(composeStart))
+				if err == errInjectedComposeFailure {
+					s.log.Debugf("Fault injection: %v", err)
+					s.results.record(nil, err)
+					continue
+				}
+				if err != nil {
+					mu.Lock()
+					composeErr = err
+					mu.Unlock()
+					return
+				}
+				mu.Lock()
+				pkts = append(pkts, pkt)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	d := time.Since(start)
+	s.pool.Lock()
+	s.pool.fills++
+	s.pool.composed += uint64(len(pkts))
+	s.pool.composeTime += d
+	s.pool.Unlock()
+	s.log.Noticef("Composed a pool of %d packets in %v.", len(pkts), d)
+	if composeErr != nil {
+		select {
+		case s.fatalErrCh <- composeErr:
+		case <-s.HaltCh():
+		}
+		return nil, false
+	}
+	select {
+	case <-s.HaltCh():
+		return nil, false
+	default:
+	}
+	return pkts, true
+}
+
+// burstWorker sends packets from a precomposed pool at the configured
+// rate.  The pool is composed again once it is used up, or once the
+// epoch changes, as the routes of its packets are then no longer valid,
+// and the run starts once the first pool is composed.
+func (s *Session) burstWorker(gen TrafficGenerator) {
+	src := &sendSource{gen: gen, noDelay: true}
+	size := s.config().PacketPool.Size
+	first := true
+	for {
+		epoch, _, _ := s.clock.now()
+		pkts, ok := s.fillPool(src, size)
+		if !ok {
+			return
+		}
+		if len(pkts) == 0 {
+			s.log.Notice("Packet pool is exhausted.")
+			return
+		}
+		if first {
+			s.results.reset()
+			first = false
+		}
+		for i, pkt := range pkts {
+			if current, _, _ := s.clock.now(); current != epoch {
+				n := len(pkts) - i
+				s.log.Noticef("Epoch %d started, discarding %d pooled packets.", current, n)
+				s.pool.Lock()
+				s.pool.discarded += uint64(n)
+				s.pool.Unlock()
+				break
+			}
+			if !s.waitUnpaused() {
+				return
+			}
+			s.onSendPacket(pkt)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// progress.go - run progress and ETA
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Progress is the progress of a run limited by a RunDuration or by
+// MaxPackets towards its end.
+type Progress struct {
+	// Elapsed is the time elapsed since the run started.
+	Elapsed time.Duration
+
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// RunDuration and MaxPackets are the limits of the run, zero if
+	// unset.
+	RunDuration time.Duration
+	MaxPackets  uint64
+
+	// Complete is the fraction of the run completed, towards whichever
+	// limit is closest to being reached.
+	Complete float64
+
+	// ETA is the estimated time remaining until the run ends, not
+	// counting the draining of the outstanding replies.  It is zero if
+	// it can not be estimated yet.
+	ETA time.Duration
+}
+
+// String returns a one line summary of the progress.
+func (p *Progress) String() string {
+	s := fmt.Sprintf("%.1f%% complete, %d", 100*p.Complete, p.Sent)
+	if p.MaxPackets > 0 {
+		s += fmt.Sprintf("/%d", p.MaxPackets)
+	}
+	s += fmt.Sprintf(" packets sent, %v", p.Elapsed.Round(time.Second))
+	if p.RunDuration > 0 {
+		s += fmt.Sprintf("/%v", p.RunDuration)
+	}
+	s += " elapsed"
+	if p.ETA > 0 {
+		s += fmt.Sprintf(", ETA %v", p.ETA.Round(time.Second))
+	}
+	return s
+}
+
+// Progress returns the progress of the run, or nil if it is neither
+// limited by a RunDuration nor by MaxPackets.  The ETA of MaxPackets is
+// estimated from the send rate achieved so far, or the configured send
+// rate before any packets were sent.
+func (s *Session) Progress() *Progress {
+	cfg := s.config().Traffic
+	if !cfg.Limited() {
+		return nil
+	}
+	p := &Progress{
+		Elapsed:     time.Since(s.limitStart),
+		Sent:        atomic.LoadUint64(&s.counters.sent),
+		RunDuration: time.Duration(cfg.RunDuration) * time.Second,
+		MaxPackets:  cfg.MaxPackets,
+	}
+	if p.RunDuration > 0 {
+		p.Complete = float64(p.Elapsed) / float64(p.RunDuration)
+		p.ETA = p.RunDuration - p.Elapsed
+	}
+	if p.MaxPackets > 0 {
+		if c := float64(p.Sent) / float64(p.MaxPackets); c > p.Complete {
+			p.Complete = c
+		}
+		rate := float64(p.Sent) / p.Elapsed.Seconds()
+		if p.Sent == 0 {
+			rate, _ = s.Rate()
+		}
+		if rate > 0 && p.Sent < p.MaxPackets {
+			eta := time.Duration(float64(p.MaxPackets-p.Sent) / rate * float64(time.Second))
+			if p.ETA <= 0 || eta < p.ETA {
+				p.ETA = eta
+			}
+		}
+	}
+	if p.Complete >= 1 {
+		p.Complete = 1
+		p.ETA = 0
+	}
+	return p
+}
+
+// progressWorker logs the progress of the run every ProgressInterval,
+// until it ends.
+func (s *Session) progressWorker() {
+	interval := time.Duration(s.config().Traffic.ProgressInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.log.Noticef("Progress: %v", s.Progress())
+		case <-s.limitCh:
+			return
+		case <-s.doneCh:
+			return
+		case <-s.HaltCh():
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// ramp.go - ramp load profile.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"time"
+
+	"github.com/katzenpost/spray/event"
+	"golang.org/x/time/rate"
+)
+
+// rampStage is a stage of the ramp load profile, during which the send
+// rate changes linearly from one rate to another.
+type rampStage struct {
+	name     string
+	from, to float64
+	duration time.Duration
+}
+
+// rampWorker runs the stages of the ramp load profile in order, pausing
+// load generation and closing doneCh once they are all done.  Every
+// stage is published as a phase of the run.
+func (s *Session) rampWorker() {
+	cfg := s.config().Ramp
+	step := time.Duration(cfg.Step) * time.Millisecond
+	stages := []rampStage{
+		{"ramp up", cfg.StartRate, cfg.PeakRate, time.Duration(cfg.RampUp) * time.Second},
+		{"hold", cfg.PeakRate, cfg.PeakRate, time.Duration(cfg.Hold) * time.Second},
+		{"ramp down", cfg.PeakRate, cfg.StartRate, time.Duration(cfg.RampDown) * time.Second},
+	}
+	for i, st := range stages {
+		if st.duration == 0 {
+			continue
+		}
+		s.log.Noticef("Starting %s from %v to %v packets per second over %v.", st.name, st.from, st.to, st.duration)
+		s.events.Publish(&event.PhaseEvent{At: time.Now(), Index: i, Name: st.name})
+		if !s.ramp(&st, step) {
+			return
+		}
+		s.logResults(st.name)
+	}
+	s.Pause()
+	s.log.Notice("Ramp complete.")
+	s.finish()
+}
+
+// slowStartStep is the interval the send rate is updated at during a
+// slow start.
+const slowStartStep = 100 * time.Millisecond
+
+// slowStart raises the send rate linearly from the current rate, or zero
+// for the first phase, to qps over d, and returns the time spent doing
+// so, and false if the session was halted in the meantime.
+func (s *Session) slowStart(first bool, qps float64, d time.Duration) (time.Duration, bool) {
+	if d == 0 {
+		return 0, true
+	}
+	from := float64(s.limiter.Limit())
+	if first {
+		from = 0
+	}
+	s.log.Noticef("Slow start from %v to %v packets per second over %v.", from, qps, d)
+	start := time.Now()
+	ok := s.ramp(&rampStage{"slow start", from, qps, d}, slowStartStep)
+	return time.Since(start), ok
+}
+
+// ramp updates the send rate every step for the duration of the stage,
+// and returns false if the session was halted in the meantime.
+func (s *Session) ramp(st *rampStage, step time.Duration) bool {
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= st.duration {
+			s.limiter.SetLimit(rate.Limit(st.to))
+			return true
+		}
+		s.limiter.SetLimit(rate.Limit(st.from + (st.to-st.from)*float64(elapsed)/float64(st.duration)))
+		next := step
+		if remaining := st.duration - elapsed; remaining < next {
+			next = remaining
+		}
+		if !s.sleep(next) {
+			return false
+		}
+	}
+}
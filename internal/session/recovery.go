@@ -0,0 +1,84 @@
+// recovery.go - lost connection recovery
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"time"
+)
+
+// recovery holds back load generation while a lost connection to the
+// Provider is being recovered.  Unlike a Pause, it is lifted on its own.
+type recovery struct {
+	gate pauseGate
+}
+
+// onConnectionLost is called by the sessionWorker when the connection to
+// the Provider drops.  Unless the disconnection was deliberate, load
+// generation is paused and a recoveryWorker is started, so that packets
+// are not sent into a dead connection.
+func (s *Session) onConnectionLost() {
+	if s.link.client() == nil || s.IsDisconnected() || s.config().Churn != nil {
+		return
+	}
+	if !s.recovery.gate.pause() {
+		// Already recovering.
+		return
+	}
+	s.log.Warning("Connection to the Provider lost, pausing load generation.")
+	// Discard the notification of the connection that was lost.
+	select {
+	case <-s.onlineCh:
+	default:
+	}
+	s.Go(s.recoveryWorker)
+}
+
+// recoveryWorker waits for the lost connection to come back, replacing
+// the client whenever the backoff expires first, with the backoff
+// doubling every time up to the MaxReconnectBackoff, and then resumes
+// load generation.
+func (s *Session) recoveryWorker() {
+	lost := time.Now()
+	backoff := time.Duration(s.config().Debug.ReconnectBackoff) * time.Millisecond
+	maxBackoff := time.Duration(s.config().Debug.MaxReconnectBackoff) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-s.onlineCh:
+		case <-time.After(backoff):
+			s.log.Noticef("Reconnecting to the Provider, attempt %d.", attempt)
+			s.link.disconnect()
+			err := s.reconnect()
+			if err == errHalted {
+				return
+			}
+			if err != nil {
+				s.log.Warningf("Failed to reconnect to the Provider: %v", err)
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+		case <-s.HaltCh():
+			return
+		}
+		break
+	}
+	d := time.Since(lost)
+	s.results.onRecovered(d)
+	s.recovery.gate.resume()
+	s.log.Noticef("Connection to the Provider recovered after %v, resuming load generation.", d)
+}
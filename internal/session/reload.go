@@ -0,0 +1,126 @@
+// reload.go - live reconfiguration of the traffic parameters.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/katzenpost/spray/config"
+)
+
+// trafficParams are the traffic parameters of the default generator that
+// may be changed while the session runs.  The generation is incremented
+// with every change, so that the generator can cheaply detect changes.
+// The targets are those of all, minus the ones excluded by target gating.
+type trafficParams struct {
+	sync.Mutex
+
+	generation  uint64
+	all         []*config.Target
+	targets     []*config.Target
+	payloadSize int
+}
+
+func (t *trafficParams) set(targets []*config.Target, payloadSize int) {
+	t.Lock()
+	defer t.Unlock()
+	t.all = targets
+	t.targets = targets
+	t.payloadSize = payloadSize
+	atomic.AddUint64(&t.generation, 1)
+}
+
+// gate restricts the targets to those of all that healthy returns true
+// for, and returns the excluded ones.  If every target is excluded, the
+// targets are left unchanged.
+func (t *trafficParams) gate(healthy func(*config.Target) bool) (int, []*config.Target) {
+	t.Lock()
+	defer t.Unlock()
+	var targets, excluded []*config.Target
+	for _, target := range t.all {
+		if healthy(target) {
+			targets = append(targets, target)
+		} else {
+			excluded = append(excluded, target)
+		}
+	}
+	if len(targets) > 0 {
+		t.targets = targets
+		atomic.AddUint64(&t.generation, 1)
+	}
+	return len(targets), excluded
+}
+
+func (t *trafficParams) get() (uint64, []*config.Target, int) {
+	t.Lock()
+	defer t.Unlock()
+	return atomic.LoadUint64(&t.generation), t.targets, t.payloadSize
+}
+
+func (t *trafficParams) allTargets() []*config.Target {
+	t.Lock()
+	defer t.Unlock()
+	return t.all
+}
+
+func (t *trafficParams) changed(generation uint64) bool {
+	return atomic.LoadUint64(&t.generation) != generation
+}
+
+// Reload applies the send rate and burst, the payload size and the
+// targets of cfg, a reloaded configuration of the session's account, to
+// the running session without reconnecting.  Every other setting
+// requires a new session, and discovered targets are kept.  A session
+// with targets can not be left without any.
+func (s *Session) Reload(cfg *config.Config) error {
+	cur := s.config()
+	if cfg.Account.Identifier() != cur.Account.Identifier() {
+		return errors.New("the account of a session can not be changed")
+	}
+	_, _, payloadSize := s.traffic.get()
+	targets := s.traffic.allTargets()
+	if cur.Discovery == nil {
+		targets = cfg.Targets()
+		if s.nodes != nil {
+			targets = s.nodes.filterTargets(s.minclient.CurrentDocument(), targets)
+		}
+		if len(targets) == 0 && len(cfg.Targets()) > 0 {
+			return errors.New("every target is on an excluded Provider")
+		}
+		if len(targets) == 0 && len(s.traffic.allTargets()) > 0 {
+			return errors.New("the targets of a session can not all be removed")
+		}
+	}
+	if size := cfg.Traffic.PayloadSize; size != payloadSize {
+		if cur.Debug.Mode == config.ModeMailboxSender && size < taggedProbeLength {
+			return fmt.Errorf("Traffic PayloadSize %v is too small for the probe header", size)
+		}
+		payloadSize = size
+	}
+	s.cfgLock.Lock()
+	s.cfg = cur.WithTraffic(cfg)
+	s.cfgLock.Unlock()
+	s.traffic.set(targets, payloadSize)
+	if qps, burst := s.Rate(); qps != cfg.Debug.SendRate || burst != cfg.Debug.SendBurst {
+		s.SetRate(cfg.Debug.SendRate, cfg.Debug.SendBurst)
+	}
+	s.log.Noticef("Reloaded the traffic parameters: %d targets, %d byte payloads.", len(targets), payloadSize)
+	return nil
+}
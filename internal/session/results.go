@@ -0,0 +1,682 @@
+// results.go - run results accounting
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+const (
+	// maxErrorKinds is the number of distinct error messages counted
+	// separately, and otherErrors the key the others are counted under.
+	maxErrorKinds = 64
+	otherErrors   = "other"
+)
+
+// results accumulates the measurements of a run across all modes.
+type results struct {
+	sync.Mutex
+
+	clock     *epochClock
+	vantage   *report.Vantage
+	startTime time.Time
+	sent      uint64
+	probes    uint64
+	outcomes  map[string]uint64
+	latency   *stats.Histogram
+
+	// errors is the number of probes per error message, up to
+	// maxErrorKinds distinct messages.
+	errors map[string]uint64
+
+	// rawLatency is the one way latency before clock skew correction.
+	rawLatency *stats.Histogram
+
+	// losses is the loss burst characterization of the probes that
+	// were sent, which are added to it in send order once they are past
+	// the reordering window of deliveries, the send times and fates of
+	// the latest probes sorted by send time.
+	losses     *report.LossBurstCounter
+	deliveries []delivery
+
+	// heatmapInterval is the time bucket duration of heatmap, which is
+	// only kept if it is nonzero.
+	heatmapInterval time.Duration
+	heatmap         *report.Heatmap
+
+	disconnects uint64
+	offline     time.Duration
+
+	connectionLosses uint64
+	reconnecting     time.Duration
+
+	// epochs are the per-epoch accounting, and models the latency
+	// models of the epochs we have seen a document for.
+	epochs map[uint64]*report.Epoch
+	models map[uint64]*stats.Histogram
+	params map[uint64]*report.Params
+
+	// targets are the per-target accounting, with independent
+	// sequence numbers.
+	targets map[targetKey]*targetResults
+
+	// hopChanges is the history of the number of hops of the paths,
+	// and byHops the latency histograms per number of hops.
+	hopChanges []hopChange
+
+	// genChanges is the history of the connection generations, and
+	// generations the accounting of every generation.  The history is
+	// not reset, as the connection outlives the reset.
+	genChanges  []genChange
+	generations map[int]*report.Generation
+
+	// clockSkew is the largest absolute clock skew versus the Provider
+	// measured, and skewExceeded is set if it exceeded the MaxClockSkew.
+	// Unlike the other results they are not reset, as they are only
+	// measured on connecting.
+	clockSkew    time.Duration
+	skewExceeded bool
+
+	// replyHops is the number of hops of the SURB reply paths, if set
+	// apart from the forward paths.
+	replyHops int
+	byHops    map[int]*stats.Histogram
+
+	vegeta     *report.VegetaEncoder
+	vegetaFile *os.File
+
+	timeSeries     *report.TimeSeriesEncoder
+	timeSeriesFile *os.File
+
+	// onProbe, if set, is called for every recorded probe.
+	onProbe func(*report.Probe)
+}
+
+func newResults(clock *epochClock) *results {
+	return &results{
+		clock:     clock,
+		startTime: time.Now(),
+		outcomes:  make(map[string]uint64),
+		latency:   stats.NewHistogram(),
+		errors:    make(map[string]uint64),
+		losses:    report.NewLossBurstCounter(),
+		epochs:    make(map[uint64]*report.Epoch),
+		models:    make(map[uint64]*stats.Histogram),
+		params:    make(map[uint64]*report.Params),
+		byHops:    make(map[int]*stats.Histogram),
+		targets:   make(map[targetKey]*targetResults),
+
+		generations: make(map[int]*report.Generation),
+	}
+}
+
+// lossReorderWindow is the number of probes kept to put the probes,
+// which complete out of order, back in send order for the loss burst
+// characterization.
+const lossReorderWindow = 4096
+
+type delivery struct {
+	sentAt int64
+	lost   bool
+}
+
+// addDelivery adds the fate of a probe sent at sentAt to the loss burst
+// characterization.  The caller must hold the lock.
+func (r *results) addDelivery(sentAt int64, lost bool) {
+	i := sort.Search(len(r.deliveries), func(i int) bool { return r.deliveries[i].sentAt > sentAt })
+	r.deliveries = append(r.deliveries, delivery{})
+	copy(r.deliveries[i+1:], r.deliveries[i:])
+	r.deliveries[i] = delivery{sentAt, lost}
+	if len(r.deliveries) > lossReorderWindow {
+		r.losses.Add(r.deliveries[0].lost)
+		r.deliveries = r.deliveries[1:]
+	}
+}
+
+// lossBursts returns the loss burst characterization of the probes.  The
+// caller must hold the lock.
+func (r *results) lossBursts() *report.LossBursts {
+	c := r.losses.Clone()
+	for _, d := range r.deliveries {
+		c.Add(d.lost)
+	}
+	if l := c.LossBursts(); l.Probes > 0 {
+		return l
+	}
+	return nil
+}
+
+type targetKey struct {
+	provider  string
+	recipient string
+}
+
+type targetResults struct {
+	seq    uint64
+	report *report.Target
+}
+
+// target returns the accounting of the given target.  The caller must
+// hold the lock.
+func (r *results) target(provider, recipient string) *targetResults {
+	k := targetKey{provider, recipient}
+	t, ok := r.targets[k]
+	if !ok {
+		t = &targetResults{report: report.NewTarget(provider, recipient)}
+		r.targets[k] = t
+	}
+	return t
+}
+
+// skipTarget records that the given target was skipped for a phase.
+func (r *results) skipTarget(provider, recipient string) {
+	r.Lock()
+	defer r.Unlock()
+	r.target(provider, recipient).report.Skipped++
+}
+
+type hopChange struct {
+	at   time.Time
+	hops int
+}
+
+// setHops records that the paths have the given number of hops from
+// now on.
+func (r *results) setHops(hops int) {
+	r.Lock()
+	defer r.Unlock()
+	r.hopChanges = append(r.hopChanges, hopChange{time.Now(), hops})
+}
+
+type genChange struct {
+	at         time.Time
+	generation int
+}
+
+// onConnected starts a new connection generation.
+func (r *results) onConnected() {
+	r.Lock()
+	defer r.Unlock()
+	r.genChanges = append(r.genChanges, genChange{time.Now(), len(r.genChanges) + 1})
+}
+
+// generationAt returns the connection generation at t, or zero if the
+// Provider was not yet connected to.  The caller must hold the lock.
+func (r *results) generationAt(t time.Time) int {
+	for i := len(r.genChanges) - 1; i >= 0; i-- {
+		if !r.genChanges[i].at.After(t) {
+			return r.genChanges[i].generation
+		}
+	}
+	return 0
+}
+
+// generation returns the accounting of the given connection generation.
+// The caller must hold the lock.
+func (r *results) generation(generation int) *report.Generation {
+	g, ok := r.generations[generation]
+	if !ok {
+		start := r.startTime
+		if generation > 0 {
+			start = r.genChanges[generation-1].at
+		}
+		g = report.NewGeneration(generation, start)
+		r.generations[generation] = g
+	}
+	return g
+}
+
+// onClockSkew accounts for an absolute clock skew measured on
+// connecting.
+func (r *results) onClockSkew(skew time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	if skew > r.clockSkew {
+		r.clockSkew = skew
+	}
+}
+
+// onSkewExceeded marks the results as measured with a clock skew above
+// the MaxClockSkew.
+func (r *results) onSkewExceeded() {
+	r.Lock()
+	defer r.Unlock()
+	r.skewExceeded = true
+}
+
+// setReplyHops records that the SURB reply paths have the given number
+// of hops from now on.
+func (r *results) setReplyHops(hops int) {
+	r.Lock()
+	defer r.Unlock()
+	r.replyHops = hops
+}
+
+// hopsAt returns the number of hops of the paths at t, or zero if
+// unknown.
+func (r *results) hopsAt(t time.Time) int {
+	for i := len(r.hopChanges) - 1; i >= 0; i-- {
+		if !r.hopChanges[i].at.After(t) {
+			return r.hopChanges[i].hops
+		}
+	}
+	return 0
+}
+
+// reset discards everything accumulated so far and restarts the clock.
+func (r *results) reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.startTime = time.Now()
+	r.sent = 0
+	r.probes = 0
+	r.outcomes = make(map[string]uint64)
+	r.latency = stats.NewHistogram()
+	r.errors = make(map[string]uint64)
+	r.rawLatency = nil
+	r.losses = report.NewLossBurstCounter()
+	r.deliveries = nil
+	if r.heatmapInterval > 0 {
+		r.heatmap = report.NewHeatmap(r.startTime, r.heatmapInterval)
+	}
+	r.epochs = make(map[uint64]*report.Epoch)
+	r.byHops = make(map[int]*stats.Histogram)
+	r.targets = make(map[targetKey]*targetResults)
+	r.generations = make(map[int]*report.Generation)
+	r.disconnects = 0
+	r.offline = 0
+	r.connectionLosses = 0
+	r.reconnecting = 0
+}
+
+// onOffline accounts for a period of time spent disconnected.
+func (r *results) onOffline(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.disconnects++
+	r.offline += d
+}
+
+// onRecovered accounts for a lost connection, and the time spent
+// recovering it.
+func (r *results) onRecovered(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.connectionLosses++
+	r.reconnecting += d
+}
+
+// setParams sets the mix parameters of an epoch.
+func (r *results) setParams(epoch uint64, params *report.Params) {
+	r.Lock()
+	defer r.Unlock()
+	r.params[epoch] = params
+}
+
+// setModel sets the latency model of an epoch.
+func (r *results) setModel(epoch uint64, model *stats.Histogram) {
+	r.Lock()
+	defer r.Unlock()
+	r.models[epoch] = model
+}
+
+// epoch returns the accounting of the given epoch.  The caller must
+// hold the lock.
+func (r *results) epoch(epoch uint64) *report.Epoch {
+	e, ok := r.epochs[epoch]
+	if !ok {
+		e = report.NewEpoch(epoch)
+		r.epochs[epoch] = e
+	}
+	return e
+}
+
+func (r *results) onSent(t time.Time) {
+	epoch, _, _ := r.clock.at(t)
+
+	r.Lock()
+	defer r.Unlock()
+	r.sent++
+	r.epoch(epoch).Sent++
+	r.generation(r.generationAt(t)).Sent++
+}
+
+// openVegeta starts streaming per-probe results to the named file.
+func (r *results) openVegeta(f, format string, attack string) error {
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc, err := report.NewVegetaEncoder(out, format, attack)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.vegeta = enc
+	r.vegetaFile = out
+	return nil
+}
+
+// openTimeSeries starts streaming a time series record per probe to the
+// named file.
+func (r *results) openTimeSeries(f, format string) error {
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc, err := report.NewTimeSeriesEncoder(out, format)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.timeSeries = enc
+	r.timeSeriesFile = out
+	return nil
+}
+
+// close flushes and closes any per-probe result streams.
+func (r *results) close() error {
+	r.Lock()
+	defer r.Unlock()
+	var err error
+	if r.vegeta != nil {
+		err = r.vegeta.Flush()
+		if cErr := r.vegetaFile.Close(); err == nil {
+			err = cErr
+		}
+		r.vegeta = nil
+		r.vegetaFile = nil
+	}
+	if r.timeSeries != nil {
+		tErr := r.timeSeries.Flush()
+		if cErr := r.timeSeriesFile.Close(); tErr == nil {
+			tErr = cErr
+		}
+		if err == nil {
+			err = tErr
+		}
+		r.timeSeries = nil
+		r.timeSeriesFile = nil
+	}
+	return err
+}
+
+// countError counts a probe that failed with the error message msg.  The
+// messages past the first maxErrorKinds are counted together, as error
+// messages may embed details that make every one of them distinct.
+func (r *results) countError(msg string) {
+	if _, ok := r.errors[msg]; !ok && len(r.errors) >= maxErrorKinds {
+		msg = otherErrors
+	}
+	r.errors[msg]++
+}
+
+// record accounts for a single probe, classifying the error returned by
+// the exchange, if any.  p may be nil if the probe was never sent.
+func (r *results) record(p *report.Probe, err error) {
+	if p == nil {
+		p = &report.Probe{Timestamp: time.Now()}
+	}
+	if p.Epoch == 0 {
+		p.Epoch, _, _ = r.clock.at(p.Timestamp)
+	}
+	p.Vantage = r.vantage
+	switch err {
+	case nil:
+		p.Outcome = report.OutcomeOK
+	case errReplyTimeout:
+		p.Outcome = report.OutcomeLost
+	case ErrIntegrity:
+		p.Outcome = report.OutcomeCorrupt
+	default:
+		p.Outcome = report.OutcomeFailed
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	r.Lock()
+	p.Seq = r.probes
+	p.Hops = r.hopsAt(p.Timestamp)
+	p.ReplyHops = r.replyHops
+	p.Generation = r.generationAt(p.Timestamp)
+	p.Params = r.params[p.Epoch]
+	r.probes++
+	r.outcomes[p.Outcome]++
+	if p.Error != "" {
+		r.countError(p.Error)
+	}
+	e := r.epoch(p.Epoch)
+	e.Outcomes[p.Outcome]++
+	switch p.Outcome {
+	case report.OutcomeOK, report.OutcomeLost, report.OutcomeCorrupt:
+		r.addDelivery(p.Timestamp.UnixNano(), p.Outcome == report.OutcomeLost)
+	}
+	var t *targetResults
+	if p.Recipient != "" {
+		t = r.target(p.Provider, p.Recipient)
+		p.TargetSeq = t.seq
+		t.seq++
+		t.report.Outcomes[p.Outcome]++
+	}
+	g := r.generation(p.Generation)
+	g.Outcomes[p.Outcome]++
+	if err == nil {
+		r.latency.Record(p.Latency)
+		if r.heatmap != nil {
+			r.heatmap.Record(p.Timestamp, p.Latency)
+		}
+		if p.RawLatency != 0 {
+			if r.rawLatency == nil {
+				r.rawLatency = stats.NewHistogram()
+			}
+			r.rawLatency.Record(p.RawLatency)
+		}
+		e.Latency.Record(p.Latency)
+		if t != nil {
+			t.report.Latency.Record(p.Latency)
+		}
+		g.Latency.Record(p.Latency)
+		if p.Hops != 0 {
+			h, ok := r.byHops[p.Hops]
+			if !ok {
+				h = stats.NewHistogram()
+				r.byHops[p.Hops] = h
+			}
+			h.Record(p.Latency)
+		}
+	}
+	if r.vegeta != nil {
+		r.vegeta.Encode(p)
+	}
+	if r.timeSeries != nil {
+		r.timeSeries.Encode(p)
+	}
+	r.Unlock()
+
+	if r.onProbe != nil {
+		r.onProbe(p)
+	}
+}
+
+func (s *Session) logResults(what string) {
+	r := s.RunReport()
+	h := r.Latency
+	s.log.Noticef("%s: %d sent, %d ok, %d lost, %d corrupt, %d failed, latency min %v mean %v p50 %v p90 %v p99 %v p999 %v max %v",
+		what, r.Sent, r.Outcomes[report.OutcomeOK], r.Outcomes[report.OutcomeLost], r.Outcomes[report.OutcomeCorrupt], r.Outcomes[report.OutcomeFailed],
+		h.Min(), h.Mean(), h.Percentile(50), h.Percentile(90), h.Percentile(99), h.Percentile(99.9), h.Max())
+	if f := r.PKIFetch; f != nil {
+		s.log.Noticef("%s: %d PKI fetches, latency p50 %v p90 %v p99 %v p999 %v max %v",
+			what, f.Count(), f.Percentile(50), f.Percentile(90), f.Percentile(99), f.Percentile(99.9), f.Max())
+	}
+	if r.Disconnects > 0 {
+		s.log.Noticef("%s: %d disconnects, %v offline (%.2f%% of the run)",
+			what, r.Disconnects, r.Offline, 100*r.Offline.Seconds()/r.Duration.Seconds())
+	}
+	if r.ConnectionLosses > 0 {
+		s.log.Noticef("%s: %d connection losses, %v reconnecting (%.2f%% of the run)",
+			what, r.ConnectionLosses, r.Reconnecting, 100*r.Reconnecting.Seconds()/r.Duration.Seconds())
+	}
+	if r.SkewExceeded {
+		s.log.Warningf("%s: clock skew of %v exceeded the MaxClockSkew, latencies are unreliable", what, r.ClockSkew)
+	}
+}
+
+// copyHistogram returns a copy of h, which the report can hold on to
+// while h keeps recording.
+func copyHistogram(h *stats.Histogram) *stats.Histogram {
+	c := stats.NewHistogram()
+	c.Merge(h)
+	return c
+}
+
+// RunReport returns a report of the measurements made by the session so
+// far, which shares no state with the session.
+func (s *Session) RunReport() *report.Run {
+	r := s.results
+	r.Lock()
+	defer r.Unlock()
+
+	outcomes := make(map[string]uint64, len(r.outcomes))
+	for k, v := range r.outcomes {
+		outcomes[k] = v
+	}
+	var errs map[string]uint64
+	if len(r.errors) > 0 {
+		errs = make(map[string]uint64, len(r.errors))
+		for k, v := range r.errors {
+			errs[k] = v
+		}
+	}
+	var model []*report.ModelComparison
+	epochs := make([]*report.Epoch, 0, len(r.epochs))
+	for epoch, e := range r.epochs {
+		c := *e
+		c.Params = r.params[epoch]
+		c.Latency = copyHistogram(e.Latency)
+		c.Outcomes = make(map[string]uint64, len(e.Outcomes))
+		for k, v := range e.Outcomes {
+			c.Outcomes[k] = v
+		}
+		epochs = append(epochs, &c)
+		if m, ok := r.models[epoch]; ok && e.Latency.Count() > 0 {
+			model = append(model, report.NewModelComparison(epoch, m, e.Latency))
+		}
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i].Epoch < epochs[j].Epoch })
+	sort.Slice(model, func(i, j int) bool { return model[i].Epoch < model[j].Epoch })
+	var byHops []*report.HopLatency
+	if len(r.byHops) > 1 {
+		for hops, h := range r.byHops {
+			byHops = append(byHops, &report.HopLatency{Hops: hops, Latency: copyHistogram(h)})
+		}
+		sort.Slice(byHops, func(i, j int) bool { return byHops[i].Hops < byHops[j].Hops })
+	}
+	var targets []*report.Target
+	if len(r.targets) > 1 {
+		for _, t := range r.targets {
+			c := *t.report
+			c.Latency = copyHistogram(t.report.Latency)
+			c.Outcomes = make(map[string]uint64, len(t.report.Outcomes))
+			for k, v := range t.report.Outcomes {
+				c.Outcomes[k] = v
+			}
+			targets = append(targets, &c)
+		}
+		report.SortTargets(targets)
+	}
+	var generations []*report.Generation
+	if len(r.generations) > 1 {
+		for _, g := range r.generations {
+			c := *g
+			c.Latency = copyHistogram(g.Latency)
+			c.Outcomes = make(map[string]uint64, len(g.Outcomes))
+			for k, v := range g.Outcomes {
+				c.Outcomes[k] = v
+			}
+			generations = append(generations, &c)
+		}
+		sort.Slice(generations, func(i, j int) bool { return generations[i].Generation < generations[j].Generation })
+	}
+	run := &report.Run{
+		Vantage:          r.vantage,
+		Mode:             s.config().Debug.Mode,
+		StartTime:        r.startTime,
+		Duration:         time.Since(r.startTime),
+		RequestedQPS:     s.config().Debug.SendRate,
+		Sent:             r.sent,
+		Outcomes:         outcomes,
+		Errors:           errs,
+		Latency:          copyHistogram(r.latency),
+		Targets:          targets,
+		LossBursts:       r.lossBursts(),
+		Epochs:           epochs,
+		Model:            model,
+		Disconnects:      r.disconnects,
+		Offline:          r.offline,
+		ClockSkew:        r.clockSkew,
+		SkewExceeded:     r.skewExceeded,
+		ConnectionLosses: r.connectionLosses,
+		Reconnecting:     r.reconnecting,
+		Hops:             byHops,
+		Generations:      generations,
+	}
+	if r.rawLatency != nil {
+		run.RawLatency = copyHistogram(r.rawLatency)
+	}
+	if s.config().Loop != nil {
+		run.Loops = s.loops.report()
+	}
+	if r.heatmap != nil {
+		run.Heatmap = r.heatmap.Clone()
+	}
+	if s.pacing.Count() > 0 {
+		run.Pacing = &report.Pacing{Error: copyHistogram(s.pacing)}
+	}
+	run.Calibration = s.calibration
+	run.Bottleneck = s.bottleneck(run.Duration)
+	if s.config().Debug.Mode == config.ModeComposeOnly {
+		run.Compose = s.compose.report(run.Duration)
+	}
+	if s.config().Debug.Mode == config.ModeMailboxReceiver {
+		run.Sequence = s.mailbox.sequence()
+	}
+	if s.drain != nil {
+		run.Drain = s.drain.report()
+	}
+	if s.pool != nil {
+		run.Pool = s.pool.report()
+	}
+	if s.pkiFetch.Count() > 0 {
+		run.PKIFetch = copyHistogram(s.pkiFetch)
+	}
+	if s.crossChecks != nil {
+		run.CrossChecks = s.crossChecks.report()
+	}
+	return run
+}
@@ -0,0 +1,183 @@
+// schedule.go - run schedule
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/event"
+)
+
+// Done returns a channel that is closed once the configured run
+// schedule, ramp or staircase has completed, the RunDuration has elapsed
+// or MaxPackets were sent and the outstanding replies are drained, or in
+// the drain mode once the spool is empty.  Otherwise it is never closed.
+func (s *Session) Done() <-chan interface{} {
+	return s.doneCh
+}
+
+// drainPollInterval is the interval the outstanding replies are checked
+// at while draining them.
+const drainPollInterval = 100 * time.Millisecond
+
+// finish closes doneCh, once the first of the configured ends of the run
+// is reached.
+func (s *Session) finish() {
+	s.doneOnce.Do(func() { close(s.doneCh) })
+}
+
+// runLimitWorker pauses load generation once the RunDuration has elapsed
+// or MaxPackets were sent, and waits for the replies to the SURBs still
+// outstanding for up to the DrainGrace period before closing doneCh.
+func (s *Session) runLimitWorker() {
+	cfg := s.config().Traffic
+	var expired <-chan time.Time
+	if cfg.RunDuration > 0 {
+		t := time.NewTimer(time.Duration(cfg.RunDuration) * time.Second)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-expired:
+		s.Pause()
+		s.log.Noticef("Run duration of %v seconds elapsed, draining %v outstanding replies.", cfg.RunDuration, s.surbs.outstanding())
+	case <-s.limitCh:
+		s.Pause()
+		s.log.Noticef("%v packets sent, draining %v outstanding replies.", cfg.MaxPackets, s.surbs.outstanding())
+	case <-s.HaltCh():
+		return
+	}
+	deadline := time.Now().Add(time.Duration(cfg.DrainGrace) * time.Second)
+	for s.surbs.outstanding() > 0 {
+		if time.Now().After(deadline) {
+			s.log.Warningf("Drain grace period elapsed with %v replies outstanding.", s.surbs.outstanding())
+			break
+		}
+		if !s.sleep(drainPollInterval) {
+			return
+		}
+	}
+	s.finish()
+}
+
+// sleep waits for d, and returns false if the session was halted in the
+// meantime.
+func (s *Session) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.HaltCh():
+		return false
+	}
+}
+
+// haltContext returns a context that is cancelled when the session is
+// halted, for the blocking calls that take one.
+func (s *Session) haltContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.HaltCh():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// awaitEpochBoundary waits for the start of the next epoch.
+func (s *Session) awaitEpochBoundary() bool {
+	_, _, till := s.clock.now()
+	return s.sleep(till)
+}
+
+// runPhase waits for the end of the phase, elapsed being the time spent
+// in its slow start.
+func (s *Session) runPhase(p *config.Phase, elapsed time.Duration) bool {
+	if !s.config().Schedule.AlignToEpochs {
+		return s.sleep(time.Duration(p.Duration)*time.Second - elapsed)
+	}
+	for i := 0; i < p.Epochs; i++ {
+		if !s.awaitEpochBoundary() {
+			return false
+		}
+	}
+	return true
+}
+
+// scheduleWorker runs the phases of the run schedule in order, pausing
+// load generation and closing doneCh once they are all done.  When the
+// schedule is aligned to epochs the session starts out paused, and the
+// run starts at the next epoch boundary.
+func (s *Session) scheduleWorker() {
+	cfg := s.config().Schedule
+	slowStart := time.Duration(cfg.SlowStart) * time.Second
+	if cfg.AlignToEpochs {
+		_, _, till := s.clock.now()
+		s.log.Noticef("Waiting %v for the next epoch boundary to start the run.", till)
+		if !s.awaitEpochBoundary() {
+			return
+		}
+		s.results.reset()
+		s.Resume()
+	}
+	sweepHops := false
+	for _, p := range cfg.Phases {
+		sweepHops = sweepHops || p.Hops != 0
+	}
+	gated := false
+	for i, p := range cfg.Phases {
+		if cfg.GateTargets {
+			if s.gateTargets() == 0 {
+				s.log.Warningf("Phase '%s' has no target left, pausing it.", p.Name)
+				if !s.IsPaused() {
+					s.Pause()
+					gated = true
+				}
+			} else if gated {
+				s.Resume()
+				gated = false
+			}
+		}
+		qps := p.SendRate
+		if qps == 0 {
+			qps = s.config().Debug.SendRate
+		}
+		if sweepHops {
+			if err := s.SetHops(p.Hops); err != nil {
+				s.log.Warningf("Phase '%s' keeps the previous number of hops: %v", p.Name, err)
+			}
+		}
+		epoch, _, _ := s.clock.now()
+		s.log.Noticef("Starting phase %d/%d '%s' in epoch %d.", i+1, len(cfg.Phases), p.Name, epoch)
+		s.events.Publish(&event.PhaseEvent{At: time.Now(), Index: i, Name: p.Name})
+		elapsed, ok := s.slowStart(i == 0, qps, slowStart)
+		if !ok {
+			return
+		}
+		s.SetRate(qps, 0)
+		if !s.runPhase(p, elapsed) {
+			return
+		}
+		s.logResults("phase " + p.Name)
+	}
+	s.Pause()
+	s.log.Notice("Run schedule complete.")
+	s.finish()
+}
@@ -0,0 +1,202 @@
+// script.go - Starlark scenario scripts
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+	"go.starlark.net/starlark"
+)
+
+// ScriptTrafficGenerator is the name of the traffic generator driven by
+// the next_send function of the configured scenario script.
+const ScriptTrafficGenerator = "script"
+
+// Names of the functions a scenario script may define.
+const (
+	scriptNextSend = "next_send"
+	scriptOnEpoch  = "on_epoch"
+	scriptOnProbe  = "on_probe"
+)
+
+func init() {
+	RegisterTrafficGenerator(ScriptTrafficGenerator, newScriptGenerator)
+}
+
+// script is a loaded Starlark scenario script.  Besides the usual
+// Starlark builtins, scripts have access to:
+//
+//	log(msg)         log a message at the Notice level
+//	set_rate(qps)    change the send rate limit
+//	state            a mutable dict for keeping state across calls
+//	payload_length   the maximum payload length in bytes
+//
+// and may define the following functions, each of which is optional:
+//
+//	next_send()                   return (provider, recipient, payload, delay_ms)
+//	                              for the next packet, or None to end the run
+//	on_epoch(epoch)               called for every new PKI document
+//	on_probe(outcome, latency_ms) called for every completed probe
+type script struct {
+	sync.Mutex
+
+	s       *Session
+	thread  *starlark.Thread
+	globals starlark.StringDict
+}
+
+func newScript(s *Session, f string) (*script, error) {
+	sc := &script{s: s}
+	sc.thread = &starlark.Thread{
+		Name: f,
+		Print: func(_ *starlark.Thread, msg string) {
+			s.log.Noticef("script: %s", msg)
+		},
+	}
+	predeclared := starlark.StringDict{
+		"log":            starlark.NewBuiltin("log", sc.builtinLog),
+		"set_rate":       starlark.NewBuiltin("set_rate", sc.builtinSetRate),
+		"state":          starlark.NewDict(0),
+		"payload_length": starlark.MakeInt(s.config().Geometry.UserForwardPayloadLength),
+	}
+	var err error
+	if sc.globals, err = starlark.ExecFile(sc.thread, f, nil, predeclared); err != nil {
+		return nil, fmt.Errorf("failed to load script '%v': %v", f, err)
+	}
+	return sc, nil
+}
+
+func (sc *script) builtinLog(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+	sc.s.log.Noticef("script: %s", msg)
+	return starlark.None, nil
+}
+
+func (sc *script) builtinSetRate(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	qps, ok := starlark.AsFloat(v)
+	if !ok || qps < 0 {
+		return nil, fmt.Errorf("%s: invalid rate %v", b.Name(), v)
+	}
+	sc.s.SetRate(qps, 0)
+	return starlark.None, nil
+}
+
+// call calls the named script function, returning nil if the script
+// does not define it.
+func (sc *script) call(name string, args ...starlark.Value) (starlark.Value, error) {
+	fn, ok := sc.globals[name]
+	if !ok {
+		return nil, nil
+	}
+	sc.Lock()
+	defer sc.Unlock()
+	return starlark.Call(sc.thread, fn, starlark.Tuple(args), nil)
+}
+
+func (sc *script) onEpoch(epoch uint64) {
+	if _, err := sc.call(scriptOnEpoch, starlark.MakeUint64(epoch)); err != nil {
+		sc.s.log.Errorf("script: %s: %v", scriptOnEpoch, err)
+	}
+}
+
+func (sc *script) onProbe(p *report.Probe) {
+	latency := starlark.Float(float64(p.Latency) / float64(time.Millisecond))
+	if _, err := sc.call(scriptOnProbe, starlark.String(p.Outcome), latency); err != nil {
+		sc.s.log.Errorf("script: %s: %v", scriptOnProbe, err)
+	}
+}
+
+// scriptGenerator is a TrafficGenerator driven by the next_send function
+// of the scenario script.
+type scriptGenerator struct {
+	sc      *script
+	payload []byte
+}
+
+func newScriptGenerator(s *Session) (TrafficGenerator, error) {
+	if s.script == nil {
+		return nil, errors.New("the script traffic generator requires a Script block")
+	}
+	if _, ok := s.script.globals[scriptNextSend]; !ok {
+		return nil, fmt.Errorf("script does not define %s()", scriptNextSend)
+	}
+	return &scriptGenerator{
+		sc:      s.script,
+		payload: make([]byte, s.config().Geometry.UserForwardPayloadLength),
+	}, nil
+}
+
+func (g *scriptGenerator) NextSend() (*config.Target, []byte, time.Duration) {
+	target, delay, err := g.nextSend()
+	if err != nil {
+		g.sc.s.log.Errorf("script: %s: %v", scriptNextSend, err)
+		return nil, nil, 0
+	}
+	return target, g.payload, delay
+}
+
+func (g *scriptGenerator) nextSend() (*config.Target, time.Duration, error) {
+	v, err := g.sc.call(scriptNextSend)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v == starlark.None {
+		return nil, 0, nil
+	}
+	t, ok := v.(starlark.Tuple)
+	if !ok || len(t) != 4 {
+		return nil, 0, fmt.Errorf("expected a (provider, recipient, payload, delay_ms) tuple, got %v", v)
+	}
+	provider, ok := starlark.AsString(t[0])
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid provider %v", t[0])
+	}
+	recipient, ok := starlark.AsString(t[1])
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid recipient %v", t[1])
+	}
+	payload, ok := starlark.AsString(t[2])
+	if !ok || len(payload) > len(g.payload) {
+		return nil, 0, fmt.Errorf("invalid payload %v", t[2])
+	}
+	delayMs, ok := starlark.AsFloat(t[3])
+	if !ok || delayMs < 0 {
+		return nil, 0, fmt.Errorf("invalid delay %v", t[3])
+	}
+	n := copy(g.payload, payload)
+	for i := n; i < len(g.payload); i++ {
+		g.payload[i] = 0
+	}
+	target := &config.Target{
+		Provider:  provider,
+		Recipient: recipient,
+		Weight:    1,
+	}
+	return target, time.Duration(delayMs * float64(time.Millisecond)), nil
+}
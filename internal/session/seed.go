@@ -0,0 +1,40 @@
+// seed.go - seeded pseudo random number generators.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"hash/fnv"
+	mrand "math/rand"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/spray/config"
+)
+
+// newRand returns the pseudo random number generator of the named
+// stream of random choices.  With a Debug Seed, every stream of every
+// account is seeded from it, so that adding a stream does not change
+// the others, and otherwise it is seeded from the system entropy.
+func newRand(cfg *config.Config, stream string) *mrand.Rand {
+	if cfg.Debug.Seed == 0 {
+		return rand.NewMath()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(cfg.Account.Identifier()))
+	h.Write([]byte{0})
+	h.Write([]byte(stream))
+	return mrand.New(mrand.NewSource(cfg.Debug.Seed ^ int64(h.Sum64())))
+}
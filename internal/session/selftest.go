@@ -0,0 +1,118 @@
+// selftest.go - pre-run end-to-end self test
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/katzenpost/spray/report"
+)
+
+type selfTestResult struct {
+	provider string
+	sentAt   time.Time
+	eta      time.Duration
+	rtt      time.Duration
+	err      error
+}
+
+// selfTest sends a handful of SURB probes through the loop service and
+// verifies the echoed replies, returning an error if too few made it
+// back intact.
+func (s *Session) selfTest(ctx context.Context) error {
+	cfg := s.config().SelfTest
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	s.log.Noticef("Starting self test with %d probes.", cfg.Probes)
+	if err := s.waitForConnection(ctx); err != nil {
+		return fmt.Errorf("self test failure, never connected to Provider: %v", err)
+	}
+
+	results := make([]*selfTestResult, cfg.Probes)
+	waitCh := make(chan struct{}, cfg.Probes)
+	for i := range results {
+		results[i] = new(selfTestResult)
+		go func(r *selfTestResult) {
+			r.err = s.selfTestProbe(ctx, r)
+			waitCh <- struct{}{}
+		}(results[i])
+	}
+	for range results {
+		<-waitCh
+	}
+
+	replies := 0
+	for i, r := range results {
+		if r.err != nil {
+			s.log.Warningf("Self test probe %d via %s failed (ETA %v): %v", i, r.provider, r.eta, r.err)
+			continue
+		}
+		s.log.Infof("Self test probe %d via %s succeeded, RTT %v (ETA %v)", i, r.provider, r.rtt, r.eta)
+		replies++
+	}
+	if replies < cfg.MinReplies {
+		return fmt.Errorf("self test failure, %d/%d probe replies received intact, %d required", replies, cfg.Probes, cfg.MinReplies)
+	}
+	s.log.Noticef("Self test passed, %d/%d probe replies received intact.", replies, cfg.Probes)
+	return nil
+}
+
+func (s *Session) selfTestProbe(ctx context.Context, r *selfTestResult) error {
+	p := new(echoProbe)
+	service, err := s.GetService(p.Capability())
+	if err != nil {
+		return err
+	}
+	r.provider = service.Provider
+
+	payload, err := p.NewRequest()
+	if err != nil {
+		return err
+	}
+	reply, err := s.sendSURBProbe(service.Name, service.Provider, payload, report.PacketReal)
+	if err != nil {
+		return err
+	}
+	r.sentAt = reply.sentAt
+	r.eta = reply.eta
+	defer s.surbs.remove(&reply.id)
+
+	select {
+	case <-ctx.Done():
+		return errReplyTimeout
+	case <-s.HaltCh():
+		return errHalted
+	case b := <-reply.replyCh:
+		r.rtt = time.Since(reply.sentAt)
+		return p.ValidateResponse(payload, b)
+	}
+}
+
+func (s *Session) waitForConnection(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.HaltCh():
+		return errHalted
+	case <-s.connectedCh:
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// sequence.go - probe sequence tracking
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"github.com/katzenpost/spray/report"
+)
+
+// maxTrackedGaps bounds the number of missing sequence numbers kept to
+// tell late probes from duplicates.  Beyond it, the oldest gaps are
+// assumed lost for good, and probes filling them count as duplicates.
+const maxTrackedGaps = 1 << 16
+
+// seqTracker detects gaps, duplicates and reordering in the sequence
+// numbers of received probes, which senders number from zero.  It is
+// not safe for concurrent use.
+type seqTracker struct {
+	// next is one past the highest sequence number received.
+	next     uint64
+	missing  map[uint64]bool
+	untraced uint64
+
+	received   uint64
+	reordered  uint64
+	duplicates uint64
+}
+
+func newSeqTracker() *seqTracker {
+	return &seqTracker{
+		missing: make(map[uint64]bool),
+	}
+}
+
+func (t *seqTracker) record(seq uint64) {
+	switch {
+	case seq >= t.next:
+		for s := t.next; s < seq; s++ {
+			if len(t.missing) >= maxTrackedGaps {
+				t.untraced += seq - s
+				break
+			}
+			t.missing[s] = true
+		}
+		t.next = seq + 1
+		t.received++
+	case t.missing[seq]:
+		delete(t.missing, seq)
+		t.received++
+		t.reordered++
+	default:
+		t.duplicates++
+	}
+}
+
+func (t *seqTracker) report() *report.Sequence {
+	return &report.Sequence{
+		Expected:   t.next,
+		Received:   t.received,
+		Missing:    uint64(len(t.missing)) + t.untraced,
+		Reordered:  t.reordered,
+		Duplicates: t.duplicates,
+	}
+}
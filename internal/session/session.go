@@ -0,0 +1,820 @@
+// session.go - mixnet spray session
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.schwanenlied.me/yawning/kyber.git"
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/log"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/core/worker"
+	"github.com/katzenpost/minclient"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/event"
+	"github.com/katzenpost/spray/internal/pkiclient"
+	"github.com/katzenpost/spray/internal/shaper"
+	"github.com/katzenpost/spray/internal/tap"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+	"github.com/katzenpost/spray/topology"
+	"golang.org/x/time/rate"
+	"gopkg.in/op/go-logging.v1"
+)
+
+// sessionLogFile is the name of the per-session log file, in the
+// account's directory.
+const sessionLogFile = "session.log"
+
+// ServiceDescriptor describe a mixnet Provider-side service.
+type ServiceDescriptor struct {
+	// Name of the service.
+	Name string
+	// Provider name.
+	Provider string
+}
+
+// FindServices is a helper function for finding Provider-side services in the PKI document.
+func FindServices(capability string, doc *pki.Document) []ServiceDescriptor {
+	services := []ServiceDescriptor{}
+	for _, provider := range doc.Providers {
+		for cap := range provider.Kaetzchen {
+			if cap == capability {
+				serviceID := ServiceDescriptor{
+					Name:     provider.Kaetzchen[cap]["endpoint"].(string),
+					Provider: provider.Name,
+				}
+				services = append(services, serviceID)
+			}
+		}
+	}
+	return services
+}
+
+type workerOp interface{}
+
+// Session is the struct type that keeps state for a given session.
+type Session struct {
+	worker.Worker
+
+	// cfg is replaced by Reload, and must be read with config.
+	cfgLock   sync.RWMutex
+	cfg       *config.Config
+	pkiClient pki.Client
+	pkiFetch  *stats.Histogram
+	minclient MixClient
+	link      *switchableClient
+	linkCfg   *LinkConfig
+	log       *logging.Logger
+
+	// logBackend is the aggregate log backend, and sessionLog the
+	// per-session one, if any.
+	logBackend *log.Backend
+	sessionLog *log.Backend
+
+	fatalErrCh chan error
+	haltedCh   chan interface{}
+	haltOnce   sync.Once
+
+	linkKey     *ecdh.PrivateKey
+	kemKey      *kyber.PrivateKey
+	identityKey *ecdh.PrivateKey
+	opCh        chan workerOp
+	onlineAt    time.Time
+	hasPKIDoc   bool
+	lastDoc     *pki.Document
+	calibration *report.Calibration
+
+	// docGate holds back load generation while the document policy
+	// pauses it, and churnGate while Churn keeps the session offline.
+	docGate   pauseGate
+	churnGate pauseGate
+
+	// targets are the load targets, either configured or discovered,
+	// and nodes the node filter, if any.  traffic holds the targets and
+	// payload size of the default generator, which Reload may change.
+	targets []*config.Target
+	nodes   *nodeFilter
+	traffic trafficParams
+
+	// authorityClients are the PKI clients of the authority workers.
+	authorityClients []pki.Client
+
+	surbs         *surbTable
+	faults        *faultInjector
+	mailbox       *mailboxReceiver
+	drain         *drainStats
+	crossChecks   *crossChecks
+	results       *results
+	probeCodec    *probeCodec
+	pipeline      pipelineStats
+	counters      counters
+	pacing        *stats.Histogram
+	poisson       *poissonPacer
+	compose       *composeStats
+	pool          *packetPool
+	clock         *epochClock
+	capture       *capture
+	loops         *loopStats
+	emissions     *emissions
+	events        *event.Bus
+	pause         pauseGate
+	offline       offlineGate
+	recovery      recovery
+	doneCh        chan interface{}
+	doneOnce      sync.Once
+	limitCh       chan interface{}
+	limitStart    time.Time
+	script        *script
+	connectedCh   chan interface{}
+	onlineCh      chan interface{}
+	connectedOnce sync.Once
+	readyCh       chan struct{}
+	startErr      error
+
+	// connected is 1 while the session is connected to the Provider, and
+	// is accessed atomically.
+	connected uint32
+
+	limiter    *rate.Limiter
+	connChan   chan bool
+	cryptoChan chan []byte
+	egressChan chan []byte
+}
+
+// PKIClients are the PKI clients of a session.  Successive sessions may
+// share them so that the document cache survives from one to the next.
+type PKIClients struct {
+	// Lookup is the client used for the session's own lookups.
+	Lookup pki.Client
+
+	// Cache is the caching client used by the link protocol.
+	Cache *pkiclient.Client
+
+	// FetchLatency is the latency of the document fetches from the
+	// authority by both clients, cache misses only.
+	FetchLatency *stats.Histogram
+}
+
+// NewPKIClients creates the PKI clients for sessions with the given
+// configuration.
+func NewPKIClients(cfg *config.Config, logBackend *log.Backend) (*PKIClients, error) {
+	lookup, err := cfg.NewPKIClient(logBackend)
+	if err != nil {
+		return nil, err
+	}
+	impl, err := cfg.NewPKIClient(logBackend)
+	if err != nil {
+		return nil, err
+	}
+	latency := stats.NewHistogram()
+	return &PKIClients{
+		Lookup:       &timedPKIClient{Client: lookup, latency: latency},
+		Cache:        pkiclient.New(&timedPKIClient{Client: impl, latency: latency}),
+		FetchLatency: latency,
+	}, nil
+}
+
+// New establishes a session with provider using key, publishing its
+// events on bus, which may be nil, with the PKI clients pkiClients,
+// which may be nil for new ones.
+// This method will block until session is connected to the Provider.
+func New(ctx context.Context, fatalErrCh chan error, logBackend *log.Backend, cfg *config.Config, bus *event.Bus, pkiClients *PKIClients) (*Session, error) {
+	s, err := newSession(fatalErrCh, logBackend, cfg, bus, pkiClients)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.start(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewAsync is like New, but returns as soon as the session is set up,
+// without waiting for the Provider and the first PKI document.  The
+// session's Ready channel is closed once it is up or failed to come up,
+// after which Err returns the reason of the failure, if any.
+func NewAsync(ctx context.Context, fatalErrCh chan error, logBackend *log.Backend, cfg *config.Config, bus *event.Bus, pkiClients *PKIClients) (*Session, error) {
+	s, err := newSession(fatalErrCh, logBackend, cfg, bus, pkiClients)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := s.start(ctx); err != nil {
+			s.log.Errorf("Session failed to come up: %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// Shutdown halts the session and tears down its connection to the
+// Provider.
+func (s *Session) Shutdown() {
+	s.Halt()
+	s.minclient.Shutdown()
+}
+
+// abort tears down a session that failed to come up.  It halts the
+// workers, such as the wire tap's, shuts down the connection to the
+// Provider, if any, and closes the per-session log.
+func (s *Session) abort() {
+	s.Halt()
+	if s.minclient != nil {
+		s.minclient.Shutdown()
+	}
+	if s.sessionLog != nil {
+		s.log.SetBackend(s.logBackend)
+		if c, ok := interface{}(s.sessionLog).(io.Closer); ok {
+			c.Close()
+		}
+		s.sessionLog = nil
+	}
+}
+
+// Ready returns a channel that is closed once the session is up, or
+// failed to come up.
+func (s *Session) Ready() <-chan struct{} {
+	return s.readyCh
+}
+
+// Err returns the reason the session failed to come up, or nil.  It must
+// only be called once the Ready channel is closed.
+func (s *Session) Err() error {
+	return s.startErr
+}
+
+// newSession sets up a session up to the point of connecting to the
+// Provider.
+func newSession(fatalErrCh chan error, logBackend *log.Backend, cfg *config.Config, bus *event.Bus, pkiClients *PKIClients) (_ *Session, err error) {
+
+	// This is a no-op when the Spray already set the epoch period.
+	if err = SetEpochPeriod(cfg); err != nil {
+		return nil, err
+	}
+
+	// create a pkiclient for our own client lookups
+	// AND create a pkiclient for minclient's use
+	if pkiClients == nil {
+		if pkiClients, err = NewPKIClients(cfg, logBackend); err != nil {
+			return nil, err
+		}
+	}
+
+	clock := newEpochClock(epochtime.Period)
+
+	s := &Session{
+		cfg:         cfg,
+		pkiClient:   pkiClients.Lookup,
+		pkiFetch:    pkiClients.FetchLatency,
+		log:         logBackend.GetLogger(cfg.Account.Identifier() + "_c"),
+		logBackend:  logBackend,
+		fatalErrCh:  fatalErrCh,
+		opCh:        make(chan workerOp),
+		limiter:     rate.NewLimiter(rate.Limit(cfg.Debug.SendRate), cfg.Debug.SendBurst),
+		connChan:    make(chan bool),
+		surbs:       newSURBTable(),
+		faults:      newFaultInjector(cfg.FaultInjection, newRand(cfg, "faults")),
+		results:     newResults(clock),
+		loops:       newLoopStats(clock),
+		clock:       clock,
+		compose:     newComposeStats(),
+		pacing:      stats.NewHistogram(),
+		poisson:     newPoissonPacer(cfg.Debug.LambdaP, newRand(cfg, "poisson")),
+		events:      bus,
+		connectedCh: make(chan interface{}),
+		readyCh:     make(chan struct{}),
+		doneCh:      make(chan interface{}),
+		limitCh:     make(chan interface{}),
+		onlineCh:    make(chan interface{}, 1),
+		cryptoChan:  make(chan []byte), // XXX
+		egressChan:  make(chan []byte), // XXX
+	}
+	defer func() {
+		if err != nil {
+			s.abort()
+		}
+	}()
+	id := cfg.Account.Identifier()
+	basePath := filepath.Join(cfg.Proxy.DataDir, id)
+	if !cfg.Proxy.Ephemeral() {
+		if err := config.MkDataDir(basePath); err != nil {
+			return nil, err
+		}
+	}
+
+	// With per-session logs the session logs to both the aggregate log
+	// and its own log, and minclient to the session's log only.
+	clientLogBackend := logBackend
+	if cfg.Logging.PerSession && cfg.Proxy.Ephemeral() {
+		s.log.Warning("Per-session logs are disabled without a writable DataDir.")
+	} else if cfg.Logging.PerSession && !cfg.Logging.Disable {
+		sessionBackend, err := log.New(filepath.Join(basePath, sessionLogFile), cfg.Logging.Level, false)
+		if err != nil {
+			return nil, err
+		}
+		s.log = logging.MustGetLogger(s.log.Module)
+		s.log.SetBackend(logging.MultiLogger(logBackend, sessionBackend))
+		s.sessionLog = sessionBackend
+		clientLogBackend = sessionBackend
+	}
+
+	s.probeCodec = new(probeCodec)
+	if cfg.Debug.ProbeTagKey != "" {
+		if s.probeCodec.key, err = hex.DecodeString(cfg.Debug.ProbeTagKey); err != nil {
+			return nil, err
+		}
+	}
+	s.mailbox = newMailboxReceiver(s.probeCodec)
+	if cfg.Debug.Mode == config.ModeDrain {
+		s.drain = newDrainStats()
+	}
+	if cfg.Debug.Mode == config.ModeBurst {
+		s.pool = new(packetPool)
+	}
+	if cfg.Debug.Mode == config.ModeAuthority {
+		for i := 0; i < cfg.AuthorityLoad.Concurrency; i++ {
+			client, err := cfg.NewPKIClient(logBackend)
+			if err != nil {
+				return nil, err
+			}
+			s.authorityClients = append(s.authorityClients, client)
+		}
+	}
+	if cfg.Debug.Mode == config.ModeCrossCheck {
+		s.crossChecks = new(crossChecks)
+		if s.crossChecks.sources, err = cfg.NewPKISources(logBackend); err != nil {
+			return nil, err
+		}
+	}
+	if cCfg := cfg.Capture; cCfg != nil {
+		if s.capture, err = newCapture(cfg.OutputPath(cCfg.Dir), cCfg.Ciphertext); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Debug.Calibrate {
+		if s.calibration, err = Calibrate(cfg.Debug.SendRate, cfg.Debug.SendBurst); err != nil {
+			return nil, err
+		}
+		if s.calibration.Accurate() {
+			s.log.Noticef("Calibration: %v", s.calibration)
+		} else {
+			s.log.Warningf("Calibration: SendRate %v exceeds what this host can deliver accurately: %v", cfg.Debug.SendRate, s.calibration)
+		}
+	}
+
+	err = s.loadKeys(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Script != nil {
+		if s.script, err = newScript(s, cfg.DataPath(cfg.Script.File)); err != nil {
+			return nil, err
+		}
+	}
+	s.results.onProbe = s.onProbe
+	if rCfg := cfg.Report; rCfg != nil && rCfg.HeatmapInterval > 0 {
+		s.results.heatmapInterval = time.Duration(rCfg.HeatmapInterval) * time.Second
+		s.results.heatmap = report.NewHeatmap(s.results.startTime, s.results.heatmapInterval)
+	}
+	s.results.setHops(cfg.Geometry.NrHops)
+	if vCfg := cfg.Vantage; vCfg != nil {
+		s.results.vantage = &report.Vantage{
+			Region: vCfg.Region,
+			ASN:    vCfg.ASN,
+			Note:   vCfg.Note,
+		}
+		s.log.Noticef("Vantage point: %v", s.results.vantage)
+	}
+
+	// Configure and bring up the minclient instance.
+	clientCfg := &minclient.ClientConfig{
+		User:                cfg.Account.User,
+		Provider:            cfg.Account.Provider,
+		ProviderKeyPin:      cfg.Account.ProviderKeyPin,
+		LinkKey:             s.linkKey,
+		LogBackend:          clientLogBackend,
+		PKIClient:           pkiClients.Cache,
+		OnConnFn:            s.onConnection,
+		OnMessageFn:         s.onMessage,
+		OnACKFn:             s.onACK,
+		OnDocumentFn:        s.onDocument,
+		OnEmptyFn:           s.onEmpty,
+		DialContextFn:       nil,
+		MessagePollInterval: time.Duration(cfg.Debug.PollingInterval) * time.Second,
+		EnableTimeSync:      cfg.Debug.EnableTimeSync,
+	}
+
+	// Excluded nodes are removed from the documents minclient sees.
+	if cfg.Nodes != nil {
+		s.nodes = &nodeFilter{cfg: cfg.Nodes, provider: cfg.Account.Provider}
+		clientCfg.PKIClient = &filteredPKIClient{Client: pkiClients.Cache, filter: s.nodes}
+	}
+
+	// The wire tap sees the traffic as shaped.
+	if rCfg := cfg.Report; rCfg != nil && rCfg.WireFile != "" {
+		r, err := tap.NewRecorder(cfg.OutputPath(rCfg.WireFile))
+		if err != nil {
+			return nil, err
+		}
+		s.Go(func() {
+			<-s.HaltCh()
+			if err := r.Close(); err != nil {
+				s.log.Errorf("Failed to write wire traffic capture: %v", err)
+			}
+		})
+		d := &tap.Dialer{Recorder: r}
+		clientCfg.DialContextFn = d.DialContext
+	}
+	if sCfg := cfg.Shaper; sCfg != nil {
+		d := &shaper.Dialer{
+			Rate:          sCfg.Rate,
+			Burst:         sCfg.Burst,
+			Latency:       time.Duration(sCfg.Latency) * time.Millisecond,
+			Jitter:        time.Duration(sCfg.Jitter) * time.Millisecond,
+			DialContextFn: clientCfg.DialContextFn,
+		}
+		clientCfg.DialContextFn = d.DialContext
+	}
+
+	s.linkCfg = &LinkConfig{
+		ClientConfig: clientCfg,
+		KEMKey:       s.kemKey,
+		Geometry:     cfg.Geometry,
+		Mock:         cfg.Mock,
+	}
+	c, err := newMixClient(cfg.Debug.LinkProtocol, s.linkCfg)
+	if err != nil {
+		return nil, err
+	}
+	s.link = &switchableClient{c: c}
+	s.minclient = s.link
+	if cfg.Debug.ReplyHops != 0 {
+		if err = s.SetReplyHops(cfg.Debug.ReplyHops); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// start waits for the Provider and the first PKI document, and starts
+// the workers of the configured mode.  It closes the Ready channel once
+// done.
+func (s *Session) start(ctx context.Context) (err error) {
+	defer func() {
+		if err != nil {
+			s.abort()
+		}
+		s.startErr = err
+		close(s.readyCh)
+	}()
+	cfg := s.config()
+
+	// block until we get the first PKI document
+	// and then set our timers accordingly
+	s.lastDoc, err = s.awaitFirstPKIDoc(ctx)
+	if err != nil {
+		return err
+	}
+	s.syncEpochClock(s.lastDoc)
+	s.events.Publish(&event.DocumentEvent{At: time.Now(), Document: s.lastDoc})
+	s.updateModel(s.lastDoc)
+
+	s.Go(s.sessionWorker)
+	if cfg.Debug.ActivePollingInterval > 0 {
+		s.Go(s.pollWorker)
+	}
+	if cfg.SelfTest != nil {
+		if err = s.selfTest(ctx); err != nil {
+			s.log.Errorf("Aborting: %v", err)
+			return err
+		}
+	}
+	s.targets = cfg.Targets()
+	if cfg.Discovery != nil {
+		if s.targets, err = s.discoverTargets(ctx); err != nil {
+			s.log.Errorf("Aborting: %v", err)
+			return err
+		}
+	}
+	if s.nodes != nil {
+		if s.targets = s.nodes.filterTargets(s.lastDoc, s.targets); len(s.targets) == 0 {
+			err = errors.New("every target is on an excluded Provider")
+			s.log.Errorf("Aborting: %v", err)
+			return err
+		}
+	}
+	s.traffic.set(s.targets, cfg.Traffic.PayloadSize)
+	var gen TrafficGenerator
+	switch cfg.Debug.Mode {
+	case config.ModeFlood, config.ModeMailboxSender, config.ModeComposeOnly, config.ModeBurst, config.ModeMailproxy:
+		if gen, err = s.newTrafficGenerator(cfg.Debug.TrafficGenerator); err != nil {
+			return err
+		}
+	}
+	// The self test probes are not part of the run.
+	s.results.reset()
+	if rCfg := cfg.Report; rCfg != nil && (rCfg.EmissionsFile != "" || rCfg.CoverTrafficFile != "") {
+		s.emissions = newEmissions()
+		if rCfg.EmissionsFile != "" {
+			if err = s.emissions.openLog(cfg.OutputPath(rCfg.EmissionsFile)); err != nil {
+				return err
+			}
+			s.Go(func() {
+				<-s.HaltCh()
+				if err := s.emissions.close(); err != nil {
+					s.log.Errorf("Failed to write emission times: %v", err)
+				}
+			})
+		}
+	}
+	if rCfg := cfg.Report; rCfg != nil && (rCfg.VegetaFile != "" || rCfg.TimeSeriesFile != "") {
+		if rCfg.VegetaFile != "" {
+			err = s.results.openVegeta(cfg.OutputPath(rCfg.VegetaFile), rCfg.VegetaFormat, cfg.Debug.Mode)
+		}
+		if err == nil && rCfg.TimeSeriesFile != "" {
+			err = s.results.openTimeSeries(cfg.OutputPath(rCfg.TimeSeriesFile), rCfg.TimeSeriesFormat)
+		}
+		if err != nil {
+			s.results.close()
+			return err
+		}
+		s.Go(func() {
+			<-s.HaltCh()
+			if err := s.results.close(); err != nil {
+				s.log.Errorf("Failed to write per-probe results: %v", err)
+			}
+		})
+	}
+	if sCfg := cfg.Schedule; sCfg != nil {
+		if sCfg.AlignToEpochs {
+			s.Pause()
+		}
+		s.Go(s.scheduleWorker)
+	}
+	if cfg.Ramp != nil {
+		s.SetRate(cfg.Ramp.StartRate, 0)
+		s.Go(s.rampWorker)
+	}
+	if cfg.Traffic.Limited() {
+		s.limitStart = time.Now()
+		s.Go(s.runLimitWorker)
+		s.Go(s.progressWorker)
+	}
+	if cfg.Staircase != nil {
+		s.SetRate(cfg.Staircase.Step[0].SendRate, 0)
+		s.Go(s.staircaseWorker)
+	}
+	if cfg.Loop != nil {
+		s.Go(s.loopWorker)
+	}
+	if cfg.Churn != nil {
+		s.Go(s.churnWorker)
+	}
+	switch cfg.Debug.Mode {
+	case config.ModeMemspool:
+		s.Go(s.memspoolWorker)
+	case config.ModeKaetzchen:
+		s.Go(s.kaetzchenWorker)
+	case config.ModeMailboxReceiver:
+		s.Go(s.mailboxReceiverWorker)
+	case config.ModeDrain:
+		s.Go(s.drainWorker)
+	case config.ModeCrossCheck:
+		s.Go(s.crossCheckWorker)
+	case config.ModeAuthority:
+		s.startAuthorityWorkers()
+	case config.ModeComposeOnly:
+		s.Go(func() { s.composeOnlyWorker(gen) })
+	case config.ModeBurst:
+		s.Go(s.bottleneckWorker)
+		s.Go(func() { s.burstWorker(gen) })
+	default:
+		if cfg.ClosedLoop != nil {
+			s.startClosedLoop()
+			break
+		}
+		s.Go(s.sendWorker)
+		s.Go(s.bottleneckWorker)
+		src := &sendSource{gen: gen}
+		for i := 0; i < cfg.Debug.CryptoWorkers; i++ {
+			s.Go(func() { s.cryptoWorker(src) })
+		}
+	}
+	return nil
+}
+
+func (s *Session) awaitFirstPKIDoc(ctx context.Context) (*pki.Document, error) {
+	for {
+		var qo workerOp
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.HaltCh():
+			s.log.Debugf("Terminating gracefully.")
+			return nil, errors.New("Terminating gracefully.")
+		case <-time.After(time.Duration(s.config().Debug.InitialMaxPKIRetrievalDelay) * time.Second):
+			return nil, errors.New("Timeout failure awaiting first PKI document.")
+		case qo = <-s.opCh:
+		}
+		switch op := qo.(type) {
+		case opNewDocument:
+			// Determine if PKI doc is valid. If not then abort.
+			err := s.isDocValid(op.doc)
+			if err != nil {
+				s.log.Errorf("Aborting, PKI doc is not valid for the Loopix decoy traffic use case: %v", err)
+				return nil, fmt.Errorf("Aborting, PKI doc is not valid for the Loopix decoy traffic use case: %v", err)
+			}
+			return op.doc, nil
+		default:
+			continue
+		}
+	}
+}
+
+func (s *Session) loadKeys(basePath string) error {
+	// In the ephemeral mode keys missing from the DataDir are generated
+	// and kept in memory only, rather than saved.
+	loadLinkKey, loadLinkKEMKey, loadIdentityKey := config.LoadLinkKey, config.LoadLinkKEMKey, config.LoadIdentityKey
+	if s.config().Proxy.Ephemeral() {
+		loadLinkKey, loadLinkKEMKey, loadIdentityKey = config.EphemeralLinkKey, config.EphemeralLinkKEMKey, config.EphemeralIdentityKey
+	}
+
+	// Load link key.
+	var err error
+	if s.linkKey, err = loadLinkKey(basePath); err != nil {
+		s.log.Errorf("Failure to load link keys: %s", err)
+		return err
+	}
+	if s.config().Debug.LinkKeyType == config.LinkKeyX25519Kyber768 {
+		if s.kemKey, err = loadLinkKEMKey(basePath); err != nil {
+			s.log.Errorf("Failure to load Kyber768 link keys: %s", err)
+			return err
+		}
+	}
+	if s.config().Mailproxy != nil {
+		if s.identityKey, err = loadIdentityKey(basePath); err != nil {
+			s.log.Errorf("Failure to load identity keys: %s", err)
+			return err
+		}
+		s.log.Noticef("Mailproxy identity public key: %v", s.identityKey.PublicKey())
+	}
+	return nil
+}
+
+// onProbe is called for every probe recorded by the session.
+func (s *Session) onProbe(p *report.Probe) {
+	s.events.Publish(&event.ProbeEvent{Probe: p})
+	if s.script != nil {
+		s.script.onProbe(p)
+	}
+}
+
+// Config returns the session's configuration.
+func (s *Session) Config() *config.Config {
+	return s.config()
+}
+
+// config returns the configuration of the session.
+func (s *Session) config() *config.Config {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.cfg
+}
+
+// Document returns the current PKI document, or nil if there is none.
+func (s *Session) Document() *pki.Document {
+	return s.minclient.CurrentDocument()
+}
+
+// GetService returns a randomly selected service
+// matching the specified service name
+func (s *Session) GetService(serviceName string) (*ServiceDescriptor, error) {
+	doc := s.minclient.CurrentDocument()
+	if doc == nil {
+		return nil, errors.New("pki doc is nil")
+	}
+	serviceDescriptors := FindServices(serviceName, doc)
+	if len(serviceDescriptors) == 0 {
+		return nil, errors.New("GetService failure, service not found in pki doc.")
+	}
+	return &serviceDescriptors[mrand.Intn(len(serviceDescriptors))], nil
+}
+
+// ProviderDescriptor returns the descriptor of the named Provider, with
+// its keys, addresses and Kaetzchen parameters, from the current PKI
+// document.
+func (s *Session) ProviderDescriptor(name string) (*pki.MixDescriptor, error) {
+	doc := s.minclient.CurrentDocument()
+	if doc == nil {
+		return nil, errors.New("pki doc is nil")
+	}
+	return doc.GetProvider(name)
+}
+
+// WriteTopology renders the topology of the current PKI document to w
+// in the given format, either "dot" or "json".
+func (s *Session) WriteTopology(w io.Writer, format string) error {
+	doc := s.minclient.CurrentDocument()
+	if doc == nil {
+		return errors.New("pki doc is nil")
+	}
+	switch format {
+	case "dot":
+		return topology.WriteDOT(w, doc)
+	case "json":
+		return topology.WriteJSON(w, doc)
+	}
+	return fmt.Errorf("invalid topology format: %v", format)
+}
+
+// OnConnection will be called by the minclient api
+// upon connecting to the Provider
+func (s *Session) onConnection(err error) {
+	select {
+	case s.opCh <- opConnStatusChanged{isConnected: err == nil}:
+	case <-s.HaltCh():
+	}
+}
+
+// OnMessage will be called by the minclient api
+// upon receiving a message
+func (s *Session) onMessage(ciphertextBlock []byte) error {
+	s.log.Debugf("OnMessage")
+	atomic.AddUint64(&s.counters.received, 1)
+	s.captureReceived(captureMessage, "", ciphertextBlock, nil)
+	if s.drain != nil {
+		s.drain.onMessage(ciphertextBlock)
+	}
+	if s.config().Debug.Mode == config.ModeMailboxReceiver {
+		if raw, latency, err := s.mailbox.onMessage(ciphertextBlock, s.minclient.ClockSkew()); err == nil {
+			s.results.record(&report.Probe{
+				Timestamp:  time.Now().Add(-latency),
+				Latency:    latency,
+				RawLatency: raw,
+				BytesIn:    uint64(len(ciphertextBlock)),
+			}, nil)
+		}
+	}
+	return nil
+}
+
+// OnACK is called by the minclient api whe
+// we receive an ACK message
+func (s *Session) onACK(surbID *[constants.SURBIDLength]byte, ciphertext []byte) error {
+	idStr := fmt.Sprintf("[%v]", hex.EncodeToString(surbID[:]))
+	s.log.Infof("OnACK with SURBID %x", idStr)
+	atomic.AddUint64(&s.counters.acks, 1)
+	if delay := s.faults.ackDelay(); delay > 0 {
+		id := *surbID
+		ct := make([]byte, len(ciphertext))
+		copy(ct, ciphertext)
+		time.AfterFunc(delay, func() {
+			if err := s.onSURBReply(&id, ct); err != nil {
+				s.log.Debugf("Delayed ACK %v: %v", idStr, err)
+			}
+		})
+		return nil
+	}
+	return s.onSURBReply(surbID, ciphertext)
+}
+
+func (s *Session) onDocument(doc *pki.Document) {
+	s.log.Debugf("onDocument(): Epoch %v", doc.Epoch)
+	if s.faults.dropDocument() {
+		s.log.Debugf("Fault injection: dropping document for Epoch %v", doc.Epoch)
+		return
+	}
+	s.hasPKIDoc = true
+	select {
+	case s.opCh <- opNewDocument{doc: doc}:
+	case <-s.HaltCh():
+	}
+}
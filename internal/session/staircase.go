@@ -0,0 +1,49 @@
+// staircase.go - staircase load profile
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/katzenpost/spray/event"
+)
+
+// staircaseWorker runs the steps of the staircase load profile in order,
+// switching the send rate at every step boundary, and pausing load
+// generation and closing doneCh once they are all done.
+func (s *Session) staircaseWorker() {
+	steps := s.config().Staircase.Step
+	slowStart := time.Duration(s.config().Staircase.SlowStart) * time.Second
+	for i, st := range steps {
+		d := time.Duration(st.Duration) * time.Second
+		s.log.Noticef("Starting step %d/%d at %v packets per second for %v.", i+1, len(steps), st.SendRate, d)
+		s.events.Publish(&event.StepEvent{At: time.Now(), Index: i, SendRate: st.SendRate})
+		elapsed, ok := s.slowStart(i == 0, st.SendRate, slowStart)
+		if !ok {
+			return
+		}
+		s.SetRate(st.SendRate, 0)
+		if !s.sleep(d - elapsed) {
+			return
+		}
+		s.logResults(fmt.Sprintf("step %d", i+1))
+	}
+	s.Pause()
+	s.log.Notice("Staircase complete.")
+	s.finish()
+}
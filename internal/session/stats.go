@@ -0,0 +1,64 @@
+// stats.go - session statistics
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+// Stats are the live statistics of a session.
+type Stats struct {
+	Counters
+
+	// Outstanding is the number of SURBs awaiting a reply.
+	Outstanding int
+
+	// RTT is the round trip time of every SURB reply received so far,
+	// measured from sending the probe to its ACK, across all the modes
+	// and decoy loops that attach SURBs.
+	RTT *stats.Histogram
+
+	// PKIFetch is the latency of the PKI document fetches from the
+	// authority, shared by the sessions of a Spray.
+	PKIFetch *stats.Histogram
+
+	// Sequence is the sequence accounting of the stamped probes
+	// received, in the mailbox receiver mode.
+	Sequence *report.Sequence
+}
+
+// Stats returns a snapshot of the statistics of the session.
+func (s *Session) Stats() *Stats {
+	t := s.surbs
+	outstanding := t.outstanding()
+	rtt := stats.NewHistogram()
+	rtt.Merge(t.rtt)
+	pkiFetch := stats.NewHistogram()
+	pkiFetch.Merge(s.pkiFetch)
+	st := &Stats{
+		Counters:    *s.Counters(),
+		Outstanding: outstanding,
+		RTT:         rtt,
+		PKIFetch:    pkiFetch,
+	}
+	if s.config().Debug.Mode == config.ModeMailboxReceiver {
+		st.Sequence = s.mailbox.sequence()
+	}
+	return st
+}
@@ -0,0 +1,193 @@
+// surb.go - SURB reply tracking
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/stats"
+)
+
+var (
+	errReplyTimeout = errors.New("timed out awaiting reply")
+	errHalted       = errors.New("halted")
+)
+
+// surbReplyHeaderLength is the length of the flags header which
+// Providers prepend to Kaetzchen SURB reply payloads.
+const surbReplyHeaderLength = 2
+
+// pendingReply is an outstanding SURB reply.
+type pendingReply struct {
+	id        [constants.SURBIDLength]byte
+	seq       uint64
+	class     string
+	recipient string
+	provider  string
+	surbKey   []byte
+	sentAt    time.Time
+	eta       time.Duration
+	replyCh   chan []byte
+}
+
+// surbTable keeps track of the SURBs we are awaiting replies for, and
+// of the round trip time of every SURB reply received, whatever probe
+// it belongs to.
+type surbTable struct {
+	sync.Mutex
+
+	pending map[[constants.SURBIDLength]byte]*pendingReply
+	seq     uint64
+	rtt     *stats.Histogram
+
+	// idleCh is signaled whenever the table becomes empty or stops
+	// being empty.
+	idleCh chan struct{}
+}
+
+func newSURBTable() *surbTable {
+	return &surbTable{
+		pending: make(map[[constants.SURBIDLength]byte]*pendingReply),
+		rtt:     stats.NewHistogram(),
+		idleCh:  make(chan struct{}, 1),
+	}
+}
+
+// outstanding returns the number of SURBs awaiting a reply.
+func (t *surbTable) outstanding() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.pending)
+}
+
+func (t *surbTable) signalIdle() {
+	select {
+	case t.idleCh <- struct{}{}:
+	default:
+	}
+}
+
+func (t *surbTable) add(r *pendingReply) {
+	t.Lock()
+	defer t.Unlock()
+	r.seq = t.seq
+	t.seq++
+	t.pending[r.id] = r
+	if len(t.pending) == 1 {
+		t.signalIdle()
+	}
+}
+
+func (t *surbTable) remove(id *[constants.SURBIDLength]byte) *pendingReply {
+	t.Lock()
+	defer t.Unlock()
+	r, ok := t.pending[*id]
+	if !ok {
+		return nil
+	}
+	delete(t.pending, *id)
+	if len(t.pending) == 0 {
+		t.signalIdle()
+	}
+	return r
+}
+
+// sendSURBProbe composes and sends a packet carrying a SURB to the given
+// recipient and registers it in the SURB table.  The decrypted reply, minus
+// the reply header, will be written to the returned pendingReply's replyCh.
+func (s *Session) sendSURBProbe(recipient, provider string, payload []byte, class string) (*pendingReply, error) {
+	r := &pendingReply{
+		class:     class,
+		recipient: recipient,
+		provider:  provider,
+		replyCh:   make(chan []byte, 1),
+	}
+	if _, err := io.ReadFull(rand.Reader, r.id[:]); err != nil {
+		return nil, err
+	}
+	pkt, surbKey, eta, err := s.composeSphinxPacket(recipient, provider, &r.id, payload)
+	if err != nil {
+		return nil, err
+	}
+	r.surbKey = surbKey
+	r.eta = eta
+	r.sentAt = time.Now()
+
+	// Register the SURB before sending so that a fast reply can't race us.
+	s.surbs.add(r)
+	if err = s.sendPacket(pkt, class); err != nil {
+		s.surbs.remove(&r.id)
+		return nil, err
+	}
+	return r, nil
+}
+
+// roundTrip sends payload to the recipient along with a SURB and waits
+// for the reply, returning the reply payload and the probe result.  The
+// probe result is nil if nothing was sent.
+func (s *Session) roundTrip(recipient, provider string, payload []byte, class string, timeout time.Duration) ([]byte, *report.Probe, error) {
+	r, err := s.sendSURBProbe(recipient, provider, payload, class)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &report.Probe{
+		Timestamp: r.sentAt,
+		BytesOut:  uint64(len(payload)),
+		Provider:  provider,
+		Recipient: recipient,
+		SURBID:    hex.EncodeToString(r.id[:]),
+	}
+	select {
+	case <-time.After(timeout):
+		s.surbs.remove(&r.id)
+		p.Latency = timeout
+		return nil, p, errReplyTimeout
+	case <-s.HaltCh():
+		s.surbs.remove(&r.id)
+		return nil, p, errHalted
+	case b := <-r.replyCh:
+		p.Latency = time.Since(r.sentAt)
+		p.BytesIn = uint64(len(b))
+		return b, p, nil
+	}
+}
+
+// onSURBReply dispatches a SURB reply to whoever is waiting for it.
+func (s *Session) onSURBReply(id *[constants.SURBIDLength]byte, ciphertext []byte) error {
+	r := s.surbs.remove(id)
+	if r == nil {
+		return errors.New("no pending reply for SURB ID")
+	}
+	s.surbs.rtt.Record(time.Since(r.sentAt))
+	plaintext, err := s.link.decryptSURBPayload(ciphertext, r.surbKey)
+	s.captureReceived(captureReply, hex.EncodeToString(id[:]), plaintext, ciphertext)
+	if err != nil {
+		return err
+	}
+	if len(plaintext) < surbReplyHeaderLength {
+		return errors.New("truncated SURB reply")
+	}
+	r.replyCh <- plaintext[surbReplyHeaderLength:]
+	return nil
+}
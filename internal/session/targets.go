@@ -0,0 +1,83 @@
+// targets.go - weighted target selection
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	mrand "math/rand"
+	"sort"
+
+	"github.com/katzenpost/spray/config"
+)
+
+// targetPicker selects load targets at random according to their
+// weights.  It is not safe for concurrent use.
+type targetPicker struct {
+	targets []*config.Target
+	cumsum  []int
+	rng     *mrand.Rand
+}
+
+func newTargetPicker(targets []*config.Target, rng *mrand.Rand) *targetPicker {
+	p := &targetPicker{
+		targets: targets,
+		cumsum:  make([]int, len(targets)),
+		rng:     rng,
+	}
+	total := 0
+	for i, t := range targets {
+		total += t.Weight
+		p.cumsum[i] = total
+	}
+	return p
+}
+
+// gateTargets restricts the default generator to the targets whose
+// Provider is in the current PKI document, and with Discovery still
+// offers the directory service, and accounts for the others as skipped.
+// It returns the number of targets left.
+func (s *Session) gateTargets() int {
+	doc := s.minclient.CurrentDocument()
+	if doc == nil {
+		return 0
+	}
+	var providers map[string]bool
+	if dCfg := s.config().Discovery; dCfg != nil {
+		providers = make(map[string]bool)
+		for _, sd := range FindServices(dCfg.Capability, doc) {
+			providers[sd.Provider] = true
+		}
+	}
+	n, excluded := s.traffic.gate(func(t *config.Target) bool {
+		if _, err := doc.GetProvider(t.Provider); err != nil {
+			return false
+		}
+		return providers == nil || providers[t.Provider]
+	})
+	for _, t := range excluded {
+		s.log.Warningf("Skipping target %v@%v, its Provider is not in the PKI document of epoch %v.", t.Recipient, t.Provider, doc.Epoch)
+		s.results.skipTarget(t.Provider, t.Recipient)
+	}
+	return n
+}
+
+func (p *targetPicker) next() *config.Target {
+	if len(p.targets) == 1 {
+		return p.targets[0]
+	}
+	n := p.rng.Intn(p.cumsum[len(p.cumsum)-1])
+	return p.targets[sort.SearchInts(p.cumsum, n+1)]
+}
@@ -0,0 +1,318 @@
+// workers.go - mixnet client workers
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/event"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/topology"
+)
+
+type opIsEmpty struct{}
+
+type opConnStatusChanged struct {
+	isConnected bool
+}
+
+type opNewDocument struct {
+	doc *pki.Document
+}
+
+func (s *Session) isDocValid(doc *pki.Document) error {
+	const serviceLoop = "loop"
+	for _, provider := range doc.Providers {
+		_, ok := provider.Kaetzchen[serviceLoop]
+		if !ok {
+			return errors.New("Error, found a Provider which does not have the loop service.")
+		}
+	}
+	if hops := len(doc.Topology) + 2; hops != s.config().Geometry.NrHops {
+		return fmt.Errorf("Error, document has %d hops but the configured geometry has %d.", hops, s.config().Geometry.NrHops)
+	}
+	return nil
+}
+
+// checkDocument validates a document received after the first, and
+// applies the configured document policy if it is not valid.  Only the
+// sessionWorker may call it.
+func (s *Session) checkDocument(doc *pki.Document) {
+	err := s.isDocValid(doc)
+	if err == nil {
+		if s.docGate.resume() {
+			s.log.Noticef("PKI document for epoch %v is valid, resuming load generation.", doc.Epoch)
+		}
+		return
+	}
+	switch s.config().Debug.DocumentPolicy {
+	case config.DocumentPolicyPause:
+		s.log.Warningf("PKI document for epoch %v is not valid, pausing load generation: %v", doc.Epoch, err)
+		s.docGate.pause()
+	case config.DocumentPolicyAbort:
+		s.log.Errorf("Aborting, PKI document for epoch %v is not valid: %v", doc.Epoch, err)
+		select {
+		case s.fatalErrCh <- fmt.Errorf("Aborting, PKI document for epoch %v is not valid: %v", doc.Epoch, err):
+		case <-s.HaltCh():
+		}
+	default:
+		s.log.Warningf("PKI document for epoch %v is not valid: %v", doc.Epoch, err)
+	}
+}
+
+func (s *Session) connStatusChange(op opConnStatusChanged) bool {
+	isConnected := false
+	s.events.Publish(&event.ConnectionEvent{At: time.Now(), IsConnected: op.isConnected})
+	var connected uint32
+	if op.isConnected {
+		connected = 1
+	}
+	atomic.StoreUint32(&s.connected, connected)
+	if isConnected = op.isConnected; isConnected {
+		const skewWarnDelta = 2 * time.Minute
+		s.onlineAt = time.Now()
+		s.results.onConnected()
+		s.connectedOnce.Do(func() { close(s.connectedCh) })
+		select {
+		case s.onlineCh <- true:
+		default:
+		}
+
+		skew := s.minclient.ClockSkew()
+		absSkew := skew
+		if absSkew < 0 {
+			absSkew = -absSkew
+		}
+		s.results.onClockSkew(absSkew)
+		if max := time.Duration(s.config().Debug.MaxClockSkew) * time.Second; max > 0 && absSkew > max {
+			s.checkClockSkew(skew)
+		} else if absSkew > skewWarnDelta {
+			// Should this do more than just warn?  Should this
+			// use skewed time?  I don't know.
+			s.log.Warningf("The observed time difference between the host and provider clocks is '%v'. Correct your system time.", skew)
+		} else {
+			s.log.Debugf("Clock skew vs provider: %v", skew)
+		}
+	} else {
+		s.onConnectionLost()
+	}
+	return isConnected
+}
+
+// checkClockSkew applies the clock skew policy to a skew exceeding the
+// MaxClockSkew.  Only the sessionWorker may call it.
+func (s *Session) checkClockSkew(skew time.Duration) {
+	switch s.config().Debug.ClockSkewPolicy {
+	case config.ClockSkewPolicyAbort:
+		err := fmt.Errorf("Aborting, the clock skew versus the Provider of %v exceeds the MaxClockSkew.", skew)
+		s.log.Error(err.Error())
+		select {
+		case s.fatalErrCh <- err:
+		case <-s.HaltCh():
+		}
+	default:
+		s.results.onSkewExceeded()
+		s.log.Warningf("The clock skew versus the Provider of %v exceeds the MaxClockSkew, the latencies of the run are unreliable.", skew)
+	}
+}
+
+// onNewDocument is called by the sessionWorker for every new document,
+// and logs how it differs from the previous one.
+func (s *Session) onNewDocument(doc *pki.Document) {
+	prev := s.lastDoc
+	s.lastDoc = doc
+	if prev == nil || prev.Epoch == doc.Epoch {
+		return
+	}
+	s.checkDocument(doc)
+	s.syncEpochClock(doc)
+	s.updateModel(doc)
+	if s.script != nil {
+		s.script.onEpoch(doc.Epoch)
+	}
+	diff := topology.NewDiff(prev, doc)
+	s.events.Publish(&event.DocumentEvent{At: time.Now(), Document: doc, Diff: diff})
+	if diff.IsEmpty() {
+		s.log.Debugf("PKI document diff: %v", diff)
+		return
+	}
+	s.log.Noticef("PKI document diff: %v", diff)
+}
+
+func (s *Session) sessionWorker() {
+	for {
+		var qo workerOp
+		select {
+		case <-s.HaltCh():
+			s.log.Debugf("Terminating gracefully.")
+			return
+		case qo = <-s.opCh:
+		}
+		if qo != nil {
+			switch op := qo.(type) {
+			case opIsEmpty:
+				if s.drain != nil {
+					s.drain.onEmpty()
+				}
+			case opConnStatusChanged:
+				// Note: s.isConnected isn't used in favor of passing the
+				// value via an op, to save on locking headaches.
+				_ = s.connStatusChange(op)
+			case opNewDocument:
+				s.onNewDocument(op.doc)
+			default:
+				s.log.Warningf("BUG: Worker received nonsensical op: %T", op)
+			} // end of switch
+		} // if qo != nil
+	}
+	// NOTREACHED
+}
+
+func (s *Session) sendWorker() {
+	for {
+		select {
+		case packet := <-s.cryptoChan:
+			if !s.waitUnpaused() {
+				return
+			}
+			s.onSendPacket(packet)
+		case <-s.HaltCh():
+			s.log.Info("HaltCh received event, halting now.")
+			return
+		}
+	}
+}
+
+// sendSource serializes the calls of the cryptoWorkers to NextSend.
+type sendSource struct {
+	sync.Mutex
+
+	gen       TrafficGenerator
+	exhausted bool
+
+	// noDelay ignores the delays of the generator.
+	noDelay bool
+}
+
+// next returns the target and a copy of the payload of the next packet,
+// once its delay has elapsed, or a nil target if the generator is
+// exhausted or the session was halted.  The delay is waited for with the
+// lock held, so that the generator paces the packets as if there was a
+// single worker.
+func (src *sendSource) next(s *Session) (*config.Target, []byte) {
+	src.Lock()
+	defer src.Unlock()
+	if src.exhausted {
+		return nil, nil
+	}
+	target, payload, delay := src.gen.NextSend()
+	if target == nil {
+		src.exhausted = true
+		s.log.Notice("Traffic generator is exhausted.")
+		return nil, nil
+	}
+	if delay > 0 && !src.noDelay {
+		select {
+		case <-time.After(delay):
+		case <-s.HaltCh():
+			return nil, nil
+		}
+	}
+	return target, append([]byte(nil), payload...)
+}
+
+// cryptoWorker composes the packets of the traffic generator, in
+// parallel with the other CryptoWorkers, and hands them to the
+// sendWorker.
+func (s *Session) cryptoWorker(src *sendSource) {
+	for {
+		target, payload := src.next(s)
+		if target == nil {
+			return
+		}
+		composeStart := time.Now()
+		pkt, _, _, err := s.composeSphinxPacket(target.Recipient, target.Provider, nil, payload)
+		s.pipeline.onCompose(time.Since(composeStart))
+		if err == errInjectedComposeFailure {
+			s.log.Debugf("Fault injection: %v", err)
+			s.results.record(nil, err)
+			select {
+			case <-s.HaltCh():
+				return
+			default:
+				continue
+			}
+		}
+		if err != nil {
+			select {
+			case s.fatalErrCh <- err:
+			case <-s.HaltCh():
+			}
+			return
+		}
+		select {
+		case s.cryptoChan <- pkt:
+		case <-s.HaltCh():
+			s.log.Info("HaltCh received event, halting now.")
+			return
+		}
+	}
+}
+
+func (s *Session) composeSphinxPacket(recipient, provider string, surbID *[constants.SURBIDLength]byte, payload []byte) ([]byte, []byte, time.Duration, error) {
+	if err := s.faults.composeFailure(); err != nil {
+		return nil, nil, 0, err
+	}
+	return s.minclient.ComposeSphinxPacket(recipient, provider, surbID, payload)
+}
+
+func (s *Session) onSendPacket(packet []byte) {
+	// The packet is scheduled by the Poisson process if LambdaP is set,
+	// and by the rate limiter otherwise, and the deviation of the actual
+	// send time from the scheduled one is the pacing error.
+	scheduled := time.Now()
+	var delay time.Duration
+	if lambdaP := s.poisson.lambdaP(); lambdaP > 0 {
+		scheduled = s.poisson.schedule(scheduled, lambdaP)
+		delay = time.Until(scheduled)
+	} else if r := s.limiter.Reserve(); r.OK() {
+		delay = r.Delay()
+		scheduled = scheduled.Add(delay)
+	}
+	if delay > 0 {
+		s.counters.onLimiterWait(delay)
+		select {
+		case <-time.After(delay):
+		case <-s.HaltCh():
+			return
+		}
+	}
+	sendStart := time.Now()
+	if err := s.sendPacket(packet, report.PacketReal); err != nil {
+		s.log.Warningf("SendSphinxPacket failure: %s", err)
+		return
+	}
+	s.pipeline.onSend(time.Since(sendStart))
+	s.pacing.Record(sendStart.Sub(scheduled))
+}
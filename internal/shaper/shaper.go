@@ -0,0 +1,160 @@
+// shaper.go - Link shaping dialer.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package shaper implements a dialer emulating a slow or high latency
+// link, without requiring external traffic control setup.
+package shaper
+
+import (
+	"context"
+	"errors"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"golang.org/x/time/rate"
+)
+
+var errClosed = errors.New("shaper: connection closed")
+
+// Dialer dials shaped connections.
+type Dialer struct {
+	// Rate is the bandwidth limit in bytes per second in each direction,
+	// zero means unlimited.
+	Rate int
+
+	// Burst is the number of bytes that may be sent or received at once
+	// in excess of Rate.
+	Burst int
+
+	// Latency is the delay added to every write.
+	Latency time.Duration
+
+	// Jitter is the maximum random deviation from Latency.
+	Jitter time.Duration
+
+	// DialContextFn is the underlying dial function, by default that of
+	// a net.Dialer.
+	DialContextFn func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialContext dials address and returns a shaped connection.  It has the
+// signature expected by minclient's DialContextFn.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := d.DialContextFn
+	if dial == nil {
+		var nd net.Dialer
+		dial = nd.DialContext
+	}
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := &shapedConn{
+		Conn:    conn,
+		d:       d,
+		rng:     rand.NewMath(),
+		closeCh: make(chan interface{}),
+	}
+	if d.Rate > 0 {
+		c.readLimiter = rate.NewLimiter(rate.Limit(d.Rate), d.Burst)
+		c.writeLimiter = rate.NewLimiter(rate.Limit(d.Rate), d.Burst)
+	}
+	return c, nil
+}
+
+// shapedConn is a net.Conn with rate limited reads and writes, and
+// delayed writes.
+type shapedConn struct {
+	net.Conn
+
+	d            *Dialer
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+
+	rngLock sync.Mutex
+	rng     *mrand.Rand
+
+	closeOnce sync.Once
+	closeCh   chan interface{}
+}
+
+func (c *shapedConn) wait(l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for n > 0 {
+		chunk := n
+		if chunk > c.d.Burst {
+			chunk = c.d.Burst
+		}
+		if err := l.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+func (c *shapedConn) delay() time.Duration {
+	d := c.d.Latency
+	if c.d.Jitter > 0 {
+		c.rngLock.Lock()
+		d += time.Duration(c.rng.Int63n(int64(2*c.d.Jitter)+1)) - c.d.Jitter
+		c.rngLock.Unlock()
+	}
+	return d
+}
+
+func (c *shapedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if wErr := c.wait(c.readLimiter, n); wErr != nil && err == nil {
+			err = wErr
+		}
+	}
+	return n, err
+}
+
+func (c *shapedConn) Write(b []byte) (int, error) {
+	if d := c.delay(); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-c.closeCh:
+			return 0, errClosed
+		}
+	}
+	if err := c.wait(c.writeLimiter, len(b)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *shapedConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closeCh) })
+	return c.Conn.Close()
+}
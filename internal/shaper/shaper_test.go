@@ -0,0 +1,93 @@
+// shaper_test.go - Link shaping dialer tests.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shaper
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialPipe returns a Dialer over d, which dials one end of a pipe, and
+// the other end.
+func dialPipe(t *testing.T, d *Dialer) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	d.DialContextFn = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return client, nil
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "provider:29483")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, server
+}
+
+func TestLatency(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	conn, server := dialPipe(t, &Dialer{Latency: latency})
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	start := time.Now()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Fatalf("write took %v, less than the latency of %v", elapsed, latency)
+	}
+}
+
+func TestRate(t *testing.T) {
+	const rate, burst, size = 1000, 100, 400
+	conn, server := dialPipe(t, &Dialer{Rate: rate, Burst: burst})
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	// The burst goes out at once, the rest at the rate.
+	min := time.Duration(size-burst) * time.Second / rate
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < min*9/10 {
+		t.Fatalf("writing %d bytes took %v, less than the %v the rate allows", size, elapsed, min)
+	}
+}
+
+func TestCloseInterruptsWrite(t *testing.T) {
+	conn, server := dialPipe(t, &Dialer{Latency: time.Hour})
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("hello"))
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-errCh:
+		if err != errClosed {
+			t.Fatalf("Write returned %v instead of %v", err, errClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not interrupt a delayed write")
+	}
+}
@@ -0,0 +1,269 @@
+// control.go - UNIX domain socket control interface
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/internal/session"
+	"github.com/katzenpost/spray/stats"
+)
+
+const controlHelp = "commands: stats, inflight, rate <qps> [burst], lambdap <lambda>, pause, resume, shutdown, help"
+
+// controlStats is the reply to the stats control command.
+type controlStats struct {
+	Mode     string
+	Duration string
+	Paused   bool
+	Rate     float64
+	Burst    int
+	LambdaP  float64
+	Sent     uint64
+	Outcomes map[string]uint64
+	P50      string
+	P90      string
+	P99      string
+	P999     string
+
+	// Outstanding, RTTP50 and RTTP99 describe the SURB round trips.
+	Outstanding int
+	RTTP50      string
+	RTTP99      string
+
+	// PKIFetchP50 and PKIFetchP99 describe the PKI document fetches.
+	PKIFetchP50 string `json:",omitempty"`
+	PKIFetchP99 string `json:",omitempty"`
+
+	// Complete and ETA are the progress of a run limited by a
+	// RunDuration or MaxPackets.
+	Complete float64 `json:",omitempty"`
+	ETA      string  `json:",omitempty"`
+}
+
+// controlServer serves a simple line protocol on a UNIX domain socket.
+// Every request is a single line holding a command and its arguments,
+// and is answered by a single line, either "ok", a JSON object, or
+// "error: " followed by the error message.
+type controlServer struct {
+	sync.Mutex
+
+	c     *Spray
+	l     net.Listener
+	conns map[net.Conn]bool
+}
+
+func newControlServer(c *Spray, f string) (*controlServer, error) {
+	// Remove a stale socket left behind by an unclean shutdown.
+	if fi, err := os.Stat(f); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(f)
+	}
+	l, err := net.Listen("unix", f)
+	if err != nil {
+		return nil, err
+	}
+	// Windows does not apply the mode bits, the socket inherits the
+	// access control list of its directory instead.
+	if !config.IsWindows {
+		if err = os.Chmod(f, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	s := &controlServer{
+		c:     c,
+		l:     l,
+		conns: make(map[net.Conn]bool),
+	}
+	go s.acceptWorker()
+	return s, nil
+}
+
+func (s *controlServer) acceptWorker() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		s.Lock()
+		if s.conns == nil {
+			s.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.Unlock()
+		go s.connWorker(conn)
+	}
+}
+
+func (s *controlServer) connWorker(conn net.Conn) {
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var resp string
+		var err error
+		s.c.runLock.Lock()
+		if s.c.running || fields[0] == "shutdown" {
+			resp, err = s.c.handleControl(fields[0], fields[1:])
+		} else {
+			err = errors.New("no run in progress")
+		}
+		s.c.runLock.Unlock()
+		if err != nil {
+			resp = "error: " + err.Error()
+		}
+		if _, err = fmt.Fprintln(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// handleControl executes a control command of the current run.  With
+// several accounts, the commands apply to the session of every account,
+// and the rate to each of them.  The caller must hold runLock.
+func (c *Spray) handleControl(cmd string, args []string) (string, error) {
+	sessions := c.sessions
+	switch cmd {
+	case "stats":
+		return c.controlStats()
+	case "inflight":
+		var probes []*session.InFlightProbe
+		for _, sess := range sessions {
+			probes = append(probes, sess.InFlight()...)
+		}
+		b, err := json.Marshal(probes)
+		return string(b), err
+	case "rate":
+		if len(args) < 1 || len(args) > 2 {
+			return "", fmt.Errorf("usage: rate <qps> [burst]")
+		}
+		qps, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || qps < 0 {
+			return "", fmt.Errorf("invalid rate '%v'", args[0])
+		}
+		burst := 0
+		if len(args) == 2 {
+			if burst, err = strconv.Atoi(args[1]); err != nil || burst <= 0 {
+				return "", fmt.Errorf("invalid burst '%v'", args[1])
+			}
+		}
+		for _, sess := range sessions {
+			sess.SetRate(qps, burst)
+		}
+	case "lambdap":
+		if len(args) != 1 {
+			return "", fmt.Errorf("usage: lambdap <lambda>")
+		}
+		lambdaP, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || lambdaP < 0 || math.IsInf(lambdaP, 0) || math.IsNaN(lambdaP) {
+			return "", fmt.Errorf("invalid LambdaP '%v'", args[0])
+		}
+		for _, sess := range sessions {
+			sess.SetLambdaP(lambdaP)
+		}
+	case "pause":
+		for _, sess := range sessions {
+			sess.Pause()
+		}
+	case "resume":
+		for _, sess := range sessions {
+			sess.Resume()
+		}
+	case "shutdown":
+		c.log.Notice("Shutdown requested on the control interface.")
+		go c.Shutdown()
+	case "help":
+		return controlHelp, nil
+	default:
+		return "", fmt.Errorf("unknown command '%v', %v", cmd, controlHelp)
+	}
+	return "ok", nil
+}
+
+func (c *Spray) controlStats() (string, error) {
+	sess := c.session
+	r := c.runReport()
+	qps, burst := sess.Rate()
+	outstanding, rtt := 0, stats.NewHistogram()
+	for _, sess := range c.sessions {
+		ss := sess.Stats()
+		outstanding += ss.Outstanding
+		rtt.Merge(ss.RTT)
+	}
+	st := &controlStats{
+		Mode:     r.Mode,
+		Duration: r.Duration.Round(time.Millisecond).String(),
+		Paused:   sess.IsPaused(),
+		Rate:     qps,
+		Burst:    burst,
+		LambdaP:  sess.LambdaP(),
+		Sent:     r.Sent,
+		Outcomes: r.Outcomes,
+		P50:      r.Latency.Percentile(50).String(),
+		P90:      r.Latency.Percentile(90).String(),
+		P99:      r.Latency.Percentile(99).String(),
+		P999:     r.Latency.Percentile(99.9).String(),
+
+		Outstanding: outstanding,
+		RTTP50:      rtt.Percentile(50).String(),
+		RTTP99:      rtt.Percentile(99).String(),
+	}
+	if f := r.PKIFetch; f != nil {
+		st.PKIFetchP50 = f.Percentile(50).String()
+		st.PKIFetchP99 = f.Percentile(99).String()
+	}
+	if p := sess.Progress(); p != nil {
+		st.Complete = p.Complete
+		st.ETA = p.ETA.Round(time.Second).String()
+	}
+	b, err := json.Marshal(st)
+	return string(b), err
+}
+
+func (s *controlServer) close() {
+	s.l.Close()
+	s.Lock()
+	defer s.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
@@ -0,0 +1,160 @@
+// fleet.go - run many independent clients in one process
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+)
+
+// Fleet is a set of independent Spray clients, each with its own
+// configuration, keys, session and statistics, run within one process.
+type Fleet struct {
+	names   []string
+	clients []*Spray
+}
+
+// NewFleet loads every ".toml" configuration file in dir, in lexical
+// order, and creates a Spray for each of them.
+func NewFleet(dir string) (*Fleet, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	f := new(Fleet)
+	epochPeriod := -1
+	for _, fi := range fis {
+		if fi.IsDir() || strings.ToLower(filepath.Ext(fi.Name())) != ".toml" {
+			continue
+		}
+		cfg, err := config.LoadFile(filepath.Join(dir, fi.Name()), false)
+		if err != nil {
+			f.Shutdown()
+			return nil, fmt.Errorf("fleet: %v: %v", fi.Name(), err)
+		}
+		// The epoch period is process wide.
+		if epochPeriod >= 0 && cfg.Debug.EpochPeriod != epochPeriod {
+			f.Shutdown()
+			return nil, fmt.Errorf("fleet: %v: Debug EpochPeriod differs from the other configurations", fi.Name())
+		}
+		epochPeriod = cfg.Debug.EpochPeriod
+		c, err := New(cfg)
+		if err != nil {
+			f.Shutdown()
+			return nil, fmt.Errorf("fleet: %v: %v", fi.Name(), err)
+		}
+		if c == nil {
+			// Key generation only.
+			continue
+		}
+		f.names = append(f.names, strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name())))
+		f.clients = append(f.clients, c)
+	}
+	if len(f.clients) == 0 {
+		return nil, fmt.Errorf("fleet: no configuration files in '%v'", dir)
+	}
+	return f, nil
+}
+
+// Start starts the sessions of all clients concurrently.  Clients that
+// fail to start are shut down and reported in the returned error, the
+// others keep running.
+func (f *Fleet) Start() error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(f.clients))
+	for i, c := range f.clients {
+		wg.Add(1)
+		go func(i int, c *Spray) {
+			defer wg.Done()
+			if _, err := c.Start(); err != nil {
+				errs[i] = err
+				c.Shutdown()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", f.names[i], err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("fleet: %d of %d clients failed to start: %v", len(failed), len(f.clients), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Shutdown shuts down all clients.
+func (f *Fleet) Shutdown() {
+	for _, c := range f.clients {
+		c.Shutdown()
+	}
+}
+
+// Wait waits till every client is terminated.
+func (f *Fleet) Wait() {
+	for _, c := range f.clients {
+		c.Wait()
+	}
+}
+
+// RunReports returns the run report of every client that started, keyed
+// by the name of its configuration file, and their aggregate.
+func (f *Fleet) RunReports() (map[string]*report.Run, *report.Run) {
+	reports := make(map[string]*report.Run)
+	var runs []*report.Run
+	for i, c := range f.clients {
+		if r := c.RunReport(); r != nil {
+			reports[f.names[i]] = r
+			runs = append(runs, r)
+		}
+	}
+	return reports, report.Merge(runs...)
+}
+
+// Summary returns a human readable per-client and aggregate summary of
+// the fleet's run.
+func (f *Fleet) Summary() string {
+	reports, agg := f.RunReports()
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	line := func(name string, r *report.Run) {
+		fmt.Fprintf(&b, "%s: %d sent, %d ok, %d lost, %d corrupt, %d failed, latency p50 %v p99 %v\n",
+			name, r.Sent, r.Outcomes[report.OutcomeOK], r.Outcomes[report.OutcomeLost],
+			r.Outcomes[report.OutcomeCorrupt], r.Outcomes[report.OutcomeFailed],
+			r.Latency.Percentile(50), r.Latency.Percentile(99))
+	}
+	for _, name := range names {
+		line(name, reports[name])
+	}
+	line(fmt.Sprintf("aggregate of %d clients", len(reports)), agg)
+	return b.String()
+}
@@ -0,0 +1,115 @@
+// httpcontrol.go - HTTP control interface
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// httpControlMethods are the HTTP methods of the commands of the HTTP
+// control interface, by command.
+var httpControlMethods = map[string]string{
+	"stats":    http.MethodGet,
+	"inflight": http.MethodGet,
+	"rate":     http.MethodPost,
+	"lambdap":  http.MethodPost,
+	"pause":    http.MethodPost,
+	"resume":   http.MethodPost,
+	"start":    http.MethodPost,
+	"stop":     http.MethodPost,
+	"shutdown": http.MethodPost,
+}
+
+// httpControlServer serves the control commands over HTTP, one path per
+// command, such as "POST /rate?qps=10&burst=5" or "GET /stats".  Unlike
+// the control socket it lives as long as the Spray, so that runs may be
+// started and stopped with "POST /start" and "POST /stop".
+type httpControlServer struct {
+	c   *Spray
+	srv *http.Server
+}
+
+func newHTTPControlServer(c *Spray, addr string) (*httpControlServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &httpControlServer{c: c}
+	s.srv = &http.Server{Handler: s}
+	go s.srv.Serve(l)
+	return s, nil
+}
+
+func (s *httpControlServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cmd := strings.Trim(r.URL.Path, "/")
+	method, ok := httpControlMethods[cmd]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown command '%v'", cmd), http.StatusNotFound)
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, fmt.Sprintf("%v requires %v", cmd, method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var args []string
+	switch cmd {
+	case "start":
+		if _, err := s.c.Start(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+		return
+	case "stop":
+		s.c.Stop()
+		fmt.Fprintln(w, "ok")
+		return
+	case "rate":
+		args = append(args, r.FormValue("qps"))
+		if burst := r.FormValue("burst"); burst != "" {
+			args = append(args, burst)
+		}
+	case "lambdap":
+		args = append(args, r.FormValue("lambda"))
+	}
+
+	s.c.runLock.Lock()
+	if !s.c.running && cmd != "shutdown" {
+		s.c.runLock.Unlock()
+		http.Error(w, "no run in progress", http.StatusConflict)
+		return
+	}
+	resp, err := s.c.handleControl(cmd, args)
+	s.c.runLock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	fmt.Fprintln(w, resp)
+}
+
+func (s *httpControlServer) close() {
+	s.srv.Close()
+}
@@ -0,0 +1,89 @@
+// metrics.go - Prometheus metrics exporter
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/katzenpost/spray/internal/session"
+)
+
+// metricsServer exposes the counters of the current session in the
+// Prometheus text exposition format.  The counters restart from zero
+// with every run, which Prometheus handles as a counter reset.
+type metricsServer struct {
+	c   *Spray
+	l   net.Listener
+	srv *http.Server
+}
+
+func newMetricsServer(c *Spray, addr, path string) (*metricsServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &metricsServer{
+		c: c,
+		l: l,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, m)
+	m.srv = &http.Server{Handler: mux}
+	go m.srv.Serve(l)
+	return m, nil
+}
+
+// counters returns the sum of the counters of the current sessions.  The
+// caller must hold runLock.
+func (c *Spray) counters() *session.Counters {
+	cnt := new(session.Counters)
+	for _, sess := range c.sessions {
+		sc := sess.Counters()
+		cnt.Sent += sc.Sent
+		cnt.SendFailures += sc.SendFailures
+		cnt.Received += sc.Received
+		cnt.ACKs += sc.ACKs
+		cnt.LimiterWaits += sc.LimiterWaits
+		cnt.LimiterWait += sc.LimiterWait
+	}
+	return cnt
+}
+
+func (m *metricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.c.runLock.Lock()
+	cnt := m.c.counters()
+	m.c.runLock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	metric := func(name, help string, v interface{}) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, v)
+	}
+	metric("spray_packets_sent_total", "Number of packets sent.", cnt.Sent)
+	metric("spray_send_failures_total", "Number of packets that failed to be sent.", cnt.SendFailures)
+	metric("spray_messages_received_total", "Number of messages received from the spool.", cnt.Received)
+	metric("spray_surb_acks_total", "Number of SURB replies received.", cnt.ACKs)
+	metric("spray_limiter_waits_total", "Number of times a packet waited for the rate limiter.", cnt.LimiterWaits)
+	metric("spray_limiter_wait_seconds_total", "Total time spent waiting for the rate limiter.", cnt.LimiterWait.Seconds())
+	bw.Flush()
+}
+
+func (m *metricsServer) close() {
+	m.srv.Close()
+}
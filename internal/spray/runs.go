@@ -0,0 +1,108 @@
+// runs.go - per-run directories
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+)
+
+// runDirTimeFormat is the format of the start time prefixing the names
+// of the run directories.
+const runDirTimeFormat = "20060102T150405Z"
+
+// newRunDir prunes the RunsDir according to the retention options, and
+// creates the directory of the run about to start, with an output sink
+// streaming its events into it.  The caller must hold runLock.
+func (c *Spray) newRunDir(rCfg *config.Report) error {
+	dir := c.cfg.DataPath(rCfg.RunsDir)
+	if err := config.MkDataDir(dir); err != nil {
+		return err
+	}
+	c.pruneRunDirs(dir, rCfg.RunsKeep, time.Duration(rCfg.RunsMaxAge)*24*time.Hour)
+
+	// The run ID is that of the run about to start.
+	runID := fmt.Sprintf("run-%d", c.runs+1)
+	runDir := filepath.Join(dir, time.Now().UTC().Format(runDirTimeFormat)+"-"+runID)
+	if err := config.MkDataDir(runDir); err != nil {
+		return err
+	}
+	sink, err := newNDJSONFileSink(filepath.Join(runDir, runEventsFile))
+	if err != nil {
+		os.RemoveAll(runDir)
+		return err
+	}
+	c.sinks = append(c.sinks, &outputSink{name: runEventsFile, sink: sink, perRun: true})
+	c.runDir, c.cfg.RunDir = runDir, runDir
+	return nil
+}
+
+// removeRunDir removes the directory of a run that failed to start.
+func (c *Spray) removeRunDir() {
+	if c.runDir == "" {
+		return
+	}
+	if err := os.RemoveAll(c.runDir); err != nil {
+		c.log.Warningf("Failed to remove the run directory: %v", err)
+	}
+	c.runDir, c.cfg.RunDir = "", ""
+}
+
+// pruneRunDirs removes the run directories of dir older than maxAge,
+// and all but the keep-1 most recent ones, making room for a new one.
+// Zero keep and maxAge disable the respective limit.
+func (c *Spray) pruneRunDirs(dir string, keep int, maxAge time.Duration) {
+	if keep == 0 && maxAge == 0 {
+		return
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		c.log.Warningf("Failed to list the run directories: %v", err)
+		return
+	}
+	type runDir struct {
+		name  string
+		start time.Time
+	}
+	var runs []runDir
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.IsDir() || len(name) <= len(runDirTimeFormat) {
+			continue
+		}
+		start, err := time.Parse(runDirTimeFormat, name[:len(runDirTimeFormat)])
+		if err != nil {
+			continue
+		}
+		runs = append(runs, runDir{name, start})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].start.After(runs[j].start) })
+	for i, r := range runs {
+		if (keep > 0 && i >= keep-1) || (maxAge > 0 && time.Since(r.start) > maxAge) {
+			c.log.Noticef("Removing run directory %v.", r.name)
+			if err := os.RemoveAll(filepath.Join(dir, r.name)); err != nil {
+				c.log.Warningf("Failed to remove run directory %v: %v", r.name, err)
+			}
+		}
+	}
+}
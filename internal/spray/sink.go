@@ -0,0 +1,305 @@
+// sink.go - output sink plugins
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/event"
+	"github.com/katzenpost/spray/report"
+)
+
+// sinkBufSize is the number of events buffered for every output sink.
+// Events are dropped for sinks that do not keep up.
+const sinkBufSize = 4096
+
+// OutputSink is a result backend, such as a database or a message
+// queue, written to by every run as it progresses.  An OutputSink
+// outlives the runs of a Spray, and its methods are never called
+// concurrently.
+type OutputSink interface {
+	// Start is called when the run with the given ID starts.
+	Start(runID string) error
+
+	// WriteEvent writes an event of the run, one of the event package's
+	// *Event types.
+	WriteEvent(e event.Event) error
+
+	// WriteSummary writes the summary of the run, once it has ended and
+	// all its events were written.
+	WriteSummary(s *report.Summary) error
+
+	// Close flushes and closes the sink when the Spray is shut down.
+	Close() error
+}
+
+// OutputSinkFactory constructs an OutputSink from the Options of its
+// Sink block.
+type OutputSinkFactory func(cfg *config.Config, options map[string]string) (OutputSink, error)
+
+// NDJSONOutputSink is the name of the stock output sink, which appends a
+// JSON record per event and per run summary to the file named by its
+// "File" option, relative to the DataDir.
+const NDJSONOutputSink = "ndjson"
+
+var (
+	outputSinksLock sync.Mutex
+	outputSinks     = map[string]OutputSinkFactory{
+		NDJSONOutputSink: newNDJSONSink,
+	}
+)
+
+// RegisterOutputSink registers an output sink by name, making it
+// selectable with a Sink block of the configuration.
+func RegisterOutputSink(name string, factory OutputSinkFactory) {
+	outputSinksLock.Lock()
+	defer outputSinksLock.Unlock()
+	outputSinks[name] = factory
+}
+
+func newOutputSink(cfg *config.Config, sCfg *config.Sink) (OutputSink, error) {
+	outputSinksLock.Lock()
+	factory, ok := outputSinks[sCfg.Name]
+	outputSinksLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown output sink: %v", sCfg.Name)
+	}
+	return factory(cfg, sCfg.Options)
+}
+
+// ndjsonRecord is a record of the ndjson output sink.  DocumentEvents
+// are recorded with their epoch only.
+type ndjsonRecord struct {
+	Type  string
+	Run   string
+	At    time.Time   `json:",omitempty"`
+	Epoch uint64      `json:",omitempty"`
+	Event interface{} `json:",omitempty"`
+}
+
+type ndjsonSink struct {
+	f     *os.File
+	bw    *bufio.Writer
+	enc   *json.Encoder
+	runID string
+}
+
+func newNDJSONSink(cfg *config.Config, options map[string]string) (OutputSink, error) {
+	name := options["File"]
+	if name == "" {
+		return nil, errors.New("ndjson output sink: File is missing")
+	}
+	return newNDJSONFileSink(cfg.DataPath(name))
+}
+
+// newNDJSONFileSink returns an ndjson output sink appending to the named
+// file.
+func newNDJSONFileSink(name string) (*ndjsonSink, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &ndjsonSink{
+		f:  f,
+		bw: bufio.NewWriter(f),
+	}
+	s.enc = json.NewEncoder(s.bw)
+	return s, nil
+}
+
+func (s *ndjsonSink) Start(runID string) error {
+	s.runID = runID
+	return s.enc.Encode(&ndjsonRecord{Type: "start", Run: runID, At: time.Now()})
+}
+
+func (s *ndjsonSink) WriteEvent(e event.Event) error {
+	r := &ndjsonRecord{Run: s.runID, At: e.Time(), Event: e}
+	switch e := e.(type) {
+	case *event.ConnectionEvent:
+		r.Type = "connection"
+	case *event.DocumentEvent:
+		r.Type = "document"
+		r.Epoch = e.Document.Epoch
+		r.Event = nil
+	case *event.SentEvent:
+		r.Type = "sent"
+	case *event.PhaseEvent:
+		r.Type = "phase"
+	case *event.StepEvent:
+		r.Type = "step"
+	case *event.ProbeEvent:
+		r.Type = "probe"
+		r.Event = e.Probe
+	default:
+		return nil
+	}
+	return s.enc.Encode(r)
+}
+
+func (s *ndjsonSink) WriteSummary(summary *report.Summary) error {
+	if err := s.enc.Encode(&ndjsonRecord{Type: "summary", Run: s.runID, At: time.Now(), Event: summary}); err != nil {
+		return err
+	}
+	return s.bw.Flush()
+}
+
+func (s *ndjsonSink) Close() error {
+	err := s.bw.Flush()
+	if cErr := s.f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// outputSink is a configured OutputSink, with the subscription feeding
+// it the events of the current run.
+type outputSink struct {
+	sync.WaitGroup
+
+	name string
+	sink OutputSink
+	sub  *event.Subscription
+
+	// perRun is set for the sinks of a single run, such as the events
+	// of the run's directory, which are closed when it ends.
+	perRun bool
+}
+
+// newOutputSinks constructs the output sinks of the configuration.
+func (c *Spray) newOutputSinks() error {
+	for _, sCfg := range c.cfg.Sink {
+		sink, err := newOutputSink(c.cfg, sCfg)
+		if err != nil {
+			c.closeOutputSinks()
+			return err
+		}
+		c.sinks = append(c.sinks, &outputSink{name: sCfg.Name, sink: sink})
+	}
+	return nil
+}
+
+// subscribeOutputSinks subscribes every output sink to the events of the
+// run about to start, so that the events of establishing its sessions
+// are not missed.  The caller must hold runLock.
+func (c *Spray) subscribeOutputSinks() {
+	for _, s := range c.sinks {
+		s.sub = c.events.Subscribe(sinkBufSize)
+	}
+}
+
+// unsubscribeOutputSinks stops feeding the output sinks the events of a
+// run that failed to start.  The caller must hold runLock.
+func (c *Spray) unsubscribeOutputSinks() {
+	for _, s := range c.sinks {
+		if s.sub != nil {
+			s.sub.Close()
+			s.Wait()
+			s.sub = nil
+		}
+	}
+	c.closeRunOutputSinks()
+}
+
+// closeRunOutputSinks closes and removes the output sinks of the run.
+func (c *Spray) closeRunOutputSinks() {
+	sinks := c.sinks[:0]
+	for _, s := range c.sinks {
+		if !s.perRun {
+			sinks = append(sinks, s)
+			continue
+		}
+		if err := s.sink.Close(); err != nil {
+			c.log.Errorf("Failed to close output sink '%v': %v", s.name, err)
+		}
+	}
+	c.sinks = sinks
+}
+
+// startOutputSinks starts the current run on every output sink, and
+// feeds them its events.  The caller must hold runLock.
+func (c *Spray) startOutputSinks() {
+	for _, s := range c.sinks {
+		if err := s.sink.Start(c.runID()); err != nil {
+			c.log.Errorf("Failed to start output sink '%v': %v", s.name, err)
+			s.sub.Close()
+			s.sub = nil
+			continue
+		}
+		s.Add(1)
+		go func(s *outputSink, sub *event.Subscription) {
+			defer s.Done()
+			var failed bool
+			for e := range sub.C() {
+				if err := s.sink.WriteEvent(e); err != nil && !failed {
+					c.log.Errorf("Failed to write events to output sink '%v': %v", s.name, err)
+					failed = true
+				}
+			}
+		}(s, s.sub)
+	}
+}
+
+// stopOutputSinks writes the summary of the current run to every output
+// sink, once they were fed all its events.  The caller must hold
+// runLock.
+func (c *Spray) stopOutputSinks() {
+	if len(c.sinks) == 0 {
+		return
+	}
+	r := c.runReport()
+	r.ID = c.runID()
+	if rCfg := c.cfg.Report; rCfg != nil {
+		r.Labels = rCfg.Labels
+	}
+	cfg, err := json.Marshal(c.runCfg)
+	if err != nil {
+		cfg = nil
+	}
+	summary := report.NewSummary(r, c.verdict, cfg)
+	for _, s := range c.sinks {
+		if s.sub == nil {
+			continue
+		}
+		s.sub.Close()
+		s.Wait()
+		if dropped := s.sub.Dropped(); dropped > 0 {
+			c.log.Warningf("Output sink '%v' dropped %v events.", s.name, dropped)
+		}
+		s.sub = nil
+		if err := s.sink.WriteSummary(summary); err != nil {
+			c.log.Errorf("Failed to write the run summary to output sink '%v': %v", s.name, err)
+		}
+	}
+	c.closeRunOutputSinks()
+}
+
+// closeOutputSinks closes every output sink.
+func (c *Spray) closeOutputSinks() {
+	for _, s := range c.sinks {
+		if err := s.sink.Close(); err != nil {
+			c.log.Errorf("Failed to close output sink '%v': %v", s.name, err)
+		}
+	}
+	c.sinks = nil
+}
@@ -0,0 +1,826 @@
+// spray.go - Katzenpost client for load testing.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/log"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/event"
+	"github.com/katzenpost/spray/internal/session"
+	"github.com/katzenpost/spray/report"
+	"github.com/katzenpost/spray/topology"
+	"gopkg.in/op/go-logging.v1"
+)
+
+// The files of a run's directory.
+const (
+	manifestFile  = "manifest.json"
+	runEventsFile = "events.ndjson"
+	runReportFile = "report.json"
+)
+
+type Spray struct {
+	cfg        *config.Config
+	logBackend *log.Backend
+	log        *logging.Logger
+	fatalErrCh chan error
+	haltedCh   chan interface{}
+	haltOnce   *sync.Once
+	events     *event.Bus
+
+	// runLock serializes starting and stopping runs.
+	runLock    sync.Mutex
+	running    bool
+	runs       int
+	runDir     string
+	pkiClients *session.PKIClients
+
+	// runCfg is the configuration of the current or last run, which
+	// differs from cfg in the seed of a sweep run.
+	runCfg *config.Config
+
+	// session is the session of the first account, and sessions those
+	// of every account.
+	session  *session.Session
+	sessions []*session.Session
+	control  *controlServer
+	metrics  *metricsServer
+	statsd   *statsdSink
+	sinks    []*outputSink
+	verdict  *report.Verdict
+
+	// httpControl is the HTTP control interface, which outlives runs.
+	httpControl *httpControlServer
+
+	// embedded is set for a Spray wrapped by a Client, which ends its
+	// runs itself.
+	embedded bool
+
+	// onFatalErr is the OnFatalError callback, and fatalErr the error
+	// the Spray shut down due to, if any.
+	fatalErrLock sync.Mutex
+	onFatalErr   func(error)
+	fatalErr     error
+}
+
+func (c *Spray) initLogging() error {
+	f := c.cfg.Logging.File
+	if !c.cfg.Logging.Disable && c.cfg.Logging.File != "" {
+		f = c.cfg.DataPath(f)
+	}
+	// Without a writable DataDir only absolute log file paths can work,
+	// log to stdout otherwise.
+	if c.cfg.Proxy.Ephemeral() && !filepath.IsAbs(filepath.FromSlash(c.cfg.Logging.File)) {
+		f = ""
+	}
+
+	var err error
+	c.logBackend, err = log.New(f, c.cfg.Logging.Level, c.cfg.Logging.Disable)
+	if err == nil {
+		c.log = c.logBackend.GetLogger("katzenpost/client")
+	}
+	return err
+}
+
+// GetLogger returns a new logger with the given name.
+func (c *Spray) GetLogger(name string) *logging.Logger {
+	return c.logBackend.GetLogger(name)
+}
+
+// Events returns the event bus of the Spray, which embedders may
+// subscribe to before calling Start so as not to miss any events.
+func (c *Spray) Events() *event.Bus {
+	return c.events
+}
+
+// Shutdown cleanly shuts down a given Spray instance.
+func (c *Spray) Shutdown() {
+	c.haltOnce.Do(func() { c.halt() })
+}
+
+// Wait waits till the Spray is terminated for any reason.
+func (c *Spray) Wait() {
+	<-c.haltedCh
+}
+
+// HaltCh returns a channel that is closed once the Spray is terminated.
+func (c *Spray) HaltCh() <-chan interface{} {
+	return c.haltedCh
+}
+
+// Embed marks the Spray as wrapped by a Client, which ends its runs
+// itself.  It must be called before Start.
+func (c *Spray) Embed() {
+	c.embedded = true
+}
+
+func (c *Spray) halt() {
+	c.log.Noticef("Starting graceful shutdown.")
+	c.runLock.Lock()
+	c.stopRun()
+	c.runLock.Unlock()
+	if c.metrics != nil {
+		c.metrics.close()
+	}
+	if c.statsd != nil {
+		c.statsd.close()
+	}
+	c.closeOutputSinks()
+	if c.httpControl != nil {
+		c.httpControl.close()
+	}
+	if c.pkiClients != nil {
+		c.pkiClients.Cache.Halt()
+	}
+	c.events.Close()
+	close(c.haltedCh)
+}
+
+// logModel logs what limited the achieved send rate, and how the
+// observed latency compares with the latency model of every epoch,
+// flagging the epochs that diverge.
+func (c *Spray) logModel() {
+	r := c.runReport()
+	if r.Bottleneck != nil {
+		c.log.Noticef("Send pipeline: %v", r.Bottleneck)
+	}
+	if r.Pacing != nil {
+		c.log.Noticef("Send pacing: %v", r.Pacing)
+	}
+	if r.Sequence != nil {
+		c.log.Noticef("Probe sequence: %v", r.Sequence)
+	}
+	if r.Pool != nil {
+		c.log.Noticef("Packet pool: %v", r.Pool)
+	}
+	if l := r.LossBursts; l != nil && l.Losses > 0 {
+		c.log.Noticef("Loss pattern: %v", l)
+	}
+	for _, t := range r.Targets {
+		c.log.Noticef("Per target: %v", t)
+	}
+	for _, e := range r.Epochs {
+		c.log.Noticef("Per epoch: %v", e)
+	}
+	for _, g := range r.Generations {
+		c.log.Noticef("Per connection %v", g)
+	}
+	for _, h := range r.Hops {
+		c.log.Noticef("Latency by path length: %v", h)
+	}
+	for _, m := range r.Model {
+		if m.Diverges {
+			c.log.Warningf("Latency diverges from the model: %v", m)
+		} else {
+			c.log.Noticef("Latency matches the model: %v", m)
+		}
+	}
+}
+
+// Stop ends the current measurement run and writes its reports, without
+// tearing down the Spray, so that another run may be started with Start.
+func (c *Spray) Stop() {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	c.stopRun()
+}
+
+// stopRun ends the current run, if any.  The caller must hold runLock.
+func (c *Spray) stopRun() {
+	if !c.running {
+		return
+	}
+	c.running = false
+	if c.control != nil {
+		c.control.close()
+		c.control = nil
+	}
+	for _, sess := range c.sessions {
+		sess.Shutdown()
+	}
+	c.logModel()
+	c.writeReports()
+	c.stopOutputSinks()
+	c.log.Noticef("Run %v ended.", c.runID())
+}
+
+// runID returns the ID of the current or last run.
+func (c *Spray) runID() string {
+	return fmt.Sprintf("run-%d", c.runs)
+}
+
+// runPath returns the path of the report file f of the current run.  The
+// reports go into the run's directory if there is one, and otherwise
+// those of the runs after the first have the run ID appended.
+func (c *Spray) runPath(f string) string {
+	if c.runDir != "" {
+		if f = filepath.FromSlash(f); filepath.IsAbs(f) {
+			return f
+		}
+		return filepath.Join(c.runDir, f)
+	}
+	f = c.cfg.DataPath(f)
+	if c.runs <= 1 {
+		return f
+	}
+	ext := filepath.Ext(f)
+	return strings.TrimSuffix(f, ext) + "-" + c.runID() + ext
+}
+
+func (c *Spray) writeReports() {
+	rCfg := c.cfg.Report
+	if rCfg == nil {
+		return
+	}
+	r := c.runReport()
+	r.ID = c.runID()
+	r.Labels = rCfg.Labels
+	if rCfg.FortioFile != "" {
+		if err := report.WriteFile(c.runPath(rCfg.FortioFile), r, report.WriteFortio); err != nil {
+			c.log.Errorf("Failed to write Fortio results: %v", err)
+		}
+	}
+	if rCfg.HdrHistogramFile != "" {
+		if err := report.WriteFile(c.runPath(rCfg.HdrHistogramFile), r, report.WriteHdrHistogram); err != nil {
+			c.log.Errorf("Failed to write HdrHistogram results: %v", err)
+		}
+	}
+	if rCfg.HeatmapFile != "" {
+		if err := report.WriteFile(c.runPath(rCfg.HeatmapFile), r, report.WriteHeatmap); err != nil {
+			c.log.Errorf("Failed to write latency heatmap: %v", err)
+		}
+	}
+	if rCfg.CoverTrafficFile != "" {
+		if err := c.writeCoverTraffic(c.runPath(rCfg.CoverTrafficFile)); err != nil {
+			c.log.Errorf("Failed to write cover traffic analysis: %v", err)
+		}
+	}
+	if rCfg.SLO != "" {
+		slo, err := report.ParseSLO(rCfg.SLO)
+		if err != nil {
+			c.log.Errorf("Failed to parse SLO: %v", err)
+			return
+		}
+		c.verdict = slo.Evaluate(r)
+		c.log.Notice(c.verdict.String())
+	}
+	if rCfg.SummaryFile != "" {
+		if err := c.writeSummary(c.runPath(rCfg.SummaryFile), r); err != nil {
+			c.log.Errorf("Failed to write the run summary: %v", err)
+		}
+	}
+	if c.runDir != "" {
+		if err := report.WriteFile(filepath.Join(c.runDir, runReportFile), r, report.WriteJSON); err != nil {
+			c.log.Errorf("Failed to write the run report: %v", err)
+		}
+	}
+	if rCfg.HTMLFile != "" {
+		writeHTML := func(w io.Writer, r *report.Run) error {
+			return report.WriteHTML(w, r, c.verdict)
+		}
+		if err := report.WriteFile(c.runPath(rCfg.HTMLFile), r, writeHTML); err != nil {
+			c.log.Errorf("Failed to write the HTML report: %v", err)
+		}
+	}
+}
+
+// writeManifest writes the run manifest into the directory of the
+// current run.
+func (c *Spray) writeManifest() error {
+	m := &report.Manifest{
+		RunID:     c.runID(),
+		StartTime: c.runReport().StartTime,
+		Version:   report.Version(),
+		Host:      report.NewHost(),
+	}
+	if lCfg := c.cfg.Logging; !lCfg.Disable && lCfg.File != "" {
+		m.LogFile = c.cfg.DataPath(lCfg.File)
+	}
+	for _, sess := range c.sessions {
+		doc := sess.Document()
+		if doc == nil {
+			continue
+		}
+		digest, err := topology.Digest(doc)
+		if err != nil {
+			return err
+		}
+		m.Document = &report.ManifestDocument{Epoch: doc.Epoch, Digest: digest}
+		break
+	}
+	var err error
+	if m.Config, err = json.Marshal(c.runCfg); err != nil {
+		c.log.Warningf("Failed to snapshot the configuration for the run manifest: %v", err)
+		m.Config = nil
+	}
+	out, err := os.OpenFile(filepath.Join(c.runDir, manifestFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteManifest(out, m); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeSummary writes the JSON summary of the run r, including the SLO
+// verdict and a snapshot of the configuration.
+func (c *Spray) writeSummary(f string, r *report.Run) error {
+	cfg, err := json.Marshal(c.runCfg)
+	if err != nil {
+		c.log.Warningf("Failed to snapshot the configuration for the run summary: %v", err)
+		cfg = nil
+	}
+	s := report.NewSummary(r, c.verdict, cfg)
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteSummary(out, s); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runReport returns the report of the session, or the aggregate of the
+// reports of the sessions of every account.
+func (c *Spray) runReport() *report.Run {
+	if len(c.sessions) == 1 {
+		return c.session.RunReport()
+	}
+	runs := make([]*report.Run, 0, len(c.sessions))
+	for _, sess := range c.sessions {
+		runs = append(runs, sess.RunReport())
+	}
+	return report.Merge(runs...)
+}
+
+// Sessions returns a copy of the sessions of the current or last run,
+// one per account.
+func (c *Spray) Sessions() []*session.Session {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	return append([]*session.Session(nil), c.sessions...)
+}
+
+// Status is a snapshot of the current or last run.
+type Status struct {
+	// Running is true while a run is in progress.
+	Running bool
+
+	// Report is the report of the run, nil if no run was started.
+	Report *report.Run
+
+	// Progress is the progress of the first account's session towards
+	// the limits of the run, nil if the run is not limited.
+	Progress *session.Progress
+
+	// Outstanding is the number of SURBs awaiting a reply.
+	Outstanding int
+}
+
+// Status returns a snapshot of the current run, or of the last one if
+// none is in progress.
+func (c *Spray) Status() *Status {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	st := &Status{Running: c.running}
+	if c.session == nil {
+		return st
+	}
+	st.Report = c.runReport()
+	st.Progress = c.session.Progress()
+	for _, sess := range c.sessions {
+		st.Outstanding += sess.Stats().Outstanding
+	}
+	return st
+}
+
+// RunReport returns the report of the measurements made so far, or nil
+// if the session was never started.  With several accounts it is the
+// aggregate of the reports of their sessions.
+func (c *Spray) RunReport() *report.Run {
+	if c.session == nil {
+		return nil
+	}
+	r := c.runReport()
+	r.ID = c.runID()
+	if rCfg := c.cfg.Report; rCfg != nil {
+		r.Labels = rCfg.Labels
+	}
+	return r
+}
+
+// SLOVerdict returns the verdict of the configured SLO for the last run,
+// or nil if no SLO is configured.  It must only be called after Stop or
+// Wait returns.  Pipelines should treat a verdict that did not pass as a
+// failure.
+func (c *Spray) SLOVerdict() *report.Verdict {
+	return c.verdict
+}
+
+// Sweep executes the runs of the Sweep configuration one after the other,
+// with successive seeds, and returns their aggregate, which is also
+// logged and written to the Report SweepFile.  Every run writes its own
+// reports.
+func (c *Spray) Sweep() (*report.Sweep, error) {
+	sCfg := c.cfg.Sweep
+	if sCfg == nil {
+		return nil, errors.New("spray: no Sweep block was present")
+	}
+	var seeds []int64
+	var runs []*report.Run
+	for i := 0; i < sCfg.Runs; i++ {
+		seed := sCfg.Seed + int64(i)
+		sess, err := c.start(&seed)
+		if err != nil {
+			return nil, err
+		}
+		c.log.Noticef("Sweep run %d of %d, seed %d.", i+1, sCfg.Runs, seed)
+		var expired <-chan time.Time
+		if sCfg.Duration > 0 {
+			expired = time.After(time.Duration(sCfg.Duration) * time.Second)
+		}
+		select {
+		case <-expired:
+		case <-sess.Done():
+		case <-c.haltedCh:
+			return nil, errors.New("spray: halted during the sweep")
+		}
+		c.Stop()
+		seeds = append(seeds, seed)
+		runs = append(runs, c.RunReport())
+	}
+
+	sw := report.NewSweep(seeds, runs)
+	c.log.Notice(sw.String())
+	if rCfg := c.cfg.Report; rCfg != nil && rCfg.SweepFile != "" {
+		if err := c.writeSweep(c.cfg.DataPath(rCfg.SweepFile), sw); err != nil {
+			c.log.Errorf("Failed to write the sweep results: %v", err)
+		}
+	}
+	return sw, nil
+}
+
+func (c *Spray) writeSweep(f string, sw *report.Sweep) error {
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteSweep(out, sw); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Reload applies the traffic parameters of cfg, a reloaded configuration,
+// to the sessions of the current run, matching them by account, see
+// Session.Reload.  Sessions of accounts missing from cfg are left alone,
+// and new accounts, like every other change, take a restart.
+func (c *Spray) Reload(cfg *config.Config) error {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	if !c.running {
+		return errors.New("spray: no run in progress")
+	}
+	accounts := make(map[string]*config.Account)
+	for _, a := range cfg.Accounts {
+		accounts[a.Identifier()] = a
+	}
+	var err error
+	for _, sess := range c.sessions {
+		id := sess.Config().Account.Identifier()
+		a, ok := accounts[id]
+		if !ok {
+			c.log.Warningf("Account %v is no longer configured, restart to remove it.", id)
+			continue
+		}
+		if sErr := sess.Reload(cfg.ForAccount(a)); sErr != nil {
+			c.log.Errorf("Failed to reload account %v: %v", id, sErr)
+			if err == nil {
+				err = sErr
+			}
+		}
+	}
+	return err
+}
+
+// Start starts a new measurement run with a new session per account,
+// and returns the session of the first account.  A Spray may execute
+// several successive runs, each ended by Stop, which share the logging
+// and the PKI document cache.
+func (c *Spray) Start() (*session.Session, error) {
+	return c.start(nil)
+}
+
+// start starts a new measurement run like Start, with the Debug Seed
+// overridden by seed if set, for a run of a sweep.
+func (c *Spray) start(seed *int64) (*session.Session, error) {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	if c.running {
+		return nil, errors.New("spray: a run is already in progress")
+	}
+
+	var err error
+	if c.pkiClients == nil {
+		if c.pkiClients, err = session.NewPKIClients(c.cfg, c.logBackend); err != nil {
+			return nil, err
+		}
+	}
+	c.runDir, c.cfg.RunDir = "", ""
+	if rCfg := c.cfg.Report; rCfg != nil && rCfg.RunsDir != "" && c.cfg.Proxy.Ephemeral() {
+		c.log.Warning("Not creating a run directory without a writable DataDir.")
+	} else if rCfg != nil && rCfg.RunsDir != "" {
+		if err := c.newRunDir(rCfg); err != nil {
+			c.log.Errorf("Failed to create the run directory: %v", err)
+			return nil, err
+		}
+	}
+	cfg := c.cfg
+	if seed != nil {
+		cfg = c.cfg.WithSeed(*seed)
+	}
+	c.subscribeOutputSinks()
+	sessions, err := c.newSessions(cfg)
+	if err != nil {
+		c.unsubscribeOutputSinks()
+		c.removeRunDir()
+		return nil, err
+	}
+	sess := sessions[0]
+	c.session, c.sessions, c.runCfg = sess, sessions, cfg
+	c.running = true
+	c.runs++
+	c.log.Noticef("Run %v started.", c.runID())
+	c.startOutputSinks()
+	if c.runDir != "" {
+		if err := c.writeManifest(); err != nil {
+			c.log.Errorf("Failed to write the run manifest: %v", err)
+		}
+	}
+	if rCfg := c.cfg.Report; rCfg != nil && rCfg.TopologyFile != "" {
+		if err := c.writeTopology(c.runPath(rCfg.TopologyFile)); err != nil {
+			c.log.Errorf("Failed to write topology: %v", err)
+		}
+	}
+	// A sweep and a Client end their runs themselves.
+	if (c.cfg.Schedule != nil || c.cfg.Ramp != nil || c.cfg.Staircase != nil || c.cfg.Traffic.Limited() || c.cfg.Debug.Mode == config.ModeDrain) && c.cfg.Sweep == nil && !c.embedded {
+		go func() {
+			select {
+			case <-sess.Done():
+				c.Shutdown()
+			case <-sess.HaltCh():
+			case <-c.haltedCh:
+			}
+		}()
+	}
+	if cCfg := c.cfg.Control; cCfg != nil && cCfg.Socket != "" {
+		if c.control, err = newControlServer(c, c.cfg.DataPath(cCfg.Socket)); err != nil {
+			c.log.Errorf("Failed to start the control socket: %v", err)
+			c.running = false
+			for _, sess := range sessions {
+				sess.Shutdown()
+			}
+			c.unsubscribeOutputSinks()
+			c.removeRunDir()
+			return nil, err
+		}
+	}
+	return sess, nil
+}
+
+// newSessions establishes the sessions of every account of cfg
+// concurrently.  If any of them fails, the others are shut down.
+func (c *Spray) newSessions(cfg *config.Config) ([]*session.Session, error) {
+	timeout := time.Duration(cfg.Debug.SessionDialTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	accounts := cfg.Accounts
+	sessions := make([]*session.Session, len(accounts))
+	errs := make([]error, len(accounts))
+	var wg sync.WaitGroup
+	for i, a := range accounts {
+		wg.Add(1)
+		go func(i int, a *config.Account) {
+			defer wg.Done()
+			sessions[i], errs[i] = session.New(ctx, c.fatalErrCh, c.logBackend, cfg.ForAccount(a), c.events, c.pkiClients)
+		}(i, a)
+	}
+	wg.Wait()
+
+	var err error
+	for i, e := range errs {
+		if e != nil && err == nil {
+			err = e
+			if len(accounts) > 1 {
+				c.log.Errorf("Account %v failed to start: %v", accounts[i].Identifier(), e)
+			}
+		}
+	}
+	if err != nil {
+		for _, sess := range sessions {
+			if sess != nil {
+				sess.Shutdown()
+			}
+		}
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// writeCoverTraffic writes the cover traffic analysis of the session of
+// every account, with every packet class labeled with the account if
+// there are several.
+func (c *Spray) writeCoverTraffic(f string) error {
+	var cover *report.CoverTraffic
+	for _, sess := range c.sessions {
+		ct := sess.CoverTraffic()
+		if ct == nil {
+			continue
+		}
+		if len(c.sessions) > 1 {
+			for _, class := range ct.Classes {
+				class.Account = sess.Config().Account.Identifier()
+			}
+		}
+		if cover == nil {
+			cover = ct
+		} else {
+			cover.Classes = append(cover.Classes, ct.Classes...)
+		}
+	}
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteCoverTraffic(out, cover); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (c *Spray) writeTopology(f string) error {
+	format := "json"
+	if strings.ToLower(filepath.Ext(f)) == ".dot" {
+		format = "dot"
+	}
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = c.session.WriteTopology(out, format); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// New creates a new Spray with the provided configuration.
+func New(cfg *config.Config) (*Spray, error) {
+	c := new(Spray)
+	c.cfg = cfg
+	c.fatalErrCh = make(chan error)
+	c.haltedCh = make(chan interface{})
+	c.haltOnce = new(sync.Once)
+	c.events = event.NewBus()
+
+	// Time-compressed lab networks use a shorter epoch period, which is
+	// process wide, so set it before any session or PKI client exists.
+	if err := session.SetEpochPeriod(cfg); err != nil {
+		return nil, err
+	}
+
+	// Do the early initialization and bring up logging.
+	notice, err := c.cfg.InitDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.initLogging(); err != nil {
+		return nil, err
+	}
+	if notice != "" {
+		c.log.Warning(notice)
+	}
+
+	// Ensure we generate keys if the user requested it.
+	if c.cfg.Debug.GenerateOnly {
+		err := config.GenerateKeys(c.cfg)
+		return nil, err
+	}
+
+	c.log.Noticef("😼 Katzenpost is still pre-alpha.  DO NOT DEPEND ON IT FOR STRONG SECURITY OR ANONYMITY. 😼")
+
+	if mCfg := c.cfg.Metrics; mCfg != nil && mCfg.Address != "" {
+		var err error
+		if c.metrics, err = newMetricsServer(c, mCfg.Address, mCfg.Path); err != nil {
+			c.log.Errorf("Failed to start the metrics exporter: %v", err)
+			return nil, err
+		}
+		c.log.Noticef("Serving metrics on http://%v%v", mCfg.Address, mCfg.Path)
+	}
+	if mCfg := c.cfg.Metrics; mCfg != nil && mCfg.StatsdAddress != "" {
+		var err error
+		interval := time.Duration(mCfg.StatsdInterval) * time.Second
+		if c.statsd, err = newStatsdSink(c, mCfg.StatsdAddress, mCfg.StatsdPrefix, interval); err != nil {
+			c.log.Errorf("Failed to start the statsd sink: %v", err)
+			return nil, err
+		}
+		c.log.Noticef("Pushing metrics to statsd at %v", mCfg.StatsdAddress)
+	}
+	if err := c.newOutputSinks(); err != nil {
+		c.log.Errorf("Failed to create the output sinks: %v", err)
+		return nil, err
+	}
+
+	if cCfg := c.cfg.Control; cCfg != nil && cCfg.HTTPAddress != "" {
+		var err error
+		if c.httpControl, err = newHTTPControlServer(c, cCfg.HTTPAddress); err != nil {
+			c.log.Errorf("Failed to start the HTTP control interface: %v", err)
+			return nil, err
+		}
+		c.log.Noticef("Serving the control interface on http://%v/", cCfg.HTTPAddress)
+	}
+
+	go c.fatalErrorWorker()
+	return c, nil
+}
+
+// OnFatalError registers fn to be called with the fatal errors of the
+// sessions, instead of the Spray shutting itself down on the first one,
+// so that the caller may recover, for instance by stopping the run and
+// starting another one, or fail over to another instance.  fn is called
+// on a goroutine of its own for every error, and may call Stop, Start or
+// Shutdown.  A nil fn restores the default behavior.
+func (c *Spray) OnFatalError(fn func(error)) {
+	c.fatalErrLock.Lock()
+	defer c.fatalErrLock.Unlock()
+	c.onFatalErr = fn
+}
+
+// Err returns the fatal error the Spray shut down due to, such as a PKI
+// document or a clock skew aborting the run, or nil if it was shut down
+// otherwise.  It must only be called after Wait returns.
+func (c *Spray) Err() error {
+	c.fatalErrLock.Lock()
+	defer c.fatalErrLock.Unlock()
+	return c.fatalErr
+}
+
+// fatalErrorWorker hands the fatal errors of the sessions to the
+// OnFatalError callback, or shuts down on the first one without a
+// callback.  It drains the errors until the Spray is halted, so that no
+// session blocks sending one.
+func (c *Spray) fatalErrorWorker() {
+	for {
+		var err error
+		select {
+		case err = <-c.fatalErrCh:
+		case <-c.haltedCh:
+			return
+		}
+		c.fatalErrLock.Lock()
+		fn := c.onFatalErr
+		if fn == nil && c.fatalErr == nil {
+			c.fatalErr = err
+		}
+		c.fatalErrLock.Unlock()
+		if fn != nil {
+			c.log.Warningf("Fatal error: %v", err)
+			go fn(err)
+			continue
+		}
+		c.log.Warningf("Shutting down due to error: %v", err)
+		c.Shutdown()
+	}
+}
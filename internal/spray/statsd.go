@@ -0,0 +1,120 @@
+// statsd.go - statsd metrics sink
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spray
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/spray/internal/session"
+	"github.com/katzenpost/spray/stats"
+)
+
+// statsdSink periodically pushes the counters of the current sessions to
+// a statsd server over UDP, as counters of the increments since the last
+// push, along with gauges of the outstanding SURBs and their round trip
+// time.  Unlike the Prometheus exporter it outlives the runs, and the
+// counters of a new run are pushed from zero.
+type statsdSink struct {
+	sync.WaitGroup
+
+	c      *Spray
+	conn   net.Conn
+	prefix string
+	last   session.Counters
+	haltCh chan struct{}
+}
+
+func newStatsdSink(c *Spray, addr, prefix string, interval time.Duration) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsdSink{
+		c:      c,
+		conn:   conn,
+		prefix: prefix,
+		haltCh: make(chan struct{}),
+	}
+	s.Add(1)
+	go s.worker(interval)
+	return s, nil
+}
+
+func (s *statsdSink) worker(interval time.Duration) {
+	defer s.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.push(); err != nil {
+				s.c.log.Debugf("Failed to push metrics to statsd: %v", err)
+			}
+		case <-s.haltCh:
+			return
+		}
+	}
+}
+
+// delta returns the increment of a counter since the last push, the
+// counters restarting from zero with every run.
+func delta(cur, last uint64) uint64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+func (s *statsdSink) push() error {
+	s.c.runLock.Lock()
+	cnt := s.c.counters()
+	outstanding, rtt := 0, stats.NewHistogram()
+	for _, sess := range s.c.sessions {
+		st := sess.Stats()
+		outstanding += st.Outstanding
+		rtt.Merge(st.RTT)
+	}
+	s.c.runLock.Unlock()
+
+	var b bytes.Buffer
+	metric := func(name string, v interface{}, typ string) {
+		fmt.Fprintf(&b, "%s.%s:%v|%s\n", s.prefix, name, v, typ)
+	}
+	metric("packets_sent", delta(cnt.Sent, s.last.Sent), "c")
+	metric("send_failures", delta(cnt.SendFailures, s.last.SendFailures), "c")
+	metric("messages_received", delta(cnt.Received, s.last.Received), "c")
+	metric("surb_acks", delta(cnt.ACKs, s.last.ACKs), "c")
+	metric("limiter_waits", delta(cnt.LimiterWaits, s.last.LimiterWaits), "c")
+	metric("surbs_outstanding", outstanding, "g")
+	if rtt.Count() > 0 {
+		metric("rtt.p50", int64(rtt.Percentile(50)/time.Millisecond), "g")
+		metric("rtt.p99", int64(rtt.Percentile(99)/time.Millisecond), "g")
+	}
+	s.last = *cnt
+	_, err := s.conn.Write(b.Bytes())
+	return err
+}
+
+func (s *statsdSink) close() {
+	close(s.haltCh)
+	s.Wait()
+	s.conn.Close()
+}
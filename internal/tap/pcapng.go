@@ -0,0 +1,106 @@
+// pcapng.go - pcapng encoding
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tap
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	pcapngSHB = 0x0a0d0d0a
+	pcapngIDB = 0x00000001
+	pcapngEPB = 0x00000006
+
+	pcapngByteOrderMagic = 0x1a2b3c4d
+	pcapngLinkTypeUser0  = 147
+
+	pcapngOptEnd      = 0
+	pcapngOptIfName   = 2
+	pcapngOptIfTsresl = 9
+	pcapngOptEPBFlags = 2
+
+	pcapngFlagInbound  = 1
+	pcapngFlagOutbound = 2
+)
+
+var le = binary.LittleEndian
+
+func pcapngOption(code uint16, value []byte) []byte {
+	b := make([]byte, 4, 4+len(value)+3)
+	le.PutUint16(b, code)
+	le.PutUint16(b[2:], uint16(len(value)))
+	b = append(b, value...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// pcapngBlock frames a block body, appending the end of options marker
+// if there are options.
+func pcapngBlock(blockType uint32, body, options []byte) []byte {
+	if options != nil {
+		options = append(options, pcapngOption(pcapngOptEnd, nil)...)
+	}
+	l := 12 + len(body) + len(options)
+	b := make([]byte, 8, l)
+	le.PutUint32(b, blockType)
+	le.PutUint32(b[4:], uint32(l))
+	b = append(b, body...)
+	b = append(b, options...)
+	var trailer [4]byte
+	le.PutUint32(trailer[:], uint32(l))
+	return append(b, trailer[:]...)
+}
+
+func pcapngSectionHeader() []byte {
+	body := make([]byte, 16)
+	le.PutUint32(body, pcapngByteOrderMagic)
+	le.PutUint16(body[4:], 1)
+	le.PutUint16(body[6:], 0)
+	le.PutUint64(body[8:], ^uint64(0)) // Unspecified section length.
+	return pcapngBlock(pcapngSHB, body, nil)
+}
+
+func pcapngInterface(name string) []byte {
+	body := make([]byte, 8)
+	le.PutUint16(body, pcapngLinkTypeUser0)
+	le.PutUint32(body[4:], 0) // No snapshot length limit.
+	opts := pcapngOption(pcapngOptIfName, []byte(name))
+	opts = append(opts, pcapngOption(pcapngOptIfTsresl, []byte{9})...) // Nanoseconds.
+	return pcapngBlock(pcapngIDB, body, opts)
+}
+
+// pcapngPacket returns an enhanced packet block without data, whose
+// original length is the recorded size.
+func pcapngPacket(conn uint32, dir string, n int, t time.Time) []byte {
+	body := make([]byte, 20)
+	ts := uint64(t.UnixNano())
+	le.PutUint32(body, conn)
+	le.PutUint32(body[4:], uint32(ts>>32))
+	le.PutUint32(body[8:], uint32(ts))
+	le.PutUint32(body[12:], 0)
+	le.PutUint32(body[16:], uint32(n))
+	flags := make([]byte, 4)
+	if dir == DirIn {
+		le.PutUint32(flags, pcapngFlagInbound)
+	} else {
+		le.PutUint32(flags, pcapngFlagOutbound)
+	}
+	return pcapngBlock(pcapngEPB, body, pcapngOption(pcapngOptEPBFlags, flags))
+}
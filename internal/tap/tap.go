@@ -0,0 +1,163 @@
+// tap.go - wire traffic capture
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package tap implements a dialer recording the timestamped sizes of all
+// of the traffic on the connections it dials, for studying the traffic
+// analysis resistance of the client itself.  Only sizes are recorded,
+// as the link protocol encrypts everything else.
+package tap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Traffic directions.
+const (
+	DirIn  = "in"
+	DirOut = "out"
+)
+
+// Recorder writes traffic records to a file, either in the pcapng
+// format if the file name ends in ".pcapng", with one interface per
+// connection and empty packets of the recorded sizes, or otherwise as
+// CSV lines of the time in nanoseconds since the UNIX epoch, the
+// connection number, the direction and the size in bytes.
+type Recorder struct {
+	sync.Mutex
+
+	f      *os.File
+	w      *bufio.Writer
+	pcapng bool
+	conns  uint32
+	err    error
+}
+
+// NewRecorder creates the file f and returns a Recorder writing to it.
+func NewRecorder(f string) (*Recorder, error) {
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		f:      out,
+		w:      bufio.NewWriter(out),
+		pcapng: strings.ToLower(filepath.Ext(f)) == ".pcapng",
+	}
+	if r.pcapng {
+		r.w.Write(pcapngSectionHeader())
+	}
+	return r, nil
+}
+
+// newConn allocates the number of a new connection.
+func (r *Recorder) newConn(address string) uint32 {
+	r.Lock()
+	defer r.Unlock()
+	id := r.conns
+	r.conns++
+	if r.pcapng {
+		r.w.Write(pcapngInterface(fmt.Sprintf("conn-%d %s", id, address)))
+	}
+	return id
+}
+
+func (r *Recorder) record(conn uint32, dir string, n int, t time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.pcapng {
+		_, r.err = r.w.Write(pcapngPacket(conn, dir, n, t))
+	} else {
+		_, r.err = fmt.Fprintf(r.w, "%d,%d,%s,%d\n", t.UnixNano(), conn, dir, n)
+	}
+}
+
+// Close flushes the records and closes the file, returning the first
+// error encountered while recording, if any.
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	err := r.err
+	if fErr := r.w.Flush(); err == nil {
+		err = fErr
+	}
+	if cErr := r.f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// Dialer dials connections whose traffic is recorded.
+type Dialer struct {
+	// Recorder is the recorder of the traffic.
+	Recorder *Recorder
+
+	// DialContextFn is the underlying dial function, by default that of
+	// a net.Dialer.
+	DialContextFn func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialContext dials address and returns a connection whose traffic is
+// recorded.  It has the signature expected by minclient's DialContextFn.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := d.DialContextFn
+	if dial == nil {
+		var nd net.Dialer
+		dial = nd.DialContext
+	}
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &tappedConn{
+		Conn: conn,
+		r:    d.Recorder,
+		id:   d.Recorder.newConn(address),
+	}, nil
+}
+
+type tappedConn struct {
+	net.Conn
+
+	r  *Recorder
+	id uint32
+}
+
+func (c *tappedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.r.record(c.id, DirIn, n, time.Now())
+	}
+	return n, err
+}
+
+func (c *tappedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.r.record(c.id, DirOut, n, time.Now())
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+// bottleneck.go - send pipeline bottleneck attribution
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import "fmt"
+
+// Stages of the send pipeline.
+const (
+	// StageCPU is Sphinx packet composition.
+	StageCPU = "cpu"
+
+	// StageLimit is the configured send rate limit.
+	StageLimit = "limit"
+
+	// StageNetwork is handing packets to the Provider.
+	StageNetwork = "network"
+)
+
+// Bottleneck attributes the achieved send rate to the stage of the send
+// pipeline that limited it.  Rates are in packets per second.
+type Bottleneck struct {
+	// Stage is the limiting stage.
+	Stage string
+
+	// AchievedRate is the achieved send rate.
+	AchievedRate float64
+
+	// CryptoRate is the rate packets could be composed at.
+	CryptoRate float64
+
+	// LimiterRate is the configured send rate limit.
+	LimiterRate float64
+
+	// WireRate is the rate packets could be handed to the Provider at.
+	WireRate float64
+}
+
+// Attribute sets Stage to the slowest stage of the send pipeline.
+func (b *Bottleneck) Attribute() {
+	min := b.CryptoRate
+	b.Stage = StageCPU
+	if b.LimiterRate < min {
+		b.Stage, min = StageLimit, b.LimiterRate
+	}
+	if b.WireRate < min {
+		b.Stage = StageNetwork
+	}
+}
+
+// String returns a one line summary of the attribution.
+func (b *Bottleneck) String() string {
+	return fmt.Sprintf("achieved %.2f/s, limited by %s (crypto %.2f/s, limit %.2f/s, wire %.2f/s)",
+		b.AchievedRate, b.Stage, b.CryptoRate, b.LimiterRate, b.WireRate)
+}
+
+// mergeBottlenecks returns the attribution of the aggregate send rate of
+// several runs, whose pipelines run side by side so that their rates add
+// up, or nil if none of the runs has one.
+func mergeBottlenecks(runs []*Run) *Bottleneck {
+	var agg *Bottleneck
+	for _, r := range runs {
+		b := r.Bottleneck
+		if b == nil {
+			continue
+		}
+		if agg == nil {
+			agg = new(Bottleneck)
+		}
+		agg.AchievedRate += b.AchievedRate
+		agg.CryptoRate += b.CryptoRate
+		agg.LimiterRate += b.LimiterRate
+		agg.WireRate += b.WireRate
+	}
+	if agg != nil {
+		agg.Attribute()
+	}
+	return agg
+}
@@ -0,0 +1,61 @@
+// calibration.go - send cadence calibration
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"time"
+)
+
+// Calibration is the measured send cadence the host can deliver.
+type Calibration struct {
+	// TimerResolution is the median duration of the shortest possible
+	// sleep.
+	TimerResolution time.Duration
+
+	// SyscallOverhead is the mean duration of a small write system call.
+	SyscallOverhead time.Duration
+
+	// MaxAccurateRate is the highest send rate in packets per second
+	// that can be paced one packet at a time.
+	MaxAccurateRate float64
+
+	// RequestedRate is the configured send rate in packets per second.
+	RequestedRate float64
+
+	// AchievedRate is the rate the rate limiter delivered when asked
+	// for the requested rate, zero if the requested rate is unlimited.
+	AchievedRate float64
+
+	// PacingJitter is the 99th percentile of the deviation of the
+	// inter-packet gaps from the ideal gap.
+	PacingJitter time.Duration
+}
+
+// Accurate returns true if the host can deliver the requested rate.
+func (c *Calibration) Accurate() bool {
+	if c.RequestedRate <= 0 {
+		return true
+	}
+	return c.RequestedRate <= c.MaxAccurateRate && c.AchievedRate >= 0.95*c.RequestedRate
+}
+
+// String returns a one line summary of the calibration.
+func (c *Calibration) String() string {
+	return fmt.Sprintf("timer resolution %v, syscall overhead %v, max accurate rate %.1f/s, requested %.1f/s, achieved %.1f/s, pacing jitter p99 %v",
+		c.TimerResolution, c.SyscallOverhead, c.MaxAccurateRate, c.RequestedRate, c.AchievedRate, c.PacingJitter)
+}
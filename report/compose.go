@@ -0,0 +1,72 @@
+// compose.go - compose-only dry run statistics
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Compose is the result of a compose-only dry run.
+type Compose struct {
+	// Composed is the number of packets composed.
+	Composed uint64
+
+	// Errors is the number of failed compositions.
+	Errors uint64
+
+	// Throughput is the achieved compose rate in packets per second.
+	Throughput float64
+
+	// ComposeTime is the histogram of the time taken to compose a
+	// packet.
+	ComposeTime *stats.Histogram
+
+	// RouteDelay is the histogram of the total mixing delay of the
+	// selected routes.
+	RouteDelay *stats.Histogram
+}
+
+// String returns a one line summary of the dry run.
+func (c *Compose) String() string {
+	return fmt.Sprintf("%d composed, %d errors, %.2f/s, compose time p50 %v p99 %v max %v, route delay p50 %v p99 %v max %v",
+		c.Composed, c.Errors, c.Throughput,
+		c.ComposeTime.Percentile(50), c.ComposeTime.Percentile(99), c.ComposeTime.Max(),
+		c.RouteDelay.Percentile(50), c.RouteDelay.Percentile(99), c.RouteDelay.Max())
+}
+
+// mergeCompose returns the aggregate of the dry runs of several runs,
+// composing concurrently, or nil if none of the runs was one.
+func mergeCompose(runs []*Run) *Compose {
+	var agg *Compose
+	for _, r := range runs {
+		c := r.Compose
+		if c == nil {
+			continue
+		}
+		if agg == nil {
+			agg = &Compose{ComposeTime: stats.NewHistogram(), RouteDelay: stats.NewHistogram()}
+		}
+		agg.Composed += c.Composed
+		agg.Errors += c.Errors
+		agg.Throughput += c.Throughput
+		agg.ComposeTime.Merge(c.ComposeTime)
+		agg.RouteDelay.Merge(c.RouteDelay)
+	}
+	return agg
+}
@@ -0,0 +1,82 @@
+// cover.go - cover traffic analysis
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Packet classes, as far as an observer of the client's link is
+// concerned they should be indistinguishable.
+const (
+	PacketReal  = "real"
+	PacketDecoy = "decoy"
+)
+
+// CoverTrafficClass is the emission analysis of one class of packets.
+type CoverTrafficClass struct {
+	// Account is the account whose packets were analyzed, if the run
+	// has several, each emitting on a link of its own.
+	Account string `json:",omitempty"`
+
+	// Class is the packet class.
+	Class string
+
+	// Packets is the number of packets emitted.
+	Packets uint64
+
+	// ObservedRate is the observed mean emission rate in packets per
+	// second.
+	ObservedRate float64
+
+	// ExpectedRate is the configured Poisson emission rate in packets
+	// per second, or zero if there is none.
+	ExpectedRate float64
+
+	// InterDeparture is the distribution of the intervals between
+	// consecutive packets.
+	InterDeparture []stats.Bucket
+
+	// KS tests the intervals against the exponential distribution with
+	// the expected rate, and is nil if there is none.
+	KS *stats.KSTest `json:",omitempty"`
+
+	// KSFitted tests the intervals against the exponential distribution
+	// with the observed rate, that is whether the packets are emitted
+	// by a Poisson process at all.
+	KSFitted *stats.KSTest `json:",omitempty"`
+
+	// Truncated is true if only the first intervals were retained for
+	// the tests.
+	Truncated bool
+}
+
+// CoverTraffic is the analysis of the emission times of real and decoy
+// packets.
+type CoverTraffic struct {
+	Classes []*CoverTrafficClass
+}
+
+// WriteCoverTraffic writes the analysis to w as JSON.
+func WriteCoverTraffic(w io.Writer, c *CoverTraffic) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
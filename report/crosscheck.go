@@ -0,0 +1,79 @@
+// crosscheck.go - PKI document cross-check results
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Divergence is a document that differs from the reference document.
+type Divergence struct {
+	// Source is the authority that served the document.
+	Source string
+
+	// Diff describes how the document differs from the reference.
+	Diff string
+}
+
+// CrossCheck is the comparison of the documents of an epoch fetched
+// from every authority.
+type CrossCheck struct {
+	// Epoch is the epoch of the documents.
+	Epoch uint64
+
+	// Reference is the authority whose document the others are
+	// compared with, the first that served one.
+	Reference string
+
+	// Agreeing is the number of authorities whose document matches the
+	// reference, including the reference itself.
+	Agreeing int
+
+	// Divergent are the documents that differ from the reference.
+	Divergent []*Divergence `json:",omitempty"`
+
+	// Failed maps the authorities that failed to serve a document to
+	// the error.
+	Failed map[string]string `json:",omitempty"`
+}
+
+// Agrees returns true if every authority served the same document.
+func (c *CrossCheck) Agrees() bool {
+	return c.Agreeing > 0 && len(c.Divergent) == 0 && len(c.Failed) == 0
+}
+
+// String returns a one line summary of the cross-check.
+func (c *CrossCheck) String() string {
+	parts := []string{fmt.Sprintf("epoch %d: %d agree", c.Epoch, c.Agreeing)}
+	if c.Reference != "" {
+		parts[0] += fmt.Sprintf(" with %s", c.Reference)
+	}
+	for _, d := range c.Divergent {
+		parts = append(parts, fmt.Sprintf("%s diverges: %s", d.Source, d.Diff))
+	}
+	failed := make([]string, 0, len(c.Failed))
+	for source := range c.Failed {
+		failed = append(failed, source)
+	}
+	sort.Strings(failed)
+	for _, source := range failed {
+		parts = append(parts, fmt.Sprintf("%s failed: %s", source, c.Failed[source]))
+	}
+	return strings.Join(parts, ", ")
+}
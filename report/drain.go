@@ -0,0 +1,70 @@
+// drain.go - spool drain results
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"time"
+)
+
+// Drain is the result of draining our own spool.
+type Drain struct {
+	// Messages is the number of messages retrieved.
+	Messages uint64
+
+	// Bytes is the total size of the messages retrieved.
+	Bytes uint64
+
+	// Duration is the time it took to drain the spool, or the time spent
+	// draining so far if the spool was not found empty.
+	Duration time.Duration
+
+	// Complete is true if the Provider reported the spool empty.
+	Complete bool
+}
+
+// String returns a one line summary of the drain.
+func (d *Drain) String() string {
+	state := "incomplete"
+	if d.Complete {
+		state = "spool empty"
+	}
+	return fmt.Sprintf("%d messages, %d bytes retrieved in %v (%s)", d.Messages, d.Bytes, d.Duration, state)
+}
+
+// mergeDrains returns the aggregate of the drains of several runs, which
+// is complete once every spool was found empty, or nil if none of the
+// runs drained.
+func mergeDrains(runs []*Run) *Drain {
+	var agg *Drain
+	for _, r := range runs {
+		d := r.Drain
+		if d == nil {
+			continue
+		}
+		if agg == nil {
+			agg = &Drain{Complete: true}
+		}
+		agg.Messages += d.Messages
+		agg.Bytes += d.Bytes
+		if d.Duration > agg.Duration {
+			agg.Duration = d.Duration
+		}
+		agg.Complete = agg.Complete && d.Complete
+	}
+	return agg
+}
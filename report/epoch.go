@@ -0,0 +1,111 @@
+// epoch.go - per-epoch report sections
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Params are the mix parameters of the PKI document of an epoch, so that
+// latencies can be normalized across parameter changes.  Like in the PKI
+// documents, the rates are the inverses of the mean delays and intervals
+// in milliseconds.
+type Params struct {
+	Mu      float64
+	LambdaP float64
+	LambdaL float64
+	LambdaD float64
+	LambdaM float64
+}
+
+// Epoch is the accounting of the packets and probes sent in a single
+// PKI epoch, as Provider behavior often changes at epoch boundaries.
+type Epoch struct {
+	// Epoch is the epoch.
+	Epoch uint64
+
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// Outcomes is the number of probes per outcome.
+	Outcomes map[string]uint64
+
+	// Latency is the latency histogram of the successful probes.
+	Latency *stats.Histogram
+
+	// Params are the mix parameters of the epoch, if its PKI document
+	// was seen.
+	Params *Params `json:",omitempty"`
+}
+
+// NewEpoch returns a new empty Epoch.
+func NewEpoch(epoch uint64) *Epoch {
+	return &Epoch{
+		Epoch:    epoch,
+		Outcomes: make(map[string]uint64),
+		Latency:  stats.NewHistogram(),
+	}
+}
+
+// LossRate returns the fraction of the probes that were lost.
+func (e *Epoch) LossRate() float64 {
+	var n uint64
+	for _, v := range e.Outcomes {
+		n += v
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(e.Outcomes[OutcomeLost]) / float64(n)
+}
+
+// String returns a one line summary of the epoch.
+func (e *Epoch) String() string {
+	return fmt.Sprintf("epoch %d: %d sent, %d ok, %d lost (%.2f%%), %d corrupt, %d failed, latency p50 %v p99 %v max %v",
+		e.Epoch, e.Sent, e.Outcomes[OutcomeOK], e.Outcomes[OutcomeLost], 100*e.LossRate(), e.Outcomes[OutcomeCorrupt], e.Outcomes[OutcomeFailed],
+		e.Latency.Percentile(50), e.Latency.Percentile(99), e.Latency.Max())
+}
+
+// mergeEpochs returns the aggregate of the per-epoch accounting of
+// several runs, in epoch order.
+func mergeEpochs(runs []*Run) []*Epoch {
+	m := make(map[uint64]*Epoch)
+	var epochs []*Epoch
+	for _, r := range runs {
+		for _, e := range r.Epochs {
+			agg, ok := m[e.Epoch]
+			if !ok {
+				agg = NewEpoch(e.Epoch)
+				m[e.Epoch] = agg
+				epochs = append(epochs, agg)
+			}
+			agg.Sent += e.Sent
+			for k, v := range e.Outcomes {
+				agg.Outcomes[k] += v
+			}
+			agg.Latency.Merge(e.Latency)
+			if agg.Params == nil {
+				agg.Params = e.Params
+			}
+		}
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i].Epoch < epochs[j].Epoch })
+	return epochs
+}
@@ -0,0 +1,133 @@
+// fortio.go - Fortio compatible JSON results
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// fortioPercentiles are the percentiles Fortio reports by default.
+var fortioPercentiles = []float64{50, 75, 90, 99, 99.9}
+
+type fortioInterval struct {
+	Start   float64
+	End     float64
+	Percent float64
+	Count   int64
+}
+
+type fortioPercentile struct {
+	Percentile float64
+	Value      float64
+}
+
+type fortioHistogram struct {
+	Count       int64
+	Min         float64
+	Max         float64
+	Sum         float64
+	Avg         float64
+	StdDev      float64
+	Data        []fortioInterval
+	Percentiles []fortioPercentile
+}
+
+type fortioResults struct {
+	RunType           string
+	Labels            string
+	StartTime         time.Time
+	RequestedQPS      string
+	RequestedDuration string
+	ActualQPS         float64
+	ActualDuration    time.Duration
+	NumThreads        int
+	Version           string
+	DurationHistogram fortioHistogram
+	Exactly           int64
+	RetCodes          map[string]int64
+}
+
+// WriteFortio writes the report in Fortio's JSON result format, so that
+// Fortio's report and comparison UI can be used to browse spray results.
+// Histogram values are in seconds and the outcome counts take the place
+// of HTTP return codes.
+func WriteFortio(w io.Writer, r *Run) error {
+	h := r.Latency
+	res := &fortioResults{
+		RunType:           "Spray " + r.Mode,
+		Labels:            fortioLabels(r),
+		StartTime:         r.StartTime,
+		RequestedQPS:      fmt.Sprintf("%v", r.RequestedQPS),
+		RequestedDuration: "until stop",
+		ActualDuration:    r.Duration,
+		NumThreads:        1,
+		RetCodes:          make(map[string]int64),
+		DurationHistogram: fortioHistogram{
+			Count:  int64(h.Count()),
+			Min:    h.Min().Seconds(),
+			Max:    h.Max().Seconds(),
+			Sum:    h.Sum().Seconds(),
+			Avg:    h.Mean().Seconds(),
+			StdDev: h.StdDev().Seconds(),
+		},
+	}
+	if r.Duration > 0 {
+		res.ActualQPS = float64(r.Sent) / r.Duration.Seconds()
+	}
+	for k, v := range r.Outcomes {
+		res.RetCodes[k] = int64(v)
+	}
+
+	var seen uint64
+	count := h.Count()
+	for _, b := range h.Buckets() {
+		seen += b.Count
+		res.DurationHistogram.Data = append(res.DurationHistogram.Data, fortioInterval{
+			Start:   b.Start.Seconds(),
+			End:     b.End.Seconds(),
+			Percent: 100 * float64(seen) / float64(count),
+			Count:   int64(b.Count),
+		})
+	}
+	if count > 0 {
+		for _, p := range fortioPercentiles {
+			res.DurationHistogram.Percentiles = append(res.DurationHistogram.Percentiles, fortioPercentile{
+				Percentile: p,
+				Value:      h.Percentile(p).Seconds(),
+			})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// fortioLabels returns the labels of the run, followed by its vantage
+// point, if any.
+func fortioLabels(r *Run) string {
+	if r.Vantage == nil {
+		return r.Labels
+	}
+	if r.Labels == "" {
+		return r.Vantage.String()
+	}
+	return r.Labels + " " + r.Vantage.String()
+}
@@ -0,0 +1,108 @@
+// generation.go - per connection generation accounting
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Generation is the accounting of the probes sent over a single
+// connection to the Provider, kept apart so that anomalies following a
+// reconnect are not averaged away.  Generations are numbered from one,
+// the number being incremented on every reconnect.
+type Generation struct {
+	// Generation is the number of the connection generation.
+	Generation int
+
+	// Start is the time the connection was established.
+	Start time.Time
+
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// Outcomes is the number of probes per outcome.
+	Outcomes map[string]uint64
+
+	// Latency is the latency histogram of the successful probes.
+	Latency *stats.Histogram
+}
+
+// NewGeneration returns a new empty Generation.
+func NewGeneration(generation int, start time.Time) *Generation {
+	return &Generation{
+		Generation: generation,
+		Start:      start,
+		Outcomes:   make(map[string]uint64),
+		Latency:    stats.NewHistogram(),
+	}
+}
+
+// Probes returns the number of probes sent in the generation.
+func (g *Generation) Probes() uint64 {
+	var n uint64
+	for _, v := range g.Outcomes {
+		n += v
+	}
+	return n
+}
+
+// LossRate returns the fraction of the probes that were lost.
+func (g *Generation) LossRate() float64 {
+	n := g.Probes()
+	if n == 0 {
+		return 0
+	}
+	return float64(g.Outcomes[OutcomeLost]) / float64(n)
+}
+
+// String returns a one line summary of the generation.
+func (g *Generation) String() string {
+	return fmt.Sprintf("generation %d: %d sent, %d probes, %d lost (%.2f%%), latency p50 %v p99 %v max %v",
+		g.Generation, g.Sent, g.Probes(), g.Outcomes[OutcomeLost], 100*g.LossRate(),
+		g.Latency.Percentile(50), g.Latency.Percentile(99), g.Latency.Max())
+}
+
+// mergeGenerations returns the aggregate of the per-generation
+// accounting of several runs, matching generations by number.
+func mergeGenerations(runs []*Run) []*Generation {
+	m := make(map[int]*Generation)
+	var generations []*Generation
+	for _, r := range runs {
+		for _, g := range r.Generations {
+			agg, ok := m[g.Generation]
+			if !ok {
+				agg = NewGeneration(g.Generation, g.Start)
+				m[g.Generation] = agg
+				generations = append(generations, agg)
+			}
+			if g.Start.Before(agg.Start) {
+				agg.Start = g.Start
+			}
+			agg.Sent += g.Sent
+			for o, v := range g.Outcomes {
+				agg.Outcomes[o] += v
+			}
+			agg.Latency.Merge(g.Latency)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].Generation < generations[j].Generation })
+	return generations
+}
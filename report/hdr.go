@@ -0,0 +1,70 @@
+// hdr.go - HdrHistogram percentile distribution output
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// hdrTicksPerHalfDistance matches the default of wrk2 and the
+	// HdrHistogram reference implementation.
+	hdrTicksPerHalfDistance = 5
+
+	hdrMaxRows = 1000
+)
+
+func hdrMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteHdrHistogram writes the latency histogram as an HdrHistogram
+// percentile distribution (the format printed by wrk2 and consumed by
+// the HdrHistogram plotter), with values in milliseconds.
+func WriteHdrHistogram(w io.Writer, r *Run) error {
+	h := r.Latency
+	count := h.Count()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	if count > 0 {
+		p := 0.0
+		for i := 0; i < hdrMaxRows; i++ {
+			total := uint64(math.Ceil(p / 100 * float64(count)))
+			if total >= count {
+				break
+			}
+			if total == 0 {
+				total = 1
+			}
+			fmt.Fprintf(bw, "%12.3f %14.12f %10d %14.2f\n", hdrMillis(h.Percentile(p)), p/100, total, 1/(1-p/100))
+
+			halfDistance := math.Floor(math.Log2(100/(100-p))) + 1
+			p += 100 / (hdrTicksPerHalfDistance * math.Pow(2, halfDistance))
+		}
+		fmt.Fprintf(bw, "%12.3f %14.12f %10d\n", hdrMillis(h.Max()), 1.0, count)
+	}
+	buckets, subBuckets := h.Layout()
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", hdrMillis(h.Mean()), hdrMillis(h.StdDev()))
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", hdrMillis(h.Max()), count)
+	fmt.Fprintf(bw, "#[Buckets = %12d, SubBuckets     = %12d]\n", buckets, subBuckets)
+	return bw.Flush()
+}
@@ -0,0 +1,147 @@
+// heatmap.go - latency heatmaps
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const heatmapBins = 18
+
+// HeatmapBins are the upper bounds of the latency bins of heatmaps,
+// doubling from 1ms.  A last bin holds the latencies above the last
+// bound.
+var HeatmapBins = func() []time.Duration {
+	bins := make([]time.Duration, heatmapBins)
+	for i := range bins {
+		bins[i] = time.Millisecond << uint(i)
+	}
+	return bins
+}()
+
+// Heatmap is the latency distribution over time, in time buckets of a
+// fixed interval, so that latency drift across a long run can be
+// visualized.
+type Heatmap struct {
+	// Start is the start of the first time bucket.
+	Start time.Time
+
+	// Interval is the duration of every time bucket.
+	Interval time.Duration
+
+	// Rows are the latency bin counts of the probes sent in every time
+	// bucket, with a count per HeatmapBins bound and a last count for
+	// the latencies above them.
+	Rows [][]uint64
+}
+
+// NewHeatmap returns a new empty Heatmap.
+func NewHeatmap(start time.Time, interval time.Duration) *Heatmap {
+	return &Heatmap{
+		Start:    start,
+		Interval: interval,
+	}
+}
+
+// Record accounts for a probe sent at t with the given latency.  It is
+// not safe for concurrent use.
+func (h *Heatmap) Record(t time.Time, latency time.Duration) {
+	if t.Before(h.Start) {
+		return
+	}
+	row := int(t.Sub(h.Start) / h.Interval)
+	for len(h.Rows) <= row {
+		h.Rows = append(h.Rows, make([]uint64, heatmapBins+1))
+	}
+	bin := heatmapBins
+	for i, bound := range HeatmapBins {
+		if latency <= bound {
+			bin = i
+			break
+		}
+	}
+	h.Rows[row][bin]++
+}
+
+// Clone returns a deep copy of the Heatmap.
+func (h *Heatmap) Clone() *Heatmap {
+	c := *h
+	c.Rows = make([][]uint64, len(h.Rows))
+	for i, row := range h.Rows {
+		c.Rows[i] = append([]uint64(nil), row...)
+	}
+	return &c
+}
+
+// WriteHeatmap writes the latency heatmap of the run as CSV, with a row
+// per time bucket holding the unix time of its start followed by the
+// counts of every latency bin.
+func WriteHeatmap(w io.Writer, r *Run) error {
+	h := r.Heatmap
+	if h == nil {
+		return errors.New("report: the run has no heatmap")
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "start")
+	for _, bound := range HeatmapBins {
+		fmt.Fprintf(bw, ",le_%v", bound)
+	}
+	fmt.Fprintf(bw, ",gt_%v\n", HeatmapBins[len(HeatmapBins)-1])
+	for i, row := range h.Rows {
+		fmt.Fprint(bw, h.Start.Add(time.Duration(i)*h.Interval).Unix())
+		for _, n := range row {
+			fmt.Fprintf(bw, ",%d", n)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// mergeHeatmaps returns the aggregate of the heatmaps of several runs,
+// with their time buckets realigned to the earliest start, or nil if
+// none of the runs has one.
+func mergeHeatmaps(runs []*Run) *Heatmap {
+	var agg *Heatmap
+	for _, r := range runs {
+		if h := r.Heatmap; h != nil && (agg == nil || h.Start.Before(agg.Start)) {
+			agg = NewHeatmap(h.Start, h.Interval)
+		}
+	}
+	if agg == nil {
+		return nil
+	}
+	for _, r := range runs {
+		h := r.Heatmap
+		if h == nil {
+			continue
+		}
+		offset := int(h.Start.Sub(agg.Start) / agg.Interval)
+		for i, row := range h.Rows {
+			for len(agg.Rows) <= offset+i {
+				agg.Rows = append(agg.Rows, make([]uint64, heatmapBins+1))
+			}
+			for bin, n := range row {
+				agg.Rows[offset+i][bin] += n
+			}
+		}
+	}
+	return agg
+}
@@ -0,0 +1,60 @@
+// hops.go - latency per hop count
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// HopLatency is the latency of the probes whose paths had a given
+// number of hops, a point of a latency versus hops curve.
+type HopLatency struct {
+	// Hops is the number of hops, including both Providers.
+	Hops int
+
+	// Latency is the latency histogram of the probes.
+	Latency *stats.Histogram
+}
+
+// String returns a one line summary of the latency.
+func (h *HopLatency) String() string {
+	return fmt.Sprintf("%d hops: %d probes, latency p50 %v p99 %v max %v",
+		h.Hops, h.Latency.Count(), h.Latency.Percentile(50), h.Latency.Percentile(99), h.Latency.Max())
+}
+
+// mergeHops returns the aggregate of the latency per number of hops of
+// several runs, in hop order.
+func mergeHops(runs []*Run) []*HopLatency {
+	m := make(map[int]*HopLatency)
+	var hops []*HopLatency
+	for _, r := range runs {
+		for _, h := range r.Hops {
+			agg, ok := m[h.Hops]
+			if !ok {
+				agg = &HopLatency{Hops: h.Hops, Latency: stats.NewHistogram()}
+				m[h.Hops] = agg
+				hops = append(hops, agg)
+			}
+			agg.Latency.Merge(h.Latency)
+		}
+	}
+	sort.Slice(hops, func(i, j int) bool { return hops[i].Hops < hops[j].Hops })
+	return hops
+}
@@ -0,0 +1,190 @@
+// html.go - standalone HTML report
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+)
+
+// htmlBar is a bar of a chart of the HTML report, with its length in
+// percent of the longest bar.
+type htmlBar struct {
+	Label string
+	Value string
+	Width float64
+}
+
+// htmlCount is a row of a count table of the HTML report.
+type htmlCount struct {
+	Name  string
+	Count uint64
+}
+
+type htmlReport struct {
+	Summary     *Summary
+	Outcomes    []htmlCount
+	Errors      []htmlCount
+	Percentiles []htmlBar
+	Timeline    []htmlBar
+	Targets     []*Target
+}
+
+var htmlPercentiles = []float64{50, 75, 90, 95, 99, 99.9}
+
+// newHTMLReport returns the view of the run r and the SLO verdict v
+// rendered by WriteHTML.
+func newHTMLReport(r *Run, v *Verdict) *htmlReport {
+	h := &htmlReport{
+		Summary:  NewSummary(r, v, nil),
+		Outcomes: htmlCounts(r.Outcomes),
+		Errors:   htmlCounts(r.Errors),
+		Targets:  r.Targets,
+	}
+
+	if max := r.Latency.Max(); max > 0 {
+		for _, p := range htmlPercentiles {
+			d := r.Latency.Percentile(p)
+			h.Percentiles = append(h.Percentiles, htmlBar{
+				Label: fmt.Sprintf("p%v", p),
+				Value: d.String(),
+				Width: 100 * float64(d) / float64(max),
+			})
+		}
+		h.Percentiles = append(h.Percentiles, htmlBar{Label: "max", Value: max.String(), Width: 100})
+	}
+
+	var maxLoss float64
+	for _, e := range r.Epochs {
+		if l := e.LossRate(); l > maxLoss {
+			maxLoss = l
+		}
+	}
+	for _, e := range r.Epochs {
+		b := htmlBar{
+			Label: fmt.Sprintf("epoch %d", e.Epoch),
+			Value: fmt.Sprintf("%.2f%% of %d", 100*e.LossRate(), e.Sent),
+		}
+		if maxLoss > 0 {
+			b.Width = 100 * e.LossRate() / maxLoss
+		}
+		h.Timeline = append(h.Timeline, b)
+	}
+	return h
+}
+
+func htmlCounts(m map[string]uint64) []htmlCount {
+	counts := make([]htmlCount, 0, len(m))
+	for k, v := range m {
+		counts = append(counts, htmlCount{k, v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.2f%%", 100*f) },
+	"time":    func(t time.Time) string { return t.UTC().Format(time.RFC1123) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>spray report{{with .Summary.ID}} {{.}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #f0f0f0; }
+.chart td { border: none; padding: 0.15em 0.5em; }
+.bar { height: 1em; min-width: 1px; }
+.latency { background: #4a7fb5; }
+.loss { background: #c0504d; }
+.pass { color: #2e7d32; font-weight: bold; }
+.fail { color: #c62828; font-weight: bold; }
+</style>
+</head>
+<body>
+{{with .Summary}}
+<h1>spray report{{with .ID}} {{.}}{{end}}</h1>
+{{with .Labels}}<p>{{.}}</p>{{end}}
+<h2>Summary</h2>
+<table>
+<tr><th>Mode</th><td>{{.Mode}}</td></tr>
+<tr><th>Start</th><td>{{time .StartTime}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Sent</th><td>{{.Sent}}</td></tr>
+<tr><th>Received</th><td>{{.Received}}</td></tr>
+<tr><th>Lost</th><td>{{.Lost}} ({{percent .LossRate}})</td></tr>
+<tr><th>Latency</th><td>min {{.Latency.Min}}, mean {{.Latency.Mean}}, p50 {{.Latency.P50}}, p99 {{.Latency.P99}}, max {{.Latency.Max}}</td></tr>
+</table>
+{{with .Verdict}}
+<h2>SLO <span class="{{if .Pass}}pass">PASS{{else}}fail">FAIL{{end}}</span></h2>
+<table>
+<tr><th>Objective</th><th>Observed</th><th>Result</th></tr>
+{{range .Results}}<tr><td>{{.Objective}}</td><td>{{.Observed}}</td><td class="{{if .Pass}}pass">ok{{else}}fail">failed{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+{{with .Percentiles}}
+<h2>Latency percentiles</h2>
+<table class="chart">
+{{range .}}<tr><td>{{.Label}}</td><td style="width: 30em"><div class="bar latency" style="width: {{printf "%.1f" .Width}}%"></div></td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Timeline}}
+<h2>Loss per epoch</h2>
+<table class="chart">
+{{range .}}<tr><td>{{.Label}}</td><td style="width: 30em"><div class="bar loss" style="width: {{printf "%.1f" .Width}}%"></div></td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Outcomes</h2>
+<table>
+<tr><th>Outcome</th><th>Probes</th></tr>
+{{range .Outcomes}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{with .Errors}}
+<h2>Errors</h2>
+<table>
+<tr><th>Error</th><th>Probes</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Targets}}
+<h2>Targets</h2>
+<table>
+<tr><th>Target</th><th>Probes</th><th>Lost</th><th>p50</th><th>p99</th><th>max</th></tr>
+{{range .}}<tr><td>{{.Recipient}}@{{.Provider}}</td><td>{{.Probes}}</td><td>{{percent .LossRate}}</td><td>{{.Latency.Percentile 50.0}}</td><td>{{.Latency.Percentile 99.0}}</td><td>{{.Latency.Max}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the run r as a standalone HTML page, with summary
+// tables, a latency percentile chart and the loss per epoch, and the
+// SLO verdict v if it is not nil.
+func WriteHTML(w io.Writer, r *Run, v *Verdict) error {
+	return htmlTemplate.Execute(w, newHTMLReport(r, v))
+}
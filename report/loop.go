@@ -0,0 +1,104 @@
+// loop.go - decoy loop statistics
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"sort"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// LoopEpoch is the decoy loop accounting of a single epoch.
+type LoopEpoch struct {
+	// Epoch is the epoch the loops were sent in.
+	Epoch uint64
+
+	// Sent is the number of loops sent.
+	Sent uint64
+
+	// Lost is the number of loops that did not return in time.
+	Lost uint64
+}
+
+// DropRate returns the fraction of the loops sent that were lost.
+func (e *LoopEpoch) DropRate() float64 {
+	if e.Sent == 0 {
+		return 0
+	}
+	return float64(e.Lost) / float64(e.Sent)
+}
+
+// Loops are the statistics of the decoy loops sent to our own Provider,
+// kept apart from the other probes.
+type Loops struct {
+	// Sent is the number of loops sent.
+	Sent uint64
+
+	// Lost is the number of loops that did not return in time.
+	Lost uint64
+
+	// Corrupt is the number of loops that returned modified.
+	Corrupt uint64
+
+	// RTT is the round trip time histogram of the returned loops.
+	RTT *stats.Histogram
+
+	// Epochs is the per-epoch accounting, in epoch order.
+	Epochs []*LoopEpoch
+}
+
+// DropRate returns the fraction of the loops sent that were lost.
+func (l *Loops) DropRate() float64 {
+	if l.Sent == 0 {
+		return 0
+	}
+	return float64(l.Lost) / float64(l.Sent)
+}
+
+// mergeLoops returns the aggregate of the decoy loop statistics of
+// several runs, or nil if none of the runs sent any loops.
+func mergeLoops(runs []*Run) *Loops {
+	var agg *Loops
+	m := make(map[uint64]*LoopEpoch)
+	for _, r := range runs {
+		l := r.Loops
+		if l == nil {
+			continue
+		}
+		if agg == nil {
+			agg = &Loops{RTT: stats.NewHistogram()}
+		}
+		agg.Sent += l.Sent
+		agg.Lost += l.Lost
+		agg.Corrupt += l.Corrupt
+		agg.RTT.Merge(l.RTT)
+		for _, e := range l.Epochs {
+			aggE, ok := m[e.Epoch]
+			if !ok {
+				aggE = &LoopEpoch{Epoch: e.Epoch}
+				m[e.Epoch] = aggE
+				agg.Epochs = append(agg.Epochs, aggE)
+			}
+			aggE.Sent += e.Sent
+			aggE.Lost += e.Lost
+		}
+	}
+	if agg != nil {
+		sort.Slice(agg.Epochs, func(i, j int) bool { return agg.Epochs[i].Epoch < agg.Epochs[j].Epoch })
+	}
+	return agg
+}
@@ -0,0 +1,237 @@
+// loss.go - loss burst characterization
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+)
+
+// Loss patterns.
+const (
+	LossNone   = "none"
+	LossRandom = "random"
+	LossBursty = "bursty"
+)
+
+// burstyCorrelation is the loss correlation above which losses are
+// considered bursty rather than random.
+const burstyCorrelation = 0.2
+
+// LossBursts characterizes the temporal pattern of the losses of a run,
+// with a two state Gilbert-Elliott model where every probe sent in the
+// bad state is lost and every probe sent in the good state arrives.
+type LossBursts struct {
+	// Probes is the number of probes considered, in send order.
+	Probes uint64
+
+	// Losses is the number of probes lost.
+	Losses uint64
+
+	// Bursts is the number of runs of consecutive losses.
+	Bursts uint64
+
+	// MeanBurst and MaxBurst are the mean and maximum burst lengths.
+	MeanBurst float64
+	MaxBurst  uint64
+
+	// MeanGap is the mean number of probes that arrived between two
+	// bursts.
+	MeanGap float64
+
+	// BurstLengths is the number of bursts per burst length.
+	BurstLengths map[uint64]uint64
+
+	// P is the probability of a transition from the good state to the
+	// bad state, and R from the bad state to the good state.
+	P float64
+	R float64
+
+	// Correlation is the correlation of the losses of successive
+	// probes, 1-P-R, near zero for random loss and near one for
+	// outages.
+	Correlation float64
+
+	// Pattern is either "none", "random" or "bursty".
+	Pattern string
+}
+
+// NewLossBursts characterizes the losses of a sequence of probes, in send
+// order, where lost[i] is true if probe i was lost.
+func NewLossBursts(lost []bool) *LossBursts {
+	c := NewLossBurstCounter()
+	for _, v := range lost {
+		c.Add(v)
+	}
+	return c.LossBursts()
+}
+
+// LossBurstCounter characterizes the losses of a sequence of probes
+// incrementally, keeping only the current burst or gap length and the
+// burst length histogram.
+type LossBurstCounter struct {
+	l LossBursts
+
+	last                                    bool
+	burst, gap, gaps, gapSum                uint64
+	goodFrom, goodToBad, badFrom, badToGood uint64
+}
+
+// NewLossBurstCounter returns a LossBurstCounter without any probes.
+func NewLossBurstCounter() *LossBurstCounter {
+	return &LossBurstCounter{
+		l: LossBursts{BurstLengths: make(map[uint64]uint64)},
+	}
+}
+
+// Add adds the next probe in send order, lost if it was lost.
+func (c *LossBurstCounter) Add(lost bool) {
+	if c.l.Probes > 0 {
+		if c.last {
+			c.badFrom++
+			if !lost {
+				c.badToGood++
+			}
+		} else {
+			c.goodFrom++
+			if lost {
+				c.goodToBad++
+			}
+		}
+	}
+	c.l.Probes++
+	c.last = lost
+	if lost {
+		c.l.Losses++
+		if c.burst == 0 && c.l.Bursts > 0 {
+			c.gaps++
+			c.gapSum += c.gap
+		}
+		c.burst++
+		c.gap = 0
+		return
+	}
+	if c.burst > 0 {
+		c.l.endBurst(c.burst)
+		c.burst = 0
+	}
+	c.gap++
+}
+
+// Clone returns a copy of the counter.
+func (c *LossBurstCounter) Clone() *LossBurstCounter {
+	cp := *c
+	cp.l.BurstLengths = make(map[uint64]uint64, len(c.l.BurstLengths))
+	for k, v := range c.l.BurstLengths {
+		cp.l.BurstLengths[k] = v
+	}
+	return &cp
+}
+
+// LossBursts returns the characterization of the probes added so far,
+// with the current burst, if any, counted as ended.
+func (c *LossBurstCounter) LossBursts() *LossBursts {
+	l := c.Clone().l
+	l.Pattern = LossNone
+	if c.burst > 0 {
+		l.endBurst(c.burst)
+	}
+	if l.Losses == 0 {
+		return &l
+	}
+	l.MeanBurst = float64(l.Losses) / float64(l.Bursts)
+	if c.gaps > 0 {
+		l.MeanGap = float64(c.gapSum) / float64(c.gaps)
+	}
+	if c.goodFrom > 0 {
+		l.P = float64(c.goodToBad) / float64(c.goodFrom)
+	}
+	if c.badFrom > 0 {
+		l.R = float64(c.badToGood) / float64(c.badFrom)
+	}
+	l.classify()
+	return &l
+}
+
+// classify derives the correlation and the pattern from the transition
+// probabilities.
+func (l *LossBursts) classify() {
+	l.Correlation = 1 - l.P - l.R
+	l.Pattern = LossRandom
+	if l.Correlation > burstyCorrelation {
+		l.Pattern = LossBursty
+	}
+}
+
+func (l *LossBursts) endBurst(n uint64) {
+	l.Bursts++
+	l.BurstLengths[n]++
+	if n > l.MaxBurst {
+		l.MaxBurst = n
+	}
+}
+
+// String returns a one line summary of the loss pattern.
+func (l *LossBursts) String() string {
+	return fmt.Sprintf("%s loss: %d/%d probes lost in %d bursts, burst mean %.2f max %d, mean gap %.2f, Gilbert-Elliott p %.4f r %.4f, correlation %.3f",
+		l.Pattern, l.Losses, l.Probes, l.Bursts, l.MeanBurst, l.MaxBurst, l.MeanGap, l.P, l.R, l.Correlation)
+}
+
+// mergeLossBursts returns the aggregate of the loss characterizations of
+// several runs, each a sequence of probes of its own, or nil if none of
+// the runs has one.  The transition probabilities and the mean gap are
+// the means of those of the runs, weighted by the number of probes,
+// losses and bursts they were estimated from.
+func mergeLossBursts(runs []*Run) *LossBursts {
+	var agg *LossBursts
+	var p, r, gap float64
+	for _, run := range runs {
+		l := run.LossBursts
+		if l == nil {
+			continue
+		}
+		if agg == nil {
+			agg = &LossBursts{BurstLengths: make(map[uint64]uint64)}
+		}
+		agg.Probes += l.Probes
+		agg.Losses += l.Losses
+		agg.Bursts += l.Bursts
+		for n, v := range l.BurstLengths {
+			agg.BurstLengths[n] += v
+		}
+		if l.MaxBurst > agg.MaxBurst {
+			agg.MaxBurst = l.MaxBurst
+		}
+		p += l.P * float64(l.Probes-l.Losses)
+		r += l.R * float64(l.Losses)
+		gap += l.MeanGap * float64(l.Bursts)
+	}
+	if agg == nil {
+		return nil
+	}
+	agg.Pattern = LossNone
+	if agg.Losses == 0 {
+		return agg
+	}
+	agg.MeanBurst = float64(agg.Losses) / float64(agg.Bursts)
+	agg.MeanGap = gap / float64(agg.Bursts)
+	if good := agg.Probes - agg.Losses; good > 0 {
+		agg.P = p / float64(good)
+	}
+	agg.R = r / float64(agg.Losses)
+	agg.classify()
+	return agg
+}
@@ -0,0 +1,157 @@
+// loss_test.go - loss burst characterization tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"reflect"
+	"testing"
+)
+
+// lossBurstsOf characterizes the losses of a whole sequence of probes at
+// once, the way it was computed from every delivery before the
+// LossBurstCounter, as the reference for the counter.
+func lossBurstsOf(lost []bool) *LossBursts {
+	l := &LossBursts{
+		Probes:       uint64(len(lost)),
+		BurstLengths: make(map[uint64]uint64),
+		Pattern:      LossNone,
+	}
+	var burst, gap, gaps, gapSum uint64
+	var goodFrom, goodToBad, badFrom, badToGood uint64
+	for i, v := range lost {
+		if i > 0 {
+			if lost[i-1] {
+				badFrom++
+				if !v {
+					badToGood++
+				}
+			} else {
+				goodFrom++
+				if v {
+					goodToBad++
+				}
+			}
+		}
+		if v {
+			l.Losses++
+			if burst == 0 && l.Bursts > 0 {
+				gaps++
+				gapSum += gap
+			}
+			burst++
+			gap = 0
+			continue
+		}
+		if burst > 0 {
+			l.endBurst(burst)
+			burst = 0
+		}
+		gap++
+	}
+	if burst > 0 {
+		l.endBurst(burst)
+	}
+	if l.Losses == 0 {
+		return l
+	}
+	l.MeanBurst = float64(l.Losses) / float64(l.Bursts)
+	if gaps > 0 {
+		l.MeanGap = float64(gapSum) / float64(gaps)
+	}
+	if goodFrom > 0 {
+		l.P = float64(goodToBad) / float64(goodFrom)
+	}
+	if badFrom > 0 {
+		l.R = float64(badToGood) / float64(badFrom)
+	}
+	l.Correlation = 1 - l.P - l.R
+	l.Pattern = LossRandom
+	if l.Correlation > burstyCorrelation {
+		l.Pattern = LossBursty
+	}
+	return l
+}
+
+// parseLosses parses a sequence of probes written as "." for a probe
+// that arrived and "x" for a lost one.
+func parseLosses(s string) []bool {
+	lost := make([]bool, len(s))
+	for i, c := range s {
+		lost[i] = c == 'x'
+	}
+	return lost
+}
+
+func TestLossBurstCounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		probes  string
+		pattern string
+	}{
+		{"empty", "", LossNone},
+		{"no losses", "..........", LossNone},
+		{"all lost", "xxxxxxxxxx", LossBursty},
+		{"single loss", "....x.....", LossRandom},
+		{"leading loss", "x.........", LossRandom},
+		// Without any recovery observed, R is zero.
+		{"trailing loss", ".........x", LossBursty},
+		{"alternating", ".x.x.x.x.x", LossRandom},
+		{"trailing burst", "......xxxx", LossBursty},
+		{"outages", "...xxxx.......xxxxx....xxx..", LossBursty},
+		{"mixed", ".x..xx...x....xxx.x..x......xx.", LossRandom},
+	}
+	for _, tt := range tests {
+		lost := parseLosses(tt.probes)
+		c := NewLossBurstCounter()
+		for i, v := range lost {
+			c.Add(v)
+			// A snapshot with the current burst still open must match
+			// the reference for the probes so far.
+			if got, want := c.LossBursts(), lossBurstsOf(lost[:i+1]); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: after %d probes got %+v, want %+v", tt.name, i+1, got, want)
+			}
+		}
+		got := c.LossBursts()
+		if want := lossBurstsOf(lost); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, want)
+		}
+		if !reflect.DeepEqual(NewLossBursts(lost), got) {
+			t.Errorf("%s: NewLossBursts differs from the counter", tt.name)
+		}
+		if got.Pattern != tt.pattern {
+			t.Errorf("%s: pattern %v, want %v", tt.name, got.Pattern, tt.pattern)
+		}
+	}
+}
+
+func TestLossBurstCounterClone(t *testing.T) {
+	c := NewLossBurstCounter()
+	for _, v := range parseLosses("..xx.") {
+		c.Add(v)
+	}
+	before := c.LossBursts()
+	clone := c.Clone()
+	for _, v := range parseLosses("xxx..") {
+		clone.Add(v)
+	}
+	if got := c.LossBursts(); !reflect.DeepEqual(got, before) {
+		t.Fatalf("adding to a clone changed the original: %+v, was %+v", got, before)
+	}
+	if got, want := clone.LossBursts(), lossBurstsOf(parseLosses("..xx.xxx..")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+// manifest.go - run manifests.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+const modulePath = "github.com/katzenpost/spray"
+
+// Host describes the host a run was made from.
+type Host struct {
+	Hostname  string
+	OS        string
+	Arch      string
+	NumCPU    int
+	GoVersion string
+}
+
+// NewHost returns the description of this host.
+func NewHost() *Host {
+	h := &Host{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+	}
+	h.Hostname, _ = os.Hostname()
+	return h
+}
+
+// Version returns the version of spray built into the running binary,
+// or "unknown" if it was built without module information.
+func Version() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, m := range bi.Deps {
+		if m.Path == modulePath {
+			return m.Version
+		}
+	}
+	return "unknown"
+}
+
+// ManifestDocument identifies the PKI document a run started with.
+type ManifestDocument struct {
+	Epoch  uint64
+	Digest string
+}
+
+// Manifest describes a run, so that its result set is self-describing.
+type Manifest struct {
+	// RunID is the ID of the run, and StartTime the time it started.
+	RunID     string
+	StartTime time.Time
+
+	// Version is the version of spray.
+	Version string
+
+	// Host is the host the run was made from.
+	Host *Host
+
+	// Document is the PKI document the run started with, if any.
+	Document *ManifestDocument `json:",omitempty"`
+
+	// LogFile is the path of the log file the run logs to, shared by
+	// the runs of a Spray, if logging to a file.
+	LogFile string `json:",omitempty"`
+
+	// Config is the resolved configuration of the run.
+	Config json.RawMessage `json:",omitempty"`
+}
+
+// WriteManifest writes the manifest as indented JSON.
+func WriteManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
@@ -0,0 +1,106 @@
+// model.go - latency model comparison
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// ModelTolerance is the factor by which the observed median or 90th
+// percentile latency may differ from the model before an epoch is
+// flagged as diverging.
+const ModelTolerance = 2.0
+
+// ModelComparison compares the latency observed during an epoch with
+// the latency expected from the mixing delay parameters of the epoch's
+// PKI document.
+type ModelComparison struct {
+	// Epoch is the epoch.
+	Epoch uint64
+
+	// Probes is the number of successful probes in the epoch.
+	Probes uint64
+
+	// ModelP50 and ModelP90 are the expected latency percentiles.
+	ModelP50 time.Duration
+	ModelP90 time.Duration
+
+	// ObservedP50 and ObservedP90 are the observed latency percentiles.
+	ObservedP50 time.Duration
+	ObservedP90 time.Duration
+
+	// Diverges is true if the observation differs from the model by
+	// more than ModelTolerance.
+	Diverges bool
+}
+
+// NewModelComparison compares the observed latency histogram of an
+// epoch with the model histogram.
+func NewModelComparison(epoch uint64, model, observed *stats.Histogram) *ModelComparison {
+	return newModelComparison(epoch, model.Percentile(50), model.Percentile(90), observed)
+}
+
+func newModelComparison(epoch uint64, modelP50, modelP90 time.Duration, observed *stats.Histogram) *ModelComparison {
+	c := &ModelComparison{
+		Epoch:       epoch,
+		Probes:      observed.Count(),
+		ModelP50:    modelP50,
+		ModelP90:    modelP90,
+		ObservedP50: observed.Percentile(50),
+		ObservedP90: observed.Percentile(90),
+	}
+	c.Diverges = diverges(c.ModelP50, c.ObservedP50) || diverges(c.ModelP90, c.ObservedP90)
+	return c
+}
+
+func diverges(model, observed time.Duration) bool {
+	if model <= 0 {
+		return observed > 0
+	}
+	ratio := float64(observed) / float64(model)
+	return ratio > ModelTolerance || ratio < 1/ModelTolerance
+}
+
+// String returns a one line summary of the comparison.
+func (c *ModelComparison) String() string {
+	return fmt.Sprintf("epoch %d: %d probes, p50 %v (model %v), p90 %v (model %v)",
+		c.Epoch, c.Probes, c.ObservedP50, c.ModelP50, c.ObservedP90, c.ModelP90)
+}
+
+// mergeModel compares the aggregate latency of every epoch of several
+// runs with the model of the epoch, which is the same for every run as
+// it only depends on the epoch's PKI document.
+func mergeModel(runs []*Run, epochs []*Epoch) []*ModelComparison {
+	models := make(map[uint64]*ModelComparison)
+	for _, r := range runs {
+		for _, c := range r.Model {
+			if _, ok := models[c.Epoch]; !ok {
+				models[c.Epoch] = c
+			}
+		}
+	}
+	var model []*ModelComparison
+	for _, e := range epochs {
+		if m, ok := models[e.Epoch]; ok && e.Latency.Count() > 0 {
+			model = append(model, newModelComparison(e.Epoch, m.ModelP50, m.ModelP90, e.Latency))
+		}
+	}
+	return model
+}
@@ -0,0 +1,54 @@
+// pacing.go - send pacing accuracy
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Pacing is the accuracy of the send pacing, which bounds the noise the
+// client itself adds to the latency measurements.
+type Pacing struct {
+	// Error is the distribution of the delay between the time the rate
+	// limiter scheduled every packet for and the time it was actually
+	// handed to the Provider.
+	Error *stats.Histogram
+}
+
+// String returns a one line summary of the pacing accuracy.
+func (p *Pacing) String() string {
+	return fmt.Sprintf("%d packets, pacing error mean %v p50 %v p99 %v max %v",
+		p.Error.Count(), p.Error.Mean(), p.Error.Percentile(50), p.Error.Percentile(99), p.Error.Max())
+}
+
+// mergePacing returns the aggregate of the pacing accuracy of several
+// runs, or nil if none of the runs has one.
+func mergePacing(runs []*Run) *Pacing {
+	var agg *Pacing
+	for _, r := range runs {
+		if r.Pacing == nil {
+			continue
+		}
+		if agg == nil {
+			agg = &Pacing{Error: stats.NewHistogram()}
+		}
+		agg.Error.Merge(r.Pacing.Error)
+	}
+	return agg
+}
@@ -0,0 +1,66 @@
+// pool.go - precomposed packet pool report
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pool is the accounting of the precomposed packet pool of the burst
+// mode.
+type Pool struct {
+	// Fills is the number of times the pool was composed.
+	Fills uint64
+
+	// Composed is the number of packets composed.
+	Composed uint64
+
+	// Discarded is the number of packets discarded unsent because the
+	// epoch changed.
+	Discarded uint64
+
+	// ComposeTime is the total time spent composing the pool, during
+	// which nothing was sent.
+	ComposeTime time.Duration
+}
+
+// String returns a one line summary of the pool.
+func (p *Pool) String() string {
+	return fmt.Sprintf("%d fills, %d composed, %d discarded, %v composing",
+		p.Fills, p.Composed, p.Discarded, p.ComposeTime)
+}
+
+// mergePools returns the aggregate of the packet pool accounting of
+// several runs, or nil if none of the runs has one.
+func mergePools(runs []*Run) *Pool {
+	var agg *Pool
+	for _, r := range runs {
+		p := r.Pool
+		if p == nil {
+			continue
+		}
+		if agg == nil {
+			agg = new(Pool)
+		}
+		agg.Fills += p.Fills
+		agg.Composed += p.Composed
+		agg.Discarded += p.Discarded
+		agg.ComposeTime += p.ComposeTime
+	}
+	return agg
+}
@@ -0,0 +1,338 @@
+// report.go - run reports
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package report implements the various output formats of spray's run
+// reports.
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Outcomes of a single probe.
+const (
+	OutcomeOK      = "ok"
+	OutcomeLost    = "lost"
+	OutcomeCorrupt = "corrupt"
+	OutcomeFailed  = "failed"
+)
+
+// Probe is the result of a single request/response exchange.
+type Probe struct {
+	// Seq is the sequence number of the probe within the run.
+	Seq uint64
+
+	// Timestamp is the time the probe was sent.
+	Timestamp time.Time
+
+	// Epoch is the epoch the probe was sent in.
+	Epoch uint64
+
+	// Latency is the time it took for the reply to arrive.  One way
+	// latency is corrected for the clock skew of the hosts involved.
+	Latency time.Duration
+
+	// RawLatency is the one way latency before clock skew correction.
+	// Round trip probes are timed by a single clock and do not set it.
+	RawLatency time.Duration `json:",omitempty"`
+
+	// BytesOut is the size of the request payload.
+	BytesOut uint64
+
+	// BytesIn is the size of the reply payload.
+	BytesIn uint64
+
+	// Outcome is the outcome of the probe.
+	Outcome string
+
+	// Error is the error encountered, if any.
+	Error string
+
+	// Vantage is the vantage point the probe was sent from, if
+	// configured.
+	Vantage *Vantage `json:",omitempty"`
+
+	// Hops is the number of hops of the probe's path, including both
+	// Providers.
+	Hops int `json:",omitempty"`
+
+	// Generation is the connection generation the probe was sent in,
+	// counting the connections to the Provider from one.
+	Generation int `json:",omitempty"`
+
+	// ReplyHops is the number of hops of the SURB reply path of the
+	// probe, if it was given one distinct from the forward path.
+	ReplyHops int `json:",omitempty"`
+
+	// Provider and Recipient are the target of the probe, if it was
+	// sent to a specific recipient.
+	Provider  string `json:",omitempty"`
+	Recipient string `json:",omitempty"`
+
+	// SURBID is the hex encoded ID of the SURB the reply to the probe
+	// was sent with, if any.
+	SURBID string `json:",omitempty"`
+
+	// TargetSeq is the sequence number of the probe among the probes
+	// sent to the same target.
+	TargetSeq uint64 `json:",omitempty"`
+
+	// Params are the mix parameters of the epoch the probe was sent in,
+	// if its PKI document was seen.  Together with Hops they allow the
+	// latency to be normalized across parameter changes.
+	Params *Params `json:",omitempty"`
+}
+
+// Run is the report of a single measurement run.
+type Run struct {
+	// ID identifies the run among the runs of a Spray.
+	ID string `json:",omitempty"`
+
+	// Labels is a free form description of the run.
+	Labels string
+
+	// Vantage is the vantage point of the run, if configured.
+	Vantage *Vantage `json:",omitempty"`
+
+	// Mode is the configured load generation mode.
+	Mode string
+
+	// StartTime is the time the run started.
+	StartTime time.Time
+
+	// Duration is the duration of the run.
+	Duration time.Duration
+
+	// RequestedQPS is the configured send rate in packets per second.
+	RequestedQPS float64
+
+	// Sent is the number of packets sent.
+	Sent uint64
+
+	// Outcomes is the number of probes per outcome.
+	Outcomes map[string]uint64
+
+	// Errors is the number of failed probes per error message.
+	Errors map[string]uint64 `json:",omitempty"`
+
+	// Latency is the probe latency histogram.
+	Latency *stats.Histogram
+
+	// RawLatency is the one way latency histogram before clock skew
+	// correction, if any one way probes were received.
+	RawLatency *stats.Histogram `json:",omitempty"`
+
+	// PKIFetch is the latency histogram of the PKI document fetches
+	// from the authority, which the sessions of every account share.
+	PKIFetch *stats.Histogram `json:",omitempty"`
+
+	// Disconnects is the number of times the session was deliberately
+	// disconnected from the Provider.
+	Disconnects uint64
+
+	// Offline is the total time spent disconnected.
+	Offline time.Duration
+
+	// ClockSkew is the largest absolute clock skew versus the Provider
+	// measured during the run.
+	ClockSkew time.Duration `json:",omitempty"`
+
+	// SkewExceeded is set if the clock skew exceeded the configured
+	// bound, making the latencies of the run unreliable.
+	SkewExceeded bool `json:",omitempty"`
+
+	// ConnectionLosses is the number of times the connection to the
+	// Provider was lost unexpectedly.
+	ConnectionLosses uint64
+
+	// Reconnecting is the total time load generation was paused while
+	// recovering lost connections.
+	Reconnecting time.Duration
+
+	// Heatmap is the latency distribution over time, if enabled.
+	Heatmap *Heatmap `json:",omitempty"`
+
+	// Hops is the latency per number of hops, if the number of hops
+	// was varied during the run.
+	Hops []*HopLatency `json:",omitempty"`
+
+	// Generations is the accounting of every connection generation, in
+	// order, if the connection to the Provider was reestablished during
+	// the run.
+	Generations []*Generation `json:",omitempty"`
+
+	// LossBursts characterizes the temporal pattern of the losses.
+	LossBursts *LossBursts `json:",omitempty"`
+
+	// Targets is the accounting of every target, if probes were sent
+	// to more than one.
+	Targets []*Target `json:",omitempty"`
+
+	// Epochs is the accounting of every epoch of the run, in epoch
+	// order.
+	Epochs []*Epoch `json:",omitempty"`
+
+	// Model compares the observed latency of every epoch with the
+	// latency expected from the epoch's mixing delay parameters.
+	Model []*ModelComparison
+
+	// Pacing is the accuracy of the send pacing, if any rate limited
+	// packets were sent.
+	Pacing *Pacing `json:",omitempty"`
+
+	// Calibration is the measured send cadence of the host, if
+	// calibration was enabled.
+	Calibration *Calibration `json:",omitempty"`
+
+	// Bottleneck attributes the achieved send rate to the limiting stage
+	// of the send pipeline, if any packets were sent by it.
+	Bottleneck *Bottleneck
+
+	// Compose is the result of a compose-only dry run.
+	Compose *Compose
+
+	// Loops are the decoy loop statistics, if decoy loops were sent.
+	Loops *Loops
+
+	// Sequence is the sequence accounting of the stamped probes
+	// received, in the mailbox receiver mode.
+	Sequence *Sequence `json:",omitempty"`
+
+	// Drain is the result of draining our own spool, in the drain mode.
+	Drain *Drain `json:",omitempty"`
+
+	// Pool is the accounting of the precomposed packet pool, in the
+	// burst mode.
+	Pool *Pool `json:",omitempty"`
+
+	// CrossChecks are the cross-checks of the documents served by the
+	// authorities, in epoch order, in the crosscheck mode.
+	CrossChecks []*CrossCheck `json:",omitempty"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func WriteJSON(w io.Writer, r *Run) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteFile writes the report to the named file with the provided
+// writer function.
+func WriteFile(f string, r *Run, fn func(io.Writer, *Run) error) error {
+	out, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = fn(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Merge returns the aggregate of several runs, for example those of the
+// clients of a fleet or the sessions of several accounts.  The
+// calibration and the cross-checks, which are properties of the host and
+// of the authorities, are those of the first run that has them.
+func Merge(runs ...*Run) *Run {
+	agg := &Run{
+		Outcomes: make(map[string]uint64),
+		Latency:  stats.NewHistogram(),
+	}
+	var end time.Time
+	for i, r := range runs {
+		if agg.StartTime.IsZero() || r.StartTime.Before(agg.StartTime) {
+			agg.StartTime = r.StartTime
+		}
+		if rEnd := r.StartTime.Add(r.Duration); rEnd.After(end) {
+			end = rEnd
+		}
+		if agg.Mode == "" {
+			agg.Mode = r.Mode
+		} else if agg.Mode != r.Mode {
+			agg.Mode = "mixed"
+		}
+		// Only runs from a single vantage point keep it.
+		if i == 0 {
+			agg.Vantage = r.Vantage
+		} else if agg.Vantage != nil && (r.Vantage == nil || *agg.Vantage != *r.Vantage) {
+			agg.Vantage = nil
+		}
+		agg.RequestedQPS += r.RequestedQPS
+		agg.Sent += r.Sent
+		for k, v := range r.Outcomes {
+			agg.Outcomes[k] += v
+		}
+		for k, v := range r.Errors {
+			if agg.Errors == nil {
+				agg.Errors = make(map[string]uint64)
+			}
+			agg.Errors[k] += v
+		}
+		agg.Latency.Merge(r.Latency)
+		if r.RawLatency != nil {
+			if agg.RawLatency == nil {
+				agg.RawLatency = stats.NewHistogram()
+			}
+			agg.RawLatency.Merge(r.RawLatency)
+		}
+		if agg.PKIFetch == nil {
+			agg.PKIFetch = r.PKIFetch
+		}
+		agg.Disconnects += r.Disconnects
+		agg.Offline += r.Offline
+		if r.ClockSkew > agg.ClockSkew {
+			agg.ClockSkew = r.ClockSkew
+		}
+		agg.SkewExceeded = agg.SkewExceeded || r.SkewExceeded
+		agg.ConnectionLosses += r.ConnectionLosses
+		agg.Reconnecting += r.Reconnecting
+		if agg.Calibration == nil {
+			agg.Calibration = r.Calibration
+		}
+		if agg.CrossChecks == nil {
+			agg.CrossChecks = r.CrossChecks
+		}
+	}
+	agg.Duration = end.Sub(agg.StartTime)
+	agg.Epochs = mergeEpochs(runs)
+	if generations := mergeGenerations(runs); len(generations) > 1 {
+		agg.Generations = generations
+	}
+	if targets := mergeTargets(runs); len(targets) > 1 {
+		agg.Targets = targets
+	}
+	if hops := mergeHops(runs); len(hops) > 1 {
+		agg.Hops = hops
+	}
+	agg.Model = mergeModel(runs, agg.Epochs)
+	agg.Heatmap = mergeHeatmaps(runs)
+	agg.LossBursts = mergeLossBursts(runs)
+	agg.Pacing = mergePacing(runs)
+	agg.Bottleneck = mergeBottlenecks(runs)
+	agg.Compose = mergeCompose(runs)
+	agg.Loops = mergeLoops(runs)
+	agg.Sequence = mergeSequences(runs)
+	agg.Drain = mergeDrains(runs)
+	agg.Pool = mergePools(runs)
+	return agg
+}
@@ -0,0 +1,101 @@
+// report_test.go - report tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// testRun returns a run started at start, with a probe of the given
+// latency per heatmap row and the given loss sequence.
+func testRun(start time.Time, latency time.Duration, rows int, lost []bool) *Run {
+	r := &Run{
+		StartTime:  start,
+		Outcomes:   make(map[string]uint64),
+		Latency:    stats.NewHistogram(),
+		Heatmap:    NewHeatmap(start, time.Second),
+		LossBursts: NewLossBursts(lost),
+		Pacing:     &Pacing{Error: stats.NewHistogram()},
+		Bottleneck: &Bottleneck{AchievedRate: 10, CryptoRate: 100, LimiterRate: 10, WireRate: 50},
+		Sequence:   &Sequence{Expected: 10, Received: 9, Missing: 1},
+		Loops:      &Loops{Sent: 4, Lost: 1, RTT: stats.NewHistogram(), Epochs: []*LoopEpoch{{Epoch: 1, Sent: 4, Lost: 1}}},
+	}
+	for i := 0; i < rows; i++ {
+		r.Latency.Record(latency)
+		r.Heatmap.Record(start.Add(time.Duration(i)*time.Second), latency)
+	}
+	r.Pacing.Error.Record(time.Millisecond)
+	r.Epochs = []*Epoch{NewEpoch(1)}
+	r.Epochs[0].Latency.Merge(r.Latency)
+	model := stats.NewHistogram()
+	model.Record(latency)
+	r.Model = []*ModelComparison{NewModelComparison(1, model, r.Epochs[0].Latency)}
+	return r
+}
+
+func TestMerge(t *testing.T) {
+	start := time.Unix(1000, 0)
+	a := testRun(start, 3*time.Millisecond, 2, []bool{false, true, true, false})
+	b := testRun(start.Add(time.Second), 300*time.Millisecond, 2, []bool{false, false, true, false})
+	agg := Merge(a, b)
+
+	if h := agg.Heatmap; h == nil || !h.Start.Equal(start) || len(h.Rows) != 3 {
+		t.Fatalf("heatmap not realigned to the earliest start: %+v", h)
+	}
+	for i, want := range []uint64{1, 2, 1} {
+		var n uint64
+		for _, c := range agg.Heatmap.Rows[i] {
+			n += c
+		}
+		if n != want {
+			t.Errorf("heatmap row %d has %d probes, want %d", i, n, want)
+		}
+	}
+
+	l := agg.LossBursts
+	if l == nil || l.Probes != 8 || l.Losses != 3 || l.Bursts != 2 || l.MaxBurst != 2 || l.BurstLengths[1] != 1 || l.BurstLengths[2] != 1 {
+		t.Fatalf("loss bursts not merged: %+v", l)
+	}
+	if l.MeanBurst != 1.5 {
+		t.Errorf("mean burst %v, want 1.5", l.MeanBurst)
+	}
+
+	if agg.Pacing == nil || agg.Pacing.Error.Count() != 2 {
+		t.Errorf("pacing not merged: %+v", agg.Pacing)
+	}
+	if b := agg.Bottleneck; b == nil || b.AchievedRate != 20 || b.Stage != StageLimit {
+		t.Errorf("bottleneck not merged: %+v", b)
+	}
+	if s := agg.Sequence; s == nil || s.Expected != 20 || s.Missing != 2 {
+		t.Errorf("sequence not merged: %+v", s)
+	}
+	if lo := agg.Loops; lo == nil || lo.Sent != 8 || len(lo.Epochs) != 1 || lo.Epochs[0].Lost != 2 {
+		t.Errorf("loops not merged: %+v", lo)
+	}
+	if len(agg.Model) != 1 || agg.Model[0].Probes != 4 {
+		t.Fatalf("model not recomputed from the merged epochs: %+v", agg.Model)
+	}
+	if agg.Model[0].ModelP50 != a.Model[0].ModelP50 {
+		t.Errorf("model latency %v is not that of the epoch's document %v", agg.Model[0].ModelP50, a.Model[0].ModelP50)
+	}
+	if agg.Drain != nil || agg.Pool != nil || agg.Compose != nil {
+		t.Errorf("sections absent from every run were merged")
+	}
+}
@@ -0,0 +1,88 @@
+// sequence.go - probe sequence accounting
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+)
+
+// Sequence is the receive side accounting of the sequence numbers of
+// the stamped probes, which tells loss apart from reordering and
+// duplication without any knowledge of what the sender sent.
+type Sequence struct {
+	// Expected is the number of probes up to the highest sequence
+	// number received.
+	Expected uint64
+
+	// Received is the number of distinct probes received.
+	Received uint64
+
+	// Missing is the number of sequence numbers skipped over that are
+	// yet to be received, which are lost unless they are late.
+	Missing uint64
+
+	// Reordered is the number of probes received after a probe with a
+	// higher sequence number.
+	Reordered uint64
+
+	// Duplicates is the number of probes received more than once.
+	Duplicates uint64
+}
+
+// LossRate returns the fraction of the expected probes that are missing.
+func (s *Sequence) LossRate() float64 {
+	if s.Expected == 0 {
+		return 0
+	}
+	return float64(s.Missing) / float64(s.Expected)
+}
+
+// ReorderRate returns the fraction of the received probes that arrived
+// out of order.
+func (s *Sequence) ReorderRate() float64 {
+	if s.Received == 0 {
+		return 0
+	}
+	return float64(s.Reordered) / float64(s.Received)
+}
+
+// String returns a one line summary of the sequence accounting.
+func (s *Sequence) String() string {
+	return fmt.Sprintf("%d of %d expected received, %d missing (%.2f%%), %d reordered (%.2f%%), %d duplicates",
+		s.Received, s.Expected, s.Missing, 100*s.LossRate(), s.Reordered, 100*s.ReorderRate(), s.Duplicates)
+}
+
+// mergeSequences returns the aggregate of the sequence accounting of
+// several runs, or nil if none of the runs has one.
+func mergeSequences(runs []*Run) *Sequence {
+	var agg *Sequence
+	for _, r := range runs {
+		s := r.Sequence
+		if s == nil {
+			continue
+		}
+		if agg == nil {
+			agg = new(Sequence)
+		}
+		agg.Expected += s.Expected
+		agg.Received += s.Received
+		agg.Missing += s.Missing
+		agg.Reordered += s.Reordered
+		agg.Duplicates += s.Duplicates
+	}
+	return agg
+}
@@ -0,0 +1,229 @@
+// slo.go - service level objective evaluation
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sloOperators = []string{"<=", ">=", "<", ">"}
+
+// sloClause is a single "<metric><operator><threshold>" objective.
+type sloClause struct {
+	text      string
+	metric    string
+	op        string
+	threshold float64
+
+	// percentile is set for latency percentile metrics.
+	percentile float64
+}
+
+// SLO is a set of service level objectives, all of which a run must
+// meet to pass.
+type SLO struct {
+	clauses []*sloClause
+}
+
+// ParseSLO parses a comma separated list of objectives such as
+// "p99<30s,loss<1%".  The supported metrics are:
+//
+//	pN       the Nth latency percentile, e.g. p50, p99 or p99.9
+//	mean     the mean latency
+//	max      the maximum latency
+//	loss     the percentage of probes lost
+//	corrupt  the percentage of probes with corrupted replies
+//	failed   the percentage of probes that failed otherwise
+//	sent     the number of packets sent
+//
+// Latency thresholds are durations ("30s", "250ms"), loss, corrupt and
+// failed thresholds are percentages with an optional "%" suffix, and the
+// operator is one of <, <=, > or >=.
+func ParseSLO(spec string) (*SLO, error) {
+	s := new(SLO)
+	for _, text := range strings.Split(spec, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		c, err := parseSLOClause(text)
+		if err != nil {
+			return nil, fmt.Errorf("slo: '%v': %v", text, err)
+		}
+		s.clauses = append(s.clauses, c)
+	}
+	if len(s.clauses) == 0 {
+		return nil, errors.New("slo: no objectives")
+	}
+	return s, nil
+}
+
+func parseSLOClause(text string) (*sloClause, error) {
+	c := &sloClause{text: text}
+	idx := -1
+	for _, op := range sloOperators {
+		if idx = strings.Index(text, op); idx > 0 {
+			c.op = op
+			break
+		}
+	}
+	if c.op == "" {
+		return nil, errors.New("missing operator")
+	}
+	c.metric = strings.TrimSpace(text[:idx])
+	value := strings.TrimSpace(text[idx+len(c.op):])
+
+	var err error
+	switch {
+	case c.metric == "mean" || c.metric == "max":
+		err = c.parseDuration(value)
+	case strings.HasPrefix(c.metric, "p"):
+		if c.percentile, err = strconv.ParseFloat(c.metric[1:], 64); err != nil || c.percentile <= 0 || c.percentile > 100 {
+			return nil, errors.New("invalid percentile")
+		}
+		err = c.parseDuration(value)
+	case c.metric == OutcomeLost || c.metric == "loss" || c.metric == OutcomeCorrupt || c.metric == OutcomeFailed:
+		c.threshold, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	case c.metric == "sent":
+		c.threshold, err = strconv.ParseFloat(value, 64)
+	default:
+		return nil, fmt.Errorf("unknown metric '%v'", c.metric)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold '%v'", value)
+	}
+	return c, nil
+}
+
+func (c *sloClause) parseDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	c.threshold = float64(d)
+	return err
+}
+
+// observe returns the observed value of the metric in r, and its string
+// representation.
+func (c *sloClause) observe(r *Run) (float64, string, error) {
+	var probes uint64
+	for _, n := range r.Outcomes {
+		probes += n
+	}
+	switch c.metric {
+	case "sent":
+		return float64(r.Sent), fmt.Sprintf("%d", r.Sent), nil
+	case "loss", OutcomeLost, OutcomeCorrupt, OutcomeFailed:
+		if probes == 0 {
+			return 0, "", errors.New("no probes")
+		}
+		outcome := c.metric
+		if outcome == "loss" {
+			outcome = OutcomeLost
+		}
+		pct := 100 * float64(r.Outcomes[outcome]) / float64(probes)
+		return pct, fmt.Sprintf("%.3g%%", pct), nil
+	}
+
+	if r.Latency == nil || r.Latency.Count() == 0 {
+		return 0, "", errors.New("no latency measurements")
+	}
+	var d time.Duration
+	switch c.metric {
+	case "mean":
+		d = r.Latency.Mean()
+	case "max":
+		d = r.Latency.Max()
+	default:
+		d = r.Latency.Percentile(c.percentile)
+	}
+	return float64(d), d.String(), nil
+}
+
+func (c *sloClause) evaluate(r *Run) *SLOResult {
+	res := &SLOResult{Objective: c.text}
+	v, s, err := c.observe(r)
+	if err != nil {
+		res.Observed = err.Error()
+		return res
+	}
+	res.Observed = s
+	switch c.op {
+	case "<":
+		res.Pass = v < c.threshold
+	case "<=":
+		res.Pass = v <= c.threshold
+	case ">":
+		res.Pass = v > c.threshold
+	case ">=":
+		res.Pass = v >= c.threshold
+	}
+	return res
+}
+
+// SLOResult is the result of evaluating a single objective.
+type SLOResult struct {
+	// Objective is the objective as specified.
+	Objective string
+
+	// Observed is the observed value, or why it could not be observed.
+	Observed string
+
+	// Pass is true if the objective was met.
+	Pass bool
+}
+
+// Verdict is the result of evaluating an SLO against a run.
+type Verdict struct {
+	// Pass is true if every objective was met.
+	Pass bool
+
+	// Results are the per objective results.
+	Results []*SLOResult
+}
+
+// Evaluate evaluates the SLO against the run.  Objectives that can not
+// be evaluated, such as latency objectives of a run without any replies,
+// fail.
+func (s *SLO) Evaluate(r *Run) *Verdict {
+	v := &Verdict{Pass: true}
+	for _, c := range s.clauses {
+		res := c.evaluate(r)
+		v.Pass = v.Pass && res.Pass
+		v.Results = append(v.Results, res)
+	}
+	return v
+}
+
+// String returns a one line summary of the verdict.
+func (v *Verdict) String() string {
+	verdict := "PASS"
+	if !v.Pass {
+		verdict = "FAIL"
+	}
+	parts := make([]string, 0, len(v.Results))
+	for _, res := range v.Results {
+		status := "ok"
+		if !res.Pass {
+			status = "FAILED"
+		}
+		parts = append(parts, fmt.Sprintf("%s (observed %s): %s", res.Objective, res.Observed, status))
+	}
+	return fmt.Sprintf("SLO %s: %s", verdict, strings.Join(parts, ", "))
+}
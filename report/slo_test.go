@@ -0,0 +1,125 @@
+// slo_test.go - SLO tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+func TestParseSLO(t *testing.T) {
+	tests := []struct {
+		spec    string
+		clauses []sloClause
+		err     bool
+	}{
+		{spec: "p99<30s", clauses: []sloClause{{metric: "p99", op: "<", threshold: float64(30 * time.Second), percentile: 99}}},
+		{spec: "p99.9 <= 250ms", clauses: []sloClause{{metric: "p99.9", op: "<=", threshold: float64(250 * time.Millisecond), percentile: 99.9}}},
+		{spec: "mean<1s, max>=2s", clauses: []sloClause{
+			{metric: "mean", op: "<", threshold: float64(time.Second)},
+			{metric: "max", op: ">=", threshold: float64(2 * time.Second)},
+		}},
+		{spec: "loss<1%,corrupt<=0.5,failed<2%", clauses: []sloClause{
+			{metric: "loss", op: "<", threshold: 1},
+			{metric: OutcomeCorrupt, op: "<=", threshold: 0.5},
+			{metric: OutcomeFailed, op: "<", threshold: 2},
+		}},
+		{spec: "lost<1", clauses: []sloClause{{metric: OutcomeLost, op: "<", threshold: 1}}},
+		{spec: "sent>1000,", clauses: []sloClause{{metric: "sent", op: ">", threshold: 1000}}},
+		{spec: "", err: true},
+		{spec: " , ", err: true},
+		{spec: "p99", err: true},
+		{spec: "<30s", err: true},
+		{spec: "p0<30s", err: true},
+		{spec: "p101<30s", err: true},
+		{spec: "pfoo<30s", err: true},
+		{spec: "p99<30", err: true},
+		{spec: "loss<one", err: true},
+		{spec: "sent>1k", err: true},
+		{spec: "jitter<1s", err: true},
+		{spec: "p99<30s,loss", err: true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSLO(tt.spec)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSLO(%q) did not fail", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSLO(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if len(s.clauses) != len(tt.clauses) {
+			t.Errorf("ParseSLO(%q) returned %d objectives, want %d", tt.spec, len(s.clauses), len(tt.clauses))
+			continue
+		}
+		for i, c := range s.clauses {
+			want := tt.clauses[i]
+			if c.metric != want.metric || c.op != want.op || c.threshold != want.threshold || c.percentile != want.percentile {
+				t.Errorf("ParseSLO(%q) objective %d is %+v, want %+v", tt.spec, i, *c, want)
+			}
+		}
+	}
+}
+
+func TestSLOEvaluate(t *testing.T) {
+	r := &Run{
+		Sent:     100,
+		Outcomes: map[string]uint64{OutcomeOK: 98, OutcomeLost: 2},
+		Latency:  stats.NewHistogram(),
+	}
+	for i := 1; i <= 10; i++ {
+		r.Latency.Record(time.Duration(i) * time.Second)
+	}
+	tests := []struct {
+		spec string
+		pass bool
+	}{
+		{"max<=10s", true},
+		{"max<10s", false},
+		{"p50<1s", false},
+		{"loss<5%", true},
+		{"loss<1%", false},
+		{"corrupt<=0", true},
+		{"sent>=100", true},
+		{"sent>100", false},
+		{"loss<5%,max<10s", false},
+	}
+	for _, tt := range tests {
+		s, err := ParseSLO(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseSLO(%q) failed: %v", tt.spec, err)
+		}
+		if v := s.Evaluate(r); v.Pass != tt.pass {
+			t.Errorf("%q: %v, want pass %v", tt.spec, v, tt.pass)
+		}
+	}
+
+	s, err := ParseSLO("p99<30s,loss<1%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := &Run{Outcomes: make(map[string]uint64), Latency: stats.NewHistogram()}
+	v := s.Evaluate(empty)
+	if v.Pass || len(v.Results) != 2 || v.Results[0].Pass || v.Results[1].Pass {
+		t.Errorf("objectives of a run without probes did not fail: %v", v)
+	}
+}
@@ -0,0 +1,133 @@
+// summary.go - end of run JSON summary.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// SummaryLatency is the latency distribution of a Summary.
+type SummaryLatency struct {
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+	Max  time.Duration
+}
+
+func newSummaryLatency(h *stats.Histogram) SummaryLatency {
+	return SummaryLatency{
+		Min:  h.Min(),
+		Mean: h.Mean(),
+		P50:  h.Percentile(50),
+		P90:  h.Percentile(90),
+		P99:  h.Percentile(99),
+		P999: h.Percentile(99.9),
+		Max:  h.Max(),
+	}
+}
+
+// Summary is the machine readable summary of a run, written when the
+// run ends for consumption by CI pipelines.
+type Summary struct {
+	// ID, Labels and Mode are those of the run.
+	ID     string `json:",omitempty"`
+	Labels string
+	Mode   string
+
+	// StartTime is the time the run started, and Duration its duration.
+	StartTime time.Time
+	Duration  time.Duration
+
+	// Sent is the number of packets sent, and Received the number of
+	// probes answered, including the corrupt ones.
+	Sent     uint64
+	Received uint64
+
+	// Lost is the number of lost probes, and LossRate their fraction of
+	// the probes.
+	Lost     uint64
+	LossRate float64
+
+	// Latency is the latency of the successful probes.
+	Latency SummaryLatency
+
+	// PKIFetch is the latency of the PKI document fetches, if any.
+	PKIFetch *SummaryLatency `json:",omitempty"`
+
+	// Outcomes is the number of probes per outcome, and Errors the
+	// number of probes per error.
+	Outcomes map[string]uint64
+	Errors   map[string]uint64 `json:",omitempty"`
+
+	// SkewExceeded is set if the clock skew versus the Provider
+	// exceeded the configured bound, making the latencies unreliable.
+	SkewExceeded bool `json:",omitempty"`
+
+	// Verdict is the SLO verdict, if an SLO was configured.
+	Verdict *Verdict `json:",omitempty"`
+
+	// Config is the configuration of the run.
+	Config json.RawMessage `json:",omitempty"`
+}
+
+// NewSummary returns the summary of the run r, with the SLO verdict v,
+// which may be nil, and the configuration snapshot cfg, which may be
+// empty.
+func NewSummary(r *Run, v *Verdict, cfg json.RawMessage) *Summary {
+	s := &Summary{
+		ID:           r.ID,
+		Labels:       r.Labels,
+		Mode:         r.Mode,
+		StartTime:    r.StartTime,
+		Duration:     r.Duration,
+		Sent:         r.Sent,
+		Received:     r.Outcomes[OutcomeOK] + r.Outcomes[OutcomeCorrupt],
+		Lost:         r.Outcomes[OutcomeLost],
+		Latency:      newSummaryLatency(r.Latency),
+		Outcomes:     r.Outcomes,
+		Errors:       r.Errors,
+		SkewExceeded: r.SkewExceeded,
+		Verdict:      v,
+		Config:       cfg,
+	}
+	if r.PKIFetch != nil {
+		l := newSummaryLatency(r.PKIFetch)
+		s.PKIFetch = &l
+	}
+	var n uint64
+	for _, v := range r.Outcomes {
+		n += v
+	}
+	if n > 0 {
+		s.LossRate = float64(s.Lost) / float64(n)
+	}
+	return s
+}
+
+// WriteSummary writes the summary as indented JSON.
+func WriteSummary(w io.Writer, s *Summary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,113 @@
+// sweep.go - aggregates of repeated runs.
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Spread is the mean and the sample standard deviation of a value across
+// the runs of a sweep.
+type Spread struct {
+	Mean   float64
+	StdDev float64
+}
+
+func newSpread(v []float64) Spread {
+	var s Spread
+	if len(v) == 0 {
+		return s
+	}
+	for _, x := range v {
+		s.Mean += x
+	}
+	s.Mean /= float64(len(v))
+	if len(v) > 1 {
+		var ss float64
+		for _, x := range v {
+			ss += (x - s.Mean) * (x - s.Mean)
+		}
+		s.StdDev = math.Sqrt(ss / float64(len(v)-1))
+	}
+	return s
+}
+
+func (s Spread) durationString() string {
+	return fmt.Sprintf("%v±%v", time.Duration(s.Mean).Round(time.Microsecond), time.Duration(s.StdDev).Round(time.Microsecond))
+}
+
+// Sweep is the aggregate of the runs of a seeded sweep, the same scenario
+// repeated with a different seed every run.  The latency spreads are in
+// nanoseconds.
+type Sweep struct {
+	// Seeds are the seeds of the runs, and Runs their reports.
+	Seeds []int64
+	Runs  []*Run
+
+	Sent     Spread
+	LossRate Spread
+	P50      Spread
+	P90      Spread
+	P99      Spread
+}
+
+// NewSweep returns the aggregate of the runs made with the given seeds.
+func NewSweep(seeds []int64, runs []*Run) *Sweep {
+	var sent, loss, p50, p90, p99 []float64
+	for _, r := range runs {
+		sent = append(sent, float64(r.Sent))
+		var n uint64
+		for _, v := range r.Outcomes {
+			n += v
+		}
+		if n > 0 {
+			loss = append(loss, float64(r.Outcomes[OutcomeLost])/float64(n))
+		}
+		if r.Latency.Count() > 0 {
+			p50 = append(p50, float64(r.Latency.Percentile(50)))
+			p90 = append(p90, float64(r.Latency.Percentile(90)))
+			p99 = append(p99, float64(r.Latency.Percentile(99)))
+		}
+	}
+	return &Sweep{
+		Seeds:    seeds,
+		Runs:     runs,
+		Sent:     newSpread(sent),
+		LossRate: newSpread(loss),
+		P50:      newSpread(p50),
+		P90:      newSpread(p90),
+		P99:      newSpread(p99),
+	}
+}
+
+// String returns a one line summary of the sweep.
+func (s *Sweep) String() string {
+	return fmt.Sprintf("sweep of %d runs: %.0f±%.0f sent, loss %.2f%%±%.2f%%, latency p50 %v p90 %v p99 %v",
+		len(s.Runs), s.Sent.Mean, s.Sent.StdDev, 100*s.LossRate.Mean, 100*s.LossRate.StdDev,
+		s.P50.durationString(), s.P90.durationString(), s.P99.durationString())
+}
+
+// WriteSweep writes the sweep as indented JSON.
+func WriteSweep(w io.Writer, s *Sweep) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
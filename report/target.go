@@ -0,0 +1,120 @@
+// target.go - per-target report sections
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/katzenpost/spray/stats"
+)
+
+// Target is the accounting of the probes sent to a single (provider,
+// recipient) pair, kept apart so that a slow target does not pollute
+// the statistics of the others.
+type Target struct {
+	// Provider is the Provider of the recipient.
+	Provider string
+
+	// Recipient is the recipient.
+	Recipient string
+
+	// Outcomes is the number of probes per outcome.
+	Outcomes map[string]uint64
+
+	// Latency is the latency histogram of the successful probes.
+	Latency *stats.Histogram
+
+	// Skipped is the number of schedule phases the target was skipped
+	// for, as its Provider had vanished from the PKI document.
+	Skipped int `json:",omitempty"`
+}
+
+// NewTarget returns a new empty Target.
+func NewTarget(provider, recipient string) *Target {
+	return &Target{
+		Provider:  provider,
+		Recipient: recipient,
+		Outcomes:  make(map[string]uint64),
+		Latency:   stats.NewHistogram(),
+	}
+}
+
+// Probes returns the number of probes sent to the target.
+func (t *Target) Probes() uint64 {
+	var n uint64
+	for _, v := range t.Outcomes {
+		n += v
+	}
+	return n
+}
+
+// LossRate returns the fraction of the probes that were lost.
+func (t *Target) LossRate() float64 {
+	n := t.Probes()
+	if n == 0 {
+		return 0
+	}
+	return float64(t.Outcomes[OutcomeLost]) / float64(n)
+}
+
+// String returns a one line summary of the target.
+func (t *Target) String() string {
+	s := fmt.Sprintf("%s@%s: %d probes, %d ok, %d lost (%.2f%%), latency p50 %v p99 %v max %v",
+		t.Recipient, t.Provider, t.Probes(), t.Outcomes[OutcomeOK], t.Outcomes[OutcomeLost], 100*t.LossRate(),
+		t.Latency.Percentile(50), t.Latency.Percentile(99), t.Latency.Max())
+	if t.Skipped > 0 {
+		s += fmt.Sprintf(", skipped for %d phases", t.Skipped)
+	}
+	return s
+}
+
+// SortTargets sorts targets by Provider and recipient.
+func SortTargets(targets []*Target) {
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Provider != targets[j].Provider {
+			return targets[i].Provider < targets[j].Provider
+		}
+		return targets[i].Recipient < targets[j].Recipient
+	})
+}
+
+// mergeTargets returns the aggregate of the per-target accounting of
+// several runs.
+func mergeTargets(runs []*Run) []*Target {
+	type key struct{ provider, recipient string }
+	m := make(map[key]*Target)
+	var targets []*Target
+	for _, r := range runs {
+		for _, t := range r.Targets {
+			k := key{t.Provider, t.Recipient}
+			agg, ok := m[k]
+			if !ok {
+				agg = NewTarget(t.Provider, t.Recipient)
+				m[k] = agg
+				targets = append(targets, agg)
+			}
+			for o, v := range t.Outcomes {
+				agg.Outcomes[o] += v
+			}
+			agg.Latency.Merge(t.Latency)
+			agg.Skipped += t.Skipped
+		}
+	}
+	SortTargets(targets)
+	return targets
+}
@@ -0,0 +1,124 @@
+// timeseries.go - per-probe time series export
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// TimeSeriesCSV is the CSV encoding, with a header row.
+	TimeSeriesCSV = "csv"
+
+	// TimeSeriesNDJSON is the newline delimited JSON encoding.
+	TimeSeriesNDJSON = "ndjson"
+)
+
+var timeSeriesHeader = []string{
+	"send_time", "surb_id", "provider", "recipient", "ack_time", "latency_ns", "outcome",
+}
+
+type timeSeriesRecord struct {
+	SendTime  time.Time  `json:"send_time"`
+	SURBID    string     `json:"surb_id,omitempty"`
+	Provider  string     `json:"provider,omitempty"`
+	Recipient string     `json:"recipient,omitempty"`
+	AckTime   *time.Time `json:"ack_time,omitempty"`
+	Latency   int64      `json:"latency_ns"`
+	Outcome   string     `json:"outcome"`
+}
+
+// TimeSeriesEncoder encodes a record per probe, with its send time, SURB
+// ID, target, the time its reply was received and its latency and
+// outcome, for importing the results into tools such as pandas or
+// Grafana.  The ack time and latency are only set for successful probes.
+type TimeSeriesEncoder struct {
+	bw   *bufio.Writer
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// NewTimeSeriesEncoder returns a TimeSeriesEncoder writing to w in the
+// given format.
+func NewTimeSeriesEncoder(w io.Writer, format string) (*TimeSeriesEncoder, error) {
+	e := &TimeSeriesEncoder{
+		bw: bufio.NewWriter(w),
+	}
+	switch format {
+	case TimeSeriesCSV, "":
+		e.csv = csv.NewWriter(e.bw)
+		if err := e.csv.Write(timeSeriesHeader); err != nil {
+			return nil, err
+		}
+	case TimeSeriesNDJSON:
+		e.json = json.NewEncoder(e.bw)
+	default:
+		return nil, fmt.Errorf("report: invalid time series format: %v", format)
+	}
+	return e, nil
+}
+
+// Encode writes the record of a single probe.
+func (e *TimeSeriesEncoder) Encode(p *Probe) error {
+	r := &timeSeriesRecord{
+		SendTime:  p.Timestamp,
+		SURBID:    p.SURBID,
+		Provider:  p.Provider,
+		Recipient: p.Recipient,
+		Outcome:   p.Outcome,
+	}
+	if p.Outcome == OutcomeOK {
+		ack := p.Timestamp.Add(p.Latency)
+		r.AckTime = &ack
+		r.Latency = p.Latency.Nanoseconds()
+	}
+	if e.json != nil {
+		return e.json.Encode(r)
+	}
+	ackTime, latency := "", ""
+	if r.AckTime != nil {
+		ackTime = r.AckTime.UTC().Format(time.RFC3339Nano)
+		latency = strconv.FormatInt(r.Latency, 10)
+	}
+	e.csv.Write([]string{
+		r.SendTime.UTC().Format(time.RFC3339Nano),
+		r.SURBID,
+		r.Provider,
+		r.Recipient,
+		ackTime,
+		latency,
+		r.Outcome,
+	})
+	return e.csv.Error()
+}
+
+// Flush flushes any buffered records to the underlying writer.
+func (e *TimeSeriesEncoder) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.bw.Flush()
+}
@@ -0,0 +1,53 @@
+// vantage.go - measurement vantage point
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Vantage describes where a measurement was made from, so that results
+// from geographically distributed agents can be analyzed by vantage
+// point.
+type Vantage struct {
+	// Region is the region of the agent, such as "eu-west".
+	Region string `json:",omitempty"`
+
+	// ASN is the autonomous system number of the agent's network, or
+	// zero if unknown.
+	ASN int `json:",omitempty"`
+
+	// Note is a free form note.
+	Note string `json:",omitempty"`
+}
+
+// String returns the vantage point as "region AS<n> (note)", omitting
+// the unset fields.
+func (v *Vantage) String() string {
+	var parts []string
+	if v.Region != "" {
+		parts = append(parts, v.Region)
+	}
+	if v.ASN != 0 {
+		parts = append(parts, fmt.Sprintf("AS%d", v.ASN))
+	}
+	if v.Note != "" {
+		parts = append(parts, "("+v.Note+")")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,126 @@
+// vegeta.go - vegeta compatible per-request results
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// VegetaJSON is vegeta's JSON (one result per line) encoding.
+	VegetaJSON = "json"
+
+	// VegetaCSV is vegeta's CSV encoding.
+	VegetaCSV = "csv"
+)
+
+type vegetaResult struct {
+	Attack    string        `json:"attack"`
+	Seq       uint64        `json:"seq"`
+	Code      uint16        `json:"code"`
+	Timestamp time.Time     `json:"timestamp"`
+	Latency   time.Duration `json:"latency"`
+	BytesOut  uint64        `json:"bytes_out"`
+	BytesIn   uint64        `json:"bytes_in"`
+	Error     string        `json:"error"`
+	Body      []byte        `json:"body"`
+}
+
+// VegetaEncoder encodes probe results in vegeta's result encodings, so
+// that `vegeta report` and `vegeta plot` can be used on spray results.
+// Successful probes are mapped to code 200, corrupt replies to code 500
+// and everything else to code 0.
+type VegetaEncoder struct {
+	attack string
+	bw     *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// NewVegetaEncoder returns a VegetaEncoder writing to w in the given
+// format, labeling all results with attack.
+func NewVegetaEncoder(w io.Writer, format, attack string) (*VegetaEncoder, error) {
+	e := &VegetaEncoder{
+		attack: attack,
+		bw:     bufio.NewWriter(w),
+	}
+	switch format {
+	case VegetaJSON, "":
+		e.json = json.NewEncoder(e.bw)
+	case VegetaCSV:
+		e.csv = csv.NewWriter(e.bw)
+	default:
+		return nil, fmt.Errorf("report: invalid vegeta format: %v", format)
+	}
+	return e, nil
+}
+
+func vegetaCode(outcome string) uint16 {
+	switch outcome {
+	case OutcomeOK:
+		return 200
+	case OutcomeCorrupt:
+		return 500
+	}
+	return 0
+}
+
+// Encode writes a single probe result.
+func (e *VegetaEncoder) Encode(p *Probe) error {
+	r := &vegetaResult{
+		Attack:    e.attack,
+		Seq:       p.Seq,
+		Code:      vegetaCode(p.Outcome),
+		Timestamp: p.Timestamp,
+		Latency:   p.Latency,
+		BytesOut:  p.BytesOut,
+		BytesIn:   p.BytesIn,
+		Error:     p.Error,
+	}
+	if e.json != nil {
+		return e.json.Encode(r)
+	}
+	e.csv.Write([]string{
+		strconv.FormatInt(r.Timestamp.UnixNano(), 10),
+		strconv.FormatUint(uint64(r.Code), 10),
+		strconv.FormatInt(r.Latency.Nanoseconds(), 10),
+		strconv.FormatUint(r.BytesOut, 10),
+		strconv.FormatUint(r.BytesIn, 10),
+		r.Error,
+		"",
+		r.Attack,
+		strconv.FormatUint(r.Seq, 10),
+	})
+	return e.csv.Error()
+}
+
+// Flush flushes any buffered results to the underlying writer.
+func (e *VegetaEncoder) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.bw.Flush()
+}
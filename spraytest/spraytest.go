@@ -0,0 +1,196 @@
+// spraytest.go - test doubles for embedders
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package spraytest provides test doubles for projects embedding spray,
+// so that orchestration logic can be unit tested without an authority
+// or a mixnet: a fake PKI client, canned PKI documents, and a harness
+// running whole sessions against the in-process mock mixnet.
+package spraytest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/spray"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/internal/mockpki"
+)
+
+const (
+	// Provider is the account Provider of the canned documents and of
+	// Config.
+	Provider = "provider-0"
+
+	// User is the account user of Config.
+	User = "spraytest"
+
+	// MixesPerLayer is the number of mixes per layer of the canned
+	// documents.
+	MixesPerLayer = 2
+
+	// MeanDelay is the mean per hop mixing delay in milliseconds of the
+	// canned documents and of Config.
+	MeanDelay = 10
+)
+
+var errNotSupported = errors.New("spraytest: operation not supported")
+
+// Providers are the Providers of the canned documents, all of which run
+// the loop service.
+var Providers = []string{Provider, "provider-1"}
+
+// Document returns a canned PKI document for the given epoch, with the
+// compiled in number of hops, MixesPerLayer mixes per layer and
+// Providers.
+func Document(epoch uint64) (*pki.Document, error) {
+	c, err := newCanned()
+	if err != nil {
+		return nil, err
+	}
+	return c.Document(epoch), nil
+}
+
+func newCanned() (*mockpki.Client, error) {
+	return mockpki.New(constants.NrHops-2, MixesPerLayer, Providers, MeanDelay, 0)
+}
+
+// PKIClient is a fake pki.Client.  It serves the canned documents unless
+// told otherwise, and records the epochs it was asked for.
+type PKIClient struct {
+	sync.Mutex
+
+	canned *mockpki.Client
+	docs   map[uint64]*pki.Document
+	err    error
+	gets   []uint64
+}
+
+// NewPKIClient returns a new PKIClient serving the canned documents.
+func NewPKIClient() (*PKIClient, error) {
+	canned, err := newCanned()
+	if err != nil {
+		return nil, err
+	}
+	return &PKIClient{
+		canned: canned,
+		docs:   make(map[uint64]*pki.Document),
+	}, nil
+}
+
+// SetDocument makes the client serve doc for its epoch instead of the
+// canned document.
+func (c *PKIClient) SetDocument(doc *pki.Document) {
+	c.Lock()
+	defer c.Unlock()
+	c.docs[doc.Epoch] = doc
+}
+
+// SetError makes every subsequent Get fail with err, or succeed again
+// if err is nil.
+func (c *PKIClient) SetError(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.err = err
+}
+
+// Gets returns the epochs of every Get so far, in order.
+func (c *PKIClient) Gets() []uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return append([]uint64(nil), c.gets...)
+}
+
+// Get returns the document for the given epoch.
+func (c *PKIClient) Get(ctx context.Context, epoch uint64) (*pki.Document, []byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.gets = append(c.gets, epoch)
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+	if doc, ok := c.docs[epoch]; ok {
+		return doc, nil, nil
+	}
+	return c.canned.Document(epoch), nil, nil
+}
+
+// Post is not supported.
+func (c *PKIClient) Post(ctx context.Context, epoch uint64, signingKey *eddsa.PrivateKey, d *pki.MixDescriptor) error {
+	return errNotSupported
+}
+
+// Deserialize is not supported.
+func (c *PKIClient) Deserialize(raw []byte) (*pki.Document, error) {
+	return nil, errNotSupported
+}
+
+// Config returns a validated configuration running the echo probe
+// against the mock mixnet, with its data in dataDir, which must be an
+// absolute path.  Tests may adjust it and must then call
+// FixupAndValidate again.
+func Config(dataDir string) (*config.Config, error) {
+	cfg := &config.Config{
+		Proxy:   &config.Proxy{DataDir: dataDir},
+		Logging: &config.Logging{Disable: true},
+		Debug: &config.Debug{
+			Mode:               config.ModeKaetzchen,
+			SendRate:           10,
+			SendBurst:          1,
+			SessionDialTimeout: 30,
+		},
+		Account: &config.Account{
+			User:     User,
+			Provider: Provider,
+		},
+		Mock: &config.Mock{
+			Providers: len(Providers),
+			MeanDelay: MeanDelay,
+		},
+	}
+	if err := cfg.FixupAndValidate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Harness runs a Client, typically configured by Config.
+type Harness struct {
+	Client *spray.Client
+}
+
+// NewHarness creates a Client with the given configuration.
+func NewHarness(cfg *config.Config) (*Harness, error) {
+	c, err := spray.Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Harness{Client: c}, nil
+}
+
+// Run executes a run lasting at most d, and returns its result.
+func (h *Harness) Run(d time.Duration) (*spray.Result, error) {
+	return h.Client.Run(context.Background(), &spray.Scenario{Duration: d})
+}
+
+// Close shuts down the Client.
+func (h *Harness) Close() {
+	h.Client.Close()
+}
@@ -0,0 +1,142 @@
+// spraytest_test.go - spraytest tests
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package spraytest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/spray"
+	"github.com/katzenpost/spray/config"
+	"github.com/katzenpost/spray/report"
+)
+
+func TestPKIClient(t *testing.T) {
+	c, err := NewPKIClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _, err := c.Get(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Epoch != 3 || len(doc.Providers) != len(Providers) {
+		t.Fatalf("canned document for epoch 3 is wrong: epoch %d, %d Providers", doc.Epoch, len(doc.Providers))
+	}
+
+	custom, err := Document(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom.Providers = custom.Providers[:1]
+	c.SetDocument(custom)
+	if doc, _, err = c.Get(context.Background(), 4); err != nil || doc != custom {
+		t.Fatalf("Get did not return the document set for epoch 4: %v", err)
+	}
+
+	errFail := errors.New("no consensus")
+	c.SetError(errFail)
+	if _, _, err = c.Get(context.Background(), 5); err != errFail {
+		t.Fatalf("Get returned %v instead of the set error", err)
+	}
+	c.SetError(nil)
+	if _, _, err = c.Get(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	gets := c.Gets()
+	want := []uint64{3, 4, 5, 5}
+	if len(gets) != len(want) {
+		t.Fatalf("Gets returned %v, want %v", gets, want)
+	}
+	for i := range want {
+		if gets[i] != want[i] {
+			t.Fatalf("Gets returned %v, want %v", gets, want)
+		}
+	}
+}
+
+// runHarness runs a session against the mock mixnet for d, with the
+// configuration adjusted by fn, and returns its result.
+func runHarness(t *testing.T, d time.Duration, fn func(*config.Config)) *spray.Result {
+	dataDir, err := ioutil.TempDir("", "spraytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	cfg, err := Config(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn != nil {
+		fn(cfg)
+		if err = cfg.FixupAndValidate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := NewHarness(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	r, err := h.Run(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestHarness(t *testing.T) {
+	r := runHarness(t, 3*time.Second, nil)
+	if r.Sent == 0 {
+		t.Fatal("no probes were sent")
+	}
+	if r.Outcomes[report.OutcomeOK] == 0 {
+		t.Fatalf("no probes were answered: %v", r.Outcomes)
+	}
+}
+
+func TestHarnessFaultInjection(t *testing.T) {
+	r := runHarness(t, 2*time.Second, func(cfg *config.Config) {
+		cfg.FaultInjection = &config.FaultInjection{ComposeFailureProbability: 1}
+	})
+	if n := r.Outcomes[report.OutcomeOK]; n != 0 {
+		t.Fatalf("%d probes were answered despite every compose failing", n)
+	}
+	if r.Outcomes[report.OutcomeFailed] == 0 {
+		t.Fatalf("no compose failures were recorded: %v", r.Outcomes)
+	}
+}
+
+func TestHarnessFloodFaultInjection(t *testing.T) {
+	r := runHarness(t, 2*time.Second, func(cfg *config.Config) {
+		cfg.Debug.Mode = config.ModeFlood
+		cfg.Target = []*config.Target{{Provider: Providers[1], Recipient: User}}
+		cfg.FaultInjection = &config.FaultInjection{ComposeFailureProbability: 1}
+	})
+	if r.Sent != 0 {
+		t.Fatalf("%d packets were sent despite every compose failing", r.Sent)
+	}
+	if r.Outcomes[report.OutcomeFailed] == 0 {
+		t.Fatalf("no compose failures were recorded in the flood mode: %v", r.Outcomes)
+	}
+}
@@ -0,0 +1,239 @@
+// histogram.go - latency histogram
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package stats implements the statistics collected by spray.
+package stats
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// subBucketBits is the number of bits of precision kept within each
+	// power of two, giving a worst case relative error below 1%.
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	bucketCount    = (64 - subBucketBits + 1) * subBucketCount
+)
+
+// Bucket is a single populated histogram bucket.
+type Bucket struct {
+	// Start is the inclusive lower bound of the bucket.
+	Start time.Duration
+
+	// End is the exclusive upper bound of the bucket.
+	End time.Duration
+
+	// Count is the number of values recorded in the bucket.
+	Count uint64
+}
+
+// Histogram is a latency histogram with logarithmic-linear bucketing in
+// the spirit of HdrHistogram.  It is safe for concurrent use.
+type Histogram struct {
+	sync.Mutex
+
+	counts []uint64
+	count  uint64
+	min    time.Duration
+	max    time.Duration
+	sum    float64
+	sumSq  float64
+}
+
+// NewHistogram returns a new empty Histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]uint64, bucketCount),
+	}
+}
+
+func bucketIndex(v uint64) int {
+	if v < 2*subBucketCount {
+		return int(v)
+	}
+	shift := uint(bits.Len64(v) - subBucketBits - 1)
+	return int(shift)*subBucketCount + int(v>>shift)
+}
+
+func bucketBounds(idx int) (uint64, uint64) {
+	if idx < 2*subBucketCount {
+		return uint64(idx), uint64(idx) + 1
+	}
+	shift := uint(idx/subBucketCount - 1)
+	sub := uint64(idx%subBucketCount + subBucketCount)
+	return sub << shift, (sub + 1) << shift
+}
+
+// Record adds a value to the histogram.  Negative values are clamped
+// to zero.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	h.counts[bucketIndex(uint64(d))]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	f := float64(d)
+	h.sum += f
+	h.sumSq += f * f
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.count
+}
+
+// Min returns the smallest recorded value.
+func (h *Histogram) Min() time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return h.min
+}
+
+// Max returns the largest recorded value.
+func (h *Histogram) Max() time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return h.max
+}
+
+// Sum returns the sum of all recorded values.
+func (h *Histogram) Sum() time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return time.Duration(h.sum)
+}
+
+// Mean returns the arithmetic mean of the recorded values.
+func (h *Histogram) Mean() time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// StdDev returns the population standard deviation of the recorded values.
+func (h *Histogram) StdDev() time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	n := float64(h.count)
+	mean := h.sum / n
+	variance := h.sumSq/n - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Percentile returns the value below which p percent of the recorded
+// values fall, with p in the range [0, 100].
+func (h *Histogram) Percentile(p float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(p / 100 * float64(h.count)))
+	if target == 0 {
+		return h.min
+	}
+	var seen uint64
+	for idx, c := range h.counts {
+		seen += c
+		if seen >= target {
+			_, end := bucketBounds(idx)
+			v := time.Duration(end - 1)
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Buckets returns the populated buckets in ascending order.
+func (h *Histogram) Buckets() []Bucket {
+	h.Lock()
+	defer h.Unlock()
+	buckets := []Bucket{}
+	for idx, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		start, end := bucketBounds(idx)
+		buckets = append(buckets, Bucket{
+			Start: time.Duration(start),
+			End:   time.Duration(end),
+			Count: c,
+		})
+	}
+	return buckets
+}
+
+// Layout returns the number of buckets and of sub-buckets per bucket.
+func (h *Histogram) Layout() (int, int) {
+	return bucketCount / subBucketCount, subBucketCount
+}
+
+// Merge adds all of the values recorded in other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	other.Lock()
+	counts := make([]uint64, len(other.counts))
+	copy(counts, other.counts)
+	count, min, max, sum, sumSq := other.count, other.min, other.max, other.sum, other.sumSq
+	other.Unlock()
+
+	if count == 0 {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	for idx, c := range counts {
+		h.counts[idx] += c
+	}
+	if h.count == 0 || min < h.min {
+		h.min = min
+	}
+	if max > h.max {
+		h.max = max
+	}
+	h.count += count
+	h.sum += sum
+	h.sumSq += sumSq
+}
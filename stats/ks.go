@@ -0,0 +1,80 @@
+// ks.go - Kolmogorov-Smirnov goodness of fit test
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// KSTest is the result of a one-sample Kolmogorov-Smirnov test.
+type KSTest struct {
+	// D is the KS statistic, the largest distance between the empirical
+	// and the reference cumulative distribution functions.
+	D float64
+
+	// PValue is the asymptotic probability of observing a distance at
+	// least as large as D if the samples were drawn from the reference
+	// distribution.
+	PValue float64
+}
+
+// KSExponential tests the samples against the exponential distribution
+// with the given rate, as expected of the intervals of a Poisson process.
+// The samples are sorted in place.
+func KSExponential(samples []float64, rate float64) *KSTest {
+	n := float64(len(samples))
+	if n == 0 || rate <= 0 {
+		return nil
+	}
+	sort.Float64s(samples)
+	var d float64
+	for i, x := range samples {
+		cdf := 1 - math.Exp(-rate*x)
+		d = math.Max(d, math.Max(float64(i+1)/n-cdf, cdf-float64(i)/n))
+	}
+	sqrtN := math.Sqrt(n)
+	return &KSTest{
+		D:      d,
+		PValue: kolmogorovQ((sqrtN + 0.12 + 0.11/sqrtN) * d),
+	}
+}
+
+// kolmogorovQ is the complementary cumulative distribution function of
+// the Kolmogorov distribution.
+func kolmogorovQ(lambda float64) float64 {
+	if lambda < 1.18 {
+		// The series converges too slowly, use the complement instead.
+		if lambda <= 0 {
+			return 1
+		}
+		y := math.Exp(-math.Pi * math.Pi / (8 * lambda * lambda))
+		p := math.Sqrt(2*math.Pi) / lambda * (y + math.Pow(y, 9) + math.Pow(y, 25) + math.Pow(y, 49))
+		return 1 - p
+	}
+	var sum float64
+	sign := 1.0
+	for k := 1; k <= 100; k++ {
+		term := sign * math.Exp(-2*float64(k*k)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return math.Max(0, math.Min(1, 2*sum))
+}
@@ -0,0 +1,190 @@
+// diff.go - PKI document diffs
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package topology
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/katzenpost/core/pki"
+)
+
+const providerLayer = "provider"
+
+// Change is a changed named value.
+type Change struct {
+	// Name is the name of the changed value.
+	Name string
+
+	// Old is the previous value.
+	Old string
+
+	// New is the current value.
+	New string
+}
+
+// Diff is the structured difference between two PKI documents.
+type Diff struct {
+	// FromEpoch is the epoch of the old document.
+	FromEpoch uint64
+
+	// ToEpoch is the epoch of the new document.
+	ToEpoch uint64
+
+	// Added are the names of nodes present only in the new document.
+	Added []string
+
+	// Removed are the names of nodes present only in the old document.
+	Removed []string
+
+	// Moved are the nodes whose layer changed.
+	Moved []Change
+
+	// KeyChanges are the nodes whose identity or link key changed,
+	// named "node/identity" or "node/link".
+	KeyChanges []Change
+
+	// ServiceChanges are the Providers whose advertised Kaetzchen
+	// capabilities changed.
+	ServiceChanges []Change
+
+	// ParameterChanges are the changed document parameters.
+	ParameterChanges []Change
+}
+
+type nodeInfo struct {
+	layer    string
+	identity string
+	link     string
+	services string
+}
+
+func nodesOf(doc *pki.Document) map[string]*nodeInfo {
+	nodes := make(map[string]*nodeInfo)
+	add := func(layer string, desc *pki.MixDescriptor) {
+		n := newNode(desc)
+		nodes[desc.Name] = &nodeInfo{
+			layer:    layer,
+			identity: n.IdentityKey,
+			link:     n.LinkKey,
+			services: strings.Join(n.Services, ","),
+		}
+	}
+	for i, layer := range doc.Topology {
+		for _, desc := range layer {
+			add(fmt.Sprintf("%d", i), desc)
+		}
+	}
+	for _, desc := range doc.Providers {
+		add(providerLayer, desc)
+	}
+	return nodes
+}
+
+func parametersOf(doc *pki.Document) [][2]string {
+	return [][2]string{
+		{"SendRatePerMinute", fmt.Sprintf("%v", doc.SendRatePerMinute)},
+		{"Mu", fmt.Sprintf("%v", doc.Mu)},
+		{"MuMaxDelay", fmt.Sprintf("%v", doc.MuMaxDelay)},
+		{"LambdaP", fmt.Sprintf("%v", doc.LambdaP)},
+		{"LambdaPMaxDelay", fmt.Sprintf("%v", doc.LambdaPMaxDelay)},
+		{"LambdaL", fmt.Sprintf("%v", doc.LambdaL)},
+		{"LambdaLMaxDelay", fmt.Sprintf("%v", doc.LambdaLMaxDelay)},
+		{"LambdaD", fmt.Sprintf("%v", doc.LambdaD)},
+		{"LambdaDMaxDelay", fmt.Sprintf("%v", doc.LambdaDMaxDelay)},
+		{"LambdaM", fmt.Sprintf("%v", doc.LambdaM)},
+		{"LambdaMMaxDelay", fmt.Sprintf("%v", doc.LambdaMMaxDelay)},
+		{"Layers", fmt.Sprintf("%v", len(doc.Topology))},
+	}
+}
+
+// NewDiff returns the difference between the prev and cur documents.
+func NewDiff(prev, cur *pki.Document) *Diff {
+	d := &Diff{
+		FromEpoch: prev.Epoch,
+		ToEpoch:   cur.Epoch,
+	}
+	oldNodes, newNodes := nodesOf(prev), nodesOf(cur)
+	for name, o := range oldNodes {
+		n, ok := newNodes[name]
+		if !ok {
+			d.Removed = append(d.Removed, name)
+			continue
+		}
+		if o.layer != n.layer {
+			d.Moved = append(d.Moved, Change{name, o.layer, n.layer})
+		}
+		if o.identity != n.identity {
+			d.KeyChanges = append(d.KeyChanges, Change{name + "/identity", o.identity, n.identity})
+		}
+		if o.link != n.link {
+			d.KeyChanges = append(d.KeyChanges, Change{name + "/link", o.link, n.link})
+		}
+		if o.services != n.services {
+			d.ServiceChanges = append(d.ServiceChanges, Change{name, o.services, n.services})
+		}
+	}
+	for name := range newNodes {
+		if _, ok := oldNodes[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	for _, l := range [][]Change{d.Moved, d.KeyChanges, d.ServiceChanges} {
+		sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	}
+
+	oldParams, newParams := parametersOf(prev), parametersOf(cur)
+	for i := range oldParams {
+		if oldParams[i][1] != newParams[i][1] {
+			d.ParameterChanges = append(d.ParameterChanges, Change{oldParams[i][0], oldParams[i][1], newParams[i][1]})
+		}
+	}
+	return d
+}
+
+// IsEmpty returns true if nothing but the epoch changed.
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Moved) == 0 &&
+		len(d.KeyChanges) == 0 && len(d.ServiceChanges) == 0 && len(d.ParameterChanges) == 0
+}
+
+// String returns a one line summary of the diff.
+func (d *Diff) String() string {
+	parts := []string{fmt.Sprintf("epoch %d -> %d", d.FromEpoch, d.ToEpoch)}
+	if len(d.Added) > 0 {
+		parts = append(parts, fmt.Sprintf("added %v", d.Added))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed %v", d.Removed))
+	}
+	changes := func(what string, l []Change) {
+		for _, c := range l {
+			parts = append(parts, fmt.Sprintf("%s %s '%s' -> '%s'", what, c.Name, c.Old, c.New))
+		}
+	}
+	changes("moved", d.Moved)
+	changes("key", d.KeyChanges)
+	changes("services", d.ServiceChanges)
+	changes("parameter", d.ParameterChanges)
+	if d.IsEmpty() {
+		parts = append(parts, "no changes")
+	}
+	return strings.Join(parts, ", ")
+}
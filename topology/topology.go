@@ -0,0 +1,161 @@
+// topology.go - PKI topology export
+// Copyright (C) 2018  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package topology renders the mix network topology described by a PKI
+// document.
+package topology
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/katzenpost/core/pki"
+)
+
+// Node is a mix or Provider in the topology.
+type Node struct {
+	// Name is the node's name.
+	Name string
+
+	// IdentityKey is the node's identity key.
+	IdentityKey string
+
+	// LinkKey is the node's link key.
+	LinkKey string
+
+	// Addresses are the node's addresses by transport.
+	Addresses map[pki.Transport][]string
+
+	// Services are the Kaetzchen capabilities advertised by a Provider.
+	Services []string `json:",omitempty"`
+}
+
+// Topology is the exported topology of a PKI document.
+type Topology struct {
+	// Epoch is the epoch of the document.
+	Epoch uint64
+
+	// Layers are the mix layers, in path order.
+	Layers [][]*Node
+
+	// Providers are the Providers.
+	Providers []*Node
+}
+
+func newNode(desc *pki.MixDescriptor) *Node {
+	n := &Node{
+		Name:      desc.Name,
+		Addresses: desc.Addresses,
+	}
+	if desc.IdentityKey != nil {
+		n.IdentityKey = desc.IdentityKey.String()
+	}
+	if desc.LinkKey != nil {
+		n.LinkKey = desc.LinkKey.String()
+	}
+	for capability := range desc.Kaetzchen {
+		n.Services = append(n.Services, capability)
+	}
+	sort.Strings(n.Services)
+	return n
+}
+
+// New returns the topology of the given PKI document.
+func New(doc *pki.Document) *Topology {
+	t := &Topology{
+		Epoch:  doc.Epoch,
+		Layers: make([][]*Node, len(doc.Topology)),
+	}
+	for i, layer := range doc.Topology {
+		for _, desc := range layer {
+			t.Layers[i] = append(t.Layers[i], newNode(desc))
+		}
+	}
+	for _, desc := range doc.Providers {
+		t.Providers = append(t.Providers, newNode(desc))
+	}
+	return t
+}
+
+// WriteJSON writes the topology of doc to w as JSON.
+func WriteJSON(w io.Writer, doc *pki.Document) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(New(doc))
+}
+
+// WriteDOT writes the topology of doc to w as a Graphviz DOT digraph,
+// with an edge between every pair of nodes in adjacent layers and the
+// Providers on both ends of the path.
+func WriteDOT(w io.Writer, doc *pki.Document) error {
+	t := New(doc)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "digraph \"epoch %d\" {\n", t.Epoch)
+	fmt.Fprintf(bw, "\trankdir=LR;\n")
+	fmt.Fprintf(bw, "\tlabel=\"Epoch %d\";\n", t.Epoch)
+	for _, n := range t.Providers {
+		label := n.Name
+		if len(n.Services) > 0 {
+			label += "\\n" + strings.Join(n.Services, ",")
+		}
+		fmt.Fprintf(bw, "\t%q [shape=box, label=%q];\n", n.Name, label)
+	}
+	for i, layer := range t.Layers {
+		fmt.Fprintf(bw, "\tsubgraph cluster_layer%d {\n", i)
+		fmt.Fprintf(bw, "\t\tlabel=\"layer %d\";\n", i)
+		for _, n := range layer {
+			fmt.Fprintf(bw, "\t\t%q;\n", n.Name)
+		}
+		fmt.Fprintf(bw, "\t}\n")
+	}
+
+	edges := func(from, to []*Node) {
+		for _, a := range from {
+			for _, b := range to {
+				fmt.Fprintf(bw, "\t%q -> %q;\n", a.Name, b.Name)
+			}
+		}
+	}
+	if len(t.Layers) == 0 {
+		edges(t.Providers, t.Providers)
+	} else {
+		edges(t.Providers, t.Layers[0])
+		for i := 1; i < len(t.Layers); i++ {
+			edges(t.Layers[i-1], t.Layers[i])
+		}
+		edges(t.Layers[len(t.Layers)-1], t.Providers)
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// Digest returns the hex encoded SHA-256 digest of the JSON encoding of
+// doc, which identifies the document a measurement was made with.
+func Digest(doc *pki.Document) (string, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	d := sha256.Sum256(b)
+	return hex.EncodeToString(d[:]), nil
+}